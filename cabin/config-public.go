@@ -85,6 +85,12 @@ var (
 	publicCfgOptionExit        config.StringArrayOption
 	publicCfgOptionExitDefault = []string{"- * TCP/25"}
 	publicCfgOptionExitOrder   = 522
+
+	// Post-quantum exchange keys.
+	publicCfgOptionPostQuantumKeysKey     = "spn/publicHub/postQuantumKeys"
+	publicCfgOptionPostQuantumKeys        config.BoolOption
+	publicCfgOptionPostQuantumKeysDefault = false
+	publicCfgOptionPostQuantumKeysOrder   = 523
 )
 
 func prepPublicHubConfig() error {
@@ -266,6 +272,22 @@ func prepPublicHubConfig() error {
 	}
 	publicCfgOptionExit = config.GetAsStringArray(publicCfgOptionExitKey, publicCfgOptionExitDefault)
 
+	err = config.Register(&config.Option{
+		Name:           "Post-Quantum Exchange Keys",
+		Key:            publicCfgOptionPostQuantumKeysKey,
+		Description:    "Additionally provide hybrid X25519 and Kyber768 exchange keys, which clients that support them use preferentially. Older clients ignore these keys and continue to use the regular exchange keys.",
+		OptType:        config.OptTypeBool,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		DefaultValue:   publicCfgOptionPostQuantumKeysDefault,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: publicCfgOptionPostQuantumKeysOrder,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	publicCfgOptionPostQuantumKeys = config.GetAsBool(publicCfgOptionPostQuantumKeysKey, publicCfgOptionPostQuantumKeysDefault)
+
 	// update defaults from system
 	setDynamicPublicDefaults()
 
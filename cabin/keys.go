@@ -17,6 +17,13 @@ type providedExchKeyScheme struct {
 	id            string
 	securityLevel int //nolint:structcheck // TODO
 	tool          *tools.Tool
+
+	// optIn defines whether the scheme must be enabled in the config to be
+	// provided. This is used for schemes that not all clients support yet.
+	optIn bool
+	// hybrid defines whether keys of the scheme are published in the hybrid
+	// keys of the Hub status, which older clients ignore.
+	hybrid bool
 }
 
 var (
@@ -33,9 +40,16 @@ var (
 	// provideExchKeySchemes defines the jess tools for creating exchange keys.
 	provideExchKeySchemes = []*providedExchKeyScheme{
 		{
-			id:            "ECDH-X25519",
+			id:            hub.ExchKeySchemeX25519,
 			securityLevel: 128, // informative only, security level of ECDH-X25519 is fixed
 		},
+		{
+			id:            hub.ExchKeySchemeX25519Kyber768,
+			securityLevel: 128, // informative only, security level of the hybrid scheme is fixed
+			optIn:         true,
+			// Keys of this scheme exceed the size limit of older clients.
+			hybrid: true,
+		},
 		// TODO: test with rsa keys
 	}
 )
@@ -51,6 +65,14 @@ func initProvidedExchKeySchemes() error {
 	return nil
 }
 
+// enabled returns whether the exchange key scheme should be provided.
+func (eks *providedExchKeyScheme) enabled() bool {
+	if !eks.optIn {
+		return true
+	}
+	return publicCfgOptionPostQuantumKeys != nil && publicCfgOptionPostQuantumKeys()
+}
+
 // MaintainExchKeys maintains the exchange keys, creating new ones and
 // deprecating and deleting old ones.
 func (id *Identity) MaintainExchKeys(newStatus *hub.Status, now time.Time) (changed bool, err error) {
@@ -83,7 +105,13 @@ func (id *Identity) MaintainExchKeys(newStatus *hub.Status, now time.Time) (chan
 	}
 
 	// find or create current keys
+	var enabledSchemes int
 	for _, eks := range provideExchKeySchemes {
+		if !eks.enabled() {
+			continue
+		}
+		enabledSchemes++
+
 		found := false
 		for _, exchKey := range id.ExchKeys {
 			if exchKey.key != nil &&
@@ -104,12 +132,23 @@ func (id *Identity) MaintainExchKeys(newStatus *hub.Status, now time.Time) (chan
 	}
 
 	// export most recent keys to HubStatus
+	// Also re-export when the exported schemes do not match the enabled ones,
+	// eg. after a scheme was disabled.
+	exportedSchemes := len(newStatus.Keys) + len(newStatus.HybridKeys)
+	if len(newStatus.Keys) != 0 && exportedSchemes != enabledSchemes {
+		changed = true
+	}
 	if changed || len(newStatus.Keys) == 0 {
 		// reset
 		newStatus.Keys = make(map[string]*hub.Key)
+		newStatus.HybridKeys = nil
 
 		// find longest valid key for every provided scheme
 		for _, eks := range provideExchKeySchemes {
+			if !eks.enabled() {
+				continue
+			}
+
 			// find key of scheme that is valid the longest
 			longestValid := &ExchKey{
 				Expires: now,
@@ -134,7 +173,14 @@ func (id *Identity) MaintainExchKeys(newStatus *hub.Status, now time.Time) (chan
 				return false, fmt.Errorf("failed to export %s exchange key: %w", longestValid.tool.Info.Name, err)
 			}
 			// add
-			newStatus.Keys[longestValid.key.ID] = hubKey
+			if eks.hybrid {
+				if newStatus.HybridKeys == nil {
+					newStatus.HybridKeys = make(map[string]*hub.Key)
+				}
+				newStatus.HybridKeys[longestValid.key.ID] = hubKey
+			} else {
+				newStatus.Keys[longestValid.key.ID] = hubKey
+			}
 		}
 	}
 
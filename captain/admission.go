@@ -0,0 +1,576 @@
+package captain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tevino/abool"
+
+	"github.com/safing/portbase/api"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/metrics"
+	"github.com/safing/portbase/modules"
+	"github.com/safing/portmaster/network/netutils"
+	"github.com/safing/spn/terminal"
+)
+
+const (
+	// admissionHandshakeWindow defines the window in which handshakes are
+	// counted for the handshake rate limit.
+	admissionHandshakeWindow = 1 * time.Minute
+
+	// admissionFailureWindow defines the window in which failed handshakes are
+	// counted for banning.
+	admissionFailureWindow = 10 * time.Minute
+
+	// admissionBanDuration defines how long an IP or subnet is banned after too
+	// many failed handshakes.
+	admissionBanDuration = 30 * time.Minute
+
+	// admissionSubnetBanFactor defines how many more failed handshakes than
+	// allowed for a single IP ban the whole subnet.
+	admissionSubnetBanFactor = 4
+
+	// admissionCleanInterval defines how often unused admission state is removed.
+	admissionCleanInterval = 5 * time.Minute
+
+	// maxAdmissionIPStates defines how many IPs are tracked at most. When the
+	// limit is reached, the state of the idle IPs that were seen least recently
+	// is removed. Ships from untracked IPs are only rejected if no IP is idle.
+	maxAdmissionIPStates = 100000
+
+	// admissionEvictBatch defines how many idle IPs are removed at once when
+	// the state limit is reached.
+	admissionEvictBatch = maxAdmissionIPStates / 100
+)
+
+// Admission rejection reasons, as used for metrics.
+const (
+	admissionRejectedBanned        = "banned"
+	admissionRejectedIPLimit       = "ip_limit"
+	admissionRejectedSubnetLimit   = "subnet_limit"
+	admissionRejectedHandshakeRate = "handshake_rate"
+	admissionRejectedStateLimit    = "state_limit"
+)
+
+// Admission errors.
+var (
+	ErrAdmissionBanned        = errors.New("source is temporarily banned")
+	ErrAdmissionIPLimit       = errors.New("too many connections from source IP")
+	ErrAdmissionSubnetLimit   = errors.New("too many connections from source subnet")
+	ErrAdmissionHandshakeRate = errors.New("too many handshakes from source IP")
+	ErrAdmissionStateLimit    = errors.New("too many tracked sources")
+)
+
+// admissionControl limits incoming ships per IP and subnet and bans sources
+// with repeated failed handshakes.
+// IPv6 addresses are tracked by their /64 prefix, as this is usually assigned
+// to a single host or network.
+type admissionControl struct {
+	sync.Mutex
+
+	ips     map[string]*admissionIPState
+	subnets map[string]*admissionSubnetState
+	// tickets holds the tickets of admitted ships by crane ID.
+	tickets map[string]*admissionTicket
+}
+
+// admissionIPState holds the admission state of a single IP.
+type admissionIPState struct {
+	ships    int
+	lastSeen time.Time
+
+	handshakesSince time.Time
+	handshakes      int
+
+	failuresSince time.Time
+	failures      int
+	bannedUntil   time.Time
+}
+
+// admissionSubnetState holds the admission state of a subnet.
+type admissionSubnetState struct {
+	ships int
+
+	failuresSince time.Time
+	failures      int
+	bannedUntil   time.Time
+}
+
+// admissionTicket is issued for an admitted ship and must be released when
+// the ship is gone.
+type admissionTicket struct {
+	ip       string
+	subnet   string
+	released bool
+}
+
+var (
+	admission = newAdmissionControl()
+
+	admissionRejected          = make(map[string]*metrics.Counter)
+	admissionBans              *metrics.Counter
+	admissionMetricsRegistered = abool.New()
+)
+
+func newAdmissionControl() *admissionControl {
+	return &admissionControl{
+		ips:     make(map[string]*admissionIPState),
+		subnets: make(map[string]*admissionSubnetState),
+		tickets: make(map[string]*admissionTicket),
+	}
+}
+
+// admissionIP returns the key of the given IP used for the per-IP limits.
+func admissionIP(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.String()
+	}
+	return ip.Mask(net.CIDRMask(64, 128)).String() + "/64"
+}
+
+// admissionSubnet returns the subnet of the given IP used for the subnet limit.
+func admissionSubnet(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32)).String() + "/24"
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String() + "/48"
+}
+
+// admit checks if a new ship from the given IP may dock and returns a ticket
+// if it may. Bans and limits are checked before any state is created for the
+// IP, so that rejected sources cannot fill the state.
+func (ac *admissionControl) admit(ip net.IP, maxPerIP, maxPerSubnet, maxHandshakes int) (*admissionTicket, error) {
+	ac.Lock()
+	defer ac.Unlock()
+
+	now := time.Now()
+	ipKey := admissionIP(ip)
+	subnetKey := admissionSubnet(ip)
+	subnet := ac.subnets[subnetKey]
+	state := ac.ips[ipKey]
+
+	// Check bans and concurrent ships.
+	if err := checkAdmissionLimits(now, subnet, state, maxPerIP, maxPerSubnet); err != nil {
+		return nil, err
+	}
+
+	// Create state for new IPs.
+	if state == nil {
+		// Bound the tracked state, remove idle state first if full.
+		if len(ac.ips) >= maxAdmissionIPStates {
+			ac.cleanLocked(now)
+		}
+		if len(ac.ips) >= maxAdmissionIPStates {
+			ac.evictLocked(now)
+		}
+		if len(ac.ips) >= maxAdmissionIPStates {
+			reportAdmissionRejection(admissionRejectedStateLimit)
+			return nil, ErrAdmissionStateLimit
+		}
+
+		state = &admissionIPState{}
+		ac.ips[ipKey] = state
+	}
+
+	// Check handshake rate.
+	state.lastSeen = now
+	if now.Sub(state.handshakesSince) > admissionHandshakeWindow {
+		state.handshakesSince = now
+		state.handshakes = 0
+	}
+	state.handshakes++
+	if maxHandshakes > 0 && state.handshakes > maxHandshakes {
+		reportAdmissionRejection(admissionRejectedHandshakeRate)
+		return nil, ErrAdmissionHandshakeRate
+	}
+
+	// Admit.
+	if subnet == nil {
+		subnet = &admissionSubnetState{}
+		ac.subnets[subnetKey] = subnet
+	}
+	state.ships++
+	subnet.ships++
+	return &admissionTicket{
+		ip:     ipKey,
+		subnet: subnetKey,
+	}, nil
+}
+
+// check checks if a new ship from the given IP would currently be admitted,
+// without counting it or creating any state. It is used to reject ships before
+// any handshake.
+func (ac *admissionControl) check(ip net.IP, maxPerIP, maxPerSubnet, maxHandshakes int) error {
+	ac.Lock()
+	defer ac.Unlock()
+
+	now := time.Now()
+	subnet := ac.subnets[admissionSubnet(ip)]
+	state := ac.ips[admissionIP(ip)]
+
+	// Check bans and concurrent ships.
+	if err := checkAdmissionLimits(now, subnet, state, maxPerIP, maxPerSubnet); err != nil {
+		return err
+	}
+
+	// Check handshake rate.
+	if maxHandshakes > 0 && state != nil &&
+		now.Sub(state.handshakesSince) <= admissionHandshakeWindow &&
+		state.handshakes >= maxHandshakes {
+		reportAdmissionRejection(admissionRejectedHandshakeRate)
+		return ErrAdmissionHandshakeRate
+	}
+
+	return nil
+}
+
+// checkAdmissionLimits checks the bans and concurrent ship limits of the given
+// subnet and IP state, which may be nil if not yet tracked.
+func checkAdmissionLimits(now time.Time, subnet *admissionSubnetState, state *admissionIPState, maxPerIP, maxPerSubnet int) error {
+	// Check bans.
+	if (subnet != nil && now.Before(subnet.bannedUntil)) ||
+		(state != nil && now.Before(state.bannedUntil)) {
+		reportAdmissionRejection(admissionRejectedBanned)
+		return ErrAdmissionBanned
+	}
+
+	// Check concurrent ships.
+	if maxPerSubnet > 0 && subnet != nil && subnet.ships >= maxPerSubnet {
+		reportAdmissionRejection(admissionRejectedSubnetLimit)
+		return ErrAdmissionSubnetLimit
+	}
+	if maxPerIP > 0 && state != nil && state.ships >= maxPerIP {
+		reportAdmissionRejection(admissionRejectedIPLimit)
+		return ErrAdmissionIPLimit
+	}
+
+	return nil
+}
+
+// reset removes all admission state.
+func (ac *admissionControl) reset() {
+	ac.Lock()
+	defer ac.Unlock()
+
+	ac.ips = make(map[string]*admissionIPState)
+	ac.subnets = make(map[string]*admissionSubnetState)
+	ac.tickets = make(map[string]*admissionTicket)
+}
+
+// assign assigns the ticket to the crane with the given ID, so that it is
+// released when the crane stops.
+func (ac *admissionControl) assign(craneID string, ticket *admissionTicket) {
+	ac.Lock()
+	defer ac.Unlock()
+
+	ac.tickets[craneID] = ticket
+}
+
+// releaseCrane releases the ticket assigned to the crane with the given ID.
+func (ac *admissionControl) releaseCrane(craneID string) {
+	ac.Lock()
+	defer ac.Unlock()
+
+	ticket, ok := ac.tickets[craneID]
+	if !ok {
+		return
+	}
+	delete(ac.tickets, craneID)
+	ac.release(ticket)
+}
+
+// releaseTicket releases the given ticket.
+func (ac *admissionControl) releaseTicket(ticket *admissionTicket) {
+	ac.Lock()
+	defer ac.Unlock()
+
+	ac.release(ticket)
+}
+
+// release releases the given ticket.
+// The admission control must be locked.
+func (ac *admissionControl) release(ticket *admissionTicket) {
+	if ticket.released {
+		return
+	}
+	ticket.released = true
+
+	if state, ok := ac.ips[ticket.ip]; ok && state.ships > 0 {
+		state.ships--
+	}
+	if subnet, ok := ac.subnets[ticket.subnet]; ok {
+		if subnet.ships > 0 {
+			subnet.ships--
+		}
+		if subnet.idle(time.Now()) {
+			delete(ac.subnets, ticket.subnet)
+		}
+	}
+}
+
+// reportFailure reports a failed handshake of the ticket's IP and bans the IP
+// if it failed too often. The subnet is banned if its IPs failed
+// admissionSubnetBanFactor times as often. Returns the banned IP or subnet, or
+// an empty string if nothing was banned.
+func (ac *admissionControl) reportFailure(ticket *admissionTicket, banAfter int) (banned string) {
+	ac.Lock()
+	defer ac.Unlock()
+
+	if banAfter <= 0 {
+		return ""
+	}
+	now := time.Now()
+
+	if state, ok := ac.ips[ticket.ip]; ok {
+		if countAdmissionFailure(now, &state.failuresSince, &state.failures, banAfter) {
+			state.bannedUntil = now.Add(admissionBanDuration)
+			banned = ticket.ip
+		}
+	}
+	if subnet, ok := ac.subnets[ticket.subnet]; ok {
+		if countAdmissionFailure(now, &subnet.failuresSince, &subnet.failures, banAfter*admissionSubnetBanFactor) {
+			subnet.bannedUntil = now.Add(admissionBanDuration)
+			banned = ticket.subnet
+		}
+	}
+
+	if banned != "" && admissionBans != nil {
+		admissionBans.Inc()
+	}
+	return banned
+}
+
+// countAdmissionFailure counts a failure within the failure window and returns
+// whether the failures reached the given limit, in which case they are reset.
+func countAdmissionFailure(now time.Time, since *time.Time, failures *int, limit int) (reached bool) {
+	if now.Sub(*since) > admissionFailureWindow {
+		*since = now
+		*failures = 0
+	}
+	*failures++
+
+	if *failures >= limit {
+		*failures = 0
+		return true
+	}
+	return false
+}
+
+// clean removes the state of IPs that are not in use anymore.
+func (ac *admissionControl) clean() {
+	ac.Lock()
+	defer ac.Unlock()
+
+	ac.cleanLocked(time.Now())
+}
+
+// cleanLocked removes the state of IPs and subnets that are not in use anymore.
+// The admission control must be locked.
+func (ac *admissionControl) cleanLocked(now time.Time) {
+	for ipKey, state := range ac.ips {
+		if state.idle(now) &&
+			now.Sub(state.handshakesSince) > admissionHandshakeWindow {
+			delete(ac.ips, ipKey)
+		}
+	}
+	for subnetKey, subnet := range ac.subnets {
+		if subnet.idle(now) {
+			delete(ac.subnets, subnetKey)
+		}
+	}
+}
+
+// evictLocked removes the state of the idle IPs that were seen least recently,
+// even if their handshake window has not yet passed.
+// The admission control must be locked.
+func (ac *admissionControl) evictLocked(now time.Time) {
+	type idleIP struct {
+		key      string
+		lastSeen time.Time
+	}
+	idle := make([]idleIP, 0, len(ac.ips))
+	for ipKey, state := range ac.ips {
+		if state.idle(now) {
+			idle = append(idle, idleIP{key: ipKey, lastSeen: state.lastSeen})
+		}
+	}
+
+	// Remove the least recently seen.
+	sort.Slice(idle, func(i, j int) bool {
+		return idle[i].lastSeen.Before(idle[j].lastSeen)
+	})
+	if len(idle) > admissionEvictBatch {
+		idle = idle[:admissionEvictBatch]
+	}
+	for _, ip := range idle {
+		delete(ac.ips, ip.key)
+	}
+}
+
+// idle returns whether the IP has no ships, is not banned and has no recent
+// failures.
+func (state *admissionIPState) idle(now time.Time) bool {
+	return state.ships == 0 &&
+		now.After(state.bannedUntil) &&
+		now.Sub(state.failuresSince) > admissionFailureWindow
+}
+
+// idle returns whether the subnet has no ships, is not banned and has no
+// recent failures.
+func (subnet *admissionSubnetState) idle(now time.Time) bool {
+	return subnet.ships == 0 &&
+		now.After(subnet.bannedUntil) &&
+		now.Sub(subnet.failuresSince) > admissionFailureWindow
+}
+
+// bans returns the amount of currently banned IPs and subnets.
+func (ac *admissionControl) bans() int {
+	ac.Lock()
+	defer ac.Unlock()
+
+	var banned int
+	now := time.Now()
+	for _, state := range ac.ips {
+		if now.Before(state.bannedUntil) {
+			banned++
+		}
+	}
+	for _, subnet := range ac.subnets {
+		if now.Before(subnet.bannedUntil) {
+			banned++
+		}
+	}
+	return banned
+}
+
+// admitShip checks if a ship from the given IP may dock, using the configured
+// limits.
+func admitShip(ip net.IP) (*admissionTicket, error) {
+	return admission.admit(
+		ip,
+		int(cfgOptionMaxShipsPerIP()),
+		int(cfgOptionMaxShipsPerSubnet()),
+		int(cfgOptionMaxHandshakesPerMinute()),
+	)
+}
+
+// checkNewConnection checks if a new connection from the given address would
+// be admitted. It is run by piers before any handshake.
+func checkNewConnection(remoteAddr net.Addr) error {
+	remoteIP, err := netutils.IPFromAddr(remoteAddr)
+	if err != nil {
+		return fmt.Errorf("failed to parse remote IP: %w", err)
+	}
+
+	return admission.check(
+		remoteIP,
+		int(cfgOptionMaxShipsPerIP()),
+		int(cfgOptionMaxShipsPerSubnet()),
+		int(cfgOptionMaxHandshakesPerMinute()),
+	)
+}
+
+// isHandshakeFailure returns whether the error returned when starting an
+// incoming crane was caused by the remote end violating the protocol.
+// Ending the crane, eg. after requesting info or verifying the Hub, and
+// disconnecting are not failures.
+func isHandshakeFailure(err error) bool {
+	switch {
+	case errors.Is(err, terminal.ErrMalformedData),
+		errors.Is(err, terminal.ErrIntegrity),
+		errors.Is(err, terminal.ErrTimeout):
+		return true
+	default:
+		return false
+	}
+}
+
+// reportFailedHandshake reports a failed handshake of an admitted ship.
+func reportFailedHandshake(ticket *admissionTicket) {
+	if banned := admission.reportFailure(ticket, int(cfgOptionBanAfterFailedHandshakes())); banned != "" {
+		log.Warningf(
+			"spn/captain: banned %s for %s after too many failed handshakes",
+			banned, admissionBanDuration,
+		)
+	}
+}
+
+func startAdmissionControl() error {
+	// Reset state, as all ships are sunk when the module stops.
+	admission.reset()
+
+	module.NewTask("clean admission state", func(_ context.Context, _ *modules.Task) error {
+		admission.clean()
+		return nil
+	}).Repeat(admissionCleanInterval)
+
+	return registerAdmissionMetrics()
+}
+
+func reportAdmissionRejection(reason string) {
+	if counter, ok := admissionRejected[reason]; ok {
+		counter.Inc()
+	}
+}
+
+func registerAdmissionMetrics() error {
+	// Only register metrics once.
+	if !admissionMetricsRegistered.SetToIf(false, true) {
+		return nil
+	}
+
+	for _, reason := range []string{
+		admissionRejectedBanned,
+		admissionRejectedIPLimit,
+		admissionRejectedSubnetLimit,
+		admissionRejectedHandshakeRate,
+		admissionRejectedStateLimit,
+	} {
+		counter, err := metrics.NewCounter(
+			"spn/piers/rejected/total",
+			map[string]string{
+				"reason": reason,
+			},
+			&metrics.Options{
+				Name:       "SPN Rejected Incoming Ships",
+				Permission: api.PermitUser,
+			},
+		)
+		if err != nil {
+			return err
+		}
+		admissionRejected[reason] = counter
+	}
+
+	var err error
+	admissionBans, err = metrics.NewCounter(
+		"spn/piers/bans/total",
+		nil,
+		&metrics.Options{
+			Name:       "SPN Banned Sources",
+			Permission: api.PermitUser,
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = metrics.NewGauge(
+		"spn/piers/bans/active",
+		nil,
+		func() float64 {
+			return float64(admission.bans())
+		},
+		&metrics.Options{
+			Name:       "SPN Active Bans",
+			Permission: api.PermitUser,
+		},
+	)
+	return err
+}
@@ -0,0 +1,295 @@
+package captain
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/safing/spn/terminal"
+)
+
+func TestAdmissionLimits(t *testing.T) {
+	t.Parallel()
+
+	ac := newAdmissionControl()
+	ip := net.ParseIP("192.0.2.1")
+
+	// Admit up to the IP limit.
+	var tickets []*admissionTicket
+	for i := 0; i < 2; i++ {
+		ticket, err := ac.admit(ip, 2, 3, 0)
+		if err != nil {
+			t.Fatalf("ship %d should be admitted: %s", i, err)
+		}
+		tickets = append(tickets, ticket)
+	}
+	if _, err := ac.admit(ip, 2, 3, 0); !errors.Is(err, ErrAdmissionIPLimit) {
+		t.Fatalf("third ship should hit the IP limit, got %v", err)
+	}
+
+	// Admit up to the subnet limit from another IP in the same subnet.
+	if _, err := ac.admit(net.ParseIP("192.0.2.2"), 2, 3, 0); err != nil {
+		t.Fatalf("ship from other IP should be admitted: %s", err)
+	}
+	if _, err := ac.admit(net.ParseIP("192.0.2.3"), 2, 3, 0); !errors.Is(err, ErrAdmissionSubnetLimit) {
+		t.Fatalf("fourth ship in subnet should hit the subnet limit, got %v", err)
+	}
+	if _, err := ac.admit(net.ParseIP("198.51.100.1"), 2, 3, 0); err != nil {
+		t.Fatalf("ship from other subnet should be admitted: %s", err)
+	}
+
+	// Releasing via the crane frees the slot, releasing twice does not.
+	ac.assign("crane1", tickets[0])
+	ac.releaseCrane("crane1")
+	ac.releaseCrane("crane1")
+	ac.releaseTicket(tickets[0])
+	if ac.ips[ip.String()].ships != 1 {
+		t.Fatalf("expected 1 ship after release, got %d", ac.ips[ip.String()].ships)
+	}
+	if ac.subnets[admissionSubnet(ip)].ships != 2 {
+		t.Fatalf("expected 2 ships in subnet after release, got %d", ac.subnets[admissionSubnet(ip)].ships)
+	}
+	if _, err := ac.admit(ip, 2, 3, 0); err != nil {
+		t.Fatalf("ship should be admitted after release: %s", err)
+	}
+
+	// Zero disables the limits.
+	for i := 0; i < 10; i++ {
+		if _, err := ac.admit(ip, 0, 0, 0); err != nil {
+			t.Fatalf("ship should be admitted without limits: %s", err)
+		}
+	}
+}
+
+func TestAdmissionHandshakeRate(t *testing.T) {
+	t.Parallel()
+
+	ac := newAdmissionControl()
+	ip := net.ParseIP("2001:db8::1")
+
+	for i := 0; i < 3; i++ {
+		ticket, err := ac.admit(ip, 0, 0, 3)
+		if err != nil {
+			t.Fatalf("handshake %d should be admitted: %s", i, err)
+		}
+		ac.releaseTicket(ticket)
+	}
+	if _, err := ac.admit(ip, 0, 0, 3); !errors.Is(err, ErrAdmissionHandshakeRate) {
+		t.Fatalf("fourth handshake should hit the rate limit, got %v", err)
+	}
+
+	// The window resets after it passed.
+	ac.ips[admissionIP(ip)].handshakesSince = time.Now().Add(-2 * admissionHandshakeWindow)
+	if _, err := ac.admit(ip, 0, 0, 3); err != nil {
+		t.Fatalf("handshake should be admitted in new window: %s", err)
+	}
+}
+
+func TestAdmissionBan(t *testing.T) {
+	t.Parallel()
+
+	ac := newAdmissionControl()
+	ip := net.ParseIP("192.0.2.1")
+
+	ticket, err := ac.admit(ip, 0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if banned := ac.reportFailure(ticket, 3); banned != "" {
+			t.Fatalf("failure %d should not ban, but banned %s", i, banned)
+		}
+	}
+	if banned := ac.reportFailure(ticket, 3); banned != ip.String() {
+		t.Fatalf("third failure should ban the IP, but banned %q", banned)
+	}
+	if ac.bans() != 1 {
+		t.Fatalf("expected 1 ban, got %d", ac.bans())
+	}
+	if _, err := ac.admit(ip, 0, 0, 0); !errors.Is(err, ErrAdmissionBanned) {
+		t.Fatalf("banned IP should be rejected, got %v", err)
+	}
+
+	// Banning is disabled with zero.
+	other, err := ac.admit(net.ParseIP("192.0.2.2"), 0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if banned := ac.reportFailure(other, 0); banned != "" {
+			t.Fatalf("banning should be disabled, but banned %s", banned)
+		}
+	}
+
+	// Bans expire.
+	ac.ips[ip.String()].bannedUntil = time.Now().Add(-time.Second)
+	if _, err := ac.admit(ip, 0, 0, 0); err != nil {
+		t.Fatalf("IP should be admitted after the ban expired: %s", err)
+	}
+}
+
+func TestAdmissionSubnetBan(t *testing.T) {
+	t.Parallel()
+
+	ac := newAdmissionControl()
+
+	// Fail handshakes from different IPs in the same subnet, without reaching
+	// the ban limit for a single IP.
+	var banned string
+	for i := 0; i < 3*admissionSubnetBanFactor && banned == ""; i++ {
+		ticket, err := ac.admit(net.IPv4(192, 0, 2, byte(i)), 0, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		banned = ac.reportFailure(ticket, 3)
+		ac.releaseTicket(ticket)
+	}
+	if banned != "192.0.2.0/24" {
+		t.Fatalf("subnet should be banned, but banned %q", banned)
+	}
+
+	// New IPs in the subnet are rejected without creating state for them.
+	ips := len(ac.ips)
+	if _, err := ac.admit(net.ParseIP("192.0.2.200"), 0, 0, 0); !errors.Is(err, ErrAdmissionBanned) {
+		t.Fatalf("IP in banned subnet should be rejected, got %v", err)
+	}
+	if len(ac.ips) != ips {
+		t.Fatalf("rejected IP should not be tracked")
+	}
+	if _, err := ac.admit(net.ParseIP("192.0.3.1"), 0, 0, 0); err != nil {
+		t.Fatalf("IP in other subnet should be admitted: %s", err)
+	}
+}
+
+func TestAdmissionIPv6Prefix(t *testing.T) {
+	t.Parallel()
+
+	ac := newAdmissionControl()
+
+	// IPs in the same /64 share their state.
+	if _, err := ac.admit(net.ParseIP("2001:db8::1"), 2, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ac.admit(net.ParseIP("2001:db8::ffff:2"), 2, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ac.admit(net.ParseIP("2001:db8::1234:3"), 2, 0, 0); !errors.Is(err, ErrAdmissionIPLimit) {
+		t.Fatalf("third ship from the same /64 should hit the IP limit, got %v", err)
+	}
+	if len(ac.ips) != 1 {
+		t.Fatalf("expected 1 tracked IP, got %d", len(ac.ips))
+	}
+
+	// Other /64 prefixes in the same /48 are tracked separately.
+	if _, err := ac.admit(net.ParseIP("2001:db8:0:1::1"), 2, 0, 0); err != nil {
+		t.Fatalf("ship from other /64 should be admitted: %s", err)
+	}
+}
+
+func TestAdmissionStateLimit(t *testing.T) {
+	t.Parallel()
+
+	ac := newAdmissionControl()
+	testIP := func(i int) net.IP {
+		return net.IPv4(10, byte(i>>16), byte(i>>8), byte(i))
+	}
+
+	// Fill the state with active IPs.
+	for i := 0; i < maxAdmissionIPStates; i++ {
+		ip := testIP(i)
+		if _, err := ac.admit(ip, 0, 0, 0); err != nil {
+			t.Fatalf("IP %s should be admitted: %s", ip, err)
+		}
+	}
+	newIP := net.ParseIP("198.51.100.1")
+	if _, err := ac.admit(newIP, 0, 0, 0); !errors.Is(err, ErrAdmissionStateLimit) {
+		t.Fatalf("new IP should hit the state limit, got %v", err)
+	}
+
+	// Idle IPs are evicted to make room, the least recently seen first, even if
+	// their handshake window has not yet passed.
+	now := time.Now()
+	for i := 0; i < 2*admissionEvictBatch; i++ {
+		state := ac.ips[testIP(i).String()]
+		state.ships = 0
+		state.lastSeen = now.Add(-time.Duration(2*admissionEvictBatch-i) * time.Second)
+	}
+	// Banned IPs are not evicted.
+	bannedIP := testIP(0).String()
+	ac.ips[bannedIP].bannedUntil = now.Add(admissionBanDuration)
+
+	if _, err := ac.admit(newIP, 0, 0, 0); err != nil {
+		t.Fatalf("new IP should be admitted after evicting idle IPs: %s", err)
+	}
+	if len(ac.ips) > maxAdmissionIPStates {
+		t.Fatalf("tracked IPs exceed limit: %d", len(ac.ips))
+	}
+	if _, ok := ac.ips[bannedIP]; !ok {
+		t.Fatal("banned IP should not be evicted")
+	}
+	if _, ok := ac.ips[testIP(1).String()]; ok {
+		t.Fatal("least recently seen idle IP should be evicted")
+	}
+	if _, ok := ac.ips[testIP(2*admissionEvictBatch-1).String()]; !ok {
+		t.Fatal("recently seen idle IP should not be evicted")
+	}
+}
+
+func TestIsHandshakeFailure(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		err     error
+		failure bool
+	}{
+		{nil, false},
+		{terminal.ErrStopping, false},
+		{terminal.ErrStopping.With("remote ended crane after request"), false},
+		{terminal.ErrShipSunk.With("waiting for crane init msg"), false},
+		{terminal.ErrCanceled, false},
+		{terminal.ErrMalformedData.With("failed to parse crane msg type"), true},
+		{terminal.ErrIntegrity.With("failed to decrypt initial packet"), true},
+		{terminal.ErrTimeout.With("waiting for crane init msg"), true},
+	} {
+		if isHandshakeFailure(tc.err) != tc.failure {
+			t.Errorf("isHandshakeFailure(%v) should be %v", tc.err, tc.failure)
+		}
+	}
+}
+
+func TestAdmissionCheck(t *testing.T) {
+	t.Parallel()
+
+	ac := newAdmissionControl()
+	ip := net.ParseIP("192.0.2.1")
+
+	// Checking does not count or track anything.
+	for i := 0; i < 3; i++ {
+		if err := ac.check(ip, 1, 0, 1); err != nil {
+			t.Fatalf("unknown IP should pass the check: %s", err)
+		}
+	}
+	if len(ac.ips) != 0 || len(ac.subnets) != 0 {
+		t.Fatal("check should not create state")
+	}
+
+	// Checking applies the limits of admitted ships.
+	ticket, err := ac.admit(ip, 1, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ac.check(ip, 1, 0, 2); !errors.Is(err, ErrAdmissionIPLimit) {
+		t.Fatalf("check should hit the IP limit, got %v", err)
+	}
+	ac.releaseTicket(ticket)
+	if err := ac.check(ip, 1, 0, 2); err != nil {
+		t.Fatalf("check should pass after release: %s", err)
+	}
+	if _, err := ac.admit(ip, 1, 0, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := ac.check(ip, 0, 0, 2); !errors.Is(err, ErrAdmissionHandshakeRate) {
+		t.Fatalf("check should hit the handshake rate, got %v", err)
+	}
+}
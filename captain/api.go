@@ -5,7 +5,6 @@ import (
 	"fmt"
 
 	"github.com/safing/portbase/api"
-	"github.com/safing/portbase/database"
 	"github.com/safing/portbase/database/query"
 	"github.com/safing/portbase/modules"
 )
@@ -43,10 +42,6 @@ func handleReInit(ar *api.Request) (msg string, err error) {
 	}
 
 	// Delete SPN cache.
-	db := database.NewInterface(&database.Options{
-		Local:    true,
-		Internal: true,
-	})
 	deletedRecords, err := db.Purge(ar.Context(), query.New("cache:spn/"))
 	if err != nil {
 		return "", fmt.Errorf("failed to delete SPN cache: %w", err)
@@ -76,7 +76,10 @@ func clientManager(ctx context.Context) error {
 		netenv.ConnectedToSPN.UnSet()
 		resetSPNStatus(StatusDisabled, true)
 		module.Resolve("")
+		clientStopStandbyHome(ctx)
 		clientStopHomeHub(ctx)
+		addSPNEvent(EventDisconnected, nil, nil, "SPN client stopped")
+		saveSPNTimeline()
 	}()
 
 	module.Hint(
@@ -98,6 +101,10 @@ func clientManager(ctx context.Context) error {
 
 	healthCheckTicker := module.NewSleepyTicker(clientHealthCheckTickDuration, clientHealthCheckTickDurationSleepMode)
 
+	// Only add a connecting event for the first attempt, so that repeated
+	// failures can be combined in the timeline.
+	var connectingEventAdded bool
+
 reconnect:
 	for {
 		// Check if we are shutting down.
@@ -113,9 +120,14 @@ reconnect:
 			log.Info("spn/captain: client not ready")
 		}
 		resetSPNStatus(StatusConnecting, true)
+		if !connectingEventAdded {
+			addSPNEvent(EventConnecting, nil, nil, "connecting to the SPN")
+			connectingEventAdded = true
+		}
 
 		// Check everything and connect to the SPN.
 		for _, clientFunc := range []clientComponentFunc{
+			clientStopStandbyHome,
 			clientStopHomeHub,
 			clientCheckNetworkReady,
 			clientCheckAccountAndTokens,
@@ -140,6 +152,7 @@ reconnect:
 
 		log.Info("spn/captain: client is ready")
 		ready.Set()
+		connectingEventAdded = false
 		netenv.ConnectedToSPN.Set()
 
 		module.TriggerEvent(SPNConnectedEvent, nil)
@@ -158,6 +171,7 @@ reconnect:
 				clientCheckHomeHubConnection,
 				clientCheckAccountAndTokens,
 				clientSetActiveConnectionStatus,
+				clientMaintainStandbyHome,
 			} {
 				switch clientFunc(ctx) {
 				case clientResultOk:
@@ -179,6 +193,7 @@ reconnect:
 			case <-crew.ConnectErrors():
 			case <-clientNetworkChangedFlag.Signal():
 				clientNetworkChangedFlag.Refresh()
+				addSPNEvent(EventNetworkChanged, nil, nil, "network changed")
 			case <-ctx.Done():
 				return nil
 			}
@@ -214,6 +229,7 @@ func clientCheckAccountAndTokens(ctx context.Context) clientComponentResult {
 			// TODO: Use special UI restart action in order to reload UI on restart.
 		).AttachToModule(module)
 		resetSPNStatus(StatusFailed, true)
+		addSPNEvent(EventInternalProblem, nil, err, "failed to get SPN user")
 		log.Errorf("spn/captain: client internal error: %s", err)
 		return clientResultReconnect
 	}
@@ -227,6 +243,7 @@ func clientCheckAccountAndTokens(ctx context.Context) clientComponentResult {
 			spnLoginButton,
 		).AttachToModule(module)
 		resetSPNStatus(StatusFailed, true)
+		addSPNEvent(EventAccountIssue, nil, nil, "not logged in")
 		log.Warningf("spn/captain: enabled but not logged in")
 		return clientResultReconnect
 	}
@@ -245,6 +262,7 @@ func clientCheckAccountAndTokens(ctx context.Context) clientComponentResult {
 					fmt.Sprintf(`The status of your SPN account could not be updated: %s`, err),
 				).AttachToModule(module)
 				resetSPNStatus(StatusFailed, true)
+				addSPNEvent(EventAccountIssue, nil, err, "failed to update ineligible account")
 				log.Errorf("spn/captain: failed to update ineligible account: %s", err)
 				return clientResultReconnect
 			}
@@ -262,6 +280,7 @@ func clientCheckAccountAndTokens(ctx context.Context) clientComponentResult {
 					spnOpenAccountPage,
 				).AttachToModule(module)
 				resetSPNStatus(StatusFailed, true)
+				addSPNEvent(EventAccountIssue, nil, nil, "package does not include the SPN")
 				return clientResultReconnect
 			}
 
@@ -277,6 +296,7 @@ func clientCheckAccountAndTokens(ctx context.Context) clientComponentResult {
 				spnOpenAccountPage,
 			).AttachToModule(module)
 			resetSPNStatus(StatusFailed, true)
+			addSPNEvent(EventAccountIssue, nil, nil, "package issue: "+message)
 			return clientResultReconnect
 		}
 	}
@@ -286,6 +306,7 @@ func clientCheckAccountAndTokens(ctx context.Context) clientComponentResult {
 		err := access.UpdateTokens()
 		if err != nil {
 			log.Errorf("spn/captain: failed to get tokens: %s", err)
+			addSPNEvent(EventAccountIssue, nil, err, "failed to get new access tokens")
 
 			// There was an error updating the account.
 			// Check if we have enough tokens to continue anyway.
@@ -297,6 +318,7 @@ func clientCheckAccountAndTokens(ctx context.Context) clientComponentResult {
 					`The Portmaster failed to get new access tokens to access the SPN. The Portmaster will automatically retry to get new access tokens.`,
 				).AttachToModule(module)
 				resetSPNStatus(StatusFailed, false)
+				addSPNEvent(EventTokensExhausted, nil, err, "access tokens exhausted")
 			}
 			return clientResultRetry
 		}
@@ -329,6 +351,7 @@ func clientConnectToHomeHub(ctx context.Context) clientComponentResult {
 	if err != nil {
 		log.Errorf("spn/captain: failed to establish connection to home hub: %s", err)
 		resetSPNStatus(StatusFailed, true)
+		addSPNEvent(EventConnectFailed, nil, err, "failed to connect to a home hub")
 
 		switch {
 		case errors.Is(err, ErrAllHomeHubsExcluded):
@@ -376,6 +399,7 @@ func clientConnectToHomeHub(ctx context.Context) clientComponentResult {
 	home, _ := navigator.Main.GetHome()
 	if home != nil {
 		log.Infof("spn/captain: established new home %s", home.Hub)
+		addSPNEvent(EventConnected, home.Hub, nil, "connected to home hub")
 	}
 
 	return clientResultOk
@@ -422,9 +446,13 @@ func clientSetActiveConnectionStatus(ctx context.Context) clientComponentResult
 func clientCheckHomeHubConnection(ctx context.Context) clientComponentResult {
 	// Check the status of the Home Hub.
 	home, homeTerminal := navigator.Main.GetHome()
-	if home == nil || homeTerminal == nil || homeTerminal.IsBeingAbandoned() {
+	if home == nil {
 		return clientResultReconnect
 	}
+	if homeTerminal == nil || homeTerminal.IsBeingAbandoned() {
+		addSPNEvent(EventHomeHubFailed, home.Hub, nil, "lost connection to home hub")
+		return clientFailoverToStandbyHome(ctx, home)
+	}
 
 	// Get crane controller for health check.
 	crane := docks.GetAssignedCrane(home.Hub.ID)
@@ -437,6 +465,7 @@ func clientCheckHomeHubConnection(ctx context.Context) clientComponentResult {
 	latency, tErr := pingHome(ctx, crane.Controller, clientHealthCheckTimeout)
 	if tErr != nil {
 		log.Warningf("spn/captain: failed to ping home hub: %s", tErr)
+		addSPNEvent(EventHomeHubFailed, home.Hub, tErr, "home hub failed health check")
 
 		// Prepare to reconnect to the network.
 
@@ -445,13 +474,30 @@ func clientCheckHomeHubConnection(ctx context.Context) clientComponentResult {
 		// Mark the home hub itself as failing, as we want to try to connect to somewhere else.
 		home.MarkAsFailingFor(5 * time.Minute)
 
-		return clientResultReconnect
+		return clientFailoverToStandbyHome(ctx, home)
 	}
 
 	log.Debugf("spn/captain: pinged home hub in %s", latency)
 	return clientResultOk
 }
 
+func clientFailoverToStandbyHome(ctx context.Context, failedHome *navigator.Pin) clientComponentResult {
+	// Check if we are shutting down.
+	if ctx.Err() != nil {
+		return clientResultShutdown
+	}
+
+	// Switch to the standby home, if available.
+	// Otherwise, reconnect to the network.
+	err := failoverToStandbyHome(failedHome)
+	if err != nil {
+		log.Warningf("spn/captain: failed to switch to standby home hub: %s", err)
+		return clientResultReconnect
+	}
+
+	return clientResultOk
+}
+
 func pingHome(ctx context.Context, t terminal.Terminal, timeout time.Duration) (latency time.Duration, err *terminal.Error) {
 	started := time.Now()
 
@@ -6,6 +6,7 @@ import (
 	"github.com/safing/portbase/config"
 	"github.com/safing/portmaster/profile"
 	"github.com/safing/portmaster/profile/endpoints"
+	"github.com/safing/spn/conf"
 	"github.com/safing/spn/navigator"
 )
 
@@ -41,6 +42,24 @@ var (
 
 	// Config options for use.
 	cfgOptionRoutingAlgorithm config.StringOption
+
+	// Admission control of public Hubs. Zero disables the limit.
+	cfgOptionMaxShipsPerIPKey                = "spn/publicHub/maxShipsPerIP"
+	cfgOptionMaxShipsPerIP                   config.IntOption
+	cfgOptionMaxShipsPerIPDefault            int64 = 10
+	cfgOptionMaxShipsPerIPOrder                    = 530
+	cfgOptionMaxShipsPerSubnetKey                  = "spn/publicHub/maxShipsPerSubnet"
+	cfgOptionMaxShipsPerSubnet               config.IntOption
+	cfgOptionMaxShipsPerSubnetDefault        int64 = 50
+	cfgOptionMaxShipsPerSubnetOrder                = 531
+	cfgOptionMaxHandshakesPerMinuteKey             = "spn/publicHub/maxHandshakesPerMinute"
+	cfgOptionMaxHandshakesPerMinute          config.IntOption
+	cfgOptionMaxHandshakesPerMinuteDefault   int64 = 20
+	cfgOptionMaxHandshakesPerMinuteOrder           = 532
+	cfgOptionBanAfterFailedHandshakesKey           = "spn/publicHub/banAfterFailedHandshakes"
+	cfgOptionBanAfterFailedHandshakes        config.IntOption
+	cfgOptionBanAfterFailedHandshakesDefault int64 = 10
+	cfgOptionBanAfterFailedHandshakesOrder         = 533
 )
 
 func prepConfig() error {
@@ -142,6 +161,77 @@ This setting mainly exists for when you need to simulate your presence in anothe
 	// Config options for use.
 	cfgOptionRoutingAlgorithm = config.Concurrent.GetAsString(profile.CfgOptionRoutingAlgorithmKey, navigator.DefaultRoutingProfileID)
 
+	if conf.PublicHub() {
+		return prepAdmissionConfig()
+	}
+	return nil
+}
+
+func prepAdmissionConfig() error {
+	err := config.Register(&config.Option{
+		Name:           "Max Ships per IP",
+		Key:            cfgOptionMaxShipsPerIPKey,
+		Description:    "Maximum number of concurrent incoming connections from a single IPv4 address or /64 IPv6 subnet. Set to 0 to disable.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		DefaultValue:   cfgOptionMaxShipsPerIPDefault,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionMaxShipsPerIPOrder,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	cfgOptionMaxShipsPerIP = config.Concurrent.GetAsInt(cfgOptionMaxShipsPerIPKey, cfgOptionMaxShipsPerIPDefault)
+
+	err = config.Register(&config.Option{
+		Name:           "Max Ships per Subnet",
+		Key:            cfgOptionMaxShipsPerSubnetKey,
+		Description:    "Maximum number of concurrent incoming connections from a single /24 IPv4 or /48 IPv6 subnet. Set to 0 to disable.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		DefaultValue:   cfgOptionMaxShipsPerSubnetDefault,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionMaxShipsPerSubnetOrder,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	cfgOptionMaxShipsPerSubnet = config.Concurrent.GetAsInt(cfgOptionMaxShipsPerSubnetKey, cfgOptionMaxShipsPerSubnetDefault)
+
+	err = config.Register(&config.Option{
+		Name:           "Max Handshakes per Minute",
+		Key:            cfgOptionMaxHandshakesPerMinuteKey,
+		Description:    "Maximum number of new incoming connections per minute from a single IPv4 address or /64 IPv6 subnet. Set to 0 to disable.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		DefaultValue:   cfgOptionMaxHandshakesPerMinuteDefault,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionMaxHandshakesPerMinuteOrder,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	cfgOptionMaxHandshakesPerMinute = config.Concurrent.GetAsInt(cfgOptionMaxHandshakesPerMinuteKey, cfgOptionMaxHandshakesPerMinuteDefault)
+
+	err = config.Register(&config.Option{
+		Name:           "Ban After Failed Handshakes",
+		Key:            cfgOptionBanAfterFailedHandshakesKey,
+		Description:    "Temporarily ban an IPv4 address or /64 IPv6 subnet after this many failed handshakes within 10 minutes. The whole /24 IPv4 or /48 IPv6 subnet is banned after four times as many. Set to 0 to disable.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		DefaultValue:   cfgOptionBanAfterFailedHandshakesDefault,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionBanAfterFailedHandshakesOrder,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	cfgOptionBanAfterFailedHandshakes = config.Concurrent.GetAsInt(cfgOptionBanAfterFailedHandshakesKey, cfgOptionBanAfterFailedHandshakesDefault)
+
 	return nil
 }
 
@@ -7,16 +7,21 @@ import (
 
 var (
 	exceptionLock sync.Mutex
-	exceptIPv4    net.IP
-	exceptIPv6    net.IP
+	exceptIPs     []net.IP
 )
 
-func setExceptions(ipv4, ipv6 net.IP) {
+// setExceptions sets the IPs that are excepted from the SPN.
+// Any previously set exceptions are replaced.
+func setExceptions(ips ...net.IP) {
 	exceptionLock.Lock()
 	defer exceptionLock.Unlock()
 
-	exceptIPv4 = ipv4
-	exceptIPv6 = ipv6
+	exceptIPs = make([]net.IP, 0, len(ips))
+	for _, ip := range ips {
+		if ip != nil {
+			exceptIPs = append(exceptIPs, ip)
+		}
+	}
 }
 
 // IsExcepted checks if the given IP is currently excepted from the SPN.
@@ -24,5 +29,10 @@ func IsExcepted(ip net.IP) bool {
 	exceptionLock.Lock()
 	defer exceptionLock.Unlock()
 
-	return ip.Equal(exceptIPv4) || ip.Equal(exceptIPv6)
+	for _, exceptIP := range exceptIPs {
+		if ip.Equal(exceptIP) {
+			return true
+		}
+	}
+	return false
 }
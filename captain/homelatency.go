@@ -0,0 +1,288 @@
+package captain
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/utils"
+	"github.com/safing/portmaster/netenv"
+	"github.com/safing/spn/docks"
+	"github.com/safing/spn/hub"
+)
+
+const (
+	// homeHubLatencyTestCandidates defines how many of the nearest home hub
+	// candidates are tested for their latency.
+	homeHubLatencyTestCandidates = 5
+
+	// homeHubLatencyTestTimeout defines how long to wait for a candidate to
+	// respond to the latency test.
+	homeHubLatencyTestTimeout = 3 * time.Second
+
+	// homeHubLatencyTTL defines how long latency test results of a network are
+	// used.
+	homeHubLatencyTTL = 1 * time.Hour
+)
+
+// homeHubLatencyCache holds the latency test results of home hub candidates
+// per network, so that results can be used again when returning to a network.
+type homeHubLatencyCache struct {
+	sync.Mutex
+
+	latencies map[homeHubLatencyKey]*homeHubLatency
+
+	network        string
+	networkKnown   bool
+	networkChanged *utils.Flag
+}
+
+type homeHubLatencyKey struct {
+	network string
+	hubID   string
+}
+
+type homeHubLatency struct {
+	latency time.Duration
+	tested  time.Time
+}
+
+var homeHubLatencies = &homeHubLatencyCache{
+	latencies:      make(map[homeHubLatencyKey]*homeHubLatency),
+	networkChanged: netenv.GetNetworkChangedFlag(),
+}
+
+// get returns the cached latency of the Hub with the given ID for the current
+// network.
+func (c *homeHubLatencyCache) get(hubID string) (latency time.Duration, ok bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.checkNetwork()
+
+	entry, ok := c.latencies[homeHubLatencyKey{network: c.network, hubID: hubID}]
+	if !ok || time.Since(entry.tested) > homeHubLatencyTTL {
+		return 0, false
+	}
+	return entry.latency, true
+}
+
+// set saves the latency of the Hub with the given ID for the current network.
+func (c *homeHubLatencyCache) set(hubID string, latency time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.checkNetwork()
+
+	// Remove expired entries of all networks.
+	for key, entry := range c.latencies {
+		if time.Since(entry.tested) > homeHubLatencyTTL {
+			delete(c.latencies, key)
+		}
+	}
+
+	c.latencies[homeHubLatencyKey{network: c.network, hubID: hubID}] = &homeHubLatency{
+		latency: latency,
+		tested:  time.Now(),
+	}
+}
+
+// checkNetwork updates the current network if it changed.
+// The cache must be locked.
+func (c *homeHubLatencyCache) checkNetwork() {
+	if !c.networkKnown || c.networkChanged.IsSet() {
+		c.networkChanged.Refresh()
+		c.network = currentNetworkID()
+		c.networkKnown = true
+	}
+}
+
+// currentNetworkID returns an identifier of the network the device is
+// connected to, derived from its gateways and assigned IPv4 addresses.
+// IPv6 addresses are not used, as temporary addresses change regularly.
+func currentNetworkID() string {
+	var ids []string
+	for _, gateway := range netenv.Gateways() {
+		ids = append(ids, gateway.String())
+	}
+	ipv4, _, err := netenv.GetAssignedAddresses()
+	if err == nil {
+		for _, ip := range ipv4 {
+			ids = append(ids, ip.String())
+		}
+	}
+	sort.Strings(ids)
+	return strings.Join(ids, ",")
+}
+
+// sortHomeHubCandidatesByLatency tests the latency to the nearest home hub
+// candidates and sorts them by the measured latency. The geoip location of the
+// device is often inaccurate, eg. with mobile carriers or corporate networks,
+// so the nearest candidates are not necessarily the fastest.
+// Candidates that could not be tested keep their order after the tested ones.
+func sortHomeHubCandidatesByLatency(ctx context.Context, candidates []*hub.Hub) []*hub.Hub {
+	testCandidates := candidates
+	if len(testCandidates) > homeHubLatencyTestCandidates {
+		testCandidates = testCandidates[:homeHubLatencyTestCandidates]
+	}
+	if len(testCandidates) < 2 {
+		return candidates
+	}
+
+	// Get latencies from cache and test the others.
+	latencies := make(map[string]time.Duration, len(testCandidates))
+	var untested []*hub.Hub
+	for _, candidate := range testCandidates {
+		if latency, ok := homeHubLatencies.get(candidate.ID); ok {
+			latencies[candidate.ID] = latency
+		} else {
+			untested = append(untested, candidate)
+		}
+	}
+	for hubID, latency := range testHomeHubLatencies(ctx, untested) {
+		homeHubLatencies.set(hubID, latency)
+		latencies[hubID] = latency
+	}
+
+	// Sort tested candidates by latency and then add the rest.
+	sorted := make([]*hub.Hub, 0, len(candidates))
+	for _, candidate := range testCandidates {
+		if _, ok := latencies[candidate.ID]; ok {
+			sorted = append(sorted, candidate)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return latencies[sorted[i].ID] < latencies[sorted[j].ID]
+	})
+	for _, candidate := range candidates {
+		if _, ok := latencies[candidate.ID]; !ok {
+			sorted = append(sorted, candidate)
+		}
+	}
+
+	if best := sorted[0]; best != candidates[0] {
+		log.Infof(
+			"spn/captain: preferring %s (%s) over nearest home hub candidate %s",
+			best, latencies[best.ID], candidates[0],
+		)
+	}
+	return sorted
+}
+
+// testHomeHubLatencies measures the round trip time to the given Hubs in
+// parallel. All Hubs are tested with the same transport protocol, so that the
+// results are comparable. Failed Hubs and Hubs that do not support the
+// protocol are not included.
+func testHomeHubLatencies(ctx context.Context, hubs []*hub.Hub) map[string]time.Duration {
+	transports := selectHomeHubLatencyTestTransports(hubs)
+	if len(transports) == 0 {
+		return nil
+	}
+
+	// Only connect to home hubs one at a time, as the exceptions are global.
+	homeHubConnectLock.Lock()
+	defer homeHubConnectLock.Unlock()
+
+	// Set and clean up exceptions for all tested Hubs.
+	exceptions := make([]net.IP, 0, len(hubs)*2)
+	for _, h := range hubs {
+		exceptions = append(exceptions, h.Info.IPv4, h.Info.IPv6)
+	}
+	setExceptions(exceptions...)
+	defer setExceptions()
+
+	ctx, cancel := context.WithTimeout(ctx, homeHubLatencyTestTimeout)
+	defer cancel()
+
+	var (
+		latencies     = make(map[string]time.Duration, len(hubs))
+		latenciesLock sync.Mutex
+		wg            sync.WaitGroup
+	)
+	for _, h := range hubs {
+		testHub := h
+		transport, ok := transports[testHub.ID]
+		if !ok {
+			continue
+		}
+
+		wg.Add(1)
+		module.StartWorker("home hub latency test", func(_ context.Context) error {
+			defer wg.Done()
+
+			latency, err := docks.MeasureHubLatency(ctx, testHub, transport)
+			if err != nil {
+				log.Debugf("spn/captain: failed to test latency of %s: %s", testHub, err)
+				return nil
+			}
+
+			latenciesLock.Lock()
+			defer latenciesLock.Unlock()
+			latencies[testHub.ID] = latency
+			return nil
+		})
+	}
+	wg.Wait()
+
+	return latencies
+}
+
+// selectHomeHubLatencyTestTransports selects the transport protocol that is
+// supported by most of the given Hubs and returns the matching transport of
+// every Hub that supports it, by Hub ID. Ties are broken by the transport
+// order of the first Hub.
+func selectHomeHubLatencyTestTransports(hubs []*hub.Hub) map[string]*hub.Transport {
+	// Get the first transport of every protocol, per Hub.
+	var protocols []string
+	hubTransports := make(map[string]map[string]*hub.Transport, len(hubs))
+	for _, h := range hubs {
+		if h.Info == nil {
+			continue
+		}
+		byProtocol := make(map[string]*hub.Transport)
+		for _, definition := range h.Info.Transports {
+			t, err := hub.ParseTransport(definition)
+			if err != nil {
+				continue
+			}
+			if _, ok := byProtocol[t.Protocol]; !ok {
+				byProtocol[t.Protocol] = t
+				protocols = append(protocols, t.Protocol)
+			}
+		}
+		hubTransports[h.ID] = byProtocol
+	}
+
+	// Select the protocol supported by most Hubs.
+	var (
+		selected     string
+		selectedHubs int
+	)
+	for _, protocol := range protocols {
+		var supported int
+		for _, byProtocol := range hubTransports {
+			if _, ok := byProtocol[protocol]; ok {
+				supported++
+			}
+		}
+		if supported > selectedHubs {
+			selected = protocol
+			selectedHubs = supported
+		}
+	}
+	if selectedHubs == 0 {
+		return nil
+	}
+
+	transports := make(map[string]*hub.Transport, selectedHubs)
+	for hubID, byProtocol := range hubTransports {
+		if t, ok := byProtocol[selected]; ok {
+			transports[hubID] = t
+		}
+	}
+	return transports
+}
@@ -0,0 +1,48 @@
+package captain
+
+import (
+	"testing"
+
+	"github.com/safing/spn/hub"
+)
+
+func TestSelectHomeHubLatencyTestTransports(t *testing.T) {
+	t.Parallel()
+
+	newHub := func(id string, transports ...string) *hub.Hub {
+		return &hub.Hub{
+			ID:   id,
+			Info: &hub.Announcement{Transports: transports},
+		}
+	}
+
+	// The protocol supported by most Hubs is selected.
+	transports := selectHomeHubLatencyTestTransports([]*hub.Hub{
+		newHub("a", "tls:443", "tcp:17"),
+		newHub("b", "tcp:18"),
+		newHub("c", "udp:17", "tcp:19"),
+	})
+	if len(transports) != 3 {
+		t.Fatalf("expected transports for 3 hubs, got %d", len(transports))
+	}
+	for hubID, port := range map[string]uint16{"a": 17, "b": 18, "c": 19} {
+		if transports[hubID].Protocol != "tcp" || transports[hubID].Port != port {
+			t.Errorf("unexpected transport for hub %s: %s", hubID, transports[hubID])
+		}
+	}
+
+	// Hubs without the selected protocol are not tested.
+	transports = selectHomeHubLatencyTestTransports([]*hub.Hub{
+		newHub("a", "tls:443", "tcp:17"),
+		newHub("b", "tls:443"),
+		newHub("c", "udp:17"),
+	})
+	if len(transports) != 2 || transports["a"].Protocol != "tls" || transports["b"].Protocol != "tls" {
+		t.Errorf("unexpected transports: %v", transports)
+	}
+
+	// Hubs without info are skipped.
+	if transports := selectHomeHubLatencyTestTransports([]*hub.Hub{{ID: "a"}}); len(transports) != 0 {
+		t.Errorf("unexpected transports: %v", transports)
+	}
+}
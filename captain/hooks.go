@@ -26,6 +26,11 @@ func handleCraneUpdate(crane *docks.Crane) {
 		triggerClientHealthCheck()
 	}
 
+	if conf.PublicHub() && crane.Stopped() {
+		// Release the admission ticket of the crane.
+		admission.releaseCrane(crane.ID)
+	}
+
 	if conf.PublicHub() && crane.Public() {
 		// Update Hub status.
 		updateConnectionStatus()
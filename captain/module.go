@@ -63,6 +63,11 @@ func prep() error {
 		return err
 	}
 
+	// Register SPN timeline provider.
+	if err := registerSPNTimelineProvider(); err != nil {
+		return err
+	}
+
 	// Register API endpoints.
 	if err := registerAPIEndpoints(); err != nil {
 		return err
@@ -158,6 +163,7 @@ func start() error {
 
 	// client + home hub manager
 	if conf.Client() {
+		startSPNTimeline()
 		module.StartServiceWorker("client manager", 0, clientManager)
 	}
 
@@ -168,6 +174,9 @@ func stop() error {
 	// Reset intel resource so that it is loaded again when starting.
 	resetSPNIntel()
 
+	// Stop saving the SPN timeline.
+	stopSPNTimeline()
+
 	// Unregister crane update hook.
 	stopDockHooks()
 
@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/safing/portbase/log"
@@ -20,6 +22,9 @@ import (
 
 const stopCraneAfterBeingUnsuggestedFor = 6 * time.Hour
 
+// homeHubConnectLock locks connecting to home hubs.
+var homeHubConnectLock sync.Mutex
+
 var (
 	// ErrAllHomeHubsExcluded is returned when all available home hubs were excluded.
 	ErrAllHomeHubsExcluded = errors.New("all home hubs are excluded")
@@ -29,10 +34,40 @@ var (
 )
 
 func establishHomeHub(ctx context.Context) error {
+	// Find home hub candidates.
+	candidates, err := findHomeHubCandidates(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	// Try connecting to a hub.
+	var tries int
+	var candidate *hub.Hub
+	for tries, candidate = range candidates {
+		err = connectToHomeHub(ctx, candidate)
+		if err != nil {
+			if errors.Is(err, terminal.ErrStopping) {
+				return err
+			}
+			log.Warningf("spn/captain: failed to connect to %s as new home: %s", candidate, err)
+		} else {
+			log.Infof("spn/captain: established connection to %s as new home with %d failed tries", candidate, tries)
+			return nil
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to a new home hub - tried %d hubs: %w", tries+1, err)
+	}
+	return fmt.Errorf("no home hub candidates available")
+}
+
+// findHomeHubCandidates returns the home hub candidates near the device.
+// If excludeHubID is set, the Hub with this ID is not included.
+func findHomeHubCandidates(ctx context.Context, excludeHubID string) ([]*hub.Hub, error) {
 	// Get own IP.
 	locations, ok := netenv.GetInternetLocation()
 	if !ok || len(locations.All) == 0 {
-		return errors.New("failed to locate own device")
+		return nil, errors.New("failed to locate own device")
 	}
 	log.Debugf(
 		"spn/captain: looking for new home hub near %s and %s",
@@ -57,7 +92,7 @@ func establishHomeHub(ctx context.Context) error {
 	// Get home hub policy for selecting the home hub.
 	homePolicy, err := getHomeHubPolicy()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Build navigation options for searching for a home hub.
@@ -77,6 +112,16 @@ func establishHomeHub(ctx context.Context) error {
 		opts.Regard = opts.Regard.Add(navigator.StateTrusted)
 	}
 
+	// Exclude the given Hub.
+	if excludeHubID != "" {
+		opts.HubPolicies = append(opts.HubPolicies, []endpoints.Endpoint{
+			&endpoints.EndpointDomain{
+				OriginalValue: excludeHubID,
+				Domain:        strings.ToLower(excludeHubID) + ".",
+			},
+		})
+	}
+
 	// Find nearby hubs.
 findCandidates:
 	candidates, err := navigator.Main.FindNearestHubs(
@@ -90,43 +135,63 @@ findCandidates:
 			// bootstrap to the network!
 			err := bootstrapWithUpdates()
 			if err != nil {
-				return err
+				return nil, err
 			}
 			goto findCandidates
 
 		case errors.Is(err, navigator.ErrAllPinsDisregarded):
 			if len(homePolicy) > 0 {
-				return ErrAllHomeHubsExcluded
+				return nil, ErrAllHomeHubsExcluded
 			}
-			return ErrReInitSPNSuggested
+			return nil, ErrReInitSPNSuggested
 
 		default:
-			return fmt.Errorf("failed to find nearby hubs: %w", err)
+			return nil, fmt.Errorf("failed to find nearby hubs: %w", err)
 		}
 	}
 
-	// Try connecting to a hub.
-	var tries int
-	var candidate *hub.Hub
-	for tries, candidate = range candidates {
-		err = connectToHomeHub(ctx, candidate)
-		if err != nil {
-			if errors.Is(err, terminal.ErrStopping) {
-				return err
-			}
-			log.Warningf("spn/captain: failed to connect to %s as new home: %s", candidate, err)
-		} else {
-			log.Infof("spn/captain: established connection to %s as new home with %d failed tries", candidate, tries)
-			return nil
-		}
+	// Prefer the candidates with the lowest measured latency, as the location
+	// of the device may be inaccurate.
+	return sortHomeHubCandidatesByLatency(ctx, candidates), nil
+}
+
+func connectToHomeHub(ctx context.Context, dst *hub.Hub) error {
+	// Connect and authenticate to hub.
+	crane, homeTerminal, err := prepareHomeHub(ctx, dst)
+	if err != nil {
+		return err
 	}
+
+	// Use as new home.
+	err = activateHomeHub(crane, homeTerminal)
 	if err != nil {
-		return fmt.Errorf("failed to connect to a new home hub - tried %d hubs: %w", tries+1, err)
+		crane.Stop(nil)
+		return err
 	}
-	return fmt.Errorf("no home hub candidates available")
+
+	return nil
 }
 
-func connectToHomeHub(ctx context.Context, dst *hub.Hub) error {
+// prepareHomeHub connects and authenticates to the given Hub, so that it is
+// ready to be used as the home hub.
+func prepareHomeHub(ctx context.Context, dst *hub.Hub) (*docks.Crane, *docks.CraneTerminal, error) {
+	crane, err := connectHomeHub(ctx, dst)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	homeTerminal, err := authenticateHomeHub(ctx, crane)
+	if err != nil {
+		crane.Stop(nil)
+		return nil, nil, err
+	}
+
+	return crane, homeTerminal, nil
+}
+
+// connectHomeHub connects to the given Hub and queries the gossip messages,
+// but does not authenticate yet.
+func connectHomeHub(ctx context.Context, dst *hub.Hub) (*docks.Crane, error) {
 	// Create new context with timeout.
 	// The maximum timeout is a worst case safeguard.
 	// Keep in mind that multiple IPs and protocols may be tried in all configurations.
@@ -134,24 +199,20 @@ func connectToHomeHub(ctx context.Context, dst *hub.Hub) error {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
 	defer cancel()
 
+	// Only connect to one home hub at a time, as the exceptions are global.
+	homeHubConnectLock.Lock()
+	defer homeHubConnectLock.Unlock()
+
 	// Set and clean up exceptions.
 	setExceptions(dst.Info.IPv4, dst.Info.IPv6)
-	defer setExceptions(nil, nil)
+	defer setExceptions()
 
 	// Connect to hub.
 	crane, err := EstablishCrane(ctx, dst)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Cleanup connection in case of failure.
-	var success bool
-	defer func() {
-		if !success {
-			crane.Stop(nil)
-		}
-	}()
-
 	// Query all gossip msgs on first connection.
 	gossipQuery, tErr := NewGossipQueryOp(crane.Controller)
 	if tErr != nil {
@@ -161,37 +222,50 @@ func connectToHomeHub(ctx context.Context, dst *hub.Hub) error {
 	select {
 	case <-gossipQuery.ctx.Done():
 	case <-ctx.Done():
-		return context.Canceled
+		crane.Stop(nil)
+		return nil, context.Canceled
 	}
 
+	return crane, nil
+}
+
+// authenticateHomeHub creates the home terminal on the given crane and
+// authenticates to the Hub. This uses up an access token.
+// The crane must be stopped by the caller if authenticating fails.
+func authenticateHomeHub(ctx context.Context, crane *docks.Crane) (*docks.CraneTerminal, error) {
 	// Create communication terminal.
 	homeTerminal, initData, tErr := docks.NewLocalCraneTerminal(crane, nil, terminal.DefaultHomeHubTerminalOpts())
 	if tErr != nil {
-		return tErr.Wrap("failed to create home terminal")
+		return nil, tErr.Wrap("failed to create home terminal")
 	}
 	tErr = crane.EstablishNewTerminal(homeTerminal, initData)
 	if tErr != nil {
-		return tErr.Wrap("failed to connect home terminal")
+		return nil, tErr.Wrap("failed to connect home terminal")
 	}
 
 	// Authenticate to home hub.
 	authOp, tErr := access.AuthorizeToTerminal(homeTerminal)
 	if tErr != nil {
-		return tErr.Wrap("failed to authorize")
+		return nil, tErr.Wrap("failed to authorize")
 	}
 	select {
 	case tErr := <-authOp.Result:
 		if !tErr.Is(terminal.ErrExplicitAck) {
-			return tErr.Wrap("failed to authenticate to")
+			return nil, tErr.Wrap("failed to authenticate to")
 		}
 	case <-time.After(3 * time.Second):
-		return terminal.ErrTimeout.With("waiting for auth to complete")
+		return nil, terminal.ErrTimeout.With("waiting for auth to complete")
 	case <-ctx.Done():
-		return terminal.ErrStopping
+		return nil, terminal.ErrStopping
 	}
 
+	return homeTerminal, nil
+}
+
+// activateHomeHub sets the Hub of the given prepared crane as the new home.
+func activateHomeHub(crane *docks.Crane, homeTerminal *docks.CraneTerminal) error {
 	// Set new home on map.
-	ok := navigator.Main.SetHome(dst.ID, homeTerminal)
+	ok := navigator.Main.SetHome(crane.ConnectedHub.ID, homeTerminal)
 	if !ok {
 		return fmt.Errorf("failed to set home hub on map")
 	}
@@ -199,7 +273,6 @@ func connectToHomeHub(ctx context.Context, dst *hub.Hub) error {
 	// Assign crane to home hub in order to query it later.
 	docks.AssignCrane(crane.ConnectedHub.ID, crane)
 
-	success = true
 	return nil
 }
 
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/safing/portbase/log"
 	"github.com/safing/portbase/modules"
@@ -15,19 +16,65 @@ import (
 	"github.com/safing/spn/ships"
 )
 
+const (
+	// managePiersInterval defines how often the piers are checked.
+	managePiersInterval = 5 * time.Minute
+
+	// pierRestartMinBackoff and pierRestartMaxBackoff define the range of the
+	// delay before restarting a failed pier. The delay is doubled with every
+	// consecutive failure.
+	pierRestartMinBackoff = 5 * time.Second
+	pierRestartMaxBackoff = 5 * time.Minute
+
+	// pierStableAfter defines how long a pier must run until previous failures
+	// are forgotten.
+	pierStableAfter = 10 * time.Minute
+)
+
 var (
 	managePiersTask *modules.Task
 	pierMgmtLock    sync.Mutex
-	pierMgmtCycleID int
+
+	// managedPiers holds all piers that should be running, by transport.
+	managedPiers = make(map[string]*managedPier)
 
 	dockingRequests = make(chan *ships.DockingRequest, 10)
 )
 
+// managedPier is a pier that is supervised and restarted when it fails.
+type managedPier struct {
+	transport *hub.Transport
+
+	// pier is the running pier. It is nil while the pier is not running.
+	pier    ships.Pier
+	started time.Time
+
+	// failures holds the amount of consecutive failures.
+	failures  int
+	restartAt time.Time
+}
+
 func startPierMgmt() error {
+	// Reset piers, as they are stopped with the module.
+	pierMgmtLock.Lock()
+	managedPiers = make(map[string]*managedPier)
+	pierMgmtLock.Unlock()
+
 	managePiersTask = module.NewTask(
 		"manage piers",
 		managePiers,
-	)
+	).Repeat(managePiersInterval)
+
+	// Set identity for piers that authenticate the Hub on the transport layer.
+	if err := ships.SetIdentity(publicIdentity.Signet); err != nil {
+		log.Warningf("spn/captain: failed to set identity for piers: %s", err)
+	}
+
+	if err := startAdmissionControl(); err != nil {
+		return err
+	}
+	// Check new connections before any handshake.
+	ships.SetAcceptCheck(checkNewConnection)
 
 	module.StartServiceWorker("docking request handler", 0, dockingRequestHandler)
 
@@ -39,41 +86,136 @@ func startPierMgmt() error {
 	return nil
 }
 
+// managePiers reconciles the running piers with the transports of the Hub.
+// Piers of removed transports are abolished, piers of new transports are
+// established and failed piers are restarted after their backoff.
 func managePiers(ctx context.Context, task *modules.Task) error {
 	pierMgmtLock.Lock()
 	defer pierMgmtLock.Unlock()
 
-	// TODO: do proper management (this is a workaround for now)
-	if pierMgmtCycleID > 0 {
-		return nil
-	}
-	pierMgmtCycleID = 1
-
+	// Get transports from the current announcement.
+	transports := make(map[string]*hub.Transport, len(publicIdentity.Hub.Info.Transports))
 	for _, t := range publicIdentity.Hub.Info.Transports {
 		transport, err := hub.ParseTransport(t)
 		if err != nil {
 			log.Warningf("spn/captain: cannot build pier for invalid transport %q: %s", t, err)
 			continue
 		}
+		transports[transport.String()] = transport
+	}
+
+	// Abolish piers of removed transports.
+	for key, mp := range managedPiers {
+		if _, ok := transports[key]; ok {
+			continue
+		}
+		if mp.pier != nil {
+			mp.pier.Abolish()
+		}
+		delete(managedPiers, key)
+		log.Infof("spn/captain: pier for transport %q abolished", key)
+	}
 
-		// create listener
+	// Establish piers of new and failed transports.
+	var nextRestart time.Time
+	for key, transport := range transports {
+		mp, ok := managedPiers[key]
+		if !ok {
+			mp = &managedPier{
+				transport: transport,
+			}
+			managedPiers[key] = mp
+		}
+
+		// Check if pier is running or waiting for restart.
+		switch {
+		case mp.pier != nil:
+			continue
+		case time.Now().Before(mp.restartAt):
+			if nextRestart.IsZero() || mp.restartAt.Before(nextRestart) {
+				nextRestart = mp.restartAt
+			}
+			continue
+		}
+
+		// Create listener.
 		pier, err := ships.EstablishPier(transport, dockingRequests)
 		if err != nil {
-			log.Warningf("spn/captin: failed to establish pier for transport %q: %s", t, err)
+			mp.markFailed()
+			if nextRestart.IsZero() || mp.restartAt.Before(nextRestart) {
+				nextRestart = mp.restartAt
+			}
+			log.Warningf(
+				"spn/captain: failed to establish pier for transport %q (retrying in %s): %s",
+				key, time.Until(mp.restartAt).Round(time.Second), err,
+			)
 			continue
 		}
-		log.Infof("spn/captain: pier for transport %q built", t)
+		mp.pier = pier
+		mp.started = time.Now()
+		log.Infof("spn/captain: pier for transport %q built", key)
 
-		// start accepting connections
+		// Start accepting connections.
 		module.StartWorker("pier docking", pier.Docking)
 	}
 
-	// TODO:
-	// task.Schedule(5 * time.Minute)
+	// Schedule restart of failed piers.
+	if !nextRestart.IsZero() {
+		task.Schedule(nextRestart)
+	}
 
 	return nil
 }
 
+// markFailed marks the pier as failed and sets the time to restart it.
+// The pier management lock must be held.
+func (mp *managedPier) markFailed() {
+	// Forget previous failures if the pier was running stable.
+	if !mp.started.IsZero() && time.Since(mp.started) > pierStableAfter {
+		mp.failures = 0
+	}
+
+	mp.pier = nil
+	mp.failures++
+
+	// Double the backoff with every consecutive failure.
+	backoff := pierRestartMinBackoff
+	for i := 1; i < mp.failures && backoff < pierRestartMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > pierRestartMaxBackoff {
+		backoff = pierRestartMaxBackoff
+	}
+	mp.restartAt = time.Now().Add(backoff)
+}
+
+// handlePierFailure marks the given pier as failed and schedules its restart.
+func handlePierFailure(pier ships.Pier, err error) {
+	pierMgmtLock.Lock()
+	defer pierMgmtLock.Unlock()
+
+	for _, mp := range managedPiers {
+		// Compare by transport, as the pier reporting the failure may be the
+		// embedded pier base instead of the managed pier.
+		if mp.pier == nil || mp.pier.Transport() != pier.Transport() {
+			continue
+		}
+
+		mp.markFailed()
+		log.Errorf(
+			"spn/captain: pier %s failed (restarting in %s): %s",
+			pier.Transport(), time.Until(mp.restartAt).Round(time.Second), err,
+		)
+		if managePiersTask != nil {
+			managePiersTask.Schedule(mp.restartAt)
+		}
+		return
+	}
+
+	// Pier is not managed anymore.
+	log.Warningf("spn/captain: unmanaged pier %s failed: %s", pier.Transport(), err)
+}
+
 func dockingRequestHandler(ctx context.Context) error {
 	for {
 		select {
@@ -82,14 +224,21 @@ func dockingRequestHandler(ctx context.Context) error {
 		case r := <-dockingRequests:
 			switch {
 			case r.Err != nil:
-				// TODO: Restart pier?
-				// TODO: Do actual pier management.
-				log.Errorf("spn/captain: pier %s failed: %s", r.Pier.Transport(), r.Err)
+				handlePierFailure(r.Pier, r.Err)
 			case r.Ship != nil:
+				ticket, err := checkDockingAdmission(r.Ship)
+				if err != nil {
+					// Only log on debug level, as this may be triggered at a high rate.
+					log.Debugf("spn/captain: rejected %s at pier %s: %s", r.Ship, r.Pier.Transport(), err)
+					r.Ship.Sink()
+					continue
+				}
 				if err := checkDockingPermission(ctx, r.Ship); err != nil {
 					log.Warningf("spn/captain: denied ship from %s to dock at pier %s: %s", r.Ship.RemoteAddr(), r.Pier.Transport(), err)
+					r.Ship.Sink()
+					admission.releaseTicket(ticket)
 				} else {
-					handleDockingRequest(r.Ship)
+					handleDockingRequest(r.Ship, ticket)
 				}
 			default:
 				log.Warningf("spn/captain: received invalid docking request without ship for pier %s", r.Pier.Transport())
@@ -98,6 +247,17 @@ func dockingRequestHandler(ctx context.Context) error {
 	}
 }
 
+// checkDockingAdmission checks if the ship is within the admission limits and
+// returns an admission ticket, which must be released when the ship is gone.
+func checkDockingAdmission(ship ships.Ship) (*admissionTicket, error) {
+	remoteIP, err := netutils.IPFromAddr(ship.RemoteAddr())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse remote IP: %w", err)
+	}
+
+	return admitShip(remoteIP)
+}
+
 func checkDockingPermission(ctx context.Context, ship ships.Ship) error {
 	remoteIP, err := netutils.IPFromAddr(ship.RemoteAddr())
 	if err != nil {
@@ -120,18 +280,25 @@ func checkDockingPermission(ctx context.Context, ship ships.Ship) error {
 	return nil
 }
 
-func handleDockingRequest(ship ships.Ship) {
+func handleDockingRequest(ship ships.Ship, ticket *admissionTicket) {
 	log.Infof("spn/captain: pemitting %s to dock", ship)
 
 	crane, err := docks.NewCrane(ship, nil, publicIdentity)
 	if err != nil {
 		log.Warningf("spn/captain: failed to commission crane for %s: %s", ship, err)
+		ship.Sink()
+		admission.releaseTicket(ticket)
 		return
 	}
+	// Release the admission ticket when the crane stops.
+	admission.assign(crane.ID, ticket)
 
 	module.StartWorker("start crane", func(ctx context.Context) error {
-		_ = crane.Start(ctx)
-		// Crane handles errors internally.
+		if err := crane.Start(ctx); isHandshakeFailure(err) {
+			// Crane handles errors internally, but count protocol failures for
+			// banning.
+			reportFailedHandshake(ticket)
+		}
 		return nil
 	})
 }
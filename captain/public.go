@@ -168,6 +168,8 @@ func maintainPublicStatus(ctx context.Context, task *modules.Task) error {
 			ID:       crane.ConnectedHub.ID,
 			Latency:  latency,
 			Capacity: capacity,
+			Jitter:   measurements.GetJitter(),
+			Loss:     measurements.GetLoss(),
 		})
 	}
 	// Sort Lanes for comparing.
@@ -193,7 +195,7 @@ func maintainPublicStatus(ctx context.Context, task *modules.Task) error {
 	}
 
 	// Set flags.
-	var flags []string
+	flags := []string{hub.FlagConnectResume}
 	if !patrol.HTTPSConnectivityConfirmed() {
 		flags = append(flags, hub.FlagNetError)
 	}
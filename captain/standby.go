@@ -0,0 +1,259 @@
+package captain
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/tevino/abool"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/spn/crew"
+	"github.com/safing/spn/docks"
+	"github.com/safing/spn/hub"
+	"github.com/safing/spn/navigator"
+)
+
+// standbyHome is an authenticated connection to a second home hub candidate,
+// which is kept warm in order to quickly switch to it when the current home
+// hub fails. Authenticating uses up an access token, so the home terminal is
+// pinged regularly to keep it from running into its idle timeout. This way,
+// the standby is only replaced, and a token is only used, when it fails.
+type standbyHome struct {
+	hub          *hub.Hub
+	crane        *docks.Crane
+	homeTerminal *docks.CraneTerminal
+}
+
+var (
+	standby     *standbyHome
+	standbyLock sync.Mutex
+
+	standbyEstablishing       = abool.New()
+	standbyEstablishingCancel context.CancelFunc
+
+	// standbyHealthCheckTimeout is shorter than the home hub health check
+	// timeout, as the standby is not yet in use and can easily be replaced.
+	standbyHealthCheckTimeout = 5 * time.Second
+
+	// standbyRefreshInterval defines how often the home terminal of the standby
+	// is pinged. It must be shorter than the idle timeout of terminals, which
+	// is about 5 minutes by default, and is independent of the client health check,
+	// as that only runs every 5 minutes in sleep mode.
+	standbyRefreshInterval = 2 * time.Minute
+)
+
+func getStandbyHome() *standbyHome {
+	standbyLock.Lock()
+	defer standbyLock.Unlock()
+
+	return standby
+}
+
+// takeStandbyHome removes the standby home and returns it.
+func takeStandbyHome() *standbyHome {
+	standbyLock.Lock()
+	defer standbyLock.Unlock()
+
+	sb := standby
+	standby = nil
+	return sb
+}
+
+func (sb *standbyHome) isUsable() bool {
+	return !sb.crane.Stopped() &&
+		!sb.crane.IsStopping() &&
+		sb.homeTerminal.Ctx().Err() == nil
+}
+
+// refresh pings the standby home through its home terminal until the standby
+// fails or is stopped. This keeps the home terminal from running into its idle
+// timeout and detects failed standbys.
+func (sb *standbyHome) refresh(ctx context.Context) error {
+	ticker := time.NewTicker(standbyRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-sb.homeTerminal.Ctx().Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		// Stop refreshing when the standby was removed or is now the home hub.
+		if getStandbyHome() != sb {
+			return nil
+		}
+
+		_, tErr := pingHome(ctx, sb.homeTerminal, standbyHealthCheckTimeout)
+		if tErr != nil {
+			// Stop the failed standby, it is replaced by the next health check.
+			log.Warningf("spn/captain: failed to ping standby home hub %s: %s", sb.hub, tErr)
+			sb.crane.Stop(nil)
+			return nil
+		}
+	}
+}
+
+// clientMaintainStandbyHome checks the standby home and starts establishing a
+// new one in the background if there is none or if it failed.
+func clientMaintainStandbyHome(ctx context.Context) clientComponentResult {
+	// Check existing standby home.
+	if sb := getStandbyHome(); sb != nil {
+		if sb.isUsable() {
+			return clientResultOk
+		}
+
+		// Remove failed standby home.
+		clientStopStandbyHome(ctx)
+	}
+
+	// Establish a new standby home in the background.
+	if !standbyEstablishing.SetToIf(false, true) {
+		return clientResultOk
+	}
+	standbyCtx, cancel := context.WithCancel(ctx)
+	standbyLock.Lock()
+	standbyEstablishingCancel = cancel
+	standbyLock.Unlock()
+
+	module.StartWorker("establish standby home hub", func(_ context.Context) error {
+		defer func() {
+			standbyLock.Lock()
+			standbyEstablishingCancel = nil
+			standbyLock.Unlock()
+			cancel()
+			standbyEstablishing.UnSet()
+		}()
+
+		err := establishStandbyHome(standbyCtx)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			log.Warningf("spn/captain: failed to establish standby home hub: %s", err)
+		}
+		return nil
+	})
+
+	return clientResultOk
+}
+
+// establishStandbyHome connects and authenticates to the best home hub
+// candidate other than the current home hub and keeps it as the standby home.
+func establishStandbyHome(ctx context.Context) error {
+	home, _ := navigator.Main.GetHome()
+	if home == nil {
+		return navigator.ErrHomeHubUnset
+	}
+
+	// Find home hub candidates, excluding the current home hub.
+	candidates, err := findHomeHubCandidates(ctx, home.Hub.ID)
+	if err != nil {
+		return err
+	}
+
+	// Try connecting to a hub.
+	for _, candidate := range candidates {
+		// Skip Hubs we are already connected to via a crane.
+		if docks.GetAssignedCrane(candidate.ID) != nil {
+			continue
+		}
+
+		crane, err := connectHomeHub(ctx, candidate)
+		if err != nil {
+			if ctx.Err() != nil {
+				return context.Canceled
+			}
+			log.Debugf("spn/captain: failed to connect to %s as standby home: %s", candidate, err)
+			continue
+		}
+
+		// Authenticate now, so that failing over does not wait for it.
+		homeTerminal, err := authenticateHomeHub(ctx, crane)
+		if err != nil {
+			crane.Stop(nil)
+			if ctx.Err() != nil {
+				return context.Canceled
+			}
+			log.Debugf("spn/captain: failed to authenticate to %s as standby home: %s", candidate, err)
+			continue
+		}
+
+		// Save as standby, if establishing was not canceled in the meantime.
+		standbyLock.Lock()
+		if ctx.Err() != nil {
+			standbyLock.Unlock()
+			crane.Stop(nil)
+			return context.Canceled
+		}
+		sb := &standbyHome{
+			hub:          candidate,
+			crane:        crane,
+			homeTerminal: homeTerminal,
+		}
+		standby = sb
+		standbyLock.Unlock()
+		module.StartWorker("refresh standby home hub", sb.refresh)
+
+		log.Infof("spn/captain: established connection to %s as standby home", candidate)
+		addSPNEvent(EventStandbyConnected, candidate, nil, "connected to standby home hub")
+		return nil
+	}
+
+	return errors.New("no standby home hub candidates available")
+}
+
+// clientStopStandbyHome stops the standby home and any ongoing attempt to
+// establish one.
+func clientStopStandbyHome(ctx context.Context) clientComponentResult {
+	// Don't use the context in this function, as it will likely be canceled
+	// already and would disrupt any context usage in here.
+
+	standbyLock.Lock()
+	defer standbyLock.Unlock()
+
+	if standbyEstablishingCancel != nil {
+		standbyEstablishingCancel()
+	}
+	if standby != nil {
+		standby.crane.Stop(nil)
+		standby = nil
+	}
+
+	return clientResultOk
+}
+
+// failoverToStandbyHome switches the home hub to the already authenticated
+// standby home and re-establishes the expansions of the previous home hub in
+// the background.
+func failoverToStandbyHome(failedHome *navigator.Pin) error {
+	sb := takeStandbyHome()
+	if sb == nil {
+		return errors.New("no standby home hub available")
+	}
+	if !sb.isUsable() {
+		sb.crane.Stop(nil)
+		return errors.New("standby home hub connection failed")
+	}
+
+	// Remember the Hubs we are connected to via the failed home hub.
+	expandedTo := navigator.Main.GetActiveTerminalHubs()
+
+	// Switch to standby.
+	if err := activateHomeHub(sb.crane, sb.homeTerminal); err != nil {
+		sb.crane.Stop(nil)
+		return err
+	}
+	log.Infof("spn/captain: switched home from %s to standby %s", failedHome.Hub, sb.hub)
+	addSPNEvent(EventHomeHubSwitched, sb.hub, nil, "switched to standby home hub")
+
+	// Stop the crane of the failed home hub and all terminals using it.
+	if crane := docks.GetAssignedCrane(failedHome.Hub.ID); crane != nil {
+		crane.Stop(nil)
+	}
+
+	// Re-establish expansions via the new home hub.
+	crew.ReestablishExpansions(expandedTo)
+	return nil
+}
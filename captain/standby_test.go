@@ -0,0 +1,22 @@
+package captain
+
+import (
+	"testing"
+
+	"github.com/safing/spn/hub"
+	"github.com/safing/spn/navigator"
+)
+
+func TestFailoverWithoutStandbyHome(t *testing.T) {
+	// Not parallel, as the global standby home is used.
+
+	if getStandbyHome() != nil {
+		t.Fatal("there should be no standby home in tests")
+	}
+
+	// Failing over without a standby must fail, so that the client reconnects.
+	failedHome := &navigator.Pin{Hub: &hub.Hub{ID: "failed"}}
+	if err := failoverToStandbyHome(failedHome); err == nil {
+		t.Error("failover without standby home should fail")
+	}
+}
@@ -147,4 +147,9 @@ func AddToDebugInfo(di *debug.Info) {
 		debug.UseCodeSection|debug.AddContentLineBreaks,
 		lines...,
 	)
+
+	// Add latest client events.
+	if conf.Client() {
+		addSPNTimelineToDebugInfo(di)
+	}
 }
@@ -0,0 +1,329 @@
+package captain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/api"
+	"github.com/safing/portbase/database"
+	"github.com/safing/portbase/database/record"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/modules"
+	"github.com/safing/portbase/runtime"
+	"github.com/safing/portbase/utils/debug"
+	"github.com/safing/spn/hub"
+	"github.com/safing/spn/terminal"
+)
+
+// Database Keys:
+// SPN Timeline: core:spn/timeline
+const spnTimelineDBKey = "core:spn/timeline"
+
+var db = database.NewInterface(&database.Options{
+	Local:    true,
+	Internal: true,
+})
+
+const (
+	// maxSPNTimelineEvents defines how many events are kept in the timeline.
+	maxSPNTimelineEvents = 250
+
+	// spnTimelineSaveDelay defines how long to wait before saving the timeline
+	// after an event, in order to combine multiple events into one write.
+	spnTimelineSaveDelay = 1 * time.Minute
+
+	// spnTimelineDebugInfoEvents defines how many of the latest events are
+	// added to the debug info.
+	spnTimelineDebugInfoEvents = 25
+)
+
+// SPNTimeline holds the latest events of the SPN client.
+type SPNTimeline struct {
+	record.Base
+	sync.Mutex
+
+	Events []*SPNEvent
+}
+
+// SPNEvent is an event of the SPN client.
+type SPNEvent struct {
+	// Time is when the event occurred. If the event was repeated, it is when
+	// the event last occurred.
+	Time time.Time
+	// FirstTime is when a repeated event first occurred.
+	FirstTime *time.Time `json:",omitempty"`
+	// Repeated holds how often the event occurred after the first time.
+	Repeated int `json:",omitempty"`
+
+	Type    SPNEventType
+	Message string
+
+	HubID   string `json:",omitempty"`
+	HubName string `json:",omitempty"`
+
+	// Error holds the error of the event, if any.
+	Error string `json:",omitempty"`
+	// ErrorCode holds the ID of the terminal error, if the error is one.
+	ErrorCode uint8 `json:",omitempty"`
+}
+
+// SPNEventType is a type of SPN event.
+type SPNEventType string
+
+// SPN Event Types.
+const (
+	EventConnecting       SPNEventType = "connecting"
+	EventConnected        SPNEventType = "connected"
+	EventConnectFailed    SPNEventType = "connect-failed"
+	EventHomeHubFailed    SPNEventType = "home-hub-failed"
+	EventHomeHubSwitched  SPNEventType = "home-hub-switched"
+	EventAccountIssue     SPNEventType = "account-issue"
+	EventTokensExhausted  SPNEventType = "tokens-exhausted"
+	EventNetworkChanged   SPNEventType = "network-changed"
+	EventDisconnected     SPNEventType = "disconnected"
+	EventInternalProblem  SPNEventType = "internal-problem"
+	EventStandbyConnected SPNEventType = "standby-connected"
+)
+
+var (
+	spnTimeline = &SPNTimeline{
+		Events: make([]*SPNEvent, 0, maxSPNTimelineEvents),
+	}
+	spnTimelinePushFunc runtime.PushFunc
+)
+
+func registerSPNTimelineProvider() (err error) {
+	spnTimeline.SetKey("runtime:spn/timeline")
+	spnTimeline.UpdateMeta()
+	spnTimelinePushFunc, err = runtime.Register("spn/timeline", runtime.ProvideRecord(spnTimeline))
+	if err != nil {
+		return err
+	}
+
+	return api.RegisterEndpoint(api.Endpoint{
+		Path:        `spn/timeline`,
+		Read:        api.PermitUser,
+		BelongsTo:   module,
+		StructFunc:  handleSPNTimelineRequest,
+		Name:        "Get SPN timeline",
+		Description: "Returns the latest events of the SPN client, such as connect attempts, failures and home hub switches.",
+	})
+}
+
+func handleSPNTimelineRequest(ar *api.Request) (i interface{}, err error) {
+	return GetSPNTimeline(), nil
+}
+
+// GetSPNTimeline returns a copy of the latest events of the SPN client.
+func GetSPNTimeline() []*SPNEvent {
+	spnTimeline.Lock()
+	defer spnTimeline.Unlock()
+
+	return copySPNEvents(spnTimeline.Events)
+}
+
+// copySPNEvents returns a copy of the given events, so that they can be used
+// without holding the timeline lock.
+func copySPNEvents(events []*SPNEvent) []*SPNEvent {
+	copied := make([]*SPNEvent, 0, len(events))
+	for _, event := range events {
+		copiedEvent := *event
+		copied = append(copied, &copiedEvent)
+	}
+	return copied
+}
+
+// addSPNEvent adds an event to the SPN timeline.
+// The Hub and error are optional.
+func addSPNEvent(eventType SPNEventType, h *hub.Hub, err error, message string) {
+	event := &SPNEvent{
+		Time:    time.Now(),
+		Type:    eventType,
+		Message: message,
+	}
+	if h != nil {
+		event.HubID = h.ID
+		event.HubName = h.Name()
+	}
+	if err != nil {
+		event.Error = err.Error()
+		var tErr *terminal.Error
+		if errors.As(err, &tErr) {
+			event.ErrorCode = tErr.ID()
+		}
+	}
+
+	spnTimeline.Lock()
+	defer spnTimeline.Unlock()
+
+	// Combine with the last event, if it is the same.
+	// This keeps the timeline readable when retrying in a loop.
+	if len(spnTimeline.Events) > 0 {
+		last := spnTimeline.Events[len(spnTimeline.Events)-1]
+		if last.isRepeatedBy(event) {
+			if last.FirstTime == nil {
+				firstTime := last.Time
+				last.FirstTime = &firstTime
+			}
+			last.Time = event.Time
+			last.Repeated++
+			pushSPNTimelineUpdate()
+			return
+		}
+	}
+
+	// Add new event and remove the oldest events if over the limit.
+	spnTimeline.Events = append(spnTimeline.Events, event)
+	if len(spnTimeline.Events) > maxSPNTimelineEvents {
+		spnTimeline.Events = spnTimeline.Events[len(spnTimeline.Events)-maxSPNTimelineEvents:]
+	}
+	pushSPNTimelineUpdate()
+}
+
+func (event *SPNEvent) isRepeatedBy(other *SPNEvent) bool {
+	return event.Type == other.Type &&
+		event.Message == other.Message &&
+		event.HubID == other.HubID &&
+		event.Error == other.Error
+}
+
+// pushSPNTimelineUpdate pushes an update of spnTimeline, which must be locked,
+// and schedules saving it to the database.
+func pushSPNTimelineUpdate() {
+	spnTimeline.UpdateMeta()
+	if spnTimelinePushFunc != nil {
+		spnTimelinePushFunc(spnTimeline)
+	}
+	if spnTimelineSaveTask != nil {
+		spnTimelineSaveTask.Schedule(time.Now().Add(spnTimelineSaveDelay))
+	}
+}
+
+// spnTimelineSaveTask saves the timeline to the database.
+// It is guarded by the spnTimeline lock.
+var spnTimelineSaveTask *modules.Task
+
+// startSPNTimeline loads the SPN timeline from the database and starts saving
+// it when changed. It is called when the module starts.
+func startSPNTimeline() {
+	loadSPNTimeline()
+
+	spnTimeline.Lock()
+	defer spnTimeline.Unlock()
+
+	spnTimelineSaveTask = module.NewTask("save spn timeline", func(_ context.Context, _ *modules.Task) error {
+		saveSPNTimeline()
+		return nil
+	})
+}
+
+// stopSPNTimeline stops saving the SPN timeline when changed. It is called when
+// the module stops. The client manager saves the timeline a last time when it
+// stops, as it may add its last event after the module stopped.
+func stopSPNTimeline() {
+	spnTimeline.Lock()
+	defer spnTimeline.Unlock()
+
+	if spnTimelineSaveTask != nil {
+		spnTimelineSaveTask.Cancel()
+		spnTimelineSaveTask = nil
+	}
+}
+
+func loadSPNTimeline() {
+	spnTimeline.Lock()
+	defer spnTimeline.Unlock()
+
+	// Only load once, the timeline stays in memory when the module is restarted.
+	if len(spnTimeline.Events) > 0 {
+		return
+	}
+
+	r, err := db.Get(spnTimelineDBKey)
+	if err != nil {
+		if !errors.Is(err, database.ErrNotFound) {
+			log.Warningf("spn/captain: failed to load timeline: %s", err)
+		}
+		return
+	}
+	saved, err := ensureSPNTimeline(r)
+	if err != nil {
+		log.Warningf("spn/captain: failed to parse timeline: %s", err)
+		return
+	}
+
+	spnTimeline.Events = saved.Events
+	if len(spnTimeline.Events) > maxSPNTimelineEvents {
+		spnTimeline.Events = spnTimeline.Events[len(spnTimeline.Events)-maxSPNTimelineEvents:]
+	}
+	spnTimeline.UpdateMeta()
+	if spnTimelinePushFunc != nil {
+		spnTimelinePushFunc(spnTimeline)
+	}
+}
+
+func saveSPNTimeline() {
+	// Copy the events, as the timeline may be changed while saving.
+	spnTimeline.Lock()
+	saved := &SPNTimeline{
+		Events: copySPNEvents(spnTimeline.Events),
+	}
+	spnTimeline.Unlock()
+
+	saved.SetKey(spnTimelineDBKey)
+	saved.UpdateMeta()
+	if err := db.Put(saved); err != nil {
+		log.Warningf("spn/captain: failed to save timeline: %s", err)
+	}
+}
+
+// ensureSPNTimeline makes sure a database record is a SPNTimeline.
+func ensureSPNTimeline(r record.Record) (*SPNTimeline, error) {
+	// Unwrap record.
+	if r.IsWrapped() {
+		timeline := &SPNTimeline{}
+		if err := record.Unwrap(r, timeline); err != nil {
+			return nil, err
+		}
+		return timeline, nil
+	}
+
+	// Or adjust type.
+	timeline, ok := r.(*SPNTimeline)
+	if !ok {
+		return nil, fmt.Errorf("record not of type *SPNTimeline, but %T", r)
+	}
+	return timeline, nil
+}
+
+// addSPNTimelineToDebugInfo adds the latest SPN events to the given debug.Info.
+func addSPNTimelineToDebugInfo(di *debug.Info) {
+	events := GetSPNTimeline()
+	if len(events) > spnTimelineDebugInfoEvents {
+		events = events[len(events)-spnTimelineDebugInfoEvents:]
+	}
+
+	lines := make([]string, 0, len(events))
+	for _, event := range events {
+		line := fmt.Sprintf("%s %s: %s", event.Time.Format(time.RFC3339), event.Type, event.Message)
+		if event.Repeated > 0 {
+			line += fmt.Sprintf(" (repeated %dx)", event.Repeated)
+		}
+		if event.HubID != "" {
+			line += fmt.Sprintf(" [Hub %s]", event.HubName)
+		}
+		if event.Error != "" {
+			line += fmt.Sprintf(" [Error %d: %s]", event.ErrorCode, event.Error)
+		}
+		lines = append(lines, line)
+	}
+
+	di.AddSection(
+		fmt.Sprintf("SPN Timeline: %d latest events", len(events)),
+		debug.UseCodeSection|debug.AddContentLineBreaks,
+		lines...,
+	)
+}
@@ -0,0 +1,284 @@
+package crew
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/safing/portbase/api"
+	"github.com/safing/spn/navigator"
+)
+
+func registerAPIEndpoints() error {
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:        `spn/sticky`,
+		Read:        api.PermitUser,
+		BelongsTo:   module,
+		StructFunc:  handleStickyListRequest,
+		Name:        "Get SPN sticky entries",
+		Description: "Returns all destinations that are stickied to or avoid a Hub.",
+	}); err != nil {
+		return err
+	}
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:        `spn/sticky/pin`,
+		Write:       api.PermitAdmin,
+		WriteMethod: http.MethodPost,
+		BelongsTo:   module,
+		ActionFunc:  handleStickyPinRequest,
+		Name:        "Pin SPN sticky entry",
+		Description: "Pins a destination to a Hub. Pinned entries do not expire.",
+		Parameters: []api.Parameter{
+			{
+				Method:      http.MethodPost,
+				Field:       "profile",
+				Value:       "scoped profile ID",
+				Description: "Specify the profile the entry is for. If omitted, the entry is used for connections without a profile.",
+			},
+			{
+				Method:      http.MethodPost,
+				Field:       "ip",
+				Value:       "IP address",
+				Description: "Specify the IP address to pin.",
+			},
+			{
+				Method:      http.MethodPost,
+				Field:       "domain",
+				Value:       "domain",
+				Description: "Specify the domain to pin. Either an IP address or a domain is required.",
+			},
+			{
+				Method:      http.MethodPost,
+				Field:       "hub",
+				Value:       "Hub ID",
+				Description: "Specify the Hub to pin the destination to.",
+			},
+		},
+	}); err != nil {
+		return err
+	}
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:        `spn/sticky/clear`,
+		Write:       api.PermitAdmin,
+		WriteMethod: http.MethodDelete,
+		BelongsTo:   module,
+		ActionFunc:  handleStickyClearRequest,
+		Name:        "Clear SPN sticky entries",
+		Description: "Removes sticky entries, including pinned ones. Without parameters, all entries are removed.",
+		Parameters: []api.Parameter{
+			{
+				Method:      http.MethodDelete,
+				Field:       "profile",
+				Value:       "scoped profile ID",
+				Description: "Only remove entries of the given profile.",
+			},
+			{
+				Method:      http.MethodDelete,
+				Field:       "ip",
+				Value:       "IP address",
+				Description: "Only remove entries of the given IP address.",
+			},
+			{
+				Method:      http.MethodDelete,
+				Field:       "domain",
+				Value:       "domain",
+				Description: "Only remove entries of the given domain.",
+			},
+		},
+	}); err != nil {
+		return err
+	}
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:        `spn/sticky/policies`,
+		Read:        api.PermitUser,
+		BelongsTo:   module,
+		StructFunc:  handleStickyPoliciesRequest,
+		Name:        "Get SPN sticky policies",
+		Description: "Returns the sticky policies of all profiles that differ from the default.",
+	}); err != nil {
+		return err
+	}
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:        `spn/sticky/policy`,
+		Write:       api.PermitAdmin,
+		WriteMethod: http.MethodPost,
+		BelongsTo:   module,
+		ActionFunc:  handleStickyPolicyRequest,
+		Name:        "Set SPN sticky policy",
+		Description: "Sets the sticky policy of a profile.",
+		Parameters: []api.Parameter{
+			{
+				Method:      http.MethodPost,
+				Field:       "profile",
+				Value:       "scoped profile ID",
+				Description: "Specify the profile to set the policy for. If omitted, the policy is set for connections without a profile.",
+			},
+			{
+				Method:      http.MethodPost,
+				Field:       "ttl",
+				Value:       "duration, eg. 30m",
+				Description: "Specify how long destinations stick to a Hub after they were last used. If omitted, the default of 1h is used.",
+			},
+			{
+				Method:      http.MethodPost,
+				Field:       "disabled",
+				Value:       "",
+				Description: "If set, destinations of the profile are not stickied to Hubs.",
+			},
+		},
+	}); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func handleStickyListRequest(ar *api.Request) (i interface{}, err error) {
+	stickyLock.Lock()
+	defer stickyLock.Unlock()
+
+	entries := make([]*StickyEntry, 0, len(stickyIPs)+len(stickyDomains))
+	for _, stickyRegistry := range stickyRegistries() {
+		for _, stickedEntry := range stickyRegistry {
+			if !stickedEntry.isExpired() {
+				entries = append(entries, stickedEntry.export())
+			}
+		}
+	}
+
+	return entries, nil
+}
+
+func handleStickyPinRequest(ar *api.Request) (msg string, err error) {
+	profile, dbKeyPrefix, destination, err := getStickyDestinationParams(ar)
+	if err != nil {
+		return "", err
+	}
+	if destination == "" {
+		return "", errors.New("ip or domain is required")
+	}
+
+	// Check if the Hub exists.
+	hubID := ar.URL.Query().Get("hub")
+	pin, ok := navigator.Main.GetPin(hubID)
+	if !ok {
+		return "", fmt.Errorf("hub %q not found", hubID)
+	}
+
+	stickyLock.Lock()
+	defer stickyLock.Unlock()
+
+	key := makeStickyKey(profile, destination)
+	sh := &stickyHub{
+		Profile:     profile,
+		Destination: destination,
+		HubID:       pin.Hub.ID,
+		Pin:         pin,
+		LastSeen:    time.Now(),
+		Pinned:      true,
+	}
+	stickyRegistries()[dbKeyPrefix][key] = sh
+	saveStickyHub(dbKeyPrefix, key, sh)
+
+	return fmt.Sprintf("Pinned %s to %s.", key, pin.Hub), nil
+}
+
+func handleStickyClearRequest(ar *api.Request) (msg string, err error) {
+	profile, dbKeyPrefix, destination, err := getStickyDestinationParams(ar)
+	if err != nil {
+		return "", err
+	}
+	filterProfile := ar.URL.Query().Get("profile") != ""
+
+	stickyLock.Lock()
+	defer stickyLock.Unlock()
+
+	var cleared int
+	for registryDBKeyPrefix, stickyRegistry := range stickyRegistries() {
+		if destination != "" && registryDBKeyPrefix != dbKeyPrefix {
+			continue
+		}
+
+		for key, stickedEntry := range stickyRegistry {
+			switch {
+			case filterProfile && stickedEntry.Profile != profile:
+			case destination != "" && stickedEntry.Destination != destination:
+			default:
+				delete(stickyRegistry, key)
+				deleteStickyHub(registryDBKeyPrefix, key)
+				cleared++
+			}
+		}
+	}
+
+	return fmt.Sprintf("Cleared %d sticky entries.", cleared), nil
+}
+
+func handleStickyPoliciesRequest(ar *api.Request) (i interface{}, err error) {
+	return getStickyPolicies(), nil
+}
+
+func handleStickyPolicyRequest(ar *api.Request) (msg string, err error) {
+	q := ar.URL.Query()
+
+	policy := &StickyPolicy{
+		Profile:  q.Get("profile"),
+		Disabled: q.Get("disabled") != "",
+	}
+	if policy.Profile == "" {
+		policy.Profile = unknownStickyProfile
+	}
+	if ttl := q.Get("ttl"); ttl != "" {
+		policy.TTL, err = time.ParseDuration(ttl)
+		if err != nil {
+			return "", fmt.Errorf("invalid ttl: %w", err)
+		}
+		if policy.TTL <= 0 {
+			return "", errors.New("ttl must be positive")
+		}
+	}
+
+	if err := setStickyPolicy(policy); err != nil {
+		return "", fmt.Errorf("failed to save sticky policy: %w", err)
+	}
+	return fmt.Sprintf("Set sticky policy of %s.", policy.Profile), nil
+}
+
+// getStickyDestinationParams returns the profile and destination given in the
+// request, together with the database key prefix of the destination type.
+// The destination is empty if neither an IP nor a domain was given.
+func getStickyDestinationParams(ar *api.Request) (profile, dbKeyPrefix, destination string, err error) {
+	q := ar.URL.Query()
+
+	profile = q.Get("profile")
+	if profile == "" {
+		profile = unknownStickyProfile
+	}
+
+	switch {
+	case q.Get("ip") != "" && q.Get("domain") != "":
+		return "", "", "", errors.New("only one of ip and domain may be given")
+	case q.Get("ip") != "":
+		ip := net.ParseIP(q.Get("ip"))
+		if ip == nil {
+			return "", "", "", fmt.Errorf("invalid ip %q", q.Get("ip"))
+		}
+		return profile, stickyIPsDBKeyPrefix, ip.String(), nil
+	case q.Get("domain") != "":
+		// Normalize to the FQDN format used by connections.
+		domain := strings.ToLower(q.Get("domain"))
+		if !strings.HasSuffix(domain, ".") {
+			domain += "."
+		}
+		return profile, stickyDomainsDBKeyPrefix, domain, nil
+	default:
+		return profile, "", "", nil
+	}
+}
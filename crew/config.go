@@ -0,0 +1,45 @@
+package crew
+
+import (
+	"github.com/safing/portbase/config"
+)
+
+var (
+	// CfgOptionResumeConnectionsKey is the configuration key for resuming
+	// connections via a new route.
+	CfgOptionResumeConnectionsKey   = "spn/resumeConnections"
+	cfgOptionResumeConnections      config.BoolOption
+	cfgOptionResumeConnectionsOrder = 151
+)
+
+func prepConfig() error {
+	err := config.Register(&config.Option{
+		Name: "Resume Connections",
+		Key:  CfgOptionResumeConnectionsKey,
+		Description: `Keep TCP connections alive when a Hub on the route fails by resuming them via a new route to the same Exit Node. Only used with Exit Nodes that support it.
+
+Resumable connections use a smaller queue, which limits their throughput, and both sides keep recently sent data in memory in order to send it again when resuming.`,
+		OptType:        config.OptTypeBool,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		DefaultValue:   false,
+		Annotations: config.Annotations{
+			config.CategoryAnnotation:     "Routing",
+			config.DisplayOrderAnnotation: cfgOptionResumeConnectionsOrder,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	cfgOptionResumeConnections = config.Concurrent.GetAsBool(CfgOptionResumeConnectionsKey, false)
+
+	return nil
+}
+
+// resumeConnectionsEnabled returns whether connections should be resumable.
+// Returns false if the config is not available.
+func resumeConnectionsEnabled() bool {
+	if cfgOptionResumeConnections == nil {
+		return false
+	}
+	return cfgOptionResumeConnections()
+}
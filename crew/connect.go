@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"net"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/safing/portbase/log"
@@ -18,10 +17,6 @@ import (
 	"github.com/safing/spn/terminal"
 )
 
-// connectLock locks all routing operations to mitigate racy stuff for now.
-// TODO: Find a nice way to parallelize route creation.
-var connectLock sync.Mutex
-
 // HandleSluiceRequest handles a sluice request to build a tunnel.
 func HandleSluiceRequest(connInfo *network.Connection, conn net.Conn) {
 	if conn == nil {
@@ -145,8 +140,10 @@ func (t *Tunnel) establish(ctx context.Context) (err error) {
 		log.Tracer(ctx).Tracef("spn/crew: using stickied %s", sticksTo.Pin.Hub)
 
 		// Check if the stickied Hub has an active terminal.
+		// Entries without a route were loaded from the database or pinned
+		// manually, so the route to the Hub needs to be found first.
 		dstTerminal := sticksTo.Pin.GetActiveTerminal()
-		if dstTerminal != nil {
+		if dstTerminal != nil && sticksTo.Route != nil {
 			t.dstPin = sticksTo.Pin
 			t.dstTerminal = dstTerminal
 			t.route = sticksTo.Route
@@ -169,6 +166,12 @@ func (t *Tunnel) establish(ctx context.Context) (err error) {
 
 	// Find possible routes to destination.
 	if routes == nil {
+		// Spread connections across the warm routes of the destination region.
+		if t.useWarmRoute(ctx) {
+			navigator.Main.PushPinChanges()
+			return nil
+		}
+
 		log.Tracer(ctx).Trace("spn/crew: finding routes...")
 		routes, err = navigator.Main.FindRoutes(
 			t.connInfo.Entity.IP,
@@ -200,6 +203,11 @@ func (t *Tunnel) establish(ctx context.Context) (err error) {
 		t.route = route
 		t.failedTries = tries
 
+		// Keep more routes to the destination region warm.
+		if !t.stickied {
+			t.warmUpRoutes(routes)
+		}
+
 		// Push changes to Pins and return.
 		navigator.Main.PushPinChanges()
 		return nil
@@ -214,12 +222,15 @@ type hopCheck struct {
 	expansion *docks.ExpansionTerminal
 	authOp    *access.AuthorizeOp
 	pingOp    *PingOp
+
+	// pinExp is the expansion claimed for building the expansion terminal.
+	pinExp *pinExpansion
 }
 
+// establishRoute builds the given route, reusing existing connections.
+// It may be called concurrently: Expansions to the same Hub are deduplicated,
+// while expansions to different Hubs are built in parallel.
 func establishRoute(route *navigator.Route) (dstPin *navigator.Pin, dstTerminal terminal.Terminal, err error) {
-	connectLock.Lock()
-	defer connectLock.Unlock()
-
 	// Check for path length.
 	if len(route.Path) < 1 {
 		return nil, nil, errors.New("path too short")
@@ -250,13 +261,15 @@ func establishRoute(route *navigator.Route) (dstPin *navigator.Pin, dstTerminal
 		return previousHop, previousTerminal, nil
 	}
 
-	// TODO: Check what needs locking.
-
 	// Build path and save created paths.
 	hopChecks := make([]*hopCheck, 0, len(route.Path)-1)
 	for i, hop := range route.Path[1:] {
-		// Check if we already have a connection to the Hub.
-		activeTerminal := hop.Pin().GetActiveTerminal()
+		// Check if we already have a connection to the Hub, or claim the
+		// expansion to it.
+		activeTerminal, pinExp, tErr := getOrClaimTerminal(hop.Pin())
+		if tErr != nil {
+			return nil, nil, tErr.Wrap("failed to connect to %s", hop.Pin())
+		}
 		if activeTerminal != nil {
 			// Ping terminal if not recently checked.
 			if activeTerminal.NeedsReachableCheck(1 * time.Minute) {
@@ -281,15 +294,25 @@ func establishRoute(route *navigator.Route) (dstPin *navigator.Pin, dstTerminal
 		// Expand to next Hub.
 		expansion, authOp, tErr := expand(previousTerminal, previousHop, hop.Pin())
 		if tErr != nil {
+			expansions.finish(hop.Pin().Hub.ID, pinExp, tErr)
 			return nil, nil, tErr.Wrap("failed to expand to %s", hop.Pin())
 		}
 
 		// Add for checking results later.
-		hopChecks = append(hopChecks, &hopCheck{
+		check := &hopCheck{
 			pin:       hop.Pin(),
 			route:     route.CopyUpTo(i + 2),
 			expansion: expansion,
 			authOp:    authOp,
+			pinExp:    pinExp,
+		}
+		hopChecks = append(hopChecks, check)
+
+		// Check the authorization independently of this route, as other routes
+		// may be waiting for the expansion.
+		module.StartWorker("expansion auth checker", func(_ context.Context) error {
+			expansions.finish(check.pin.Hub.ID, check.pinExp, check.checkAuth())
+			return nil
 		})
 
 		// Save previous pin for next loop or end.
@@ -301,36 +324,11 @@ func establishRoute(route *navigator.Route) (dstPin *navigator.Pin, dstTerminal
 	for _, check := range hopChecks {
 		switch {
 		case check.authOp != nil:
-			// Wait for authOp result.
-			select {
-			case tErr := <-check.authOp.Result:
-				if !tErr.Is(terminal.ErrExplicitAck) {
-					// This should never happen, as all should have the same public keys
-					// and tokens are validated locally before using.
-					// Ignore Hub for a short amount of time.
-					// TODO: How can we better handle this?
-					check.pin.MarkAsFailingFor(3 * time.Minute)
-					log.Warningf("spn/crew: failed to auth to %s: %s", check.pin.Hub, tErr)
-
-					return nil, nil, tErr.Wrap("failed to authenticate to %s: %w", check.pin.Hub, tErr)
-				}
-
-			case <-time.After(5 * time.Second):
-				// Mark as failing for just a minute, until server load may be less.
-				check.pin.MarkAsFailingFor(1 * time.Minute)
-				log.Warningf("spn/crew: auth to %s timed out", check.pin.Hub)
-
-				return nil, nil, terminal.ErrTimeout.With("waiting for auth to %s", check.pin.Hub)
+			// Wait for the authorization check.
+			if tErr := check.pinExp.wait(); tErr != nil {
+				return nil, nil, tErr
 			}
 
-			// Add terminal extension to the map.
-			check.pin.SetActiveTerminal(&navigator.PinConnection{
-				Terminal: check.expansion,
-				Route:    check.route,
-			})
-			check.expansion.MarkReachable()
-			log.Infof("spn/crew: added conn to %s via %s", check.pin, check.route)
-
 		case check.pingOp != nil:
 			// Wait for ping result.
 			select {
@@ -370,7 +368,69 @@ func establishRoute(route *navigator.Route) (dstPin *navigator.Pin, dstTerminal
 	return previousHop, previousTerminal, nil
 }
 
-func expand(fromTerminal terminal.Terminal, from, to *navigator.Pin) (expansion *docks.ExpansionTerminal, authOp *access.AuthorizeOp, tErr *terminal.Error) {
+// getOrClaimTerminal returns the active terminal of the given Pin. If there is
+// none, it waits for any ongoing expansion to the Pin. If there is no ongoing
+// expansion, it is claimed and returned and the caller must build it.
+func getOrClaimTerminal(pin *navigator.Pin) (*docks.ExpansionTerminal, *pinExpansion, *terminal.Error) {
+	for {
+		pinExp, owner := expansions.claim(pin.Hub.ID, pin.HasActiveTerminal)
+		switch {
+		case pinExp == nil:
+			// Use the active terminal.
+			// If it was removed in the meantime, try again.
+			if activeTerminal := pin.GetActiveTerminal(); activeTerminal != nil {
+				return activeTerminal, nil, nil
+			}
+		case owner:
+			return nil, pinExp, nil
+		default:
+			// Wait for the concurrent expansion and then use its result.
+			if tErr := pinExp.wait(); tErr != nil {
+				return nil, nil, tErr.Wrap("concurrent expansion failed")
+			}
+		}
+	}
+}
+
+// checkAuth waits for the authorization of the expansion and adds the
+// expansion terminal to the Pin on success.
+func (check *hopCheck) checkAuth() *terminal.Error {
+	// Wait for authOp result.
+	select {
+	case tErr := <-check.authOp.Result:
+		if !tErr.Is(terminal.ErrExplicitAck) {
+			// This should never happen, as all should have the same public keys
+			// and tokens are validated locally before using.
+			// Ignore Hub for a short amount of time.
+			// TODO: How can we better handle this?
+			check.pin.MarkAsFailingFor(3 * time.Minute)
+			log.Warningf("spn/crew: failed to auth to %s: %s", check.pin.Hub, tErr)
+
+			return tErr.Wrap("failed to authenticate to %s: %w", check.pin.Hub, tErr)
+		}
+
+	case <-time.After(5 * time.Second):
+		// Mark as failing for just a minute, until server load may be less.
+		check.pin.MarkAsFailingFor(1 * time.Minute)
+		log.Warningf("spn/crew: auth to %s timed out", check.pin.Hub)
+
+		return terminal.ErrTimeout.With("waiting for auth to %s", check.pin.Hub)
+	}
+
+	// Add terminal extension to the map.
+	check.pin.SetActiveTerminal(&navigator.PinConnection{
+		Terminal: check.expansion,
+		Route:    check.route,
+	})
+	check.expansion.MarkReachable()
+	log.Infof("spn/crew: added conn to %s via %s", check.pin, check.route)
+	return nil
+}
+
+// expand expands from the given terminal to the given Pin and starts the
+// authorization of the new expansion terminal.
+// It is a variable in order to be able to replace it in tests.
+var expand = func(fromTerminal terminal.Terminal, from, to *navigator.Pin) (expansion *docks.ExpansionTerminal, authOp *access.AuthorizeOp, tErr *terminal.Error) {
 	expansion, tErr = docks.ExpandTo(fromTerminal, to.Hub.ID, to.Hub)
 	if tErr != nil {
 		return nil, nil, tErr.Wrap("failed to expand to %s", to.Hub)
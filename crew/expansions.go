@@ -0,0 +1,152 @@
+package crew
+
+import (
+	"context"
+	"sync"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/spn/navigator"
+	"github.com/safing/spn/terminal"
+)
+
+// pinExpansion is an ongoing expansion to a Hub.
+type pinExpansion struct {
+	done chan struct{}
+	tErr *terminal.Error
+}
+
+// wait waits for the expansion to finish and returns its error.
+func (exp *pinExpansion) wait() *terminal.Error {
+	<-exp.done
+	return exp.tErr
+}
+
+// expansionCoordinator coordinates concurrent expansions, so that only one
+// expansion is built to a Hub at any time. Expansions to different Hubs are
+// not affected by each other.
+type expansionCoordinator struct {
+	lock       sync.Mutex
+	expansions map[string]*pinExpansion
+}
+
+var expansions = newExpansionCoordinator()
+
+func newExpansionCoordinator() *expansionCoordinator {
+	return &expansionCoordinator{
+		expansions: make(map[string]*pinExpansion),
+	}
+}
+
+// claim returns the ongoing expansion to the Hub with the given ID.
+// If there is none, a new expansion is created and owner is true. The owner
+// must build the expansion and call finish when done.
+// The given connected function is checked before creating a new expansion. If
+// it returns true, there is no need for an expansion and nil is returned.
+func (ec *expansionCoordinator) claim(hubID string, connected func() bool) (exp *pinExpansion, owner bool) {
+	ec.lock.Lock()
+	defer ec.lock.Unlock()
+
+	// Return ongoing expansion.
+	exp, ok := ec.expansions[hubID]
+	if ok {
+		return exp, false
+	}
+
+	// Check if an expansion finished in the meantime.
+	if connected() {
+		return nil, false
+	}
+
+	// Create new expansion.
+	exp = &pinExpansion{
+		done: make(chan struct{}),
+	}
+	ec.expansions[hubID] = exp
+	return exp, true
+}
+
+// finish finishes the given expansion with the given error and wakes up all
+// waiting callers. The result of the expansion, such as the active terminal of
+// the Pin, must be set before calling finish.
+func (ec *expansionCoordinator) finish(hubID string, exp *pinExpansion, tErr *terminal.Error) {
+	ec.lock.Lock()
+	defer ec.lock.Unlock()
+
+	if ec.expansions[hubID] == exp {
+		delete(ec.expansions, hubID)
+	}
+	exp.tErr = tErr
+	close(exp.done)
+}
+
+// ReestablishExpansions re-establishes the expansion terminals to the given
+// Hubs via the current home hub in the background.
+// This is used after switching to a new home hub, as all existing expansions
+// were built on top of the previous home hub.
+func ReestablishExpansions(hubIDs []string) {
+	// Warm routes start at the previous home hub and cannot be used anymore.
+	clearWarmRoutes()
+
+	if len(hubIDs) == 0 {
+		return
+	}
+
+	module.StartWorker("re-establish expansions", func(ctx context.Context) error {
+		reestablished := reestablishExpansions(ctx, hubIDs, findExpansionRoutes, establishRoute)
+		navigator.Main.PushPinChanges()
+		log.Infof("spn/crew: re-established %d/%d expansions via new home hub", reestablished, len(hubIDs))
+		return nil
+	})
+}
+
+// findExpansionRoutes returns the routes to the Hub with the given ID, starting
+// at the current home hub.
+func findExpansionRoutes(hubID string) ([]*navigator.Route, error) {
+	routes, err := navigator.Main.FindRouteToHub(hubID, navigator.Main.DefaultOptions())
+	if err != nil {
+		return nil, err
+	}
+	return routes.All, nil
+}
+
+// reestablishExpansions establishes a route to each of the given Hubs, using
+// the first of its routes that succeeds. It returns the amount of Hubs that
+// routes were established to.
+func reestablishExpansions(
+	ctx context.Context,
+	hubIDs []string,
+	findRoutes func(hubID string) ([]*navigator.Route, error),
+	establish func(route *navigator.Route) (*navigator.Pin, terminal.Terminal, error),
+) (reestablished int) {
+	for _, hubID := range hubIDs {
+		// Check if we are shutting down.
+		if ctx.Err() != nil {
+			return reestablished
+		}
+
+		// Find a route to the Hub with the new home hub.
+		routes, err := findRoutes(hubID)
+		if err != nil {
+			log.Debugf("spn/crew: failed to find route to %s for re-establishing expansion: %s", hubID, err)
+			continue
+		}
+		if len(routes) == 0 {
+			log.Debugf("spn/crew: no route to %s for re-establishing expansion", hubID)
+			continue
+		}
+
+		// Try routes until one succeeds.
+		for _, route := range routes {
+			_, _, err = establish(route)
+			if err == nil {
+				reestablished++
+				break
+			}
+		}
+		if err != nil {
+			log.Debugf("spn/crew: failed to re-establish expansion to %s: %s", hubID, err)
+		}
+	}
+
+	return reestablished
+}
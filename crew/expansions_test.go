@@ -0,0 +1,312 @@
+package crew
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/safing/portbase/container"
+	"github.com/safing/spn/access"
+	"github.com/safing/spn/docks"
+	"github.com/safing/spn/hub"
+	"github.com/safing/spn/navigator"
+	"github.com/safing/spn/terminal"
+)
+
+// acceptingTestTerminal is a terminal that accepts operations without sending
+// them anywhere. It is used to create expansion terminals that are never used.
+type acceptingTestTerminal struct {
+	terminal.BareTerminal
+}
+
+func (t *acceptingTestTerminal) StartOperation(op terminal.Operation, _ *container.Container, _ time.Duration) *terminal.Error {
+	op.InitOperationBase(t, 1)
+	return nil
+}
+
+// setupEstablishRouteTest adds Hubs connected by the given lanes to the main
+// map, sets the first Hub as the home hub and replaces the expand function
+// with the given one.
+func setupEstablishRouteTest(
+	t *testing.T,
+	hubIDs []string,
+	lanes [][2]string,
+	testExpand func(to *navigator.Pin) (*docks.ExpansionTerminal, *access.AuthorizeOp, *terminal.Error),
+) {
+	t.Helper()
+
+	// Create Hubs.
+	hubs := make(map[string]*hub.Hub, len(hubIDs))
+	for _, hubID := range hubIDs {
+		hubs[hubID] = &hub.Hub{
+			ID:     hubID,
+			Info:   &hub.Announcement{ID: hubID},
+			Status: &hub.Status{},
+		}
+	}
+	for _, lane := range lanes {
+		hubs[lane[0]].Status.Lanes = append(hubs[lane[0]].Status.Lanes, &hub.Lane{ID: lane[1]})
+		hubs[lane[1]].Status.Lanes = append(hubs[lane[1]].Status.Lanes, &hub.Lane{ID: lane[0]})
+	}
+
+	// Add Hubs to the map and set the home hub.
+	for _, hubID := range hubIDs {
+		navigator.Main.UpdateHub(hubs[hubID])
+	}
+	if !navigator.Main.SetHome(hubIDs[0], &docks.CraneTerminal{}) {
+		t.Fatal("failed to set home hub")
+	}
+
+	// Replace expand function.
+	originalExpand := expand
+	expand = func(_ terminal.Terminal, _, to *navigator.Pin) (*docks.ExpansionTerminal, *access.AuthorizeOp, *terminal.Error) {
+		return testExpand(to)
+	}
+
+	t.Cleanup(func() {
+		expand = originalExpand
+		for _, hubID := range hubIDs {
+			navigator.Main.RemoveHub(hubID)
+		}
+	})
+}
+
+// getEstablishRouteTestRoute returns the route along the given Hubs, starting
+// at the home hub.
+func getEstablishRouteTestRoute(t *testing.T, path ...string) *navigator.Route {
+	t.Helper()
+
+	routes, err := navigator.Main.FindRouteToHub(path[len(path)-1], &navigator.Options{
+		NoDefaults:     true,
+		RoutingProfile: navigator.RoutingProfileSingleHopID,
+	})
+	if err != nil {
+		t.Fatalf("failed to find route to %s: %s", path[len(path)-1], err)
+	}
+
+nextRoute:
+	for _, route := range routes.All {
+		if len(route.Path) != len(path)+1 {
+			continue
+		}
+		for i, hubID := range path {
+			if route.Path[i+1].HubID != hubID {
+				continue nextRoute
+			}
+		}
+		return route
+	}
+
+	t.Fatalf("no route along %v", path)
+	return nil
+}
+
+// newTestExpansion creates an expansion terminal that is never used and an
+// authorization operation that finishes with the given result.
+func newTestExpansion(to *navigator.Pin, authResult *terminal.Error) (*docks.ExpansionTerminal, *access.AuthorizeOp, *terminal.Error) {
+	expansion, tErr := docks.ExpandTo(&acceptingTestTerminal{}, to.Hub.ID, nil)
+	if tErr != nil {
+		return nil, nil, tErr
+	}
+
+	authOp := &access.AuthorizeOp{}
+	authOp.Init()
+	authOp.Result <- authResult
+
+	return expansion, authOp, nil
+}
+
+func TestEstablishRouteConcurrently(t *testing.T) { //nolint:paralleltest // Changes the main map and the expand function.
+	var (
+		expansionsLock sync.Mutex
+		expansions     = make(map[string]int)
+		active         int32
+		maxActive      int32
+	)
+	setupEstablishRouteTest(
+		t,
+		[]string{"er-home", "er-a", "er-b", "er-c", "er-d"},
+		[][2]string{{"er-home", "er-a"}, {"er-a", "er-b"}, {"er-home", "er-c"}, {"er-c", "er-d"}},
+		func(to *navigator.Pin) (*docks.ExpansionTerminal, *access.AuthorizeOp, *terminal.Error) {
+			expansionsLock.Lock()
+			expansions[to.Hub.ID]++
+			expansionsLock.Unlock()
+
+			// Track how many expansions are built in parallel.
+			current := atomic.AddInt32(&active, 1)
+			defer atomic.AddInt32(&active, -1)
+			for {
+				max := atomic.LoadInt32(&maxActive)
+				if current <= max || atomic.CompareAndSwapInt32(&maxActive, max, current) {
+					break
+				}
+			}
+			time.Sleep(50 * time.Millisecond)
+
+			return newTestExpansion(to, terminal.ErrExplicitAck)
+		},
+	)
+	routes := []*navigator.Route{
+		getEstablishRouteTestRoute(t, "er-a"),
+		getEstablishRouteTestRoute(t, "er-a", "er-b"),
+		getEstablishRouteTestRoute(t, "er-c"),
+		getEstablishRouteTestRoute(t, "er-c", "er-d"),
+	}
+
+	// Establish many routes at once.
+	var wg sync.WaitGroup
+	for i := 0; i < 40; i++ {
+		route := routes[i%len(routes)]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dstPin, _, err := establishRoute(route)
+			if err != nil {
+				t.Errorf("failed to establish %s: %s", route, err)
+				return
+			}
+			if dstPin != route.Path[len(route.Path)-1].Pin() {
+				t.Errorf("unexpected destination %s for %s", dstPin, route)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Every Hub must have been expanded to exactly once.
+	expansionsLock.Lock()
+	defer expansionsLock.Unlock()
+	for _, hubID := range []string{"er-a", "er-b", "er-c", "er-d"} {
+		if expansions[hubID] != 1 {
+			t.Errorf("expanded to %s %d times", hubID, expansions[hubID])
+		}
+		if pin, ok := navigator.Main.GetPin(hubID); !ok || !pin.HasActiveTerminal() {
+			t.Errorf("%s should have an active terminal", hubID)
+		}
+	}
+
+	// Expansions to different Hubs must have been built in parallel.
+	if atomic.LoadInt32(&maxActive) < 2 {
+		t.Error("expansions were not built in parallel")
+	}
+}
+
+func TestEstablishRouteExpansionFailure(t *testing.T) { //nolint:paralleltest // Changes the main map and the expand function.
+	var (
+		expanded int32
+		started  = make(chan struct{})
+		release  = make(chan struct{})
+	)
+	setupEstablishRouteTest(
+		t,
+		[]string{"ef-home", "ef-a"},
+		[][2]string{{"ef-home", "ef-a"}},
+		func(to *navigator.Pin) (*docks.ExpansionTerminal, *access.AuthorizeOp, *terminal.Error) {
+			atomic.AddInt32(&expanded, 1)
+			close(started)
+			<-release
+
+			return newTestExpansion(to, terminal.ErrPermissionDenied)
+		},
+	)
+	route := getEstablishRouteTestRoute(t, "ef-a")
+
+	// Start the expansion that fails.
+	errs := make(chan error, 2)
+	go func() {
+		_, _, err := establishRoute(route)
+		errs <- err
+	}()
+
+	// Start a second route while the expansion is being built.
+	<-started
+	go func() {
+		_, _, err := establishRoute(route)
+		errs <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	// Both routes must fail with the error of the expansion.
+	for i := 0; i < 2; i++ {
+		if err := <-errs; !errors.Is(err, terminal.ErrPermissionDenied) {
+			t.Errorf("route should fail with the error of the expansion, got %v", err)
+		}
+	}
+	if atomic.LoadInt32(&expanded) != 1 {
+		t.Errorf("expanded %d times, expected once", expanded)
+	}
+}
+
+func TestReestablishExpansions(t *testing.T) {
+	t.Parallel()
+
+	routeA1 := &navigator.Route{}
+	routeA2 := &navigator.Route{}
+	routeB := &navigator.Route{}
+	routeC := &navigator.Route{}
+	findRoutes := func(hubID string) ([]*navigator.Route, error) {
+		switch hubID {
+		case "a":
+			return []*navigator.Route{routeA1, routeA2}, nil
+		case "b":
+			return []*navigator.Route{routeB}, nil
+		case "c":
+			return []*navigator.Route{routeC}, nil
+		default:
+			return nil, errors.New("no route")
+		}
+	}
+	var established []*navigator.Route
+	establish := func(route *navigator.Route) (*navigator.Pin, terminal.Terminal, error) {
+		established = append(established, route)
+		// The first route to A and the only route to C fail.
+		if route == routeA1 || route == routeC {
+			return nil, nil, errors.New("failed")
+		}
+		return nil, nil, nil
+	}
+
+	// Routes are tried until one succeeds and unknown Hubs are skipped.
+	reestablished := reestablishExpansions(
+		context.Background(), []string{"a", "unknown", "b", "c"}, findRoutes, establish,
+	)
+	if reestablished != 2 {
+		t.Errorf("expected 2 re-established expansions, got %d", reestablished)
+	}
+	expected := []*navigator.Route{routeA1, routeA2, routeB, routeC}
+	if len(established) != len(expected) {
+		t.Fatalf("expected %d routes to be established, got %d", len(expected), len(established))
+	}
+	for i, route := range expected {
+		if established[i] != route {
+			t.Errorf("route %d was not established in order", i)
+		}
+	}
+
+	// Nothing is established when shutting down.
+	established = nil
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if reestablishExpansions(ctx, []string{"a", "b"}, findRoutes, establish) != 0 || len(established) != 0 {
+		t.Error("expansions should not be re-established when shutting down")
+	}
+}
+
+func TestReestablishExpansionsClearsWarmRoutes(t *testing.T) {
+	// Not parallel, as the global warm routes are changed.
+
+	warmRoutesLock.Lock()
+	warmRoutes["test"] = &warmRouteSet{created: time.Now()}
+	warmRoutesLock.Unlock()
+
+	ReestablishExpansions(nil)
+
+	warmRoutesLock.Lock()
+	defer warmRoutesLock.Unlock()
+	if len(warmRoutes) != 0 {
+		t.Error("warm routes of the previous home hub should be cleared")
+	}
+}
@@ -3,6 +3,7 @@ package crew
 import (
 	"time"
 
+	"github.com/safing/portbase/log"
 	"github.com/safing/portbase/modules"
 	"github.com/safing/spn/terminal"
 )
@@ -10,18 +11,37 @@ import (
 var module *modules.Module
 
 func init() {
-	module = modules.Register("crew", nil, start, stop, "terminal", "docks", "navigator", "intel", "cabin")
+	module = modules.Register("crew", prep, start, stop, "terminal", "docks", "navigator", "intel", "cabin")
+}
+
+func prep() error {
+	if err := prepConfig(); err != nil {
+		return err
+	}
+
+	return registerAPIEndpoints()
 }
 
 func start() error {
+	// Load sticky policies and entries from the database.
+	if err := loadStickyPolicies(); err != nil {
+		log.Warningf("spn/crew: %s", err)
+	}
+	if err := loadStickyHubs(); err != nil {
+		log.Warningf("spn/crew: %s", err)
+	}
+
 	module.NewTask("sticky cleaner", cleanStickyHubs).
 		Repeat(10 * time.Minute)
+	module.NewTask("warm routes cleaner", cleanWarmRoutes).
+		Repeat(10 * time.Minute)
 
 	return registerMetrics()
 }
 
 func stop() error {
 	clearStickyHubs()
+	clearWarmRoutes()
 	terminal.StopScheduler()
 
 	return nil
@@ -0,0 +1,441 @@
+package crew
+
+import (
+	"context"
+	mrand "math/rand"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/modules"
+	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/network/packet"
+	"github.com/safing/spn/navigator"
+	"github.com/safing/spn/terminal"
+)
+
+const (
+	// maxWarmRoutes defines how many routes are kept warm per destination region.
+	maxWarmRoutes = 3
+
+	// warmRoutesTTL defines how long a set of warm routes is used before new
+	// routes are searched for the destination region.
+	warmRoutesTTL = 10 * time.Minute
+
+	// warmRoutePingTimeout defines how long to wait for the latency test of a
+	// warm route.
+	warmRoutePingTimeout = 5 * time.Second
+)
+
+var (
+	warmRoutes     = make(map[string]*warmRouteSet)
+	warmRoutesLock sync.Mutex
+)
+
+// warmRouteSet holds established routes to a destination region, which new
+// connections are spread across.
+type warmRouteSet struct {
+	routes  []*navigator.Route
+	created time.Time
+	// latencies holds the round trip time measured over the routes by pinging
+	// the destination Hub through the route.
+	latencies map[*navigator.Route]time.Duration
+	// warming is set while routes are being established for the set.
+	warming bool
+}
+
+func (set *warmRouteSet) isExpired() bool {
+	return time.Now().Add(-warmRoutesTTL).After(set.created)
+}
+
+// makeWarmRoutesKey returns the key for the warm routes of the connection.
+// The destination region is approximated by the country and IP version of the
+// destination. If the country is unknown, an empty key is returned and
+// multipath routing is not used.
+func makeWarmRoutesKey(conn *network.Connection) string {
+	if conn.Entity.Country == "" {
+		return ""
+	}
+
+	var ipVersion string
+	switch conn.IPVersion {
+	case packet.IPv4:
+		ipVersion = "/4"
+	case packet.IPv6:
+		ipVersion = "/6"
+	default:
+		return ""
+	}
+
+	return makeStickyKey(makeStickyProfileKey(conn), conn.Entity.Country+ipVersion)
+}
+
+// useWarmRoute attempts to establish the tunnel via one of the warm routes of
+// the destination region. The route is selected randomly, weighted by the
+// latency measured over the routes and by their bottleneck capacity.
+func (t *Tunnel) useWarmRoute(ctx context.Context) (ok bool) {
+	key := makeWarmRoutesKey(t.connInfo)
+	if key == "" {
+		return false
+	}
+
+	// Get intel from map before locking pins to avoid simultaneous locking.
+	mapIntel := navigator.Main.GetIntel()
+	transitMatcher := t.connInfo.TunnelOpts.Matcher(navigator.TransitHub, mapIntel)
+	destinationMatcher := t.connInfo.TunnelOpts.Matcher(navigator.DestinationHub, mapIntel)
+
+	// Get usable warm routes.
+	warmRoutesLock.Lock()
+	set := warmRoutes[key]
+	if set == nil || set.isExpired() {
+		warmRoutesLock.Unlock()
+		return false
+	}
+	candidates := make([]*navigator.Route, 0, len(set.routes))
+	latencies := make([]time.Duration, 0, len(set.routes))
+	for _, route := range set.routes {
+		if warmRouteMatches(route, t.connInfo, transitMatcher, destinationMatcher) {
+			candidates = append(candidates, route)
+			latencies = append(latencies, set.latencies[route])
+		}
+	}
+	warmRoutesLock.Unlock()
+
+	// Get the bottleneck capacity of the candidates from the map.
+	capacities := make([]int, 0, len(candidates))
+	for _, route := range candidates {
+		capacities = append(capacities, navigator.Main.RouteCapacity(route))
+	}
+
+	// Try candidates until one succeeds.
+	for len(candidates) > 0 {
+		selected := selectWeighted(warmRouteWeights(latencies, capacities), mrand.Float64()) //nolint:gosec
+		route := candidates[selected]
+
+		dstPin, dstTerminal, err := establishRoute(route)
+		if err == nil {
+			log.Tracer(ctx).Tracef("spn/crew: using warm route to %s", dstPin.Hub)
+			t.dstPin = dstPin
+			t.dstTerminal = dstTerminal
+			t.route = route
+			return true
+		}
+
+		// Remove failed route from candidates and from the warm routes.
+		log.Tracer(ctx).Tracef("spn/crew: failed to use warm route to %s: %s", route.Path[len(route.Path)-1].Pin().Hub, err)
+		candidates = append(candidates[:selected], candidates[selected+1:]...)
+		latencies = append(latencies[:selected], latencies[selected+1:]...)
+		capacities = append(capacities[:selected], capacities[selected+1:]...)
+		removeWarmRoute(key, route)
+	}
+
+	return false
+}
+
+// warmRouteMatches checks if the warm route may be used for the connection.
+func warmRouteMatches(
+	route *navigator.Route,
+	conn *network.Connection,
+	transitMatcher, destinationMatcher navigator.PinMatcher,
+) bool {
+	if len(route.Path) < 2 {
+		return false
+	}
+
+	for i, hop := range route.Path[1:] {
+		pin := hop.Pin()
+		pin.Lock()
+		var ok bool
+		if i == len(route.Path)-2 {
+			// Check if the destination Hub supports the needed IP version.
+			switch {
+			case conn.IPVersion == packet.IPv4 && pin.EntityV4 == nil:
+				// Connection is IPv4, but destination Hub has no IPv4.
+			case conn.IPVersion == packet.IPv6 && pin.EntityV6 == nil:
+				// Connection is IPv6, but destination Hub has no IPv6.
+			default:
+				ok = destinationMatcher(pin)
+			}
+		} else {
+			ok = transitMatcher(pin)
+		}
+		pin.Unlock()
+
+		if !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// warmRouteWeights returns the weights of routes with the given latencies and
+// bottleneck capacities for load balancing. Routes are weighed inversely to
+// their latency and proportionally to their capacity. Routes without a
+// measurement get the average of the measured routes.
+func warmRouteWeights(latencies []time.Duration, capacities []int) []float64 {
+	latencyWeights := make([]float64, len(latencies))
+	for i, latency := range latencies {
+		if latency > 0 {
+			latencyWeights[i] = 1 / latency.Seconds()
+		}
+	}
+	fillUnmeasuredWeights(latencyWeights)
+
+	capacityWeights := make([]float64, len(capacities))
+	for i, capacity := range capacities {
+		capacityWeights[i] = float64(capacity)
+	}
+	fillUnmeasuredWeights(capacityWeights)
+
+	weights := make([]float64, len(latencies))
+	for i := range weights {
+		weights[i] = latencyWeights[i] * capacityWeights[i]
+	}
+	return weights
+}
+
+// fillUnmeasuredWeights sets weights of zero to the average of the other
+// weights. If there are no other weights, all weights are set to 1.
+func fillUnmeasuredWeights(weights []float64) {
+	var (
+		total    float64
+		measured int
+	)
+	for _, weight := range weights {
+		if weight > 0 {
+			total += weight
+			measured++
+		}
+	}
+
+	average := float64(1)
+	if measured > 0 {
+		average = total / float64(measured)
+	}
+	for i, weight := range weights {
+		if weight == 0 {
+			weights[i] = average
+		}
+	}
+}
+
+// pingRoute measures the round trip time of the route by pinging the
+// destination Hub through it.
+func pingRoute(ctx context.Context, dstTerminal terminal.Terminal) (time.Duration, *terminal.Error) {
+	started := time.Now()
+
+	// Start ping operation.
+	pingOp, tErr := NewPingOp(dstTerminal)
+	if tErr != nil {
+		return 0, tErr
+	}
+
+	// Wait for response.
+	select {
+	case <-ctx.Done():
+		return 0, terminal.ErrCanceled
+	case <-time.After(warmRoutePingTimeout):
+		return 0, terminal.ErrTimeout
+	case result := <-pingOp.Result:
+		if result.Is(terminal.ErrExplicitAck) {
+			return time.Since(started), nil
+		}
+		if result.IsOK() {
+			return 0, result.Wrap("unexpected response")
+		}
+		return 0, result
+	}
+}
+
+// selectWeighted selects an index of the given weights, using the given random
+// value in the range [0, 1).
+func selectWeighted(weights []float64, random float64) int {
+	var total float64
+	for _, weight := range weights {
+		total += weight
+	}
+
+	target := random * total
+	for i, weight := range weights {
+		target -= weight
+		if target < 0 {
+			return i
+		}
+	}
+
+	return len(weights) - 1
+}
+
+// warmUpRoutes starts establishing additional routes to the destination region
+// of the tunnel, if not enough warm routes are available.
+func (t *Tunnel) warmUpRoutes(routes *navigator.Routes) {
+	key := makeWarmRoutesKey(t.connInfo)
+	if key == "" {
+		return
+	}
+
+	// Create a new set if needed and check if warming up is required.
+	warmRoutesLock.Lock()
+	defer warmRoutesLock.Unlock()
+
+	set := warmRoutes[key]
+	if set == nil || set.isExpired() {
+		set = &warmRouteSet{
+			routes:    []*navigator.Route{t.route},
+			created:   time.Now(),
+			latencies: make(map[*navigator.Route]time.Duration),
+		}
+		warmRoutes[key] = set
+	}
+	if set.warming || len(set.routes) >= maxWarmRoutes {
+		return
+	}
+	set.warming = true
+
+	// Select additional routes and establish them in the background.
+	// Only as many routes as are missing are established. Routes are only
+	// removed from the set while warming, so the set cannot overflow.
+	candidates := selectWarmRouteCandidates(set.routes, routes.All, maxWarmRoutes-len(set.routes))
+
+	// Measure the route of the tunnel too, if it is part of the set.
+	tunnelRoute, tunnelTerminal := t.route, t.dstTerminal
+	var measureTunnelRoute bool
+	for _, route := range set.routes {
+		if route == tunnelRoute {
+			_, measured := set.latencies[route]
+			measureTunnelRoute = !measured && tunnelTerminal != nil
+		}
+	}
+	module.StartWorker("warm up routes", func(ctx context.Context) error {
+		latencies := make(map[*navigator.Route]time.Duration, len(candidates)+1)
+
+		if measureTunnelRoute {
+			if latency, tErr := pingRoute(ctx, tunnelTerminal); tErr == nil {
+				latencies[tunnelRoute] = latency
+			}
+		}
+
+		var established []*navigator.Route
+		for _, route := range candidates {
+			_, dstTerminal, err := establishRoute(route)
+			if err != nil {
+				log.Debugf("spn/crew: failed to warm up route %s: %s", route, err)
+				continue
+			}
+			established = append(established, route)
+
+			latency, tErr := pingRoute(ctx, dstTerminal)
+			if tErr != nil {
+				log.Debugf("spn/crew: failed to measure latency of warm route %s: %s", route, tErr)
+				continue
+			}
+			latencies[route] = latency
+		}
+
+		warmRoutesLock.Lock()
+		set.warming = false
+		set.routes = append(set.routes, established...)
+		for _, route := range set.routes {
+			if latency, ok := latencies[route]; ok {
+				set.latencies[route] = latency
+			}
+		}
+		warmRoutesLock.Unlock()
+
+		navigator.Main.PushPinChanges()
+		return nil
+	})
+}
+
+// selectWarmRouteCandidates selects up to limit routes that end at a different
+// destination Hub than the existing routes. Routes that share no Hubs with the
+// existing routes, except the Home Hub, are preferred, in order to avoid
+// hotspots on single transit Hubs.
+func selectWarmRouteCandidates(existing, available []*navigator.Route, limit int) []*navigator.Route {
+	used := make(map[string]struct{})
+	usedDestinations := make(map[string]struct{})
+	for _, route := range existing {
+		addRouteHubs(route, used, usedDestinations)
+	}
+
+	selected := make([]*navigator.Route, 0, limit)
+	for _, onlyDisjoint := range []bool{true, false} {
+	nextRoute:
+		for _, route := range available {
+			if len(selected) >= limit {
+				return selected
+			}
+			if len(route.Path) < 2 {
+				continue
+			}
+
+			// Every destination Hub may only be used once, as there is only one
+			// route to a Hub at a time.
+			if _, ok := usedDestinations[route.Path[len(route.Path)-1].HubID]; ok {
+				continue
+			}
+
+			// Check for shared transit Hubs.
+			if onlyDisjoint {
+				for _, hop := range route.Path[1:] {
+					if _, ok := used[hop.HubID]; ok {
+						continue nextRoute
+					}
+				}
+			}
+
+			selected = append(selected, route)
+			addRouteHubs(route, used, usedDestinations)
+		}
+	}
+
+	return selected
+}
+
+func addRouteHubs(route *navigator.Route, used, usedDestinations map[string]struct{}) {
+	if len(route.Path) < 2 {
+		return
+	}
+	for _, hop := range route.Path[1:] {
+		used[hop.HubID] = struct{}{}
+	}
+	usedDestinations[route.Path[len(route.Path)-1].HubID] = struct{}{}
+}
+
+func removeWarmRoute(key string, route *navigator.Route) {
+	warmRoutesLock.Lock()
+	defer warmRoutesLock.Unlock()
+
+	set := warmRoutes[key]
+	if set == nil {
+		return
+	}
+	for i, warmRoute := range set.routes {
+		if warmRoute == route {
+			set.routes = append(set.routes[:i], set.routes[i+1:]...)
+			delete(set.latencies, route)
+			return
+		}
+	}
+}
+
+func cleanWarmRoutes(ctx context.Context, task *modules.Task) error {
+	warmRoutesLock.Lock()
+	defer warmRoutesLock.Unlock()
+
+	for key, set := range warmRoutes {
+		if set.isExpired() && !set.warming {
+			delete(warmRoutes, key)
+		}
+	}
+
+	return nil
+}
+
+func clearWarmRoutes() {
+	warmRoutesLock.Lock()
+	defer warmRoutesLock.Unlock()
+
+	warmRoutes = make(map[string]*warmRouteSet)
+}
@@ -0,0 +1,92 @@
+package crew
+
+import (
+	"testing"
+	"time"
+
+	"github.com/safing/spn/navigator"
+)
+
+func TestSelectWeighted(t *testing.T) {
+	t.Parallel()
+
+	weights := []float64{1, 2, 1}
+	for _, tc := range []struct {
+		random   float64
+		selected int
+	}{
+		{0, 0},
+		{0.2, 0},
+		{0.3, 1},
+		{0.7, 1},
+		{0.8, 2},
+		{0.99, 2},
+	} {
+		if selected := selectWeighted(weights, tc.random); selected != tc.selected {
+			t.Errorf("random %f: expected %d, got %d", tc.random, tc.selected, selected)
+		}
+	}
+}
+
+func TestWarmRouteWeights(t *testing.T) {
+	t.Parallel()
+
+	// Routes are weighed inversely to their latency.
+	weights := warmRouteWeights([]time.Duration{50 * time.Millisecond, 100 * time.Millisecond, 0}, []int{0, 0, 0})
+	if weights[0] != 2*weights[1] {
+		t.Errorf("route with half the latency should have double the weight: %v", weights)
+	}
+	// Unmeasured routes get the average weight.
+	if weights[2] != (weights[0]+weights[1])/2 {
+		t.Errorf("unmeasured route should have the average weight: %v", weights)
+	}
+
+	// Without measurements, all routes are weighed equally.
+	weights = warmRouteWeights([]time.Duration{0, 0}, []int{0, 0})
+	if weights[0] != weights[1] || weights[0] == 0 {
+		t.Errorf("unmeasured routes should be weighed equally: %v", weights)
+	}
+
+	// Routes are weighed proportionally to their bottleneck capacity.
+	weights = warmRouteWeights(
+		[]time.Duration{50 * time.Millisecond, 50 * time.Millisecond, 100 * time.Millisecond},
+		[]int{10000000, 20000000, 20000000},
+	)
+	if weights[1] != 2*weights[0] {
+		t.Errorf("route with double the capacity should have double the weight: %v", weights)
+	}
+	if weights[2] != weights[0] {
+		t.Errorf("route with double the latency and capacity should have the same weight: %v", weights)
+	}
+}
+
+func TestSelectWarmRouteCandidates(t *testing.T) {
+	t.Parallel()
+
+	makeRoute := func(hubIDs ...string) *navigator.Route {
+		route := &navigator.Route{}
+		for _, hubID := range hubIDs {
+			route.Path = append(route.Path, &navigator.Hop{HubID: hubID})
+		}
+		return route
+	}
+
+	existing := []*navigator.Route{makeRoute("home", "a", "b")}
+	available := []*navigator.Route{
+		makeRoute("home", "a", "b"), // Same as existing.
+		makeRoute("home", "c", "b"), // Same destination.
+		makeRoute("home", "a", "d"), // Shared transit.
+		makeRoute("home", "e", "f"), // Disjoint.
+		makeRoute("home", "e", "g"), // Shared transit with previous.
+	}
+
+	selected := selectWarmRouteCandidates(existing, available, 2)
+	if len(selected) != 2 || selected[0] != available[3] || selected[1] != available[2] {
+		t.Errorf("unexpected selection of %d routes", len(selected))
+	}
+
+	selected = selectWarmRouteCandidates(existing, available, 5)
+	if len(selected) != 3 {
+		t.Errorf("expected 3 routes, got %d", len(selected))
+	}
+}
@@ -7,9 +7,12 @@ import (
 	"io"
 	"net"
 	"strconv"
+	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/tevino/abool"
+
 	"github.com/safing/portbase/container"
 	"github.com/safing/portbase/formats/dsd"
 	"github.com/safing/portbase/log"
@@ -38,22 +41,51 @@ type ConnectOp struct {
 	cancelCtx context.CancelFunc
 	// doneWriting signals that the writer has finished writing.
 	doneWriting chan struct{}
+	// handledStop is closed when the operation has finished stopping.
+	handledStop chan struct{}
+	// workers tracks the connection reader and writer.
+	workers sync.WaitGroup
 
 	// Metrics
 	incomingTraffic *uint64
 	outgoingTraffic *uint64
 	started         time.Time
+	lastActivity    *int64
 
 	// Connection
-	t       terminal.Terminal
-	conn    net.Conn
-	request *ConnectRequest
-	entry   bool
-	tunnel  *Tunnel
+	t        terminal.Terminal
+	conn     net.Conn
+	connLock sync.Mutex
+	request  *ConnectRequest
+	entry    bool
+	tunnel   *Tunnel
+
+	// lastRebind holds the time when the connection was last rebound in
+	// datagram mode.
+	lastRebind time.Time
+
+	// Resumption
+	// resumption holds the state required to resume the connection via another
+	// route. It is nil if the connection is not resumable.
+	resumption *connectResumption
+	// resumed specifies that the operation resumes a suspended connection.
+	resumed bool
+	// resumeReply holds the reply to send first when resuming on the exit Hub.
+	resumeReply []byte
+	// resumeReplay receives the data the other side missed when resuming.
+	resumeReplay chan []byte
+	// suspended is set when the operation was suspended in order to be resumed.
+	suspended abool.AtomicBool
+	// takenOver is set when the connection is resumed via another route while
+	// the operation is still active.
+	takenOver abool.AtomicBool
 }
 
 // Type returns the type ID.
 func (op *ConnectOp) Type() string {
+	if op.resumed {
+		return ConnectResumeOpType
+	}
 	return ConnectOpType
 }
 
@@ -70,6 +102,11 @@ type ConnectRequest struct {
 	Protocol            packet.IPProtocol `json:"p,omitempty"`
 	Port                uint16            `json:"po,omitempty"`
 	QueueSize           uint32            `json:"qs,omitempty"`
+	// Datagrams specifies that every message carries exactly one datagram.
+	Datagrams bool `json:"dg,omitempty"`
+	// ResumeToken identifies the connection in order to resume it via another
+	// route, if the current route fails. Only supported for streams.
+	ResumeToken []byte `json:"rt,omitempty"`
 }
 
 // Address returns the address of the connext request.
@@ -102,6 +139,21 @@ func NewConnectOp(tunnel *Tunnel) (*ConnectOp, *terminal.Error) {
 		Port:                tunnel.connInfo.Entity.Port,
 		UsePriorityDataMsgs: terminal.UsePriorityDataMsgs,
 	}
+	if request.Protocol == packet.UDP {
+		request.Datagrams = true
+	}
+
+	// Make streams resumable, if enabled and supported by the exit Hub.
+	var resumption *connectResumption
+	if !request.Datagrams && resumeConnectionsEnabled() && tunnel.canResume() {
+		token, err := newResumeToken()
+		if err != nil {
+			return nil, terminal.ErrInternalError.With("failed to create resume token: %w", err)
+		}
+		request.ResumeToken = token
+		request.QueueSize = resumableQueueSize
+		resumption = newConnectResumption(token, request.QueueSize)
+	}
 
 	// Set defaults.
 	if request.QueueSize == 0 {
@@ -111,11 +163,13 @@ func NewConnectOp(tunnel *Tunnel) (*ConnectOp, *terminal.Error) {
 	// Create new op.
 	op := &ConnectOp{
 		doneWriting: make(chan struct{}),
+		handledStop: make(chan struct{}),
 		t:           tunnel.dstTerminal,
 		conn:        tunnel.conn,
 		request:     request,
 		entry:       true,
 		tunnel:      tunnel,
+		resumption:  resumption,
 	}
 	op.ctx, op.cancelCtx = context.WithCancel(module.Ctx)
 	op.dfq = terminal.NewDuplexFlowQueue(op.Ctx(), request.QueueSize, op.submitUpstream)
@@ -136,10 +190,10 @@ func NewConnectOp(tunnel *Tunnel) (*ConnectOp, *terminal.Error) {
 	op.incomingTraffic = new(uint64)
 	op.outgoingTraffic = new(uint64)
 	op.started = time.Now()
+	op.lastActivity = new(int64)
+	op.markActive()
 
-	module.StartWorker("connect op conn reader", op.connReader)
-	module.StartWorker("connect op conn writer", op.connWriter)
-	module.StartWorker("connect op flow handler", op.dfq.FlowHandler)
+	op.startWorkers()
 
 	log.Infof("spn/crew: connected to %s via %s", request, tunnel.dstPin.Hub)
 	return op, nil
@@ -163,6 +217,19 @@ func startConnectOp(t terminal.Terminal, opID uint32, data *container.Container)
 	if request.QueueSize == 0 || request.QueueSize > terminal.MaxQueueSize {
 		return nil, terminal.ErrInvalidOptions.With("invalid queue size of %d", request.QueueSize)
 	}
+	if request.Datagrams && request.Protocol != packet.UDP {
+		return nil, terminal.ErrInvalidOptions.With("datagrams are not supported for protocol %s", request.Protocol)
+	}
+	if request.ResumeToken != nil {
+		switch {
+		case len(request.ResumeToken) != resumeTokenSize:
+			return nil, terminal.ErrInvalidOptions.With("invalid resume token size of %d", len(request.ResumeToken))
+		case request.Datagrams:
+			return nil, terminal.ErrInvalidOptions.With("datagrams cannot be resumed")
+		case request.QueueSize > resumableQueueSize:
+			return nil, terminal.ErrInvalidOptions.With("queue size of %d is too big for resuming", request.QueueSize)
+		}
+	}
 
 	// Check if connection target is in global scope.
 	ipScope := netutils.GetIPScope(request.IP)
@@ -187,7 +254,7 @@ func startConnectOp(t terminal.Terminal, opID uint32, data *container.Container)
 	}
 
 	// Connect to destination.
-	conn, err := net.DialTimeout(dialNet, request.Address(), 3*time.Second)
+	conn, err := dialConnectOpDestination(dialNet, request.Address())
 	if err != nil {
 		return nil, terminal.ErrConnectionError.With("failed to connect to %s: %w", request, err)
 	}
@@ -195,6 +262,7 @@ func startConnectOp(t terminal.Terminal, opID uint32, data *container.Container)
 	// Create and initialize operation.
 	op := &ConnectOp{
 		doneWriting: make(chan struct{}),
+		handledStop: make(chan struct{}),
 		t:           t,
 		conn:        conn,
 		request:     request,
@@ -203,19 +271,44 @@ func startConnectOp(t terminal.Terminal, opID uint32, data *container.Container)
 	op.ctx, op.cancelCtx = context.WithCancel(t.Ctx())
 	op.dfq = terminal.NewDuplexFlowQueue(op.Ctx(), request.QueueSize, op.submitUpstream)
 
+	// Register for resuming.
+	if request.ResumeToken != nil {
+		op.resumption = newConnectResumption(request.ResumeToken, request.QueueSize)
+		if tErr := registerResumableConnectOp(op); tErr != nil {
+			_ = conn.Close()
+			return nil, tErr
+		}
+	}
+
 	// Setup metrics.
 	op.incomingTraffic = new(uint64)
 	op.outgoingTraffic = new(uint64)
+	op.lastActivity = new(int64)
+	op.markActive()
 
 	// Start worker.
-	module.StartWorker("connect op conn reader", op.connReader)
-	module.StartWorker("connect op conn writer", op.connWriter)
-	module.StartWorker("connect op flow handler", op.dfq.FlowHandler)
+	op.startWorkers()
 
 	log.Infof("spn/crew: connected op %s#%d to %s", op.t.FmtID(), op.ID(), request)
 	return op, nil
 }
 
+// dialConnectOpDestination connects to the destination of a connect request.
+// It is a variable in order to be able to replace it in tests.
+var dialConnectOpDestination = func(network, address string) (net.Conn, error) {
+	return net.DialTimeout(network, address, 3*time.Second)
+}
+
+func (op *ConnectOp) startWorkers() {
+	op.workers.Add(2)
+	module.StartWorker("connect op conn reader", op.connReader)
+	module.StartWorker("connect op conn writer", op.connWriter)
+	module.StartWorker("connect op flow handler", op.dfq.FlowHandler)
+	if op.request.Datagrams {
+		module.StartWorker("connect op datagram idle handler", op.datagramIdleHandler)
+	}
+}
+
 func (op *ConnectOp) submitUpstream(msg *terminal.Msg, timeout time.Duration) {
 	err := op.Send(msg, timeout)
 	if err != nil {
@@ -237,20 +330,48 @@ const (
 )
 
 func (op *ConnectOp) connReader(_ context.Context) error {
+	defer op.workers.Done()
+
 	// Metrics setup and submitting.
 	atomic.AddInt64(activeConnectOps, 1)
 	defer func() {
 		atomic.AddInt64(activeConnectOps, -1)
-		connectOpDurationHistogram.UpdateDuration(op.started)
-		connectOpIncomingDataHistogram.Update(float64(atomic.LoadUint64(op.incomingTraffic)))
+		// Only submit when the connection ends for good.
+		if !op.suspended.IsSet() {
+			connectOpDurationHistogram.UpdateDuration(op.started)
+			connectOpIncomingDataHistogram.Update(float64(atomic.LoadUint64(op.incomingTraffic)))
+		}
 	}()
 
+	// Send missed data first when resuming.
+	if op.resumed {
+		if tErr := op.sendResumeData(); tErr != nil {
+			op.Stop(op, tErr)
+			return nil
+		}
+	}
+
 	rateLimiter := terminal.NewRateLimiter(rateLimitMaxMbit)
 
+	// In datagram mode, read into a reusable buffer that fits any datagram.
+	var datagramBuf []byte
+	if op.request.Datagrams {
+		datagramBuf = make([]byte, maxDatagramSize)
+	}
+
 	for {
 		// Read from connection.
-		buf := make([]byte, readBufSize)
-		n, err := op.conn.Read(buf)
+		var data []byte
+		var err error
+		if op.request.Datagrams {
+			data, err = op.readDatagram(datagramBuf)
+		} else {
+			buf := make([]byte, readBufSize)
+			var n int
+			n, err = op.conn.Read(buf)
+			data = buf[:n]
+		}
+		n := len(data)
 		if err != nil {
 			if errors.Is(err, io.EOF) {
 				op.Stop(op, terminal.ErrStopping.With("connection to %s was closed on read", op.connectedType()))
@@ -264,6 +385,11 @@ func (op *ConnectOp) connReader(_ context.Context) error {
 			continue
 		}
 
+		// Keep data for resuming.
+		if op.resumption != nil {
+			op.resumption.replay.Write(data)
+		}
+
 		// Submit metrics.
 		connectOpIncomingBytes.Add(n)
 		inBytes := atomic.AddUint64(op.incomingTraffic, uint64(n))
@@ -274,7 +400,7 @@ func (op *ConnectOp) connReader(_ context.Context) error {
 		}
 
 		// Create message from data.
-		msg := op.NewMsg(buf[:n])
+		msg := op.NewMsg(data)
 
 		// Define priority and possibly wait for slot.
 		switch {
@@ -303,16 +429,28 @@ func (op *ConnectOp) Deliver(msg *terminal.Msg) *terminal.Error {
 }
 
 func (op *ConnectOp) connWriter(_ context.Context) error {
+	defer op.workers.Done()
+
 	// Metrics submitting.
 	defer func() {
-		connectOpOutgoingDataHistogram.Update(float64(atomic.LoadUint64(op.outgoingTraffic)))
+		if !op.suspended.IsSet() {
+			connectOpOutgoingDataHistogram.Update(float64(atomic.LoadUint64(op.outgoingTraffic)))
+		}
 	}()
 
 	defer func() {
-		// Close connection.
-		_ = op.conn.Close()
+		// Close connection, unless it is kept for resuming.
+		if !op.suspended.IsSet() {
+			_ = op.getConn().Close()
+			if op.resumption != nil {
+				op.resumption.replay.Free()
+			}
+		}
 	}()
 
+	// The first message is the reply of the exit Hub when resuming.
+	awaitResumeReply := op.resumed && op.entry
+
 	var msg *terminal.Msg
 	defer msg.Finish()
 
@@ -347,6 +485,16 @@ writing:
 			continue writing
 		}
 
+		// Handle resume reply.
+		if awaitResumeReply {
+			awaitResumeReply = false
+			if tErr := op.handleResumeReply(data); tErr != nil {
+				op.Stop(op, tErr)
+				return nil
+			}
+			continue writing
+		}
+
 		// Submit metrics.
 		connectOpOutgoingBytes.Add(len(data))
 		out := atomic.AddUint64(op.outgoingTraffic, uint64(len(data)))
@@ -368,9 +516,21 @@ writing:
 			}
 		}
 
+		// Send data as a single datagram.
+		if op.request.Datagrams {
+			if err := op.writeDatagram(data); err != nil {
+				op.Stop(op, terminal.ErrConnectionError.With("failed to send datagram to %s: %w", op.connectedType(), err))
+				return nil
+			}
+			continue writing
+		}
+
 		// Send all given data.
 		for {
 			n, err := op.conn.Write(data)
+			if n > 0 && op.resumption != nil {
+				atomic.AddUint64(op.resumption.written, uint64(n))
+			}
 			switch {
 			case err != nil:
 				if errors.Is(err, io.EOF) {
@@ -404,6 +564,17 @@ func (op *ConnectOp) connectedType() string {
 // The returned error is the error to send to the other side.
 // Should never be called directly. Call Stop() instead.
 func (op *ConnectOp) HandleStop(err *terminal.Error) (errorToSend *terminal.Error) {
+	defer close(op.handledStop)
+
+	// Keep the connection if the route failed and resume it via another route.
+	if op.shouldSuspend(err) {
+		op.suspend()
+		return err
+	}
+	if op.resumption != nil && !op.entry {
+		unregisterResumableConnectOp(op)
+	}
+
 	if err.IsError() {
 		reportConnectError(err)
 	}
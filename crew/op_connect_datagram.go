@@ -0,0 +1,154 @@
+package crew
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/spn/terminal"
+)
+
+/*
+
+Datagram Mode
+
+In datagram mode, every operation message carries exactly one datagram, so
+that datagram boundaries are preserved through the tunnel. This is required
+for protocols that rely on them, such as QUIC, DNS and most games.
+
+As UDP has no concept of a connection, the connect operation behaves like a NAT:
+- The operation is ended after it has been idle for a while.
+- Datagrams the destination reports as undeliverable are silently dropped.
+- If the socket to the destination breaks, the exit rebinds to a new one, just
+  like a NAT would create a new mapping.
+
+*/
+
+const (
+	// maxDatagramSize is the maximum size of an UDP datagram.
+	maxDatagramSize = 65535
+
+	// datagramIdleTimeout defines after which time of inactivity a datagram
+	// connect op is ended. RFC 4787 recommends at least 2 minutes for NAT
+	// mappings.
+	datagramIdleTimeout = 2 * time.Minute
+
+	// datagramRebindInterval defines the minimum interval between rebinding
+	// the socket to the destination.
+	datagramRebindInterval = 10 * time.Second
+)
+
+func (op *ConnectOp) getConn() net.Conn {
+	op.connLock.Lock()
+	defer op.connLock.Unlock()
+
+	return op.conn
+}
+
+func (op *ConnectOp) markActive() {
+	atomic.StoreInt64(op.lastActivity, time.Now().UnixNano())
+}
+
+// readDatagram reads the next datagram from the connection into buf and
+// returns a copy of it.
+func (op *ConnectOp) readDatagram(buf []byte) ([]byte, error) {
+	for {
+		conn := op.getConn()
+		n, err := conn.Read(buf)
+		if err != nil {
+			switch {
+			case op.getConn() != conn:
+				// The connection was rebound, continue with the new one.
+				continue
+			case isUndeliverableErr(err):
+				// A previous datagram could not be delivered, ignore.
+				continue
+			}
+			return nil, err
+		}
+		op.markActive()
+
+		// Copy datagram, so that buf can be reused.
+		datagram := make([]byte, n)
+		copy(datagram, buf[:n])
+		return datagram, nil
+	}
+}
+
+// writeDatagram writes data as a single datagram to the connection.
+func (op *ConnectOp) writeDatagram(data []byte) error {
+	conn := op.getConn()
+	_, err := conn.Write(data)
+
+	// Rebind to a new socket on the exit, if the current one broke.
+	if err != nil &&
+		!op.entry &&
+		!isUndeliverableErr(err) &&
+		time.Since(op.lastRebind) > datagramRebindInterval {
+		if rebindErr := op.rebind(conn); rebindErr != nil {
+			log.Debugf("spn/crew: connect op %s#%d failed to rebind to %s: %s", op.t.FmtID(), op.ID(), op.request, rebindErr)
+		} else {
+			_, err = op.getConn().Write(data)
+		}
+	}
+
+	switch {
+	case err == nil:
+		op.markActive()
+		return nil
+	case isUndeliverableErr(err):
+		// The destination did not accept a previous datagram, drop like a NAT.
+		return nil
+	default:
+		return err
+	}
+}
+
+// rebind replaces the connection to the destination with a new one.
+// Must only be called by the writer.
+func (op *ConnectOp) rebind(oldConn net.Conn) error {
+	op.lastRebind = time.Now()
+
+	newConn, err := net.DialTimeout("udp", op.request.Address(), 3*time.Second)
+	if err != nil {
+		return err
+	}
+
+	op.connLock.Lock()
+	op.conn = newConn
+	op.connLock.Unlock()
+
+	// Close old connection after switching in order to unblock the reader.
+	_ = oldConn.Close()
+
+	log.Debugf("spn/crew: connect op %s#%d rebound to %s from %s", op.t.FmtID(), op.ID(), op.request, newConn.LocalAddr())
+	return nil
+}
+
+func (op *ConnectOp) datagramIdleHandler(_ context.Context) error {
+	ticker := time.NewTicker(datagramIdleTimeout / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			lastActivity := time.Unix(0, atomic.LoadInt64(op.lastActivity))
+			if time.Since(lastActivity) > datagramIdleTimeout {
+				op.Stop(op, terminal.ErrStopping.With("no datagrams for %s", datagramIdleTimeout))
+				return nil
+			}
+		case <-op.ctx.Done():
+			return nil
+		}
+	}
+}
+
+// isUndeliverableErr returns whether the error was caused by a datagram
+// that was rejected by the destination via ICMP.
+func isUndeliverableErr(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
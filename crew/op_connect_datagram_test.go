@@ -0,0 +1,62 @@
+package crew
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestConnectOpDatagrams(t *testing.T) {
+	t.Parallel()
+
+	// Start UDP echo server.
+	server, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+	go func() {
+		buf := make([]byte, maxDatagramSize)
+		for {
+			n, addr, err := server.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			_, _ = server.WriteToUDP(buf[:n], addr)
+		}
+	}()
+
+	// Create connect op with a connection to the echo server.
+	conn, err := net.Dial("udp", server.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	op := &ConnectOp{
+		conn:         conn,
+		request:      &ConnectRequest{Datagrams: true},
+		entry:        true,
+		lastActivity: new(int64),
+	}
+	defer func() {
+		_ = op.getConn().Close()
+	}()
+
+	// Check that datagram boundaries are preserved, also for datagrams larger
+	// than the stream read size.
+	buf := make([]byte, maxDatagramSize)
+	for _, size := range []int{1, 100, readBufSize, 5000} {
+		datagram := bytes.Repeat([]byte{byte(size)}, size)
+		if err := op.writeDatagram(datagram); err != nil {
+			t.Fatalf("failed to write datagram: %s", err)
+		}
+		received, err := op.readDatagram(buf)
+		if err != nil {
+			t.Fatalf("failed to read datagram: %s", err)
+		}
+		if !bytes.Equal(datagram, received) {
+			t.Fatalf("datagram of size %d was received with size %d", size, len(received))
+		}
+	}
+}
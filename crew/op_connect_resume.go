@@ -0,0 +1,556 @@
+package crew
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/safing/portbase/container"
+	"github.com/safing/portbase/formats/dsd"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/rng"
+	"github.com/safing/spn/conf"
+	"github.com/safing/spn/hub"
+	"github.com/safing/spn/navigator"
+	"github.com/safing/spn/terminal"
+)
+
+// ConnectResumeOpType is the type ID for resuming a suspended connect operation.
+const ConnectResumeOpType string = "connect/resume"
+
+const (
+	// resumableQueueSize is the queue size used for resumable connect
+	// operations. It is smaller than the default, as it limits the amount of
+	// data that has to be buffered in order to be able to resume.
+	resumableQueueSize = 1000
+
+	// resumeTokenSize is the size of the token identifying a resumable
+	// connection.
+	resumeTokenSize = 16
+
+	// connectOpResumeGracePeriod defines how long a suspended connection is kept
+	// alive while waiting to be resumed.
+	connectOpResumeGracePeriod = 1 * time.Minute
+
+	// connectOpTakeOverTimeout defines how long to wait for a connect operation
+	// that is still active to be suspended when resuming it via another route.
+	connectOpTakeOverTimeout = 3 * time.Second
+
+	// replayMemoryLimit defines how much memory all replay buffers may use in
+	// total. When reached, replay buffers stop growing, which may make resuming
+	// fail if too much data was lost.
+	replayMemoryLimit = 100_000_000
+)
+
+// replayMemoryUsed holds the amount of memory used by all replay buffers.
+var replayMemoryUsed = new(int64)
+
+// ConnectResumeRequest is used to resume a suspended connect operation via a
+// new route.
+type ConnectResumeRequest struct {
+	// Token identifies the connection to resume.
+	Token []byte `json:"t"`
+	// Received is the amount of data the client has written to its connection.
+	Received uint64 `json:"r"`
+}
+
+// ConnectResumeReply is sent by the exit Hub as the first message of a resumed
+// connect operation.
+type ConnectResumeReply struct {
+	// Received is the amount of data the exit Hub has written to its connection.
+	Received uint64 `json:"r"`
+}
+
+// connectResumption holds the state of a resumable connection, which is
+// carried over to the operation that resumes it.
+type connectResumption struct {
+	// token identifies the connection when resuming.
+	token []byte
+	// replay holds recently read data that the other side might have missed.
+	replay *replayBuffer
+	// written holds the amount of data written to the connection.
+	written *uint64
+}
+
+func newConnectResumption(token []byte, queueSize uint32) *connectResumption {
+	return &connectResumption{
+		token: token,
+		// In the worst case, all of the send queue and all of the receive queue on
+		// the other side are lost, as well as the message being read and the
+		// message being written.
+		replay:  newReplayBuffer((2*int(queueSize) + 2) * readBufSize),
+		written: new(uint64),
+	}
+}
+
+var (
+	resumableConnectOps     = make(map[string]*ConnectOp)
+	resumableConnectOpsLock sync.Mutex
+)
+
+func init() {
+	terminal.RegisterOpType(terminal.OperationFactory{
+		Type:     ConnectResumeOpType,
+		Requires: terminal.MayConnect,
+		Start:    startConnectResumeOp,
+	})
+}
+
+// registerResumableConnectOp registers the given operation so that it can be
+// resumed via its token.
+func registerResumableConnectOp(op *ConnectOp) *terminal.Error {
+	resumableConnectOpsLock.Lock()
+	defer resumableConnectOpsLock.Unlock()
+
+	if _, ok := resumableConnectOps[string(op.resumption.token)]; ok {
+		return terminal.ErrInvalidOptions.With("resumption token already in use")
+	}
+	resumableConnectOps[string(op.resumption.token)] = op
+	return nil
+}
+
+// unregisterResumableConnectOp unregisters the given operation and returns
+// whether it was registered.
+func unregisterResumableConnectOp(op *ConnectOp) bool {
+	resumableConnectOpsLock.Lock()
+	defer resumableConnectOpsLock.Unlock()
+
+	if resumableConnectOps[string(op.resumption.token)] != op {
+		return false
+	}
+	delete(resumableConnectOps, string(op.resumption.token))
+	return true
+}
+
+// takeResumableConnectOp unregisters and returns the operation with the given
+// token.
+func takeResumableConnectOp(token []byte) *ConnectOp {
+	resumableConnectOpsLock.Lock()
+	defer resumableConnectOpsLock.Unlock()
+
+	op, ok := resumableConnectOps[string(token)]
+	if !ok {
+		return nil
+	}
+	delete(resumableConnectOps, string(token))
+	return op
+}
+
+// canResume returns whether connections via the tunnel can be resumed, as the
+// exit Hub supports it.
+func (t *Tunnel) canResume() bool {
+	return t.dstPin != nil &&
+		t.dstPin.Hub.Status != nil &&
+		t.dstPin.Hub.Status.HasFlag(hub.FlagConnectResume)
+}
+
+// shouldSuspend returns whether the operation should be suspended instead of
+// stopped, because it failed due to the route and not the connection itself.
+func (op *ConnectOp) shouldSuspend(err *terminal.Error) bool {
+	switch {
+	case op.resumption == nil:
+		// Connection is not resumable.
+		return false
+	case op.takenOver.IsSet():
+		// Connection is being resumed via another route.
+		return true
+	case module.IsStopping():
+		return false
+	case err == nil:
+		// The terminal is being abandoned, only suspend if it failed.
+		return op.t.AbandonError().IsError()
+	case err.IsExternal():
+		// The other side stopped the operation.
+		return false
+	default:
+		// Check if the terminal failed.
+		return op.t.Ctx().Err() != nil
+	}
+}
+
+// suspend stops all workers of the operation, but keeps the connection open in
+// order to resume it via another route.
+func (op *ConnectOp) suspend() {
+	op.suspended.Set()
+
+	// Interrupt any reads or writes and stop all workers.
+	conn := op.getConn()
+	_ = conn.SetDeadline(time.Now())
+	op.cancelCtx()
+	op.workers.Wait()
+	_ = conn.SetDeadline(time.Time{})
+
+	switch {
+	case op.entry:
+		log.Infof("spn/crew: connect op %s>%d to %s suspended, migrating to new route", op.t.FmtID(), op.ID(), op.request)
+		module.StartWorker("connect op migration", op.migrate)
+	case op.takenOver.IsSet():
+		// Connection is already being resumed.
+	default:
+		log.Infof("spn/crew: connect op %s>%d to %s suspended, waiting to be resumed", op.t.FmtID(), op.ID(), op.request)
+		module.StartWorker("connect op resumption waiter", op.awaitResumption)
+	}
+}
+
+// takeOver suspends the operation, if it is still active, so that it can be
+// resumed via another route. It returns whether the operation is suspended.
+func (op *ConnectOp) takeOver() bool {
+	op.takenOver.Set()
+	op.Stop(op, terminal.ErrStopping.With("resumed via another route"))
+
+	select {
+	case <-op.handledStop:
+		return op.suspended.IsSet()
+	case <-time.After(connectOpTakeOverTimeout):
+		return false
+	}
+}
+
+// awaitResumption closes the connection if the operation is not resumed
+// within the grace period.
+func (op *ConnectOp) awaitResumption(ctx context.Context) error {
+	select {
+	case <-time.After(connectOpResumeGracePeriod):
+	case <-ctx.Done():
+	}
+
+	// Close the connection, if it was not resumed in the meantime.
+	if unregisterResumableConnectOp(op) {
+		log.Infof("spn/crew: closing suspended connection to %s, as it was not resumed", op.request)
+		op.closeSuspended()
+	}
+	return nil
+}
+
+// closeSuspended closes the connection of the suspended operation for good.
+func (op *ConnectOp) closeSuspended() {
+	_ = op.getConn().Close()
+	op.resumption.replay.Free()
+}
+
+// migrate resumes the suspended operation via a new route to the same exit Hub.
+func (op *ConnectOp) migrate(ctx context.Context) error {
+	// Try until the exit Hub has given up on the connection.
+	deadline := time.Now().Add(connectOpResumeGracePeriod)
+	for {
+		newOp, err := resumeConnectOp(op)
+		if err == nil {
+			log.Infof("spn/crew: resumed connection to %s via %s", op.request, newOp.tunnel.route)
+			return nil
+		}
+		log.Debugf("spn/crew: failed to resume connection to %s: %s", op.request, err)
+
+		if time.Now().Add(time.Second).After(deadline) {
+			log.Warningf("spn/crew: failed to resume connection to %s: %s", op.request, err)
+			op.closeSuspended()
+			return nil
+		}
+
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			op.closeSuspended()
+			return nil
+		}
+	}
+}
+
+// establishResumeRoute establishes a new route to the exit Hub of the tunnel.
+// It is a variable in order to be able to replace it in tests.
+var establishResumeRoute = func(tunnel *Tunnel) (*navigator.Route, terminal.Terminal, error) {
+	routes, err := navigator.Main.FindRouteToHub(tunnel.dstPin.Hub.ID, tunnel.connInfo.TunnelOpts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to find route to %s: %w", tunnel.dstPin.Hub, err)
+	}
+	if len(routes.All) == 0 {
+		return nil, nil, fmt.Errorf("no routes to %s", tunnel.dstPin.Hub)
+	}
+	for _, route := range routes.All {
+		var dstTerminal terminal.Terminal
+		_, dstTerminal, err = establishRoute(route)
+		if err == nil {
+			navigator.Main.PushPinChanges()
+			return route, dstTerminal, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("failed to establish route to %s: %w", tunnel.dstPin.Hub, err)
+}
+
+// resumeConnectOp resumes the given suspended operation via a new route.
+func resumeConnectOp(suspended *ConnectOp) (*ConnectOp, error) {
+	tunnel := suspended.tunnel
+
+	// Build a new route to the exit Hub.
+	route, dstTerminal, err := establishResumeRoute(tunnel)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create operation with the state of the suspended one.
+	op := suspended.successor(dstTerminal)
+	op.ctx, op.cancelCtx = context.WithCancel(module.Ctx)
+	op.dfq = terminal.NewDuplexFlowQueue(op.Ctx(), op.request.QueueSize, op.submitUpstream)
+
+	// Prepare init msg.
+	data, err := dsd.Dump(&ConnectResumeRequest{
+		Token:    op.resumption.token,
+		Received: atomic.LoadUint64(op.resumption.written),
+	}, dsd.CBOR)
+	if err != nil {
+		return nil, terminal.ErrInternalError.With("failed to pack connect resume request: %w", err)
+	}
+
+	// Initialize.
+	tErr := op.t.StartOperation(op, container.New(data), 5*time.Second)
+	if tErr != nil {
+		op.cancelCtx()
+		return nil, tErr
+	}
+	op.startWorkers()
+
+	// Update tunnel to the new route.
+	tunnel.dstTerminal = dstTerminal
+	tunnel.route = route
+
+	// Update the tunnel context of the connection, if it was already added.
+	tunnel.connInfo.Lock()
+	defer tunnel.connInfo.Unlock()
+	if tunnel.connInfo.TunnelContext != nil {
+		addTunnelContextToConnection(tunnel)
+		tunnel.connInfo.Save()
+	}
+
+	return op, nil
+}
+
+func startConnectResumeOp(t terminal.Terminal, opID uint32, data *container.Container) (terminal.Operation, *terminal.Error) {
+	// Check if we are running a public hub.
+	if !conf.PublicHub() {
+		return nil, terminal.ErrPermissionDenied.With("connecting is only allowed on public hubs")
+	}
+
+	// Parse resume request.
+	request := &ConnectResumeRequest{}
+	_, err := dsd.Load(data.CompileData(), request)
+	if err != nil {
+		return nil, terminal.ErrMalformedData.With("failed to parse connect resume request: %w", err)
+	}
+
+	// Get the connection to resume and make sure it is suspended.
+	suspended := takeResumableConnectOp(request.Token)
+	if suspended == nil {
+		return nil, terminal.ErrConnectionError.With("connection to resume not found")
+	}
+	if !suspended.takeOver() {
+		return nil, terminal.ErrConnectionError.With("connection to resume could not be suspended")
+	}
+
+	// Get the data the client missed.
+	replay, err := suspended.resumption.replay.Since(request.Received)
+	if err != nil {
+		suspended.closeSuspended()
+		return nil, terminal.ErrConnectionError.With("failed to resume connection to %s: %w", suspended.request, err)
+	}
+	reply, err := dsd.Dump(&ConnectResumeReply{
+		Received: atomic.LoadUint64(suspended.resumption.written),
+	}, dsd.CBOR)
+	if err != nil {
+		suspended.closeSuspended()
+		return nil, terminal.ErrInternalError.With("failed to pack connect resume reply: %w", err)
+	}
+
+	// Create and initialize operation.
+	op := suspended.successor(t)
+	op.InitOperationBase(t, opID)
+	op.ctx, op.cancelCtx = context.WithCancel(t.Ctx())
+	op.dfq = terminal.NewDuplexFlowQueue(op.Ctx(), op.request.QueueSize, op.submitUpstream)
+	op.resumeReply = reply
+	op.resumeReplay <- replay
+
+	// Register for resuming again.
+	if tErr := registerResumableConnectOp(op); tErr != nil {
+		op.closeSuspended()
+		return nil, tErr
+	}
+
+	// Start worker.
+	op.startWorkers()
+
+	log.Infof("spn/crew: resumed op %s#%d to %s", op.t.FmtID(), op.ID(), op.request)
+	return op, nil
+}
+
+// successor returns a new operation for the given terminal that continues the
+// connection of the suspended operation.
+func (op *ConnectOp) successor(t terminal.Terminal) *ConnectOp {
+	return &ConnectOp{
+		doneWriting:     make(chan struct{}),
+		handledStop:     make(chan struct{}),
+		incomingTraffic: op.incomingTraffic,
+		outgoingTraffic: op.outgoingTraffic,
+		started:         op.started,
+		lastActivity:    op.lastActivity,
+		t:               t,
+		conn:            op.getConn(),
+		request:         op.request,
+		entry:           op.entry,
+		tunnel:          op.tunnel,
+		resumption:      op.resumption,
+		resumed:         true,
+		resumeReplay:    make(chan []byte, 1),
+	}
+}
+
+// handleResumeReply handles the reply of the exit Hub to a resume request and
+// schedules the data the exit Hub missed to be sent again.
+func (op *ConnectOp) handleResumeReply(data []byte) *terminal.Error {
+	reply := &ConnectResumeReply{}
+	_, err := dsd.Load(data, reply)
+	if err != nil {
+		return terminal.ErrMalformedData.With("failed to parse connect resume reply: %w", err)
+	}
+
+	replay, err := op.resumption.replay.Since(reply.Received)
+	if err != nil {
+		return terminal.ErrConnectionError.With("failed to resume connection: %w", err)
+	}
+	op.resumeReplay <- replay
+	return nil
+}
+
+// sendResumeData sends the data the other side missed while the connection
+// was suspended. On the exit Hub, the resume reply is sent first.
+func (op *ConnectOp) sendResumeData() *terminal.Error {
+	if op.resumeReply != nil {
+		msg := op.NewMsg(op.resumeReply)
+		msg.Unit.MakeHighPriority()
+		if tErr := op.dfq.Send(msg, 30*time.Second); tErr != nil {
+			msg.Finish()
+			return tErr.Wrap("failed to send resume reply")
+		}
+	}
+
+	// Wait for data to send again.
+	var replay []byte
+	select {
+	case replay = <-op.resumeReplay:
+	case <-op.ctx.Done():
+		return terminal.ErrCanceled
+	}
+
+	for len(replay) > 0 {
+		chunkSize := readBufSize
+		if chunkSize > len(replay) {
+			chunkSize = len(replay)
+		}
+		msg := op.NewMsg(replay[:chunkSize])
+		if tErr := op.dfq.Send(msg, 30*time.Second); tErr != nil {
+			msg.Finish()
+			return tErr.Wrap("failed to send missed data")
+		}
+		replay = replay[chunkSize:]
+	}
+
+	return nil
+}
+
+// newResumeToken returns a new random token to identify a resumable connection.
+func newResumeToken() ([]byte, error) {
+	return rng.Bytes(resumeTokenSize)
+}
+
+// replayBuffer holds the most recent data read from a connection, so that it
+// can be sent again when resuming the connection.
+type replayBuffer struct {
+	lock sync.Mutex
+
+	// buf holds the data. Once full, it is used as a ring buffer.
+	buf []byte
+	// size is the maximum size of buf.
+	size int
+	// total is the total amount of data written to the buffer.
+	total uint64
+}
+
+func newReplayBuffer(size int) *replayBuffer {
+	return &replayBuffer{
+		size: size,
+	}
+}
+
+// Write adds data to the buffer, dropping the oldest data if full.
+func (rb *replayBuffer) Write(data []byte) {
+	rb.lock.Lock()
+	defer rb.lock.Unlock()
+
+	for len(data) > 0 {
+		// Grow buffer until it is full.
+		if len(rb.buf) < rb.size {
+			n := rb.size - len(rb.buf)
+			if n > len(data) {
+				n = len(data)
+			}
+
+			// Stop growing if all replay buffers together reached the limit.
+			if atomic.AddInt64(replayMemoryUsed, int64(n)) > replayMemoryLimit {
+				atomic.AddInt64(replayMemoryUsed, -int64(n))
+				rb.size = len(rb.buf)
+				continue
+			}
+
+			rb.buf = append(rb.buf, data[:n]...)
+			rb.total += uint64(n)
+			data = data[n:]
+			continue
+		}
+
+		// Only count data if the buffer cannot hold any.
+		if rb.size == 0 {
+			rb.total += uint64(len(data))
+			return
+		}
+
+		// Overwrite the oldest data.
+		n := copy(rb.buf[rb.total%uint64(rb.size):], data)
+		rb.total += uint64(n)
+		data = data[n:]
+	}
+}
+
+// Free releases the memory of the buffer. Nothing can be replayed afterwards.
+func (rb *replayBuffer) Free() {
+	rb.lock.Lock()
+	defer rb.lock.Unlock()
+
+	atomic.AddInt64(replayMemoryUsed, -int64(len(rb.buf)))
+	rb.buf = nil
+	rb.size = 0
+}
+
+// Since returns all data written after the given offset.
+func (rb *replayBuffer) Since(offset uint64) ([]byte, error) {
+	rb.lock.Lock()
+	defer rb.lock.Unlock()
+
+	switch {
+	case offset > rb.total:
+		return nil, fmt.Errorf("offset %d is beyond available data of %d", offset, rb.total)
+	case rb.total-offset > uint64(len(rb.buf)):
+		return nil, errors.New("missed data was already dropped")
+	}
+
+	data := make([]byte, 0, rb.total-offset)
+	for offset < rb.total {
+		start := int(offset % uint64(rb.size))
+		end := start + int(rb.total-offset)
+		if end > len(rb.buf) {
+			end = len(rb.buf)
+		}
+		data = append(data, rb.buf[start:end]...)
+		offset += uint64(end - start)
+	}
+	return data, nil
+}
@@ -0,0 +1,403 @@
+package crew
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tevino/abool"
+
+	"github.com/safing/portbase/config"
+	"github.com/safing/portbase/container"
+	"github.com/safing/portmaster/intel"
+	"github.com/safing/portmaster/network"
+	"github.com/safing/spn/cabin"
+	"github.com/safing/spn/hub"
+	"github.com/safing/spn/navigator"
+	"github.com/safing/spn/terminal"
+)
+
+func TestReplayBuffer(t *testing.T) {
+	t.Parallel()
+
+	rb := newReplayBuffer(10)
+
+	// Fill buffer partially.
+	rb.Write([]byte("abcdef"))
+	data, err := rb.Since(2)
+	if err != nil || !bytes.Equal(data, []byte("cdef")) {
+		t.Errorf("unexpected replay %q: %v", data, err)
+	}
+
+	// Wrap around.
+	rb.Write([]byte("ghijklmn"))
+	data, err = rb.Since(4)
+	if err != nil || !bytes.Equal(data, []byte("efghijklmn")) {
+		t.Errorf("unexpected replay %q: %v", data, err)
+	}
+	data, err = rb.Since(14)
+	if err != nil || len(data) != 0 {
+		t.Errorf("unexpected replay %q: %v", data, err)
+	}
+
+	// Write more than the buffer size at once.
+	rb.Write([]byte("0123456789ABCDEF"))
+	data, err = rb.Since(20)
+	if err != nil || !bytes.Equal(data, []byte("6789ABCDEF")) {
+		t.Errorf("unexpected replay %q: %v", data, err)
+	}
+
+	// Dropped data and data beyond the buffer cannot be replayed.
+	if _, err := rb.Since(19); err == nil {
+		t.Error("replaying dropped data should fail")
+	}
+	if _, err := rb.Since(31); err == nil {
+		t.Error("replaying beyond written data should fail")
+	}
+}
+
+func TestReplayBufferMemoryLimit(t *testing.T) {
+	// Not parallel, as the total replay memory is changed.
+
+	// Only leave room for 6 bytes.
+	reserved := replayMemoryLimit - atomic.LoadInt64(replayMemoryUsed) - 6
+	atomic.AddInt64(replayMemoryUsed, reserved)
+	defer atomic.AddInt64(replayMemoryUsed, -reserved)
+
+	// The buffer stops growing when the limit is reached.
+	rb := newReplayBuffer(10)
+	defer rb.Free()
+	rb.Write([]byte("abcdef"))
+	rb.Write([]byte("gh"))
+	data, err := rb.Since(2)
+	if err != nil || !bytes.Equal(data, []byte("cdefgh")) {
+		t.Errorf("unexpected replay %q: %v", data, err)
+	}
+	if _, err := rb.Since(1); err == nil {
+		t.Error("replaying dropped data should fail")
+	}
+
+	// Buffers without any memory only count the data.
+	empty := newReplayBuffer(10)
+	defer empty.Free()
+	empty.Write([]byte("abc"))
+	data, err = empty.Since(3)
+	if err != nil || len(data) != 0 {
+		t.Errorf("unexpected replay %q: %v", data, err)
+	}
+	if _, err := empty.Since(0); err == nil {
+		t.Error("replaying dropped data should fail")
+	}
+
+	// Freeing releases the memory.
+	rb.Free()
+	more := newReplayBuffer(10)
+	defer more.Free()
+	more.Write([]byte("abcdef"))
+	data, err = more.Since(0)
+	if err != nil || !bytes.Equal(data, []byte("abcdef")) {
+		t.Errorf("unexpected replay %q: %v", data, err)
+	}
+}
+
+// failedTestTerminal is a terminal with a configurable context and abandon
+// error.
+type failedTestTerminal struct {
+	terminal.BareTerminal
+
+	ctx        context.Context
+	abandonErr *terminal.Error
+}
+
+func (t *failedTestTerminal) Ctx() context.Context {
+	return t.ctx
+}
+
+func (t *failedTestTerminal) AbandonError() *terminal.Error {
+	return t.abandonErr
+}
+
+func TestShouldSuspend(t *testing.T) {
+	t.Parallel()
+
+	canceledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	active := &failedTestTerminal{ctx: context.Background()}
+	closed := &failedTestTerminal{ctx: canceledCtx}
+	failed := &failedTestTerminal{ctx: canceledCtx, abandonErr: terminal.ErrShipSunk}
+	resumption := newConnectResumption(make([]byte, resumeTokenSize), testQueueSize)
+
+	for i, tc := range []struct {
+		term      terminal.Terminal
+		resumable bool
+		err       *terminal.Error
+		suspend   bool
+	}{
+		// Not resumable.
+		{term: failed, resumable: false, err: nil, suspend: false},
+		// Terminal failed.
+		{term: failed, resumable: true, err: nil, suspend: true},
+		// Terminal closed by owner.
+		{term: closed, resumable: true, err: nil, suspend: false},
+		// Stopped by the other side.
+		{term: failed, resumable: true, err: terminal.ErrStopping.AsExternal(), suspend: false},
+		// Failed to send via the terminal.
+		{term: failed, resumable: true, err: terminal.ErrConnectionError.With("test"), suspend: true},
+		// Connection failed.
+		{term: active, resumable: true, err: terminal.ErrConnectionError.With("test"), suspend: false},
+	} {
+		op := &ConnectOp{t: tc.term}
+		if tc.resumable {
+			op.resumption = resumption
+		}
+		if op.shouldSuspend(tc.err) != tc.suspend {
+			t.Errorf("case %d: shouldSuspend should return %v", i, tc.suspend)
+		}
+	}
+
+	// Operations that are being taken over are always suspended.
+	op := &ConnectOp{t: active, resumption: resumption}
+	op.takenOver.Set()
+	if !op.shouldSuspend(terminal.ErrStopping.AsExternal()) {
+		t.Error("operation being taken over should be suspended")
+	}
+}
+
+func TestConnectOpResumption(t *testing.T) {
+	// Not parallel, as package variables are replaced.
+
+	dstConns := setupConnectOpResumptionTest(t)
+
+	// Connect and check that data flows.
+	broken := abool.New()
+	a, b, err := newBreakableTestTerminalPair(broken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appConn, op := startResumableTestConnectOp(t, a)
+	defer func() {
+		_ = appConn.Close()
+	}()
+	dstPeer := <-dstConns
+	defer func() {
+		_ = dstPeer.Close()
+	}()
+	transferTestData(t, appConn, dstPeer, "hello exit")
+	transferTestData(t, dstPeer, appConn, "hello client")
+
+	// Break the route while the exit Hub is sending data.
+	broken.Set()
+	transferTestData(t, dstPeer, nil, "sent while broken")
+	a.Abandon(terminal.ErrConnectionError.With("test route failure"))
+	b.Abandon(terminal.ErrConnectionError.With("test route failure"))
+
+	// The client migrates to a new route and receives the missed data.
+	transferTestData(t, nil, appConn, "sent while broken")
+	if op.tunnel.dstTerminal == a {
+		t.Error("tunnel should use the new terminal")
+	}
+
+	// Data flows again in both directions.
+	transferTestData(t, appConn, dstPeer, "hello again")
+	transferTestData(t, dstPeer, appConn, "welcome back")
+}
+
+func TestConnectOpResumeRejected(t *testing.T) {
+	// Not parallel, as package variables are replaced.
+
+	dstConns := setupConnectOpResumptionTest(t)
+
+	broken := abool.New()
+	a, b, err := newBreakableTestTerminalPair(broken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Abandon(nil)
+	appConn, op := startResumableTestConnectOp(t, a)
+	defer func() {
+		_ = appConn.Close()
+	}()
+	dstPeer := <-dstConns
+	defer func() {
+		_ = dstPeer.Close()
+	}()
+	transferTestData(t, appConn, dstPeer, "hello exit")
+
+	// Make the exit Hub forget the connection and break the route.
+	if takeResumableConnectOp(op.resumption.token) == nil {
+		t.Fatal("exit hub should have registered the connection for resuming")
+	}
+	broken.Set()
+	a.Abandon(terminal.ErrConnectionError.With("test route failure"))
+
+	// The client connection is closed when the exit Hub rejects resuming,
+	// without waiting for the grace period.
+	_ = appConn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if _, err := appConn.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+		t.Fatalf("connection should be closed, got %v", err)
+	}
+}
+
+// setupConnectOpResumptionTest enables resuming and connecting to in-memory
+// destinations and returns the destination side of new connections.
+func setupConnectOpResumptionTest(t *testing.T) <-chan net.Conn {
+	t.Helper()
+
+	// Enable resuming.
+	if err := config.SetConfigOption(CfgOptionResumeConnectionsKey, true); err != nil {
+		t.Fatalf("failed to enable resuming: %s", err)
+	}
+	t.Cleanup(func() {
+		_ = config.SetConfigOption(CfgOptionResumeConnectionsKey, false)
+	})
+
+	// Enable connecting.
+	identity, err := cabin.CreateIdentity(module.Ctx, "test")
+	if err != nil {
+		t.Fatalf("failed to create identity: %s", err)
+	}
+	EnableConnecting(identity.Hub)
+
+	// Connect to in-memory destinations.
+	dstConns := make(chan net.Conn, 1)
+	previousDial := dialConnectOpDestination
+	dialConnectOpDestination = func(_, _ string) (net.Conn, error) {
+		dstConn, dstPeer := net.Pipe()
+		dstConns <- dstPeer
+		return dstConn, nil
+	}
+	t.Cleanup(func() {
+		dialConnectOpDestination = previousDial
+	})
+
+	// Establish new routes with a new test terminal pair.
+	previousEstablish := establishResumeRoute
+	establishResumeRoute = func(_ *Tunnel) (*navigator.Route, terminal.Terminal, error) {
+		a, _, err := newBreakableTestTerminalPair(abool.New())
+		if err != nil {
+			return nil, nil, err
+		}
+		return &navigator.Route{}, a, nil
+	}
+	t.Cleanup(func() {
+		establishResumeRoute = previousEstablish
+	})
+
+	return dstConns
+}
+
+// startResumableTestConnectOp starts a resumable connect op via the given
+// terminal and returns the app side of the connection.
+func startResumableTestConnectOp(t *testing.T, dstTerminal terminal.Terminal) (net.Conn, *ConnectOp) {
+	t.Helper()
+
+	appConn, sluiceConn := net.Pipe()
+	op, tErr := NewConnectOp(&Tunnel{
+		connInfo: &network.Connection{
+			Entity: &intel.Entity{
+				Protocol: 6,
+				Port:     80,
+				IP:       net.IPv4(1, 1, 1, 1),
+			},
+		},
+		conn: sluiceConn,
+		dstPin: &navigator.Pin{
+			Hub: &hub.Hub{
+				ID: "exit",
+				Status: &hub.Status{
+					Flags: []string{hub.FlagConnectResume},
+				},
+			},
+		},
+		dstTerminal: dstTerminal,
+		stickied:    true,
+	})
+	if tErr != nil {
+		t.Fatalf("failed to start connect op: %s", tErr)
+	}
+	if op.resumption == nil {
+		t.Fatal("connect op should be resumable")
+	}
+
+	return appConn, op
+}
+
+// newBreakableTestTerminalPair returns a connected test terminal pair that
+// stops delivering messages when broken is set. The remote terminal may
+// connect.
+func newBreakableTestTerminalPair(broken *abool.AtomicBool) (a, b *terminal.TestTerminal, err error) {
+	opts := &terminal.TerminalOpts{
+		FlowControl:     terminal.FlowControlDFQ,
+		FlowControlSize: testQueueSize,
+		Padding:         testPadding,
+	}
+
+	var initData *container.Container
+	var tErr *terminal.Error
+	a, initData, tErr = terminal.NewLocalTestTerminal(
+		module.Ctx, 127, "a", nil, opts, terminal.UpstreamSendFunc(
+			func(msg *terminal.Msg, _ time.Duration) *terminal.Error {
+				if broken.IsSet() {
+					return terminal.ErrConnectionError.With("test route is broken")
+				}
+				return b.Deliver(msg)
+			},
+		),
+	)
+	if tErr != nil {
+		return nil, nil, tErr.Wrap("failed to create local test terminal")
+	}
+	b, _, tErr = terminal.NewRemoteTestTerminal(
+		module.Ctx, 127, "b", nil, initData, terminal.UpstreamSendFunc(
+			func(msg *terminal.Msg, _ time.Duration) *terminal.Error {
+				if broken.IsSet() {
+					return terminal.ErrConnectionError.With("test route is broken")
+				}
+				return a.Deliver(msg)
+			},
+		),
+	)
+	if tErr != nil {
+		return nil, nil, tErr.Wrap("failed to create remote test terminal")
+	}
+	b.GrantPermission(terminal.MayConnect)
+
+	return a, b, nil
+}
+
+// transferTestData writes the data to one connection and checks that it is
+// read from the other. Either side may be nil to only write or read.
+func transferTestData(t *testing.T, from, to net.Conn, data string) {
+	t.Helper()
+
+	written := make(chan error, 1)
+	if from != nil {
+		go func() {
+			_ = from.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			_, err := from.Write([]byte(data))
+			written <- err
+		}()
+	} else {
+		written <- nil
+	}
+
+	if to != nil {
+		_ = to.SetReadDeadline(time.Now().Add(10 * time.Second))
+		received := make([]byte, len(data))
+		if _, err := io.ReadFull(to, received); err != nil {
+			t.Fatalf("failed to receive %q: %s", data, err)
+		}
+		if string(received) != data {
+			t.Fatalf("received %q instead of %q", received, data)
+		}
+	}
+
+	if err := <-written; err != nil {
+		t.Fatalf("failed to send %q: %s", data, err)
+	}
+}
@@ -0,0 +1,352 @@
+package crew
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/safing/portbase/container"
+	"github.com/safing/portbase/formats/dsd"
+	"github.com/safing/portmaster/network/netutils"
+	"github.com/safing/spn/conf"
+	"github.com/safing/spn/terminal"
+)
+
+const (
+	// ResolveOpType is the type ID of the DNS resolve operation.
+	ResolveOpType = "dns/resolve"
+
+	resolveOpTimeout       = 5 * time.Second
+	resolveUpstreamTimeout = 2 * time.Second
+	resolveUDPSize         = 1232 // Recommended by the DNS Flag Day 2020.
+
+	resolvConfPath = "/etc/resolv.conf"
+)
+
+// ResolveOp is used to resolve DNS queries on the destination Hub.
+type ResolveOp struct {
+	terminal.OneOffOperationBase
+
+	query *dns.Msg
+
+	// Response holds the DNS response, when the operation finished with
+	// terminal.ErrExplicitAck.
+	Response *dns.Msg
+}
+
+// ResolveOpRequest is a resolve request.
+type ResolveOpRequest struct {
+	Query []byte `json:"q,omitempty"`
+}
+
+// ResolveOpResponse is a resolve response.
+type ResolveOpResponse struct {
+	Response []byte `json:"r,omitempty"`
+}
+
+// Type returns the type ID.
+func (op *ResolveOp) Type() string {
+	return ResolveOpType
+}
+
+func init() {
+	terminal.RegisterOpType(terminal.OperationFactory{
+		Type:     ResolveOpType,
+		Requires: terminal.MayConnect,
+		Start:    startResolveOp,
+	})
+}
+
+// NewResolveOp resolves the given DNS query on the Hub of the given terminal.
+func NewResolveOp(t terminal.Terminal, query *dns.Msg) (*ResolveOp, *terminal.Error) {
+	// Check query.
+	if len(query.Question) != 1 {
+		return nil, terminal.ErrIncorrectUsage.With("query must have exactly one question")
+	}
+
+	// Create operation and init.
+	op := &ResolveOp{
+		query: query,
+	}
+	op.OneOffOperationBase.Init()
+
+	// Create request.
+	packedQuery, err := query.Pack()
+	if err != nil {
+		return nil, terminal.ErrInternalError.With("failed to pack query: %w", err)
+	}
+	resolveRequest, err := dsd.Dump(&ResolveOpRequest{
+		Query: packedQuery,
+	}, dsd.CBOR)
+	if err != nil {
+		return nil, terminal.ErrInternalError.With("failed to create resolve request: %w", err)
+	}
+
+	// Send request.
+	tErr := t.StartOperation(op, container.New(resolveRequest), resolveOpTimeout)
+	if tErr != nil {
+		return nil, tErr
+	}
+
+	return op, nil
+}
+
+// Deliver delivers a message to the operation.
+func (op *ResolveOp) Deliver(msg *terminal.Msg) *terminal.Error {
+	defer msg.Finish()
+
+	// Parse response.
+	response := &ResolveOpResponse{}
+	_, err := dsd.Load(msg.Data.CompileData(), response)
+	if err != nil {
+		return terminal.ErrMalformedData.With("failed to parse resolve response: %w", err)
+	}
+	reply := new(dns.Msg)
+	if err := reply.Unpack(response.Response); err != nil {
+		return terminal.ErrMalformedData.With("failed to unpack dns response: %w", err)
+	}
+
+	// Check if the response matches the query.
+	if reply.Id != op.query.Id ||
+		len(reply.Question) != 1 ||
+		!strings.EqualFold(reply.Question[0].Name, op.query.Question[0].Name) ||
+		reply.Question[0].Qtype != op.query.Question[0].Qtype {
+		return terminal.ErrIntegrity.With("dns response does not match query")
+	}
+
+	op.Response = reply
+	return terminal.ErrExplicitAck
+}
+
+func startResolveOp(t terminal.Terminal, opID uint32, data *container.Container) (terminal.Operation, *terminal.Error) {
+	// Check if we are running a public hub.
+	if !conf.PublicHub() {
+		return nil, terminal.ErrPermissionDenied.With("resolving is only allowed on public hubs")
+	}
+
+	// Parse request.
+	request := &ResolveOpRequest{}
+	_, err := dsd.Load(data.CompileData(), request)
+	if err != nil {
+		return nil, terminal.ErrMalformedData.With("failed to parse resolve request: %w", err)
+	}
+	query := new(dns.Msg)
+	if err := query.Unpack(request.Query); err != nil {
+		return nil, terminal.ErrMalformedData.With("failed to unpack dns query: %w", err)
+	}
+	if query.Response || query.Opcode != dns.OpcodeQuery || len(query.Question) != 1 {
+		return nil, terminal.ErrInvalidOptions.With("unsupported dns query")
+	}
+	question := query.Question[0]
+
+	// Check exit policy.
+	if tErr := checkResolvePolicy(question.Name); tErr != nil {
+		return nil, tErr
+	}
+
+	// Resolve query.
+	reply, err := resolveOnHub(query)
+	if err != nil {
+		return nil, terminal.ErrConnectionError.With("failed to resolve %s: %w", question.Name, err)
+	}
+
+	// Check resolved IPs against the exit policy, as the client could otherwise
+	// use the Hub to resolve the IPs of destinations it may not connect to.
+	var tErr *terminal.Error
+	reply.Answer, tErr = filterDeniedAnswers(question.Name, reply.Answer)
+	if tErr != nil {
+		return nil, tErr
+	}
+	reply.Extra, _ = filterDeniedAnswers(question.Name, reply.Extra)
+
+	// Create response.
+	packedReply, err := reply.Pack()
+	if err != nil {
+		return nil, terminal.ErrInternalError.With("failed to pack dns response: %w", err)
+	}
+	response, err := dsd.Dump(&ResolveOpResponse{
+		Response: packedReply,
+	}, dsd.CBOR)
+	if err != nil {
+		return nil, terminal.ErrInternalError.With("failed to create resolve response: %w", err)
+	}
+
+	// Send response.
+	msg := terminal.NewMsg(response)
+	msg.FlowID = opID
+	msg.Unit.MakeHighPriority()
+	if terminal.UsePriorityDataMsgs {
+		msg.Type = terminal.MsgTypePriorityData
+	}
+	tErr = t.Send(msg, resolveOpTimeout)
+	if tErr != nil {
+		// Finish message unit on failure.
+		msg.Finish()
+		return nil, tErr.With("failed to send resolve response")
+	}
+
+	// Operation is just one response and finished successfully.
+	return nil, nil
+}
+
+// HandleStop gives the operation the ability to cleanly shut down.
+// The returned error is the error to send to the other side.
+// Should never be called directly. Call Stop() instead.
+func (op *ResolveOp) HandleStop(err *terminal.Error) (errorToSend *terminal.Error) {
+	// Prevent remote from sending explicit ack, as we use it as a success signal internally.
+	if err.Is(terminal.ErrExplicitAck) && err.IsExternal() {
+		err = terminal.ErrStopping.AsExternal()
+	}
+
+	// Continue with usual handling of inherited base.
+	return op.OneOffOperationBase.HandleStop(err)
+}
+
+var (
+	upstreamResolvers     []string
+	upstreamResolversErr  error
+	upstreamResolversOnce sync.Once
+)
+
+func getUpstreamResolvers() ([]string, error) {
+	upstreamResolversOnce.Do(func() {
+		config, err := dns.ClientConfigFromFile(resolvConfPath)
+		if err != nil {
+			upstreamResolversErr = fmt.Errorf("failed to read resolver config: %w", err)
+			return
+		}
+		for _, server := range config.Servers {
+			upstreamResolvers = append(upstreamResolvers, net.JoinHostPort(server, config.Port))
+		}
+		if len(upstreamResolvers) == 0 {
+			upstreamResolversErr = errors.New("no resolvers configured")
+		}
+	})
+
+	return upstreamResolvers, upstreamResolversErr
+}
+
+// resolveOnHub resolves the question of the given query with the resolvers of
+// the Hub. Only the question is forwarded, in order to not leak any other data
+// of the client, such as EDNS options.
+func resolveOnHub(query *dns.Msg) (*dns.Msg, error) {
+	resolvers, err := getUpstreamResolvers()
+	if err != nil {
+		return nil, err
+	}
+
+	// Create new query from question.
+	question := query.Question[0]
+	upstreamQuery := new(dns.Msg)
+	upstreamQuery.SetQuestion(dns.Fqdn(question.Name), question.Qtype)
+	upstreamQuery.Question[0].Qclass = question.Qclass
+	upstreamQuery.CheckingDisabled = query.CheckingDisabled
+	dnssecOK := false
+	if opt := query.IsEdns0(); opt != nil {
+		dnssecOK = opt.Do()
+	}
+	upstreamQuery.SetEdns0(resolveUDPSize, dnssecOK)
+
+	// Ask resolvers until one replies.
+	var firstErr error
+	for _, resolver := range resolvers {
+		reply, err := exchangeWithResolver(upstreamQuery, resolver)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		// Prepare reply for client.
+		reply.Id = query.Id
+		reply.Question = query.Question
+		reply.Answer = filterLocalAnswers(reply.Answer)
+		reply.Extra = filterLocalAnswers(reply.Extra)
+		return reply, nil
+	}
+
+	return nil, firstErr
+}
+
+func exchangeWithResolver(query *dns.Msg, resolver string) (*dns.Msg, error) {
+	client := &dns.Client{
+		Net:     "udp",
+		UDPSize: resolveUDPSize,
+		Timeout: resolveUpstreamTimeout,
+	}
+	reply, _, err := client.Exchange(query, resolver)
+	if err != nil {
+		return nil, err
+	}
+
+	// Retry with TCP if the reply was truncated.
+	if reply.Truncated {
+		client.Net = "tcp"
+		reply, _, err = client.Exchange(query, resolver)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return reply, nil
+}
+
+// filterLocalAnswers removes all records that point to non-global IPs, so that
+// the local network of the Hub is not exposed.
+func filterLocalAnswers(rrs []dns.RR) []dns.RR {
+	filtered := rrs[:0]
+	for _, rr := range rrs {
+		var ip net.IP
+		switch v := rr.(type) {
+		case *dns.A:
+			ip = v.A
+		case *dns.AAAA:
+			ip = v.AAAA
+		}
+		if ip != nil && netutils.GetIPScope(ip) != netutils.Global {
+			continue
+		}
+		filtered = append(filtered, rr)
+	}
+	return filtered
+}
+
+// filterDeniedAnswers removes all records that point to IPs that are denied by
+// the exit policy. If all IPs are denied, the policy error is returned.
+func filterDeniedAnswers(domain string, rrs []dns.RR) ([]dns.RR, *terminal.Error) {
+	var (
+		filtered []dns.RR
+		allowed  int
+		firstErr *terminal.Error
+	)
+	for _, rr := range rrs {
+		var ip net.IP
+		switch v := rr.(type) {
+		case *dns.A:
+			ip = v.A
+		case *dns.AAAA:
+			ip = v.AAAA
+		}
+		if ip != nil {
+			if tErr := checkResolvedIPPolicy(domain, ip); tErr != nil {
+				if firstErr == nil {
+					firstErr = tErr
+				}
+				continue
+			}
+			allowed++
+		}
+		filtered = append(filtered, rr)
+	}
+
+	if allowed == 0 && firstErr != nil {
+		return nil, firstErr
+	}
+	return filtered, nil
+}
@@ -0,0 +1,54 @@
+package crew
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/safing/spn/cabin"
+	"github.com/safing/spn/conf"
+	"github.com/safing/spn/terminal"
+)
+
+func TestResolveOp(t *testing.T) {
+	t.Parallel()
+
+	if testing.Short() {
+		t.Skip("skipping test in short mode, as it interacts with the network")
+	}
+
+	// Create test terminal pair.
+	a, b, err := terminal.NewSimpleTestTerminalPair(0, 0, nil)
+	if err != nil {
+		t.Fatalf("failed to create test terminal pair: %s", err)
+	}
+
+	// Set up resolving.
+	b.GrantPermission(terminal.MayConnect)
+	conf.EnablePublicHub(true)
+	identity, err := cabin.CreateIdentity(module.Ctx, "test")
+	if err != nil {
+		t.Fatalf("failed to create identity: %s", err)
+	}
+	EnableConnecting(identity.Hub)
+
+	// Create resolve op.
+	query := new(dns.Msg)
+	query.SetQuestion("orf.at.", dns.TypeA)
+	op, tErr := NewResolveOp(a, query)
+	if tErr.IsError() {
+		t.Fatal(tErr)
+	}
+
+	// Wait for result.
+	select {
+	case result := <-op.Result:
+		if !result.Is(terminal.ErrExplicitAck) {
+			t.Fatalf("resolving failed: %s", result)
+		}
+		t.Logf("resolve response: %s", op.Response)
+	case <-time.After(resolveOpTimeout):
+		t.Fatal("timed out")
+	}
+}
@@ -2,6 +2,7 @@ package crew
 
 import (
 	"context"
+	"net"
 	"sync"
 
 	"github.com/safing/portmaster/intel"
@@ -49,3 +50,51 @@ func checkExitPolicy(request *ConnectRequest) *terminal.Error {
 
 	return nil
 }
+
+func checkResolvePolicy(domain string) *terminal.Error {
+	connectingHubLock.Lock()
+	defer connectingHubLock.Unlock()
+
+	// Check if resolving is allowed.
+	if connectingHub == nil {
+		return terminal.ErrPermissionDenied.With("resolve requests disabled")
+	}
+
+	// Create entity.
+	entity := &intel.Entity{
+		Domain: domain,
+	}
+
+	// Check against policy.
+	result, reason := connectingHub.GetInfo().ExitPolicy().Match(context.TODO(), entity)
+	if result == endpoints.Denied {
+		return terminal.ErrPermissionDenied.With("resolve request for %s violates the exit policy: %s", domain, reason)
+	}
+
+	return nil
+}
+
+func checkResolvedIPPolicy(domain string, ip net.IP) *terminal.Error {
+	connectingHubLock.Lock()
+	defer connectingHubLock.Unlock()
+
+	// Check if resolving is allowed.
+	if connectingHub == nil {
+		return terminal.ErrPermissionDenied.With("resolve requests disabled")
+	}
+
+	// Create entity.
+	entity := &intel.Entity{
+		Domain: domain,
+	}
+	entity.SetIP(ip)
+	entity.FetchData(context.TODO())
+
+	// Check against policy.
+	result, reason := connectingHub.GetInfo().ExitPolicy().Match(context.TODO(), entity)
+	if result == endpoints.Denied {
+		return terminal.ErrPermissionDenied.With("resolved IP %s of %s violates the exit policy: %s", ip, domain, reason)
+	}
+
+	return nil
+}
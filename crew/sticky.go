@@ -2,7 +2,6 @@ package crew
 
 import (
 	"context"
-	"fmt"
 	"sync"
 	"time"
 
@@ -14,7 +13,9 @@ import (
 )
 
 const (
-	stickyTTL = 1 * time.Hour
+	defaultStickyTTL = 1 * time.Hour
+
+	unknownStickyProfile = "?"
 )
 
 var (
@@ -24,57 +25,80 @@ var (
 )
 
 type stickyHub struct {
-	Pin      *navigator.Pin
-	Route    *navigator.Route
+	// Profile is the scoped ID of the profile the entry belongs to.
+	Profile string
+	// Destination is the IP or domain the entry is for.
+	Destination string
+
+	// HubID is the ID of the stickied Hub.
+	HubID string
+	// Pin is the Pin of the stickied Hub.
+	// It may be nil for entries loaded from the database.
+	Pin *navigator.Pin
+	// Route is the route that was used to reach the stickied Hub.
+	// It is nil for entries loaded from the database or pinned via the API.
+	Route *navigator.Route
+
 	LastSeen time.Time
-	Avoid    bool
+	// TTL defines how long the entry is kept after it was last seen.
+	TTL time.Duration
+	// Avoid specifies that the Hub should be avoided instead of used.
+	Avoid bool
+	// Pinned specifies that the entry was set manually and does not expire.
+	Pinned bool
+
+	// changed specifies if the entry changed since it was last saved.
+	changed bool
+	// savedLastSeen holds the LastSeen value that was last saved.
+	savedLastSeen time.Time
 }
 
 func (sh *stickyHub) isExpired() bool {
-	return time.Now().Add(-stickyTTL).After(sh.LastSeen)
+	if sh.Pinned {
+		return false
+	}
+	return time.Now().Add(-sh.TTL).After(sh.LastSeen)
 }
 
-func makeStickyIPKey(conn *network.Connection) string {
-	if p := conn.Process().Profile(); p != nil {
-		return fmt.Sprintf(
-			"%s/%s>%s",
-			p.LocalProfile().Source,
-			p.LocalProfile().ID,
-			conn.Entity.IP,
-		)
+// touch updates when the entry was last seen. In order to not save the entry
+// on every use, it is only marked as changed when the saved LastSeen value is
+// outdated by more than a quarter of the TTL.
+func (sh *stickyHub) touch() {
+	sh.LastSeen = time.Now()
+	if !sh.Pinned && sh.LastSeen.Sub(sh.savedLastSeen) > sh.TTL/4 {
+		sh.changed = true
 	}
-
-	return "?>" + string(conn.Entity.IP)
 }
 
-func makeStickyDomainKey(conn *network.Connection) string {
+func makeStickyProfileKey(conn *network.Connection) string {
 	if p := conn.Process().Profile(); p != nil {
-		return fmt.Sprintf(
-			"%s/%s>%s",
-			p.LocalProfile().Source,
-			p.LocalProfile().ID,
-			conn.Entity.Domain,
-		)
+		return p.LocalProfile().ScopedID()
 	}
 
-	return "?>" + conn.Entity.Domain
+	return unknownStickyProfile
+}
+
+func makeStickyKey(profile, destination string) string {
+	return profile + ">" + destination
 }
 
 func getStickiedHub(conn *network.Connection) (sticksTo *stickyHub) {
 	stickyLock.Lock()
 	defer stickyLock.Unlock()
 
+	profile := makeStickyProfileKey(conn)
+
 	// Check if IP is sticky.
-	sticksTo = stickyIPs[makeStickyIPKey(conn)] // byte comparison
-	if sticksTo != nil && !sticksTo.isExpired() {
-		sticksTo.LastSeen = time.Now()
+	sticksTo = stickyIPs[makeStickyKey(profile, conn.Entity.IP.String())]
+	if sticksTo != nil && sticksTo.isExpired() {
+		sticksTo = nil
 	}
 
 	// If the IP did not stick and we have a domain, check if that sticks.
 	if sticksTo == nil && conn.Entity.Domain != "" {
-		sticksTo, ok := stickyDomains[makeStickyDomainKey(conn)]
-		if ok && !sticksTo.isExpired() {
-			sticksTo.LastSeen = time.Now()
+		sticksTo = stickyDomains[makeStickyKey(profile, conn.Entity.Domain)]
+		if sticksTo != nil && sticksTo.isExpired() {
+			sticksTo = nil
 		}
 	}
 
@@ -83,6 +107,15 @@ func getStickiedHub(conn *network.Connection) (sticksTo *stickyHub) {
 		return nil
 	}
 
+	// Get Pin of entries loaded from the database.
+	if sticksTo.Pin == nil {
+		pin, ok := navigator.Main.GetPin(sticksTo.HubID)
+		if !ok {
+			return nil
+		}
+		sticksTo.Pin = pin
+	}
+
 	// Get intel from map before locking pin to avoid simultaneous locking.
 	mapIntel := navigator.Main.GetIntel()
 
@@ -107,30 +140,54 @@ func getStickiedHub(conn *network.Connection) (sticksTo *stickyHub) {
 	}
 
 	// Return fully checked stickied Hub.
+	sticksTo.touch()
 	return sticksTo
 }
 
 func (t *Tunnel) stickDestinationToHub() {
+	// Get sticky policy of the profile.
+	profile := makeStickyProfileKey(t.connInfo)
+	ttl, enabled := getStickyTTL(profile)
+	if !enabled {
+		return
+	}
+
 	stickyLock.Lock()
 	defer stickyLock.Unlock()
 
-	// Stick to IP.
-	ipKey := makeStickyIPKey(t.connInfo)
-	stickyIPs[ipKey] = &stickyHub{
-		Pin:      t.dstPin,
-		Route:    t.route,
-		LastSeen: time.Now(),
+	// Stick to IP, if not pinned via the API.
+	ip := t.connInfo.Entity.IP.String()
+	ipKey := makeStickyKey(profile, ip)
+	if existing := stickyIPs[ipKey]; existing == nil || !existing.Pinned {
+		stickyIPs[ipKey] = &stickyHub{
+			Profile:     profile,
+			Destination: ip,
+			HubID:       t.dstPin.Hub.ID,
+			Pin:         t.dstPin,
+			Route:       t.route,
+			LastSeen:    time.Now(),
+			TTL:         ttl,
+		}
+		saveStickyHub(stickyIPsDBKeyPrefix, ipKey, stickyIPs[ipKey])
+		log.Infof("spn/crew: sticking %s to %s", ipKey, t.dstPin.Hub)
 	}
-	log.Infof("spn/crew: sticking %s to %s", ipKey, t.dstPin.Hub)
 
-	// Stick to Domain, if present.
+	// Stick to Domain, if present and not pinned via the API.
 	if t.connInfo.Entity.Domain != "" {
-		domainKey := makeStickyDomainKey(t.connInfo)
+		domainKey := makeStickyKey(profile, t.connInfo.Entity.Domain)
+		if existing := stickyDomains[domainKey]; existing != nil && existing.Pinned {
+			return
+		}
 		stickyDomains[domainKey] = &stickyHub{
-			Pin:      t.dstPin,
-			Route:    t.route,
-			LastSeen: time.Now(),
+			Profile:     profile,
+			Destination: t.connInfo.Entity.Domain,
+			HubID:       t.dstPin.Hub.ID,
+			Pin:         t.dstPin,
+			Route:       t.route,
+			LastSeen:    time.Now(),
+			TTL:         ttl,
 		}
+		saveStickyHub(stickyDomainsDBKeyPrefix, domainKey, stickyDomains[domainKey])
 		log.Infof("spn/crew: sticking %s to %s", domainKey, t.dstPin.Hub)
 	}
 }
@@ -140,12 +197,23 @@ func (t *Tunnel) avoidDestinationHub() {
 	defer stickyLock.Unlock()
 
 	// Stick to Hub/IP Pair.
-	ipKey := makeStickyIPKey(t.connInfo)
+	profile := makeStickyProfileKey(t.connInfo)
+	ip := t.connInfo.Entity.IP.String()
+	ipKey := makeStickyKey(profile, ip)
+	if existing := stickyIPs[ipKey]; existing != nil && existing.Pinned {
+		// Do not overwrite entries pinned via the API.
+		return
+	}
 	stickyIPs[ipKey] = &stickyHub{
-		Pin:      t.dstPin,
-		LastSeen: time.Now(),
-		Avoid:    true,
+		Profile:     profile,
+		Destination: ip,
+		HubID:       t.dstPin.Hub.ID,
+		Pin:         t.dstPin,
+		LastSeen:    time.Now(),
+		TTL:         defaultStickyTTL,
+		Avoid:       true,
 	}
+	saveStickyHub(stickyIPsDBKeyPrefix, ipKey, stickyIPs[ipKey])
 	log.Warningf("spn/crew: avoiding %s for %s", t.dstPin.Hub, ipKey)
 }
 
@@ -153,10 +221,14 @@ func cleanStickyHubs(ctx context.Context, task *modules.Task) error {
 	stickyLock.Lock()
 	defer stickyLock.Unlock()
 
-	for _, stickyRegistry := range []map[string]*stickyHub{stickyIPs, stickyDomains} {
+	for dbKeyPrefix, stickyRegistry := range stickyRegistries() {
 		for key, stickedEntry := range stickyRegistry {
-			if stickedEntry.isExpired() {
+			switch {
+			case stickedEntry.isExpired():
 				delete(stickyRegistry, key)
+				deleteStickyHub(dbKeyPrefix, key)
+			case stickedEntry.changed:
+				saveStickyHub(dbKeyPrefix, key, stickedEntry)
 			}
 		}
 	}
@@ -168,9 +240,22 @@ func clearStickyHubs() {
 	stickyLock.Lock()
 	defer stickyLock.Unlock()
 
-	for _, stickyRegistry := range []map[string]*stickyHub{stickyIPs, stickyDomains} {
-		for key := range stickyRegistry {
+	for dbKeyPrefix, stickyRegistry := range stickyRegistries() {
+		for key, stickedEntry := range stickyRegistry {
+			// Save changes before removing from memory.
+			if stickedEntry.changed {
+				saveStickyHub(dbKeyPrefix, key, stickedEntry)
+			}
 			delete(stickyRegistry, key)
 		}
 	}
 }
+
+// stickyRegistries returns the sticky registries mapped by their database key
+// prefix. The sticky lock must be held.
+func stickyRegistries() map[string]map[string]*stickyHub {
+	return map[string]map[string]*stickyHub{
+		stickyIPsDBKeyPrefix:     stickyIPs,
+		stickyDomainsDBKeyPrefix: stickyDomains,
+	}
+}
@@ -0,0 +1,246 @@
+package crew
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/database"
+	"github.com/safing/portbase/database/query"
+	"github.com/safing/portbase/database/record"
+	"github.com/safing/portbase/log"
+)
+
+// Database Keys:
+// Sticky IPs:      core:spn/sticky/ips/<Profile>><IP>
+// Sticky Domains:  core:spn/sticky/domains/<Profile>><Domain>
+// Sticky Policies: core:spn/sticky/policies/<Profile>
+const (
+	stickyIPsDBKeyPrefix      = "core:spn/sticky/ips/"
+	stickyDomainsDBKeyPrefix  = "core:spn/sticky/domains/"
+	stickyPoliciesDBKeyPrefix = "core:spn/sticky/policies/"
+)
+
+var db = database.NewInterface(&database.Options{
+	Local:    true,
+	Internal: true,
+})
+
+// StickyEntry is a sticky routing entry.
+type StickyEntry struct {
+	record.Base
+	sync.Mutex
+
+	Profile     string
+	Destination string
+	HubID       string
+	LastSeen    time.Time
+	TTL         time.Duration
+	Avoid       bool
+	Pinned      bool
+}
+
+// StickyPolicy defines how destinations are stickied to Hubs for a profile.
+type StickyPolicy struct {
+	record.Base
+	sync.Mutex
+
+	// Profile is the scoped ID of the profile.
+	Profile string
+	// TTL defines how long a destination sticks to a Hub after it was last
+	// used. If zero, the default is used.
+	TTL time.Duration
+	// Disabled disables stickiness for the profile.
+	Disabled bool
+}
+
+var (
+	stickyPolicies     = make(map[string]*StickyPolicy)
+	stickyPoliciesLock sync.Mutex
+)
+
+func (sh *stickyHub) export() *StickyEntry {
+	return &StickyEntry{
+		Profile:     sh.Profile,
+		Destination: sh.Destination,
+		HubID:       sh.HubID,
+		LastSeen:    sh.LastSeen,
+		TTL:         sh.TTL,
+		Avoid:       sh.Avoid,
+		Pinned:      sh.Pinned,
+	}
+}
+
+// saveStickyHub saves the sticky entry to the database.
+// The sticky lock must be held.
+func saveStickyHub(dbKeyPrefix, key string, sh *stickyHub) {
+	entry := sh.export()
+	entry.SetKey(dbKeyPrefix + key)
+	entry.UpdateMeta()
+	if err := db.Put(entry); err != nil {
+		log.Warningf("spn/crew: failed to save sticky entry %s: %s", key, err)
+		return
+	}
+	sh.changed = false
+	sh.savedLastSeen = sh.LastSeen
+}
+
+// deleteStickyHub deletes the sticky entry from the database.
+func deleteStickyHub(dbKeyPrefix, key string) {
+	if err := db.Delete(dbKeyPrefix + key); err != nil && !errors.Is(err, database.ErrNotFound) {
+		log.Warningf("spn/crew: failed to delete sticky entry %s: %s", key, err)
+	}
+}
+
+// loadStickyHubs loads all sticky entries from the database.
+func loadStickyHubs() error {
+	stickyLock.Lock()
+	defer stickyLock.Unlock()
+
+	for dbKeyPrefix, stickyRegistry := range stickyRegistries() {
+		iter, err := db.Query(query.New(dbKeyPrefix))
+		if err != nil {
+			return fmt.Errorf("failed to query sticky entries: %w", err)
+		}
+
+		for r := range iter.Next {
+			entry, err := ensureStickyEntry(r)
+			if err != nil {
+				log.Warningf("spn/crew: failed to parse sticky entry %s: %s", r.Key(), err)
+				continue
+			}
+
+			sh := &stickyHub{
+				Profile:     entry.Profile,
+				Destination: entry.Destination,
+				HubID:       entry.HubID,
+				LastSeen:    entry.LastSeen,
+				TTL:         entry.TTL,
+				Avoid:       entry.Avoid,
+				Pinned:      entry.Pinned,
+
+				savedLastSeen: entry.LastSeen,
+			}
+			if !sh.isExpired() {
+				stickyRegistry[makeStickyKey(sh.Profile, sh.Destination)] = sh
+			}
+		}
+		if iter.Err() != nil {
+			return fmt.Errorf("failed to load sticky entries: %w", iter.Err())
+		}
+	}
+
+	return nil
+}
+
+// loadStickyPolicies loads all sticky policies from the database.
+func loadStickyPolicies() error {
+	stickyPoliciesLock.Lock()
+	defer stickyPoliciesLock.Unlock()
+
+	iter, err := db.Query(query.New(stickyPoliciesDBKeyPrefix))
+	if err != nil {
+		return fmt.Errorf("failed to query sticky policies: %w", err)
+	}
+
+	for r := range iter.Next {
+		policy, err := ensureStickyPolicy(r)
+		if err != nil {
+			log.Warningf("spn/crew: failed to parse sticky policy %s: %s", r.Key(), err)
+			continue
+		}
+		stickyPolicies[policy.Profile] = policy
+	}
+	if iter.Err() != nil {
+		return fmt.Errorf("failed to load sticky policies: %w", iter.Err())
+	}
+
+	return nil
+}
+
+// getStickyTTL returns the sticky TTL for the given profile and whether
+// stickiness is enabled.
+func getStickyTTL(profile string) (ttl time.Duration, enabled bool) {
+	stickyPoliciesLock.Lock()
+	defer stickyPoliciesLock.Unlock()
+
+	policy, ok := stickyPolicies[profile]
+	switch {
+	case !ok:
+		return defaultStickyTTL, true
+	case policy.Disabled:
+		return 0, false
+	case policy.TTL > 0:
+		return policy.TTL, true
+	default:
+		return defaultStickyTTL, true
+	}
+}
+
+// setStickyPolicy sets and saves the sticky policy of a profile.
+func setStickyPolicy(policy *StickyPolicy) error {
+	stickyPoliciesLock.Lock()
+	defer stickyPoliciesLock.Unlock()
+
+	if !policy.KeyIsSet() {
+		policy.SetKey(stickyPoliciesDBKeyPrefix + policy.Profile)
+	}
+	policy.UpdateMeta()
+	if err := db.Put(policy); err != nil {
+		return err
+	}
+
+	stickyPolicies[policy.Profile] = policy
+	return nil
+}
+
+// getStickyPolicies returns all sticky policies.
+func getStickyPolicies() []*StickyPolicy {
+	stickyPoliciesLock.Lock()
+	defer stickyPoliciesLock.Unlock()
+
+	policies := make([]*StickyPolicy, 0, len(stickyPolicies))
+	for _, policy := range stickyPolicies {
+		policies = append(policies, policy)
+	}
+	return policies
+}
+
+// ensureStickyEntry makes sure a database record is a StickyEntry.
+func ensureStickyEntry(r record.Record) (*StickyEntry, error) {
+	// Unwrap record.
+	if r.IsWrapped() {
+		entry := &StickyEntry{}
+		if err := record.Unwrap(r, entry); err != nil {
+			return nil, err
+		}
+		return entry, nil
+	}
+
+	// Or adjust type.
+	entry, ok := r.(*StickyEntry)
+	if !ok {
+		return nil, fmt.Errorf("record not of type *StickyEntry, but %T", r)
+	}
+	return entry, nil
+}
+
+// ensureStickyPolicy makes sure a database record is a StickyPolicy.
+func ensureStickyPolicy(r record.Record) (*StickyPolicy, error) {
+	// Unwrap record.
+	if r.IsWrapped() {
+		policy := &StickyPolicy{}
+		if err := record.Unwrap(r, policy); err != nil {
+			return nil, err
+		}
+		return policy, nil
+	}
+
+	// Or adjust type.
+	policy, ok := r.(*StickyPolicy)
+	if !ok {
+		return nil, fmt.Errorf("record not of type *StickyPolicy, but %T", r)
+	}
+	return policy, nil
+}
@@ -0,0 +1,222 @@
+package crew
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/safing/portbase/api"
+	"github.com/safing/portmaster/intel"
+	"github.com/safing/portmaster/network"
+	"github.com/safing/spn/hub"
+	"github.com/safing/spn/navigator"
+)
+
+func TestStickyHubExpiry(t *testing.T) {
+	t.Parallel()
+
+	sh := &stickyHub{
+		LastSeen: time.Now().Add(-10 * time.Minute),
+		TTL:      time.Hour,
+	}
+	if sh.isExpired() {
+		t.Error("entry within its TTL should not be expired")
+	}
+
+	sh.TTL = 5 * time.Minute
+	if !sh.isExpired() {
+		t.Error("entry past its TTL should be expired")
+	}
+
+	sh.Pinned = true
+	if sh.isExpired() {
+		t.Error("pinned entry should never expire")
+	}
+}
+
+func TestStickyPolicyTTL(t *testing.T) {
+	t.Parallel()
+
+	stickyPoliciesLock.Lock()
+	stickyPolicies["test:custom"] = &StickyPolicy{Profile: "test:custom", TTL: 5 * time.Minute}
+	stickyPolicies["test:disabled"] = &StickyPolicy{Profile: "test:disabled", Disabled: true}
+	stickyPolicies["test:default"] = &StickyPolicy{Profile: "test:default"}
+	stickyPoliciesLock.Unlock()
+
+	for _, tc := range []struct {
+		profile string
+		ttl     time.Duration
+		enabled bool
+	}{
+		{"test:none", defaultStickyTTL, true},
+		{"test:custom", 5 * time.Minute, true},
+		{"test:disabled", 0, false},
+		{"test:default", defaultStickyTTL, true},
+	} {
+		ttl, enabled := getStickyTTL(tc.profile)
+		if ttl != tc.ttl || enabled != tc.enabled {
+			t.Errorf("profile %s: expected ttl=%s enabled=%v, got ttl=%s enabled=%v", tc.profile, tc.ttl, tc.enabled, ttl, enabled)
+		}
+	}
+}
+
+func TestStickyHubTouch(t *testing.T) {
+	t.Parallel()
+
+	sh := &stickyHub{
+		TTL:           time.Hour,
+		savedLastSeen: time.Now().Add(-5 * time.Minute),
+	}
+	sh.touch()
+	if sh.changed {
+		t.Error("entry should not be marked as changed if the saved last seen time is recent")
+	}
+
+	sh.savedLastSeen = time.Now().Add(-30 * time.Minute)
+	sh.touch()
+	if !sh.changed {
+		t.Error("entry should be marked as changed if the saved last seen time is outdated")
+	}
+
+	pinned := &stickyHub{Pinned: true}
+	pinned.touch()
+	if pinned.changed {
+		t.Error("pinned entry should not be marked as changed")
+	}
+}
+
+func TestStickDestinationToHub(t *testing.T) { //nolint:paralleltest // Changes the policy of the unknown profile.
+	ip := net.IPv4(192, 0, 2, 1)
+	domain := "sticky-test.example."
+	ipKey := makeStickyKey(unknownStickyProfile, ip.String())
+	domainKey := makeStickyKey(unknownStickyProfile, domain)
+	defer func() {
+		stickyLock.Lock()
+		defer stickyLock.Unlock()
+		delete(stickyIPs, ipKey)
+		delete(stickyDomains, domainKey)
+	}()
+
+	tunnel := &Tunnel{
+		connInfo: &network.Connection{
+			Entity: &intel.Entity{
+				IP:     ip,
+				Domain: domain,
+			},
+		},
+		dstPin: &navigator.Pin{Hub: &hub.Hub{ID: "new"}},
+	}
+
+	// Pinned IP entries must be kept, but the domain must still stick.
+	stickyLock.Lock()
+	stickyIPs[ipKey] = &stickyHub{
+		Profile:     unknownStickyProfile,
+		Destination: ip.String(),
+		HubID:       "pinned",
+		Pinned:      true,
+	}
+	stickyLock.Unlock()
+
+	tunnel.stickDestinationToHub()
+
+	stickyLock.Lock()
+	if sh := stickyIPs[ipKey]; sh == nil || sh.HubID != "pinned" {
+		t.Error("pinned IP entry should not be overwritten")
+	}
+	if sh := stickyDomains[domainKey]; sh == nil || sh.HubID != "new" {
+		t.Error("domain should stick to the new Hub")
+	}
+	delete(stickyIPs, ipKey)
+	delete(stickyDomains, domainKey)
+	stickyLock.Unlock()
+
+	// Nothing must stick if the policy disables stickiness.
+	stickyPoliciesLock.Lock()
+	previousPolicy, hadPolicy := stickyPolicies[unknownStickyProfile]
+	stickyPolicies[unknownStickyProfile] = &StickyPolicy{Profile: unknownStickyProfile, Disabled: true}
+	stickyPoliciesLock.Unlock()
+	defer func() {
+		stickyPoliciesLock.Lock()
+		defer stickyPoliciesLock.Unlock()
+		if hadPolicy {
+			stickyPolicies[unknownStickyProfile] = previousPolicy
+		} else {
+			delete(stickyPolicies, unknownStickyProfile)
+		}
+	}()
+
+	tunnel.stickDestinationToHub()
+
+	stickyLock.Lock()
+	if stickyIPs[ipKey] != nil || stickyDomains[domainKey] != nil {
+		t.Error("nothing should stick if disabled by the policy")
+	}
+	stickyLock.Unlock()
+}
+
+func TestStickyAPI(t *testing.T) {
+	t.Parallel()
+
+	newRequest := func(query string) *api.Request {
+		return &api.Request{
+			Request: &http.Request{
+				URL: &url.URL{RawQuery: query},
+			},
+		}
+	}
+
+	// Clear only the entries matching the profile and destination.
+	stickyLock.Lock()
+	for _, destination := range []string{"192.0.2.10", "192.0.2.11"} {
+		for _, profile := range []string{"test:clear-a", "test:clear-b"} {
+			stickyIPs[makeStickyKey(profile, destination)] = &stickyHub{
+				Profile:     profile,
+				Destination: destination,
+				HubID:       "test",
+				Pinned:      true,
+			}
+		}
+	}
+	stickyLock.Unlock()
+
+	if _, err := handleStickyClearRequest(newRequest("profile=test:clear-a&ip=192.0.2.10")); err != nil {
+		t.Fatal(err)
+	}
+	stickyLock.Lock()
+	for _, tc := range []struct {
+		profile     string
+		destination string
+		cleared     bool
+	}{
+		{"test:clear-a", "192.0.2.10", true},
+		{"test:clear-a", "192.0.2.11", false},
+		{"test:clear-b", "192.0.2.10", false},
+		{"test:clear-b", "192.0.2.11", false},
+	} {
+		key := makeStickyKey(tc.profile, tc.destination)
+		if _, ok := stickyIPs[key]; ok == tc.cleared {
+			t.Errorf("entry %s should be cleared=%v", key, tc.cleared)
+		}
+		delete(stickyIPs, key)
+	}
+	stickyLock.Unlock()
+
+	// Set policies.
+	if _, err := handleStickyPolicyRequest(newRequest("profile=test:api&ttl=5m")); err != nil {
+		t.Fatal(err)
+	}
+	if ttl, enabled := getStickyTTL("test:api"); ttl != 5*time.Minute || !enabled {
+		t.Errorf("expected ttl=5m enabled=true, got ttl=%s enabled=%v", ttl, enabled)
+	}
+	if _, err := handleStickyPolicyRequest(newRequest("profile=test:api&disabled=true")); err != nil {
+		t.Fatal(err)
+	}
+	if _, enabled := getStickyTTL("test:api"); enabled {
+		t.Error("stickiness should be disabled")
+	}
+	if _, err := handleStickyPolicyRequest(newRequest("profile=test:api&ttl=-5m")); err == nil {
+		t.Error("negative ttl should be rejected")
+	}
+}
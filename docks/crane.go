@@ -105,6 +105,8 @@ type Crane struct {
 
 	// targetLoadSize defines the optimal loading size.
 	targetLoadSize int
+	// shipLoadSize holds the ship load size targetLoadSize was calculated with.
+	shipLoadSize int
 }
 
 // NewCrane returns a new crane.
@@ -142,20 +144,51 @@ func NewCrane(ship ships.Ship, connectedHub *hub.Hub, id *cabin.Identity) (*Cran
 	}
 
 	// Calculate target load size.
-	loadSize := ship.LoadSize()
+	newCrane.updateTargetLoadSize()
+
+	return newCrane, nil
+}
+
+// updateTargetLoadSize calculates the target load size from the ship's load
+// size, which may change when the ship discovers the path MTU.
+// Must only be called by the loader, or before it is started.
+func (crane *Crane) updateTargetLoadSize() {
+	loadSize := crane.ship.LoadSize()
 	if loadSize <= 0 {
 		loadSize = ships.BaseMTU
 	}
-	newCrane.targetLoadSize = loadSize
-	for newCrane.targetLoadSize < optimalMinLoadSize {
-		newCrane.targetLoadSize += loadSize
+	if loadSize == crane.shipLoadSize {
+		return
+	}
+	crane.shipLoadSize = loadSize
+
+	targetLoadSize := loadSize
+	for targetLoadSize < optimalMinLoadSize {
+		targetLoadSize += loadSize
 	}
 	// Subtract overhead needed for encryption.
-	newCrane.targetLoadSize -= 25 // Manually tested for jess.SuiteWireV1
+	targetLoadSize -= 25 // Manually tested for jess.SuiteWireV1
 	// Subtract space needed for length encoding the final chunk.
-	newCrane.targetLoadSize -= varint.EncodedSize(uint64(newCrane.targetLoadSize))
+	targetLoadSize -= varint.EncodedSize(uint64(targetLoadSize))
 
-	return newCrane, nil
+	crane.targetLoadSize = targetLoadSize
+}
+
+// measurePacketLoss returns the packet loss of the ship in percent (0-100)
+// since the last measurement. It returns false if the ship cannot measure
+// packet loss or if not enough data was sent since the last measurement.
+// Only ships that retransmit lost segments themselves can measure packet loss,
+// as it is hidden by the kernel for stream based ships.
+func (crane *Crane) measurePacketLoss() (loss float32, ok bool) {
+	reporter, ok := crane.ship.(ships.SegmentStatsReporter)
+	if !ok {
+		return 0, false
+	}
+	sent, retransmitted, ok := reporter.SegmentStats()
+	if !ok {
+		return 0, false
+	}
+	return crane.NetState.updatePacketLoss(sent, retransmitted)
 }
 
 // IsMine returns whether the crane was started on this side.
@@ -672,6 +705,9 @@ func (crane *Crane) loader(workerCtx context.Context) (err error) {
 		firstMsg.Finish()
 		firstMsg = nil
 
+		// Adapt to changes of the ship's load size.
+		crane.updateTargetLoadSize()
+
 	fillingShipment:
 		for shipment.Length() < crane.targetLoadSize {
 			// Gather segments until shipment is filled.
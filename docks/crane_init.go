@@ -11,6 +11,7 @@ import (
 	"github.com/safing/portbase/info"
 	"github.com/safing/portbase/log"
 	"github.com/safing/spn/conf"
+	"github.com/safing/spn/hub"
 	"github.com/safing/spn/terminal"
 )
 
@@ -131,7 +132,11 @@ func (crane *Crane) startLocal(callerCtx context.Context) *terminal.Error {
 
 		// Configure encryption.
 		env := jess.NewUnconfiguredEnvelope()
-		env.SuiteID = jess.SuiteWireV1
+		if suiteID, ok := hub.WireSuiteForScheme(signet.Scheme); ok {
+			env.SuiteID = suiteID
+		} else {
+			env.SuiteID = jess.SuiteWireV1
+		}
 		env.Recipients = []*jess.Signet{signet}
 
 		// Do not encrypt directly, rather get session for future use, then encrypt.
@@ -178,7 +183,12 @@ func (crane *Crane) startLocal(callerCtx context.Context) *terminal.Error {
 }
 
 func (crane *Crane) startRemote(callerCtx context.Context) *terminal.Error {
-	var initMsg *container.Container
+	var (
+		initMsg *container.Container
+		// servedRequest is set when a request was handled that may be the only
+		// one of the remote end, such as querying info or verifying the Hub.
+		servedRequest bool
+	)
 
 	module.StartWorker("crane unloader", crane.unloader)
 
@@ -190,8 +200,14 @@ handling:
 		case request = <-crane.unloading:
 
 		case <-time.After(30 * time.Second):
+			if servedRequest {
+				return terminal.ErrStopping.With("remote did not end crane after request")
+			}
 			return terminal.ErrTimeout.With("waiting for crane init msg")
 		case <-crane.ctx.Done():
+			if servedRequest {
+				return terminal.ErrStopping.With("remote ended crane after request")
+			}
 			return terminal.ErrShipSunk.With("waiting for crane init msg")
 		case <-callerCtx.Done():
 			return terminal.ErrCanceled.With("waiting for crane init msg")
@@ -214,6 +230,7 @@ handling:
 				return err
 			}
 			log.Debugf("spn/docks: %s sent version info", crane)
+			servedRequest = true
 
 		case CraneMsgTypeRequestHubInfo:
 			// Handle Hub info request.
@@ -222,6 +239,7 @@ handling:
 				return err
 			}
 			log.Debugf("spn/docks: %s sent hub info", crane)
+			servedRequest = true
 
 		case CraneMsgTypeVerify:
 			// Verify is a terminating request.
@@ -230,6 +248,7 @@ handling:
 				return err
 			}
 			log.Infof("spn/docks: %s sent hub verification", crane)
+			servedRequest = true
 
 		case CraneMsgTypeStartUnencrypted:
 			initMsg = request
@@ -9,6 +9,10 @@ import (
 // NetStatePeriodInterval defines the interval some of the net state should be reset.
 const NetStatePeriodInterval = 15 * time.Minute
 
+// packetLossMinSegments defines how many segments must have been sent since the
+// last packet loss measurement for a new measurement.
+const packetLossMinSegments = 100
+
 // NetworkOptimizationState holds data for optimization purposes.
 type NetworkOptimizationState struct {
 	lock sync.Mutex
@@ -29,6 +33,11 @@ type NetworkOptimizationState struct {
 	periodBytesIn    *uint64
 	periodBytesOut   *uint64
 	periodStarted    time.Time
+
+	// lossSentSegments and lossRetransmittedSegments hold the segment
+	// statistics of the ship at the last packet loss measurement.
+	lossSentSegments          uint64
+	lossRetransmittedSegments uint64
 }
 
 func newNetworkOptimizationState() *NetworkOptimizationState {
@@ -42,6 +51,25 @@ func newNetworkOptimizationState() *NetworkOptimizationState {
 	}
 }
 
+// updatePacketLoss calculates the packet loss in percent (0-100) from the given
+// segment statistics of the ship since the last measurement. It returns false
+// if not enough segments were sent since the last measurement.
+func (netState *NetworkOptimizationState) updatePacketLoss(sent, retransmitted uint64) (loss float32, ok bool) {
+	netState.lock.Lock()
+	defer netState.lock.Unlock()
+
+	sentDelta := sent - netState.lossSentSegments
+	if sentDelta < packetLossMinSegments {
+		return 0, false
+	}
+	retransmittedDelta := retransmitted - netState.lossRetransmittedSegments
+	netState.lossSentSegments = sent
+	netState.lossRetransmittedSegments = retransmitted
+
+	// Every retransmission was caused by a lost segment or acknowledgement.
+	return 100 * float32(retransmittedDelta) / float32(sentDelta+retransmittedDelta), true
+}
+
 // UpdateLastSuggestedAt sets when the lane was last suggested to the current time.
 func (netState *NetworkOptimizationState) UpdateLastSuggestedAt() {
 	netState.lock.Lock()
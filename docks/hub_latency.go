@@ -0,0 +1,84 @@
+package docks
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/safing/portbase/container"
+	"github.com/safing/portbase/formats/dsd"
+	"github.com/safing/portbase/formats/varint"
+	"github.com/safing/portbase/info"
+	"github.com/safing/portbase/log"
+	"github.com/safing/spn/hub"
+	"github.com/safing/spn/ships"
+	"github.com/safing/spn/terminal"
+)
+
+// MeasureHubLatency connects to the Hub using the given transport and measures
+// the round trip time of an info request. The connection is ended cleanly
+// afterwards, so that the Hub does not see it as an aborted crane.
+func MeasureHubLatency(ctx context.Context, h *hub.Hub, transport *hub.Transport) (time.Duration, error) {
+	// Create connection.
+	ship, err := ships.Launch(ctx, h, transport, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to launch ship: %w", err)
+	}
+
+	// Start crane for receiving reply.
+	crane, err := NewCrane(ship, h, nil)
+	if err != nil {
+		ship.Sink()
+		return 0, fmt.Errorf("failed to create crane: %w", err)
+	}
+	module.StartWorker("crane unloader", crane.unloader)
+	defer crane.Stop(nil)
+
+	// Measure info request.
+	started := time.Now()
+	if _, tErr := crane.requestInfo(ctx); tErr != nil {
+		return 0, tErr
+	}
+	latency := time.Since(started)
+
+	// End connection.
+	if tErr := crane.endInit(); tErr != nil {
+		log.Debugf("spn/docks: failed to end latency test connection to %s: %s", h, tErr)
+	}
+
+	return latency, nil
+}
+
+// requestInfo requests the version info of the connected Hub.
+func (crane *Crane) requestInfo(callerCtx context.Context) (*info.Info, *terminal.Error) {
+	// Send request.
+	request := container.New(
+		varint.Pack8(CraneMsgTypeInfo),
+	)
+	request.PrependLength()
+	err := crane.ship.Load(request.CompileData())
+	if err != nil {
+		return nil, terminal.ErrShipSunk.With("failed to request info: %w", err)
+	}
+
+	// Wait for reply.
+	var reply *container.Container
+	select {
+	case reply = <-crane.unloading:
+	case <-time.After(30 * time.Second):
+		return nil, terminal.ErrTimeout.With("waiting for info")
+	case <-crane.ctx.Done():
+		return nil, terminal.ErrShipSunk.With("waiting for info")
+	case <-callerCtx.Done():
+		return nil, terminal.ErrCanceled.With("waiting for info")
+	}
+
+	// Parse reply.
+	versionInfo := &info.Info{}
+	_, err = dsd.Load(reply.CompileData(), versionInfo)
+	if err != nil {
+		return nil, terminal.ErrMalformedData.With("failed to parse info: %w", err)
+	}
+
+	return versionInfo, nil
+}
@@ -26,10 +26,14 @@ const (
 
 var (
 	latencyTestPauseDuration = 1 * time.Second
-	latencyTestOpTimeout     = latencyTestRuns * latencyTestPauseDuration * 3
+	latencyTestPingTimeout   = 3 * time.Second
+	latencyTestOpTimeout     = latencyTestRuns * (latencyTestPauseDuration + latencyTestPingTimeout) * 2
 )
 
-// LatencyTestOp is used to measure latency.
+// LatencyTestOp is used to measure latency and jitter.
+// Pings are sent over the reliable connection of the crane, so a ping that is
+// not answered within the ping timeout is not a lost packet. Packet loss is
+// measured by the ship instead, if supported, and saved with the latency.
 type LatencyTestOp struct {
 	terminal.OperationBase
 }
@@ -38,11 +42,15 @@ type LatencyTestOp struct {
 type LatencyTestClientOp struct {
 	LatencyTestOp
 
-	lastPingSentAt    time.Time
-	lastPingNonce     []byte
-	measuredLatencies []time.Duration
-	responses         chan *terminal.Msg
-	testResult        time.Duration
+	lastPingSentAt     time.Time
+	lastPingNonce      []byte
+	timedOutPingNonces [][]byte
+	measuredLatencies  []time.Duration
+	timedOutPings      int
+	responses          chan *terminal.Msg
+	testResult         time.Duration
+	testJitter         time.Duration
+	testLoss           float32
 
 	result chan *terminal.Error
 }
@@ -96,6 +104,8 @@ func (op *LatencyTestClientOp) handler(ctx context.Context) error {
 
 	var nextTest <-chan time.Time
 	opTimeout := time.After(latencyTestOpTimeout)
+	// The first ping was sent when starting the operation.
+	pingTimeout := time.After(latencyTestPingTimeout)
 
 	for {
 		select {
@@ -125,6 +135,26 @@ func (op *LatencyTestClientOp) handler(ctx context.Context) error {
 			op.Flush(1 * time.Second)
 
 			nextTest = nil
+			pingTimeout = time.After(latencyTestPingTimeout)
+
+		case <-pingTimeout:
+			// The ping was not answered in time.
+			// Remember the nonce in order to ignore a late response.
+			op.timedOutPings++
+			op.timedOutPingNonces = append(op.timedOutPingNonces, op.lastPingNonce)
+			op.lastPingNonce = nil
+			pingTimeout = nil
+
+			// Check if we have enough latency tests.
+			if op.testsDone() {
+				returnErr = op.reportMeasuredLatencies()
+				return nil
+			}
+
+			// Schedule next latency test, if not yet scheduled.
+			if nextTest == nil {
+				nextTest = time.After(latencyTestPauseDuration)
+			}
 
 		case msg := <-op.responses:
 			// Check if the op ended.
@@ -133,14 +163,19 @@ func (op *LatencyTestClientOp) handler(ctx context.Context) error {
 			}
 
 			// Handle response
-			tErr := op.handleResponse(msg)
+			counted, tErr := op.handleResponse(msg)
 			if tErr != nil {
 				returnErr = tErr
 				return nil //nolint:nilerr
 			}
+			if !counted {
+				// Late response to a timed out ping.
+				continue
+			}
+			pingTimeout = nil
 
 			// Check if we have enough latency tests.
-			if len(op.measuredLatencies) >= latencyTestRuns {
+			if op.testsDone() {
 				returnErr = op.reportMeasuredLatencies()
 				return nil
 			}
@@ -170,30 +205,45 @@ func (op *LatencyTestClientOp) createPingRequest() (*container.Container, error)
 	), nil
 }
 
-func (op *LatencyTestClientOp) handleResponse(msg *terminal.Msg) *terminal.Error {
+// handleResponse handles a ping response and returns whether it was counted.
+// Late responses to timed out pings are not counted.
+func (op *LatencyTestClientOp) handleResponse(msg *terminal.Msg) (counted bool, tErr *terminal.Error) {
 	defer msg.Finish()
 
 	rType, err := msg.Data.GetNextN8()
 	if err != nil {
-		return terminal.ErrMalformedData.With("failed to get response type: %w", err)
+		return false, terminal.ErrMalformedData.With("failed to get response type: %w", err)
 	}
 
 	switch rType {
 	case latencyPingResponse:
+		nonce := msg.Data.CompileData()
+
 		// Check if the ping nonce matches.
-		if !bytes.Equal(op.lastPingNonce, msg.Data.CompileData()) {
-			return terminal.ErrIntegrity.With("ping nonce mismatch")
+		if !bytes.Equal(op.lastPingNonce, nonce) {
+			// Check if this is a late response to a timed out ping.
+			for _, timedOutNonce := range op.timedOutPingNonces {
+				if bytes.Equal(timedOutNonce, nonce) {
+					return false, nil
+				}
+			}
+			return false, terminal.ErrIntegrity.With("ping nonce mismatch")
 		}
 		op.lastPingNonce = nil
 		// Save latency.
 		op.measuredLatencies = append(op.measuredLatencies, time.Since(op.lastPingSentAt))
 
-		return nil
+		return true, nil
 	default:
-		return terminal.ErrIncorrectUsage.With("unknown response type")
+		return false, terminal.ErrIncorrectUsage.With("unknown response type")
 	}
 }
 
+// testsDone returns whether all pings have either been answered or timed out.
+func (op *LatencyTestClientOp) testsDone() bool {
+	return len(op.measuredLatencies)+op.timedOutPings >= latencyTestRuns
+}
+
 func (op *LatencyTestClientOp) reportMeasuredLatencies() *terminal.Error {
 	// Find lowest value.
 	lowestLatency := time.Hour
@@ -204,11 +254,31 @@ func (op *LatencyTestClientOp) reportMeasuredLatencies() *terminal.Error {
 	}
 	op.testResult = lowestLatency
 
+	// Calculate jitter.
+	op.testJitter = calculateJitter(op.measuredLatencies)
+
 	// Save the result to the crane.
 	if controller, ok := op.Terminal().(*CraneControllerTerminal); ok {
 		if controller.Crane.ConnectedHub != nil {
-			controller.Crane.ConnectedHub.GetMeasurements().SetLatency(op.testResult)
-			log.Infof("spn/docks: measured latency to %s: %s", controller.Crane.ConnectedHub, op.testResult)
+			if len(op.measuredLatencies) == 0 {
+				return terminal.ErrTimeout.With("all latency pings to %s timed out", controller.Crane.ConnectedHub)
+			}
+			measurements := controller.Crane.ConnectedHub.GetMeasurements()
+			measurements.SetJitter(op.testJitter)
+			measurements.SetLatency(op.testResult)
+			// Keep the last packet loss measurement, if there is no new one.
+			if loss, ok := controller.Crane.measurePacketLoss(); ok {
+				measurements.SetLoss(loss)
+			}
+			op.testLoss = measurements.GetLoss()
+			log.Infof(
+				"spn/docks: measured latency to %s: %s (jitter %s, loss %.0f%%, %d pings timed out)",
+				controller.Crane.ConnectedHub,
+				op.testResult,
+				op.testJitter,
+				op.testLoss,
+				op.timedOutPings,
+			)
 			return nil
 		} else if controller.Crane.IsMine() {
 			return terminal.ErrInternalError.With("latency operation was run on %s without a connected hub set", controller.Crane)
@@ -219,6 +289,24 @@ func (op *LatencyTestClientOp) reportMeasuredLatencies() *terminal.Error {
 	return nil
 }
 
+// calculateJitter calculates the jitter as the mean deviation between
+// consecutive latency measurements.
+func calculateJitter(latencies []time.Duration) time.Duration {
+	if len(latencies) < 2 {
+		return 0
+	}
+
+	var total time.Duration
+	for i := 1; i < len(latencies); i++ {
+		diff := latencies[i] - latencies[i-1]
+		if diff < 0 {
+			diff = -diff
+		}
+		total += diff
+	}
+	return total / time.Duration(len(latencies)-1)
+}
+
 // Deliver delivers a message to the operation.
 func (op *LatencyTestClientOp) Deliver(msg *terminal.Msg) *terminal.Error {
 	// Optimized delivery with 1s timeout.
@@ -56,4 +56,58 @@ func TestLatencyOp(t *testing.T) {
 	if float64(op.testResult) < expectedLatency*0.9 {
 		t.Fatal("measured latency too low")
 	}
+
+	// Check jitter and loss.
+	t.Logf("measured jitter: %f ms", float64(op.testJitter)/float64(time.Millisecond))
+	if op.testLoss != 0 {
+		t.Fatalf("measured loss of %.0f%%, expected none", op.testLoss)
+	}
+}
+
+func TestCalculateJitter(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		latencies []time.Duration
+		jitter    time.Duration
+	}{
+		{nil, 0},
+		{[]time.Duration{10 * time.Millisecond}, 0},
+		{[]time.Duration{10 * time.Millisecond, 10 * time.Millisecond, 10 * time.Millisecond}, 0},
+		{[]time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 10 * time.Millisecond}, 10 * time.Millisecond},
+		{[]time.Duration{10 * time.Millisecond, 14 * time.Millisecond, 12 * time.Millisecond, 12 * time.Millisecond}, 2 * time.Millisecond},
+	} {
+		if jitter := calculateJitter(tc.latencies); jitter != tc.jitter {
+			t.Errorf("jitter of %v should be %s, but is %s", tc.latencies, tc.jitter, jitter)
+		}
+	}
+}
+
+func TestUpdatePacketLoss(t *testing.T) {
+	t.Parallel()
+
+	netState := newNetworkOptimizationState()
+
+	// Too few segments for a measurement, the previous statistics are kept.
+	if _, ok := netState.updatePacketLoss(90, 10); ok {
+		t.Fatal("packet loss should not be measured with too few segments")
+	}
+
+	// 20 retransmissions for 180 new segments.
+	loss, ok := netState.updatePacketLoss(180, 20)
+	if !ok {
+		t.Fatal("packet loss should be measured")
+	}
+	if loss != 10 {
+		t.Errorf("packet loss should be 10%%, but is %.1f%%", loss)
+	}
+
+	// Only segments since the last measurement are counted.
+	loss, ok = netState.updatePacketLoss(180+packetLossMinSegments, 20)
+	if !ok {
+		t.Fatal("packet loss should be measured")
+	}
+	if loss != 0 {
+		t.Errorf("packet loss should be 0%%, but is %.1f%%", loss)
+	}
 }
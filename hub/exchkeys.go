@@ -0,0 +1,250 @@
+package hub
+
+import (
+	"crypto"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+
+	"github.com/cloudflare/circl/kem"
+	"github.com/cloudflare/circl/kem/hybrid"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/chacha20poly1305"
+
+	"github.com/safing/jess"
+	"github.com/safing/jess/tools"
+	"github.com/safing/portbase/container"
+)
+
+// Exchange Key Schemes.
+const (
+	// ExchKeySchemeX25519 is the classic exchange key scheme supported by all Hubs.
+	ExchKeySchemeX25519 = "ECDH-X25519"
+
+	// ExchKeySchemeX25519Kyber768 is a post-quantum hybrid exchange key scheme
+	// that combines X25519 with the Kyber768 key encapsulation mechanism.
+	// The session key is only compromised if both are broken.
+	ExchKeySchemeX25519Kyber768 = "KEM-X25519-KYBER768"
+)
+
+// SuiteWirePQV1 is a cipher suite for network communication, like
+// jess.SuiteWireV1, but with the post-quantum hybrid exchange key scheme.
+const SuiteWirePQV1 = "w1pq"
+
+// exchKeySchemeWireSuites maps the supported exchange key schemes to the
+// jess wire suites that use them, ordered by preference.
+var exchKeySchemeWireSuites = []struct {
+	scheme  string
+	suiteID string
+}{
+	{ExchKeySchemeX25519Kyber768, SuiteWirePQV1},
+	{ExchKeySchemeX25519, jess.SuiteWireV1},
+}
+
+// hybridKEMScheme is the circl KEM used by the hybrid exchange key scheme.
+var hybridKEMScheme = hybrid.Kyber768X25519()
+
+// hybridKEMWrapContext is used to derive the key wrapping key from the
+// encapsulated shared secret.
+var hybridKEMWrapContext = []byte("SPN Hybrid KEM Key Wrapping v1")
+
+func init() {
+	tool := &tools.Tool{
+		Info: &tools.ToolInfo{
+			Name:          ExchKeySchemeX25519Kyber768,
+			Purpose:       tools.PurposeKeyEncapsulation,
+			SecurityLevel: 128,
+			Comment:       "hybrid of X25519 and Kyber768 (round 3)",
+			Author:        "Daniel J. Bernstein, 2005; CRYSTALS team, 2017",
+		},
+		Factory: func() tools.ToolLogic { return &hybridKEM{} },
+	}
+	tools.Register(tool)
+
+	// Jess initializes the static logic of its tools before we can register
+	// ours, so we initialize it ourselves. It is used for handling signets.
+	tool.StaticLogic = tool.Factory()
+	tool.StaticLogic.Init(tool, &jess.Helper{}, nil, nil)
+
+	// Jess does not allow registering suites from the outside, so we add the
+	// post-quantum wire suite to the suite registry directly.
+	jess.SuitesMap()[SuiteWirePQV1] = &jess.Suite{
+		ID:            SuiteWirePQV1,
+		Tools:         []string{ExchKeySchemeX25519Kyber768, "HKDF(BLAKE2b-256)", "CHACHA20-POLY1305"},
+		Provides:      jess.NewRequirements().Remove(jess.SenderAuthentication),
+		SecurityLevel: 128,
+		Status:        jess.SuiteStatusRecommended,
+	}
+}
+
+// WireSuiteForScheme returns the jess wire suite to use with a signet of the
+// given exchange key scheme.
+func WireSuiteForScheme(scheme string) (suiteID string, ok bool) {
+	for _, entry := range exchKeySchemeWireSuites {
+		if entry.scheme == scheme {
+			return entry.suiteID, true
+		}
+	}
+	return "", false
+}
+
+// hybridKEM implements the jess tool logic for the hybrid exchange key scheme.
+type hybridKEM struct {
+	tools.ToolLogicBase
+}
+
+// EncapsulateKey implements the ToolLogic interface.
+func (hk *hybridKEM) EncapsulateKey(key []byte, remote tools.SignetInt) ([]byte, error) {
+	pubKey, ok := remote.PublicKey().(kem.PublicKey)
+	if !ok {
+		return nil, tools.ErrInvalidKey
+	}
+
+	// Encapsulate a shared secret with randomness from the jess helper.
+	seed, err := hk.Helper().RandomBytes(hybridKEMScheme.EncapsulationSeedSize())
+	if err != nil {
+		return nil, err
+	}
+	ct, sharedSecret, err := hybridKEMScheme.EncapsulateDeterministically(pubKey, seed)
+	if err != nil {
+		return nil, err
+	}
+
+	// Wrap the given key with the shared secret.
+	aead, err := newHybridKEMWrapCipher(sharedSecret)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	return aead.Seal(ct, nonce, key, nil), nil
+}
+
+// UnwrapKey implements the ToolLogic interface.
+func (hk *hybridKEM) UnwrapKey(wrappedKey []byte, local tools.SignetInt) ([]byte, error) {
+	privKey, ok := local.PrivateKey().(kem.PrivateKey)
+	if !ok {
+		return nil, tools.ErrInvalidKey
+	}
+	if len(wrappedKey) <= hybridKEMScheme.CiphertextSize() {
+		return nil, errors.New("wrapped key too short")
+	}
+
+	// Decapsulate shared secret.
+	sharedSecret, err := hybridKEMScheme.Decapsulate(privKey, wrappedKey[:hybridKEMScheme.CiphertextSize()])
+	if err != nil {
+		return nil, err
+	}
+
+	// Unwrap the key with the shared secret.
+	aead, err := newHybridKEMWrapCipher(sharedSecret)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	return aead.Open(nil, nonce, wrappedKey[hybridKEMScheme.CiphertextSize():], nil)
+}
+
+// newHybridKEMWrapCipher returns the cipher for wrapping a key with the given
+// shared secret. As every shared secret is only used once, a zero nonce is safe.
+func newHybridKEMWrapCipher(sharedSecret []byte) (cipher.AEAD, error) {
+	wrapKey, err := blake2b.New256(sharedSecret)
+	if err != nil {
+		return nil, err
+	}
+	_, _ = wrapKey.Write(hybridKEMWrapContext)
+	return chacha20poly1305.New(wrapKey.Sum(nil))
+}
+
+// LoadKey implements the ToolLogic interface.
+func (hk *hybridKEM) LoadKey(signet tools.SignetInt) error {
+	var pubKey crypto.PublicKey
+	var privKey crypto.PrivateKey
+
+	key, public := signet.GetStoredKey()
+	c := container.New(key)
+
+	// Check serialization version.
+	version, err := c.GetNextN8()
+	if err != nil || version != 1 {
+		return tools.ErrInvalidKey
+	}
+
+	// Load public key.
+	data, err := c.Get(hybridKEMScheme.PublicKeySize())
+	if err != nil {
+		return tools.ErrInvalidKey
+	}
+	pubKey, err = hybridKEMScheme.UnmarshalBinaryPublicKey(data)
+	if err != nil {
+		return tools.ErrInvalidKey
+	}
+
+	// Load private key.
+	if !public {
+		data, err = c.Get(hybridKEMScheme.PrivateKeySize())
+		if err != nil {
+			return tools.ErrInvalidKey
+		}
+		privKey, err = hybridKEMScheme.UnmarshalBinaryPrivateKey(data)
+		if err != nil {
+			return tools.ErrInvalidKey
+		}
+	}
+
+	signet.SetLoadedKeys(pubKey, privKey)
+	return nil
+}
+
+// StoreKey implements the ToolLogic interface.
+func (hk *hybridKEM) StoreKey(signet tools.SignetInt) error {
+	pubKey, ok := signet.PublicKey().(kem.PublicKey)
+	if !ok {
+		return fmt.Errorf("public key of invalid type %T", signet.PublicKey())
+	}
+	privKey := signet.PrivateKey()
+	public := privKey == nil
+
+	// Create storage with serialization version.
+	c := container.New()
+	c.AppendNumber(1)
+
+	// Store keys.
+	pubKeyData, err := pubKey.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	c.Append(pubKeyData)
+	if !public {
+		kemPrivKey, ok := privKey.(kem.PrivateKey)
+		if !ok {
+			return fmt.Errorf("private key of invalid type %T", privKey)
+		}
+		privKeyData, err := kemPrivKey.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		c.Append(privKeyData)
+	}
+
+	signet.SetStoredKey(c.CompileData(), public)
+	return nil
+}
+
+// GenerateKey implements the ToolLogic interface.
+func (hk *hybridKEM) GenerateKey(signet tools.SignetInt) error {
+	seed, err := hk.Helper().RandomBytes(hybridKEMScheme.SeedSize())
+	if err != nil {
+		return err
+	}
+	pubKey, privKey := hybridKEMScheme.DeriveKeyPair(seed)
+	hk.Helper().Burn(seed)
+
+	signet.SetLoadedKeys(pubKey, privKey)
+	return nil
+}
+
+// BurnKey implements the ToolLogic interface.
+// Like with the other jess tools, this is currently ineffective.
+func (hk *hybridKEM) BurnKey(signet tools.SignetInt) error {
+	return nil
+}
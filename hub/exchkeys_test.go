@@ -0,0 +1,212 @@
+package hub
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/safing/jess"
+	"github.com/safing/jess/tools"
+)
+
+type testExchKeyStore struct {
+	signet *jess.Signet
+}
+
+func (ts *testExchKeyStore) GetSignet(id string, recipient bool) (*jess.Signet, error) {
+	if id != ts.signet.ID || recipient {
+		return nil, jess.ErrSignetNotFound
+	}
+	return ts.signet, nil
+}
+
+func TestHybridExchKeySignet(t *testing.T) {
+	t.Parallel()
+
+	// Generate and store the key through the jess signet API.
+	signet, err := jess.GenerateSignet(ExchKeySchemeX25519Kyber768, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := signet.StoreKey(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Load the private key from the stored key.
+	loaded := &jess.Signet{
+		Version: 1,
+		Scheme:  ExchKeySchemeX25519Kyber768,
+		Key:     signet.Key,
+	}
+	if err := loaded.LoadKey(); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.PrivateKey() == nil {
+		t.Fatal("private key was not loaded")
+	}
+
+	// Load the public key from the stored recipient.
+	rcpt, err := loaded.AsRecipient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rcpt.StoreKey(); err != nil {
+		t.Fatal(err)
+	}
+	loadedRcpt := &jess.Signet{
+		Version: 1,
+		Scheme:  ExchKeySchemeX25519Kyber768,
+		Key:     rcpt.Key,
+		Public:  true,
+	}
+	if err := loadedRcpt.LoadKey(); err != nil {
+		t.Fatal(err)
+	}
+	if loadedRcpt.PrivateKey() != nil {
+		t.Fatal("recipient should not have a private key")
+	}
+
+	// Burn the keys.
+	if err := signet.Burn(); err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.Burn(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHybridExchKeyWire(t *testing.T) {
+	t.Parallel()
+
+	// Create exchange key of the server.
+	tool, err := tools.Get(ExchKeySchemeX25519Kyber768)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signet := jess.NewSignetBase(tool)
+	signet.ID = "test"
+	if err := signet.GenerateKey(); err != nil {
+		t.Fatal(err)
+	}
+	if err := signet.StoreKey(); err != nil {
+		t.Fatal(err)
+	}
+	rcpt, err := signet.AsRecipient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rcpt.StoreKey(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Set up client session like a crane or expansion terminal would.
+	env := jess.NewUnconfiguredEnvelope()
+	var ok bool
+	env.SuiteID, ok = WireSuiteForScheme(rcpt.Scheme)
+	if !ok || env.SuiteID != SuiteWirePQV1 {
+		t.Fatalf("unexpected wire suite %q for %s", env.SuiteID, rcpt.Scheme)
+	}
+	env.Recipients = []*jess.Signet{rcpt}
+	client, err := env.WireCorrespondence(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Exchange messages in both directions to complete the handshake.
+	var server *jess.Session
+	for i := 0; i < 5; i++ {
+		msg := []byte("ping")
+		letter, err := client.Close(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := letter.ToWire()
+		if err != nil {
+			t.Fatal(err)
+		}
+		letter, err = jess.LetterFromWire(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if server == nil {
+			server, err = letter.WireCorrespondence(&testExchKeyStore{signet: signet})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		received, err := server.Open(letter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(received, msg) {
+			t.Fatalf("server received %q", received)
+		}
+
+		msg = []byte("pong")
+		letter, err = server.Close(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err = letter.ToWire()
+		if err != nil {
+			t.Fatal(err)
+		}
+		letter, err = jess.LetterFromWire(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		received, err = client.Open(letter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(received, msg) {
+			t.Fatalf("client received %q", received)
+		}
+	}
+}
+
+func TestHybridExchKeyStatus(t *testing.T) {
+	t.Parallel()
+
+	expires := time.Now().Add(time.Hour).Unix()
+	hybridKey := &Key{
+		Scheme:  ExchKeySchemeX25519Kyber768,
+		Key:     make([]byte, 1300),
+		Expires: expires,
+	}
+	regularKey := &Key{
+		Scheme:  ExchKeySchemeX25519,
+		Key:     make([]byte, 32),
+		Expires: expires,
+	}
+
+	// Hybrid keys are only accepted separately from the regular keys, as older
+	// clients would reject the status.
+	status := &Status{
+		Keys:       map[string]*Key{"regular": regularKey},
+		HybridKeys: map[string]*Key{"hybrid": hybridKey},
+	}
+	if err := status.validateFormatting(); err != nil {
+		t.Errorf("status with hybrid keys should be valid: %s", err)
+	}
+	invalid := &Status{
+		Keys: map[string]*Key{"regular": regularKey, "hybrid": hybridKey},
+	}
+	if err := invalid.validateFormatting(); err == nil {
+		t.Error("status with hybrid keys in regular keys should be invalid")
+	}
+
+	// Hybrid keys are preferred.
+	h := &Hub{Status: status}
+	if signet := h.SelectSignet(); signet == nil || signet.ID != "hybrid" {
+		t.Errorf("hybrid key should be selected, got %+v", signet)
+	}
+	if _, err := h.GetSignet("hybrid", true); err != nil {
+		t.Errorf("hybrid key should be found: %s", err)
+	}
+
+	// Unknown schemes fall back to the regular wire suite.
+	if _, ok := WireSuiteForScheme("unknown"); ok {
+		t.Error("unknown scheme should not have a wire suite")
+	}
+}
@@ -314,10 +314,13 @@ func (a *Announcement) validateFormatting() (err error) {
 	if err = checkStringSliceFormat("Exit", a.Exit, 255, 255); err != nil {
 		return err
 	}
-	return a.parsePolicies()
+	return a.ParsePolicies()
 }
 
-func (a *Announcement) parsePolicies() error {
+// ParsePolicies parses the entry and exit policies of the Announcement.
+// This is done automatically when the Announcement is validated and only needs
+// to be called when it was created in another way.
+func (a *Announcement) ParsePolicies() error {
 	var err error
 	if a.entryPolicy, err = endpoints.ParseEndpoints(a.Entry); err != nil {
 		return fmt.Errorf("failed to parse entry policy: %w", err)
@@ -41,6 +41,10 @@ type Intel struct {
 	// VirtualNetworks holds network configurations for virtual cloud networks.
 	VirtualNetworks []*VirtualNetworkConfig
 
+	// CostModel specifies the ID of the cost model that should be used for
+	// finding routes. The default cost model is used if empty or unknown.
+	CostModel string
+
 	parsed *ParsedIntel
 }
 
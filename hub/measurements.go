@@ -21,6 +21,13 @@ type Measurements struct {
 	// LatencyMeasuredAt holds when the latency was measured.
 	LatencyMeasuredAt time.Time
 
+	// Jitter designates the variation of the latency between these Hubs.
+	// It is specified in nanoseconds and measured together with the latency.
+	Jitter time.Duration
+	// Loss designates the packet loss between these Hubs.
+	// It is specified in percent (0-100) and measured together with the latency.
+	Loss float32
+
 	// Capacity designates the available bandwidth between these Hubs.
 	// It is specified in bit/s.
 	Capacity int
@@ -54,6 +61,8 @@ func (m *Measurements) Copy() *Measurements {
 	copied := &Measurements{
 		Latency:            m.Latency,
 		LatencyMeasuredAt:  m.LatencyMeasuredAt,
+		Jitter:             m.Jitter,
+		Loss:               m.Loss,
 		Capacity:           m.Capacity,
 		CapacityMeasuredAt: m.CapacityMeasuredAt,
 		CalculatedCost:     m.CalculatedCost,
@@ -138,6 +147,43 @@ func (m *Measurements) GetLatency() (latency time.Duration, measuredAt time.Time
 	return m.Latency, m.LatencyMeasuredAt
 }
 
+// SetJitter sets the jitter to the given value.
+// The jitter is measured together with the latency.
+func (m *Measurements) SetJitter(jitter time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.Jitter = jitter
+	m.persisted.UnSet()
+}
+
+// GetJitter returns the jitter.
+func (m *Measurements) GetJitter() (jitter time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.Jitter
+}
+
+// SetLoss sets the packet loss to the given value.
+// The packet loss is specified in percent (0-100) and is measured by ships
+// that retransmit lost segments themselves, together with the latency.
+func (m *Measurements) SetLoss(loss float32) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.Loss = loss
+	m.persisted.UnSet()
+}
+
+// GetLoss returns the packet loss in percent (0-100).
+func (m *Measurements) GetLoss() (loss float32) {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.Loss
+}
+
 // SetCapacity sets the capacity to the given value.
 // The capacity is measued in bit/s.
 func (m *Measurements) SetCapacity(capacity int) {
@@ -18,6 +18,9 @@ const VersionOffline = "offline"
 // Status Flags.
 const (
 	FlagNetError = "net-error"
+	// FlagConnectResume signifies that the Hub supports resuming connections
+	// via a new route.
+	FlagConnectResume = "connect-resume"
 )
 
 // Status is the message type used to update changing Hub Information. Changes are made automatically.
@@ -30,6 +33,10 @@ type Status struct {
 	// Routing Information
 	Keys  map[string]*Key // public keys (with type)
 	Lanes []*Lane         // Connections to other Hubs.
+	// HybridKeys holds post-quantum hybrid public keys. They are kept separate
+	// from Keys, as they exceed the key size limit of older clients, which
+	// would then reject the whole status. Older clients ignore this field.
+	HybridKeys map[string]*Key `json:",omitempty"`
 
 	// Status Information
 	// Load describes max(CPU, Memory) in percent, averaged over at least 15
@@ -58,6 +65,14 @@ type Lane struct {
 	// Lateny designates the latency between these Hubs.
 	// It is specified in nanoseconds.
 	Latency time.Duration
+
+	// Jitter designates the variation of the latency between these Hubs.
+	// It is specified in nanoseconds.
+	Jitter time.Duration `json:",omitempty"`
+
+	// Loss designates the packet loss between these Hubs.
+	// It is specified in percent (0-100).
+	Loss float32 `json:",omitempty"`
 }
 
 // Copy returns a deep copy of the Status.
@@ -70,6 +85,8 @@ func (s *Status) Copy() (*Status, error) {
 }
 
 // SelectSignet selects the public key to use for initiating connections to that Hub.
+// Keys of the post-quantum hybrid scheme are preferred, if the Hub provides one.
+// Use WireSuiteForScheme to get the wire suite for the selected key.
 func (h *Hub) SelectSignet() *jess.Signet {
 	h.Lock()
 	defer h.Unlock()
@@ -79,15 +96,19 @@ func (h *Hub) SelectSignet() *jess.Signet {
 		return nil
 	}
 
-	// TODO: select key based on preferred alg?
+	// Select a valid key of the most preferred supported scheme.
 	now := time.Now().Unix()
-	for id, key := range h.Status.Keys {
-		if now < key.Expires {
-			return &jess.Signet{
-				ID:     id,
-				Scheme: key.Scheme,
-				Key:    key.Key,
-				Public: true,
+	for _, entry := range exchKeySchemeWireSuites {
+		for _, keys := range []map[string]*Key{h.Status.HybridKeys, h.Status.Keys} {
+			for id, key := range keys {
+				if key.Scheme == entry.scheme && now < key.Expires {
+					return &jess.Signet{
+						ID:     id,
+						Scheme: key.Scheme,
+						Key:    key.Key,
+						Public: true,
+					}
+				}
 			}
 		}
 	}
@@ -106,6 +127,9 @@ func (h *Hub) GetSignet(id string, recipient bool) (*jess.Signet, error) {
 	}
 	// check if ID exists
 	key, ok := h.Status.Keys[id]
+	if !ok {
+		key, ok = h.Status.HybridKeys[id]
+	}
 	if !ok {
 		return nil, jess.ErrSignetNotFound
 	}
@@ -190,6 +214,10 @@ func (l *Lane) Equal(other *Lane) bool {
 		return false
 	case l.Latency != other.Latency:
 		return false
+	case l.Jitter != other.Jitter:
+		return false
+	case l.Loss != other.Loss:
+		return false
 	}
 	return true
 }
@@ -211,6 +239,21 @@ func (s *Status) validateFormatting() (err error) {
 			return err
 		}
 	}
+	if len(s.HybridKeys) > 255 {
+		return fmt.Errorf("field HybridKeys with array/slice length of %d exceeds max length of %d", len(s.HybridKeys), 255)
+	}
+	for keyID, key := range s.HybridKeys {
+		if err = checkStringFormat("HybridKeys#ID", keyID, 255); err != nil {
+			return err
+		}
+		if err = checkStringFormat("HybridKeys.Scheme", key.Scheme, 255); err != nil {
+			return err
+		}
+		// Post-quantum hybrid keys are larger than 1024 bytes.
+		if err = checkByteSliceFormat("HybridKeys.Key", key.Key, 2048); err != nil {
+			return err
+		}
+	}
 
 	// connections
 	if len(s.Lanes) > 255 {
@@ -220,6 +263,9 @@ func (s *Status) validateFormatting() (err error) {
 		if err = checkStringFormat("Lanes.ID", lanes.ID, 255); err != nil {
 			return err
 		}
+		if lanes.Loss < 0 || lanes.Loss > 100 {
+			return fmt.Errorf("field Lanes.Loss with value of %f is out of range 0-100", lanes.Loss)
+		}
 	}
 
 	// Flags
@@ -231,7 +277,7 @@ func (s *Status) validateFormatting() (err error) {
 }
 
 func (l *Lane) String() string {
-	return fmt.Sprintf("<%s cap=%d lat=%d>", l.ID, l.Capacity, l.Latency)
+	return fmt.Sprintf("<%s cap=%d lat=%d jit=%d loss=%.1f>", l.ID, l.Capacity, l.Latency, l.Jitter, l.Loss)
 }
 
 // LanesEqual returns whether the given []*Lane are equal.
@@ -117,7 +117,7 @@ func registerAPIEndpoints() error {
 	}
 
 	if err := api.RegisterEndpoint(api.Endpoint{
-		Path:        `spn/map/{map:[A-Za-z0-9]{1,255}}/graph{format:\.[a-z]{2,4}}`,
+		Path:        `spn/map/{map:[A-Za-z0-9]{1,255}}/graph{format:\.[a-z]{2,7}}`,
 		Read:        api.PermitUser,
 		BelongsTo:   module,
 		HandlerFunc: handleMapGraphRequest,
@@ -134,13 +134,24 @@ func registerAPIEndpoints() error {
 				Method:      http.MethodGet,
 				Field:       "format (in path)",
 				Value:       "file type",
-				Description: "Specify the format you want to get the map in. Available values: `dot`, `html`. Please note that the html format is only available in development mode.",
+				Description: "Specify the format you want to get the map in. Available values: `dot`, `html`, `graphml`, `cyjs` (Cytoscape JSON), `geojson`. Please note that the html format is only available in development mode.",
 			},
 		},
 	}); err != nil {
 		return err
 	}
 
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:        `spn/map/{map:[A-Za-z0-9]{1,255}}/snapshot`,
+		Read:        api.PermitUser,
+		BelongsTo:   module,
+		StructFunc:  handleMapSnapshotRequest,
+		Name:        "Get SPN map snapshot",
+		Description: "Returns a snapshot of the map with all pins, lanes, states, measurements and intel, which can be loaded into another map for offline analysis.",
+	}); err != nil {
+		return err
+	}
+
 	// Register API endpoints from other files.
 	if err := registerRouteAPIEndpoints(); err != nil {
 		return err
@@ -166,6 +177,16 @@ func handleMapPinsRequest(ar *api.Request) (i interface{}, err error) {
 	return exportedPins, nil
 }
 
+func handleMapSnapshotRequest(ar *api.Request) (i interface{}, err error) {
+	// Get map.
+	m, ok := getMapForAPI(ar.URLVars["map"])
+	if !ok {
+		return nil, errors.New("map not found")
+	}
+
+	return m.Snapshot(), nil
+}
+
 func handleIntelUpdateRequest(ar *api.Request) (msg string, err error) {
 	// Get map.
 	m, ok := getMapForAPI(ar.URLVars["map"])
@@ -438,6 +459,9 @@ func handleMapGraphRequest(w http.ResponseWriter, hr *http.Request) {
 			http.Error(w, "Graph html formatting (js rendering) is only available in dev mode.", http.StatusPreconditionFailed)
 			return
 		}
+	case ".graphml", ".cyjs", ".geojson":
+		handleMapGraphExportRequest(w, r, m, r.URLVars["format"][1:])
+		return
 	default:
 		http.Error(w, "Unsupported format.", http.StatusBadRequest)
 		return
@@ -508,20 +532,43 @@ d3.select("#graph").graphviz(useWorker=false).engine("neato").renderDot(%s%s%s);
 	}
 }
 
-func graphNodeLabel(pin *Pin) (s string) {
-	var comment string
-	switch {
-	case pin.State == StateNone:
-		comment = "dead"
-	case pin.State.Has(StateIsHomeHub):
-		comment = "Home"
-	case pin.State.HasAnyOf(StateSummaryDisregard):
-		comment = "disregarded"
-	case !pin.State.Has(StateSummaryRegard):
-		comment = "not regarded"
-	case pin.State.Has(StateTrusted):
-		comment = "trusted"
+func handleMapGraphExportRequest(w http.ResponseWriter, r *api.Request, m *Map, format string) {
+	// Export graph in requested format.
+	var mimeType string
+	var responseData []byte
+	var err error
+	graph := m.exportGraph()
+	switch format {
+	case "graphml":
+		mimeType = "application/graphml+xml"
+		responseData, err = graph.GraphML(m.Name)
+	case "cyjs":
+		mimeType = "application/json"
+		responseData, err = graph.Cytoscape()
+	case "geojson":
+		mimeType = "application/geo+json"
+		responseData, err = graph.GeoJSON()
+	default:
+		http.Error(w, "Unsupported format.", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to export graph: %s", err), http.StatusInternalServerError)
+		return
 	}
+
+	// Write response.
+	w.Header().Set("Content-Type", mimeType+"; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(responseData)))
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(responseData)
+	if err != nil {
+		log.Tracer(r.Context()).Warningf("api: failed to write response: %s", err)
+	}
+}
+
+func graphNodeLabel(pin *Pin) (s string) {
+	comment := graphNodeStatus(pin)
 	if comment != "" {
 		comment = fmt.Sprintf("\n(%s)", comment)
 	}
@@ -537,6 +584,23 @@ func graphNodeLabel(pin *Pin) (s string) {
 	)
 }
 
+func graphNodeStatus(pin *Pin) string {
+	switch {
+	case pin.State == StateNone:
+		return "dead"
+	case pin.State.Has(StateIsHomeHub):
+		return "Home"
+	case pin.State.HasAnyOf(StateSummaryDisregard):
+		return "disregarded"
+	case !pin.State.Has(StateSummaryRegard):
+		return "not regarded"
+	case pin.State.Has(StateTrusted):
+		return "trusted"
+	default:
+		return ""
+	}
+}
+
 func graphNodeTooltip(pin *Pin) string {
 	// Gather IP info.
 	var v4Info, v6Info string
@@ -652,21 +716,32 @@ func graphEdgeColor(from, to *Pin, lane *Lane) string {
 		return graphColorError
 	}
 
+	// Check for active edge.
+	if graphEdgeIsActive(from, to) {
+		return graphColorHomeAndConnected
+	}
+
+	// Return default color if edge is not active.
+	return graphColorDefaultEdge
+}
+
+// graphEdgeIsActive returns whether the lane between the given Pins is used
+// by an active connection.
+func graphEdgeIsActive(from, to *Pin) bool {
 	// Check for active edge forward.
 	if to.HasActiveTerminal() && len(to.Connection.Route.Path) >= 2 {
 		secondLastHopIndex := len(to.Connection.Route.Path) - 2
 		if to.Connection.Route.Path[secondLastHopIndex].HubID == from.Hub.ID {
-			return graphColorHomeAndConnected
+			return true
 		}
 	}
 	// Check for active edge backward.
 	if from.HasActiveTerminal() && len(from.Connection.Route.Path) >= 2 {
 		secondLastHopIndex := len(from.Connection.Route.Path) - 2
 		if from.Connection.Route.Path[secondLastHopIndex].HubID == to.Hub.ID {
-			return graphColorHomeAndConnected
+			return true
 		}
 	}
 
-	// Return default color if edge is not active.
-	return graphColorDefaultEdge
+	return false
 }
@@ -0,0 +1,369 @@
+package navigator
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+)
+
+// graphExportNode holds the data of a Pin for graph exports.
+type graphExportNode struct {
+	ID            string
+	Name          string
+	Status        string
+	States        string
+	VerifiedOwner string
+	Country       string
+	Load          int
+	Cost          float32
+	HopDistance   int
+	Active        bool
+
+	// Coordinates are only valid if HasLocation is true.
+	HasLocation bool
+	Latitude    float64
+	Longitude   float64
+}
+
+// graphExportEdge holds the data of a Lane for graph exports.
+type graphExportEdge struct {
+	Source string
+	Target string
+	// Latency is in milliseconds.
+	Latency float64
+	// Capacity is in Mbit/s.
+	Capacity float64
+	Cost     float32
+	Active   bool
+}
+
+// graphExport holds the nodes and edges of a map for graph exports.
+type graphExport struct {
+	nodes []*graphExportNode
+	edges []*graphExportEdge
+}
+
+// exportGraph collects the nodes and edges of the map for graph exports.
+// Lanes are only exported once, even though they are present on both Pins.
+func (m *Map) exportGraph() *graphExport {
+	pins := m.sortedPins(true)
+	export := &graphExport{
+		nodes: make([]*graphExportNode, 0, len(pins)),
+	}
+
+	exportedPins := make(map[string]struct{}, len(pins))
+	for _, pin := range pins {
+		node := &graphExportNode{
+			ID:            pin.Hub.ID,
+			Name:          pin.Hub.Name(),
+			Status:        graphNodeStatus(pin),
+			States:        pin.State.String(),
+			VerifiedOwner: pin.VerifiedOwner,
+			Country:       getPinCountry(pin),
+			Load:          pin.Hub.Status.Load,
+			Cost:          pin.Cost,
+			HopDistance:   pin.HopDistance,
+			Active:        pin.HasActiveTerminal(),
+		}
+		switch {
+		case pin.LocationV4 != nil:
+			node.HasLocation = true
+			node.Latitude = pin.LocationV4.Coordinates.Latitude
+			node.Longitude = pin.LocationV4.Coordinates.Longitude
+		case pin.LocationV6 != nil:
+			node.HasLocation = true
+			node.Latitude = pin.LocationV6.Coordinates.Latitude
+			node.Longitude = pin.LocationV6.Coordinates.Longitude
+		}
+		export.nodes = append(export.nodes, node)
+		exportedPins[pin.Hub.ID] = struct{}{}
+
+		// Add lanes to already exported Pins.
+		// Like in the dot format, lanes of dead Pins are not exported.
+		if pin.State == StateNone {
+			continue
+		}
+		for _, lane := range pin.ConnectedTo {
+			if _, ok := exportedPins[lane.Pin.Hub.ID]; !ok || lane.Pin.State == StateNone {
+				continue
+			}
+			export.edges = append(export.edges, &graphExportEdge{
+				Source:   pin.Hub.ID,
+				Target:   lane.Pin.Hub.ID,
+				Latency:  float64(lane.Latency.Microseconds()) / 1000,
+				Capacity: float64(lane.Capacity) / 1000000,
+				Cost:     lane.Cost,
+				Active:   graphEdgeIsActive(pin, lane.Pin),
+			})
+		}
+	}
+
+	return export
+}
+
+// GraphML format.
+// See http://graphml.graphdrawing.org/
+
+type graphML struct {
+	XMLName xml.Name      `xml:"graphml"`
+	XMLNS   string        `xml:"xmlns,attr"`
+	Keys    []*graphMLKey `xml:"key"`
+	Graph   *graphMLGraph `xml:"graph"`
+}
+
+type graphMLKey struct {
+	ID       string `xml:"id,attr"`
+	For      string `xml:"for,attr"`
+	AttrName string `xml:"attr.name,attr"`
+	AttrType string `xml:"attr.type,attr"`
+}
+
+type graphMLGraph struct {
+	ID          string         `xml:"id,attr"`
+	EdgeDefault string         `xml:"edgedefault,attr"`
+	Nodes       []*graphMLNode `xml:"node"`
+	Edges       []*graphMLEdge `xml:"edge"`
+}
+
+type graphMLNode struct {
+	ID   string         `xml:"id,attr"`
+	Data []*graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	ID     string         `xml:"id,attr"`
+	Source string         `xml:"source,attr"`
+	Target string         `xml:"target,attr"`
+	Data   []*graphMLData `xml:"data"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+var graphMLKeys = []*graphMLKey{
+	{ID: "label", For: "node", AttrName: "label", AttrType: "string"},
+	{ID: "status", For: "node", AttrName: "status", AttrType: "string"},
+	{ID: "states", For: "node", AttrName: "states", AttrType: "string"},
+	{ID: "owner", For: "node", AttrName: "verified_owner", AttrType: "string"},
+	{ID: "country", For: "node", AttrName: "country", AttrType: "string"},
+	{ID: "load", For: "node", AttrName: "load", AttrType: "int"},
+	{ID: "hubcost", For: "node", AttrName: "cost", AttrType: "float"},
+	{ID: "hops", For: "node", AttrName: "hop_distance", AttrType: "int"},
+	{ID: "nodeactive", For: "node", AttrName: "active", AttrType: "boolean"},
+	{ID: "latitude", For: "node", AttrName: "latitude", AttrType: "double"},
+	{ID: "longitude", For: "node", AttrName: "longitude", AttrType: "double"},
+	{ID: "latency", For: "edge", AttrName: "latency_ms", AttrType: "double"},
+	{ID: "capacity", For: "edge", AttrName: "capacity_mbits", AttrType: "double"},
+	{ID: "lanecost", For: "edge", AttrName: "cost", AttrType: "float"},
+	{ID: "edgeactive", For: "edge", AttrName: "active", AttrType: "boolean"},
+}
+
+// GraphML returns the graph in the GraphML format.
+func (ge *graphExport) GraphML(name string) ([]byte, error) {
+	graph := &graphMLGraph{
+		ID:          name,
+		EdgeDefault: "undirected",
+		Nodes:       make([]*graphMLNode, 0, len(ge.nodes)),
+		Edges:       make([]*graphMLEdge, 0, len(ge.edges)),
+	}
+
+	for _, node := range ge.nodes {
+		data := []*graphMLData{
+			{Key: "label", Value: node.Name},
+			{Key: "status", Value: node.Status},
+			{Key: "states", Value: node.States},
+			{Key: "owner", Value: node.VerifiedOwner},
+			{Key: "country", Value: node.Country},
+			{Key: "load", Value: fmt.Sprintf("%d", node.Load)},
+			{Key: "hubcost", Value: fmt.Sprintf("%f", node.Cost)},
+			{Key: "hops", Value: fmt.Sprintf("%d", node.HopDistance)},
+			{Key: "nodeactive", Value: fmt.Sprintf("%t", node.Active)},
+		}
+		if node.HasLocation {
+			data = append(data,
+				&graphMLData{Key: "latitude", Value: fmt.Sprintf("%f", node.Latitude)},
+				&graphMLData{Key: "longitude", Value: fmt.Sprintf("%f", node.Longitude)},
+			)
+		}
+		graph.Nodes = append(graph.Nodes, &graphMLNode{
+			ID:   node.ID,
+			Data: data,
+		})
+	}
+
+	for i, edge := range ge.edges {
+		graph.Edges = append(graph.Edges, &graphMLEdge{
+			ID:     fmt.Sprintf("e%d", i),
+			Source: edge.Source,
+			Target: edge.Target,
+			Data: []*graphMLData{
+				{Key: "latency", Value: fmt.Sprintf("%f", edge.Latency)},
+				{Key: "capacity", Value: fmt.Sprintf("%f", edge.Capacity)},
+				{Key: "lanecost", Value: fmt.Sprintf("%f", edge.Cost)},
+				{Key: "edgeactive", Value: fmt.Sprintf("%t", edge.Active)},
+			},
+		})
+	}
+
+	data, err := xml.MarshalIndent(&graphML{
+		XMLNS: "http://graphml.graphdrawing.org/xmlns",
+		Keys:  graphMLKeys,
+		Graph: graph,
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+// Cytoscape JSON format.
+// See https://js.cytoscape.org/#notation/elements-json
+
+type cytoscapeGraph struct {
+	Elements cytoscapeElements `json:"elements"`
+}
+
+type cytoscapeElements struct {
+	Nodes []*cytoscapeElement `json:"nodes"`
+	Edges []*cytoscapeElement `json:"edges"`
+}
+
+type cytoscapeElement struct {
+	Data map[string]interface{} `json:"data"`
+}
+
+// Cytoscape returns the graph in the Cytoscape JSON format.
+func (ge *graphExport) Cytoscape() ([]byte, error) {
+	graph := &cytoscapeGraph{
+		Elements: cytoscapeElements{
+			Nodes: make([]*cytoscapeElement, 0, len(ge.nodes)),
+			Edges: make([]*cytoscapeElement, 0, len(ge.edges)),
+		},
+	}
+
+	for _, node := range ge.nodes {
+		data := node.properties()
+		data["id"] = node.ID
+		graph.Elements.Nodes = append(graph.Elements.Nodes, &cytoscapeElement{Data: data})
+	}
+	for i, edge := range ge.edges {
+		data := edge.properties()
+		data["id"] = fmt.Sprintf("e%d", i)
+		data["source"] = edge.Source
+		data["target"] = edge.Target
+		graph.Elements.Edges = append(graph.Elements.Edges, &cytoscapeElement{Data: data})
+	}
+
+	return json.Marshal(graph)
+}
+
+// GeoJSON format.
+// See https://datatracker.ietf.org/doc/html/rfc7946
+
+type geoJSONFeatureCollection struct {
+	Type     string            `json:"type"`
+	Features []*geoJSONFeature `json:"features"`
+}
+
+type geoJSONFeature struct {
+	Type       string                 `json:"type"`
+	ID         string                 `json:"id,omitempty"`
+	Geometry   *geoJSONGeometry       `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type geoJSONGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+// GeoJSON returns the graph in the GeoJSON format.
+// Hubs are exported as points and Lanes as lines between them.
+// Hubs without location and their Lanes are not included.
+func (ge *graphExport) GeoJSON() ([]byte, error) {
+	collection := &geoJSONFeatureCollection{
+		Type:     "FeatureCollection",
+		Features: make([]*geoJSONFeature, 0, len(ge.nodes)+len(ge.edges)),
+	}
+
+	// Add Hubs as points.
+	positions := make(map[string][]float64, len(ge.nodes))
+	for _, node := range ge.nodes {
+		if !node.HasLocation {
+			continue
+		}
+
+		// GeoJSON positions are longitude first.
+		position := []float64{node.Longitude, node.Latitude}
+		positions[node.ID] = position
+
+		properties := node.properties()
+		properties["type"] = "hub"
+		collection.Features = append(collection.Features, &geoJSONFeature{
+			Type: "Feature",
+			ID:   node.ID,
+			Geometry: &geoJSONGeometry{
+				Type:        "Point",
+				Coordinates: position,
+			},
+			Properties: properties,
+		})
+	}
+
+	// Add Lanes as lines.
+	for _, edge := range ge.edges {
+		sourcePosition, ok := positions[edge.Source]
+		if !ok {
+			continue
+		}
+		targetPosition, ok := positions[edge.Target]
+		if !ok {
+			continue
+		}
+
+		properties := edge.properties()
+		properties["type"] = "lane"
+		properties["source"] = edge.Source
+		properties["target"] = edge.Target
+		collection.Features = append(collection.Features, &geoJSONFeature{
+			Type: "Feature",
+			Geometry: &geoJSONGeometry{
+				Type:        "LineString",
+				Coordinates: [][]float64{sourcePosition, targetPosition},
+			},
+			Properties: properties,
+		})
+	}
+
+	return json.Marshal(collection)
+}
+
+func (node *graphExportNode) properties() map[string]interface{} {
+	properties := map[string]interface{}{
+		"name":          node.Name,
+		"status":        node.Status,
+		"states":        node.States,
+		"verifiedOwner": node.VerifiedOwner,
+		"country":       node.Country,
+		"load":          node.Load,
+		"cost":          node.Cost,
+		"hopDistance":   node.HopDistance,
+		"active":        node.Active,
+	}
+	if node.HasLocation {
+		properties["latitude"] = node.Latitude
+		properties["longitude"] = node.Longitude
+	}
+	return properties
+}
+
+func (edge *graphExportEdge) properties() map[string]interface{} {
+	return map[string]interface{}{
+		"latency":  edge.Latency,
+		"capacity": edge.Capacity,
+		"cost":     edge.Cost,
+		"active":   edge.Active,
+	}
+}
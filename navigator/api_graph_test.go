@@ -0,0 +1,80 @@
+package navigator
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"testing"
+)
+
+func TestGraphExport(t *testing.T) {
+	t.Parallel()
+
+	// Create map and lock faking in order to guarantee reproducability of faked data.
+	m := getOptimizedDefaultTestMap(t)
+	fakeLock.Lock()
+	defer fakeLock.Unlock()
+
+	graph := m.exportGraph()
+	if len(graph.nodes) != len(m.all) {
+		t.Fatalf("graph has %d nodes, expected %d", len(graph.nodes), len(m.all))
+	}
+	if len(graph.edges) == 0 {
+		t.Fatal("graph has no edges")
+	}
+
+	// Check that every lane is only exported once.
+	seenEdges := make(map[string]struct{}, len(graph.edges))
+	for _, edge := range graph.edges {
+		if _, ok := seenEdges[edge.Target+edge.Source]; ok {
+			t.Errorf("lane between %s and %s was exported twice", edge.Source, edge.Target)
+		}
+		seenEdges[edge.Source+edge.Target] = struct{}{}
+	}
+
+	// Check GraphML.
+	data, err := graph.GraphML(m.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsedGraphML := &graphML{}
+	if err := xml.Unmarshal(data, parsedGraphML); err != nil {
+		t.Fatalf("failed to parse GraphML: %s", err)
+	}
+	if len(parsedGraphML.Graph.Nodes) != len(graph.nodes) || len(parsedGraphML.Graph.Edges) != len(graph.edges) {
+		t.Error("GraphML export is incomplete")
+	}
+
+	// Check Cytoscape JSON.
+	data, err = graph.Cytoscape()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsedCytoscape := &cytoscapeGraph{}
+	if err := json.Unmarshal(data, parsedCytoscape); err != nil {
+		t.Fatalf("failed to parse Cytoscape JSON: %s", err)
+	}
+	if len(parsedCytoscape.Elements.Nodes) != len(graph.nodes) || len(parsedCytoscape.Elements.Edges) != len(graph.edges) {
+		t.Error("Cytoscape JSON export is incomplete")
+	}
+
+	// Check GeoJSON.
+	data, err = graph.GeoJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsedGeoJSON := &geoJSONFeatureCollection{}
+	if err := json.Unmarshal(data, parsedGeoJSON); err != nil {
+		t.Fatalf("failed to parse GeoJSON: %s", err)
+	}
+	for _, feature := range parsedGeoJSON.Features {
+		switch feature.Geometry.Type {
+		case "Point":
+			if _, ok := feature.Geometry.Coordinates.([]interface{}); !ok {
+				t.Errorf("hub %s has invalid coordinates", feature.ID)
+			}
+		case "LineString":
+		default:
+			t.Errorf("unexpected geometry type %s", feature.Geometry.Type)
+		}
+	}
+}
@@ -28,54 +28,80 @@ func registerRouteAPIEndpoints() error {
 		return err
 	}
 
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:        `spn/map/{map:[A-Za-z0-9]{1,255}}/route/to/{destination:[a-z0-9_\.:-]{1,255}}/json`,
+		Read:        api.PermitUser,
+		BelongsTo:   module,
+		StructFunc:  handleRouteCalculationJSONRequest,
+		Name:        "Calculate Route through SPN (JSON)",
+		Description: "Returns a structured representation of the routing process, including all evaluated routes.",
+	}); err != nil {
+		return err
+	}
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:        `spn/routing-profiles`,
+		Read:        api.PermitUser,
+		BelongsTo:   module,
+		StructFunc:  handleRoutingProfilesRequest,
+		Name:        "Get SPN routing profiles",
+		Description: "Returns all available routing profiles, including custom ones.",
+	}); err != nil {
+		return err
+	}
+
 	return nil
 }
 
-func handleRouteCalculationRequest(ar *api.Request) (msg string, err error) { //nolint:maintidx
-	// Get map.
-	m, ok := getMapForAPI(ar.URLVars["map"])
-	if !ok {
-		return "", errors.New("map not found")
-	}
+// routeCalculationDestination holds the parsed destination of a route
+// calculation request.
+type routeCalculationDestination struct {
+	locationV4 *geoip.Location
+	locationV6 *geoip.Location
+	dstIP      net.IP
+	matchFor   HubType
+	introText  string
+}
 
-	var introText string
+// parseRouteCalculationDestination parses the destination of a route
+// calculation request.
+func parseRouteCalculationDestination(ar *api.Request) (dst *routeCalculationDestination, err error) {
+	dst = &routeCalculationDestination{
+		matchFor: DestinationHub,
+	}
 
 	// Parse destination.
-	var locationV4, locationV6 *geoip.Location
-	var dstIP net.IP
 	destination := ar.URLVars["destination"]
-	matchFor := DestinationHub
-	opts := m.defaultOptions()
 	switch {
 	case destination == "":
 		// Destination is required.
-		return "", errors.New("no destination provided")
+		return nil, errors.New("no destination provided")
 
 	case destination == "home":
 		// Simulate finding home hub.
 		locations, ok := netenv.GetInternetLocation()
 		if !ok || len(locations.All) == 0 {
-			return "", errors.New("failed to locate own device for finding home hub")
+			return nil, errors.New("failed to locate own device for finding home hub")
 		}
-		introText = fmt.Sprintf("looking for home hub near %s and %s", locations.BestV4(), locations.BestV6())
-		locationV4 = locations.BestV4().LocationOrNil()
-		locationV6 = locations.BestV6().LocationOrNil()
-		matchFor = HomeHub
+		dst.introText = fmt.Sprintf("looking for home hub near %s and %s", locations.BestV4(), locations.BestV6())
+		dst.locationV4 = locations.BestV4().LocationOrNil()
+		dst.locationV6 = locations.BestV6().LocationOrNil()
+		dst.matchFor = HomeHub
 
 	case net.ParseIP(destination) != nil:
-		dstIP = net.ParseIP(destination)
-		if ip4 := dstIP.To4(); ip4 != nil {
-			locationV4, err = geoip.GetLocation(dstIP)
+		dst.dstIP = net.ParseIP(destination)
+		if ip4 := dst.dstIP.To4(); ip4 != nil {
+			dst.locationV4, err = geoip.GetLocation(dst.dstIP)
 			if err != nil {
-				return "", fmt.Errorf("failed to get geoip location for %s: %w", dstIP, err)
+				return nil, fmt.Errorf("failed to get geoip location for %s: %w", dst.dstIP, err)
 			}
-			introText = fmt.Sprintf("looking for route to %s at %s", dstIP, formatLocation(locationV4))
+			dst.introText = fmt.Sprintf("looking for route to %s at %s", dst.dstIP, formatLocation(dst.locationV4))
 		} else {
-			locationV6, err = geoip.GetLocation(dstIP)
+			dst.locationV6, err = geoip.GetLocation(dst.dstIP)
 			if err != nil {
-				return "", fmt.Errorf("failed to get geoip location for %s: %w", dstIP, err)
+				return nil, fmt.Errorf("failed to get geoip location for %s: %w", dst.dstIP, err)
 			}
-			introText = fmt.Sprintf("looking for route to %s at %s", dstIP, formatLocation(locationV6))
+			dst.introText = fmt.Sprintf("looking for route to %s at %s", dst.dstIP, formatLocation(dst.locationV6))
 		}
 
 	case netutils.IsValidFqdn(destination):
@@ -85,7 +111,7 @@ func handleRouteCalculationRequest(ar *api.Request) (msg string, err error) { //
 		// Resolve name to IPs.
 		ips, err := net.DefaultResolver.LookupIP(ar.Context(), "ip", destination)
 		if err != nil {
-			return "", fmt.Errorf("failed to lookup IP address of %s: %w", destination, err)
+			return nil, fmt.Errorf("failed to lookup IP address of %s: %w", destination, err)
 		}
 
 		// Shuffle IPs.
@@ -97,28 +123,49 @@ func handleRouteCalculationRequest(ar *api.Request) (msg string, err error) { //
 		}
 
 		// Get IP location.
-		dstIP = ips[0]
-		if ip4 := dstIP.To4(); ip4 != nil {
-			locationV4, err = geoip.GetLocation(dstIP)
+		dst.dstIP = ips[0]
+		if ip4 := dst.dstIP.To4(); ip4 != nil {
+			dst.locationV4, err = geoip.GetLocation(dst.dstIP)
 			if err != nil {
-				return "", fmt.Errorf("failed to get geoip location for %s: %w", dstIP, err)
+				return nil, fmt.Errorf("failed to get geoip location for %s: %w", dst.dstIP, err)
 			}
-			introText = fmt.Sprintf("looking for route to %s at %s\n(ignoring %d additional IPs returned by DNS)", dstIP, formatLocation(locationV4), len(ips)-1)
+			dst.introText = fmt.Sprintf("looking for route to %s at %s\n(ignoring %d additional IPs returned by DNS)", dst.dstIP, formatLocation(dst.locationV4), len(ips)-1)
 		} else {
-			locationV6, err = geoip.GetLocation(dstIP)
+			dst.locationV6, err = geoip.GetLocation(dst.dstIP)
 			if err != nil {
-				return "", fmt.Errorf("failed to get geoip location for %s: %w", dstIP, err)
+				return nil, fmt.Errorf("failed to get geoip location for %s: %w", dst.dstIP, err)
 			}
-			introText = fmt.Sprintf("looking for route to %s at %s\n(ignoring %d additional IPs returned by DNS)", dstIP, formatLocation(locationV6), len(ips)-1)
+			dst.introText = fmt.Sprintf("looking for route to %s at %s\n(ignoring %d additional IPs returned by DNS)", dst.dstIP, formatLocation(dst.locationV6), len(ips)-1)
 		}
 
 	default:
-		return "", errors.New("invalid destination provided")
+		return nil, errors.New("invalid destination provided")
 	}
 
+	return dst, nil
+}
+
+func handleRoutingProfilesRequest(ar *api.Request) (i interface{}, err error) {
+	return RoutingProfiles(), nil
+}
+
+func handleRouteCalculationRequest(ar *api.Request) (msg string, err error) { //nolint:maintidx
+	// Get map.
+	m, ok := getMapForAPI(ar.URLVars["map"])
+	if !ok {
+		return "", errors.New("map not found")
+	}
+
+	// Parse destination.
+	dst, err := parseRouteCalculationDestination(ar)
+	if err != nil {
+		return "", err
+	}
+	opts := m.defaultOptions()
+
 	// Start formatting output.
 	lines := []string{
-		"Routing simulation: " + introText,
+		"Routing simulation: " + dst.introText,
 		"Please not that this routing simulation does match the behavior of regular routing to 100%.",
 		"",
 	}
@@ -128,8 +175,12 @@ func handleRouteCalculationRequest(ar *api.Request) (msg string, err error) { //
 
 	lines = append(lines, "Routing Options:")
 	lines = append(lines, "Algorithm: "+opts.RoutingProfile)
+	lines = append(lines, "Cost Model: "+m.CostModel().ID())
 	lines = append(lines, fmt.Sprintf("Require Verified Owners: %s", opts.RequireVerifiedOwners))
 	lines = append(lines, fmt.Sprintf("Require Trusted Exit: %v", opts.RequireTrustedDestinationHubs))
+	lines = append(lines, fmt.Sprintf("Require Distinct Countries: %v", opts.RequireDistinctCountries))
+	lines = append(lines, fmt.Sprintf("Require Distinct ASNs: %v", opts.RequireDistinctASNs))
+	lines = append(lines, fmt.Sprintf("Require Distinct Owners: %v", opts.RequireDistinctOwners))
 	lines = append(lines, "Hub Policy: ")
 	for _, ep := range opts.HubPolicies {
 		lines = append(lines, ep.String())
@@ -148,7 +199,7 @@ func handleRouteCalculationRequest(ar *api.Request) (msg string, err error) { //
 	}
 
 	// Find nearest hubs.
-	nbPins, err := m.findNearestPins(locationV4, locationV6, opts, matchFor, true)
+	nbPins, err := m.findNearestPins(dst.locationV4, dst.locationV6, opts, dst.matchFor, true)
 	if err != nil {
 		return "", fmt.Errorf("failed to search for nearby pins: %w", err)
 	}
@@ -205,7 +256,7 @@ func handleRouteCalculationRequest(ar *api.Request) (msg string, err error) { //
 	// ============
 
 	// Unless we looked for a home node.
-	if destination == "home" {
+	if dst.matchFor == HomeHub {
 		return strings.Join(lines, "\n"), nil
 	}
 
@@ -224,7 +275,7 @@ func handleRouteCalculationRequest(ar *api.Request) (msg string, err error) { //
 		fmt.Fprintf(tabWriter,
 			"%.0f\t%s\n",
 			route.TotalCost,
-			formatRoute(route, dstIP),
+			formatRoute(route, dst.dstIP),
 		)
 	}
 	_ = tabWriter.Flush()
@@ -233,6 +284,202 @@ func handleRouteCalculationRequest(ar *api.Request) (msg string, err error) { //
 	return strings.Join(lines, "\n"), nil
 }
 
+// RouteCalculation is a structured representation of the routing process.
+type RouteCalculation struct {
+	Destination   string
+	DestinationIP net.IP `json:",omitempty"`
+	Options       *RouteCalculationOptions
+
+	// Destinations holds all evaluated Destination Hubs or Home Hubs.
+	Destinations []*RouteCalculationDestination
+
+	// Evaluated holds all evaluated routes, including the rejected ones.
+	Evaluated []*RouteCalculationRoute
+	// EvaluatedSkipped holds the amount of evaluated routes that were not
+	// recorded, because too many routes were evaluated.
+	EvaluatedSkipped int `json:",omitempty"`
+
+	// Routes holds the resulting routes, ordered by preference.
+	Routes []*RouteCalculationRoute
+	// Chosen is the route that would be used.
+	Chosen *RouteCalculationRoute
+
+	// Error holds the error that occurred while finding routes, if any.
+	Error string `json:",omitempty"`
+}
+
+// RouteCalculationOptions holds the options used for the route calculation.
+type RouteCalculationOptions struct {
+	RoutingProfile                string
+	RequireVerifiedOwners         []string
+	RequireTrustedDestinationHubs bool
+	RequireDistinctCountries      bool
+	RequireDistinctASNs           bool
+	RequireDistinctOwners         bool
+	HubPolicies                   []string
+}
+
+// RouteCalculationDestination is a Hub that was evaluated as a destination.
+type RouteCalculationDestination struct {
+	HubID    string
+	Name     string
+	Location string
+
+	// Status is one of "considered", "too-expensive" and "disregarded".
+	Status string
+	// Cost is the cost between the Hub and the destination.
+	Cost   float32  `json:",omitempty"`
+	Reason string   `json:",omitempty"`
+	States []string `json:",omitempty"`
+}
+
+// RouteCalculationRoute is a route that was evaluated.
+type RouteCalculationRoute struct {
+	Hops      []*RouteCalculationHop
+	DstCost   float32
+	TotalCost float32
+
+	// Result is one of "found", "non-compliant", "disqualified" and "rejected".
+	// It is not set for the resulting routes.
+	Result string `json:",omitempty"`
+	Reason string `json:",omitempty"`
+}
+
+// RouteCalculationHop is a hop of an evaluated route.
+type RouteCalculationHop struct {
+	HubID string
+	Name  string
+
+	// LaneCost is the cost of the Lane to this Hub.
+	LaneCost float32
+	// HubCost is the cost of the Hub itself.
+	HubCost float32
+	// Cost is the sum of LaneCost and HubCost.
+	Cost float32
+}
+
+func handleRouteCalculationJSONRequest(ar *api.Request) (i interface{}, err error) {
+	// Get map.
+	m, ok := getMapForAPI(ar.URLVars["map"])
+	if !ok {
+		return nil, errors.New("map not found")
+	}
+
+	// Parse destination.
+	dst, err := parseRouteCalculationDestination(ar)
+	if err != nil {
+		return nil, err
+	}
+	opts := m.defaultOptions()
+
+	// Export options.
+	calc := &RouteCalculation{
+		Destination:   ar.URLVars["destination"],
+		DestinationIP: dst.dstIP,
+		Options: &RouteCalculationOptions{
+			RoutingProfile:                opts.RoutingProfile,
+			RequireVerifiedOwners:         opts.RequireVerifiedOwners,
+			RequireTrustedDestinationHubs: opts.RequireTrustedDestinationHubs,
+			RequireDistinctCountries:      opts.RequireDistinctCountries,
+			RequireDistinctASNs:           opts.RequireDistinctASNs,
+			RequireDistinctOwners:         opts.RequireDistinctOwners,
+		},
+	}
+	for _, ep := range opts.HubPolicies {
+		calc.Options.HubPolicies = append(calc.Options.HubPolicies, ep.String())
+	}
+
+	// Start operating in map.
+	m.RLock()
+	defer m.RUnlock()
+	// Check if map is populated.
+	if m.isEmpty() {
+		return nil, ErrEmptyMap
+	}
+
+	// Find nearest hubs.
+	nbPins, err := m.findNearestPins(dst.locationV4, dst.locationV6, opts, dst.matchFor, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search for nearby pins: %w", err)
+	}
+	for _, nbPin := range nbPins.pins {
+		calc.Destinations = append(calc.Destinations, exportRouteCalculationDestination(nbPin.pin, "considered", nbPin.cost, ""))
+	}
+	for _, nbPin := range nbPins.debug.tooExpensive {
+		calc.Destinations = append(calc.Destinations, exportRouteCalculationDestination(nbPin.pin, "too-expensive", nbPin.cost, ""))
+	}
+	for _, nbPin := range nbPins.debug.disregarded {
+		calc.Destinations = append(calc.Destinations, exportRouteCalculationDestination(nbPin.pin, "disregarded", 0, nbPin.reason))
+	}
+
+	// Unless we looked for a home node.
+	if dst.matchFor == HomeHub {
+		return calc, nil
+	}
+
+	// Find routes.
+	routes, err := m.findRoutes(nbPins, opts)
+	if err != nil {
+		calc.Error = err.Error()
+	}
+	if routes == nil {
+		return calc, nil
+	}
+
+	// Export evaluated routes.
+	for _, evaluated := range routes.debug.evaluated {
+		exported := exportRouteCalculationRoute(evaluated.route)
+		exported.Result = evaluated.result
+		exported.Reason = evaluated.reason
+		calc.Evaluated = append(calc.Evaluated, exported)
+	}
+	calc.EvaluatedSkipped = routes.debug.skipped
+
+	// Export resulting routes.
+	for _, route := range routes.All {
+		calc.Routes = append(calc.Routes, exportRouteCalculationRoute(route))
+	}
+	if len(calc.Routes) > 0 {
+		calc.Chosen = calc.Routes[0]
+	}
+
+	return calc, nil
+}
+
+func exportRouteCalculationDestination(pin *Pin, status string, cost float32, reason string) *RouteCalculationDestination {
+	return &RouteCalculationDestination{
+		HubID:    pin.Hub.ID,
+		Name:     pin.Hub.Name(),
+		Location: formatMultiLocation(pin.LocationV4, pin.LocationV6),
+		Status:   status,
+		Cost:     cost,
+		Reason:   reason,
+		States:   pin.State.Export(),
+	}
+}
+
+func exportRouteCalculationRoute(r *Route) *RouteCalculationRoute {
+	exported := &RouteCalculationRoute{
+		Hops:      make([]*RouteCalculationHop, 0, len(r.Path)),
+		DstCost:   r.DstCost,
+		TotalCost: r.TotalCost,
+	}
+	for i, hop := range r.Path {
+		exportedHop := &RouteCalculationHop{
+			HubID: hop.pin.Hub.ID,
+			Name:  hop.pin.Hub.Name(),
+			Cost:  hop.Cost,
+		}
+		// The first hop is the Home Hub, which is not reached via a Lane.
+		if i > 0 {
+			exportedHop.HubCost = hop.pin.Cost
+			exportedHop.LaneCost = hop.Cost - hop.pin.Cost
+		}
+		exported.Hops = append(exported.Hops, exportedHop)
+	}
+	return exported
+}
+
 func formatLocation(loc *geoip.Location) string {
 	return fmt.Sprintf(
 		"%s (AS%d %s)",
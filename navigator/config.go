@@ -0,0 +1,133 @@
+package navigator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/safing/portbase/config"
+	"github.com/safing/portbase/log"
+)
+
+var (
+	// CfgOptionCustomRoutingProfilesKey is the configuration key for custom routing profiles.
+	CfgOptionCustomRoutingProfilesKey   = "spn/customRoutingProfiles"
+	cfgOptionCustomRoutingProfiles      config.StringArrayOption
+	cfgOptionCustomRoutingProfilesOrder = 149
+
+	// CfgOptionCostModelKey is the configuration key for the cost model.
+	CfgOptionCostModelKey   = "spn/costModel"
+	cfgOptionCostModel      config.StringOption
+	cfgOptionCostModelOrder = 150
+)
+
+func prepConfig() error {
+	err := config.Register(&config.Option{
+		Name: "Custom Routing Algorithms",
+		Key:  CfgOptionCustomRoutingProfilesKey,
+		Description: `Define additional routing algorithms, which can then be selected by their ID in addition to the built-in ones.
+
+Every entry has the format "<id>:<min hops>:<max hops>:<max extra hops>:<max extra cost>[:<name>]", eg. "quad-hop:4:6:3:10000:Paranoid". The Home Node is counted as a hop. The max extra hops and cost limit how much worse than the currently best route other explored routes may be.`,
+		OptType:        config.OptTypeStringArray,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		DefaultValue:   []string{},
+		Annotations: config.Annotations{
+			config.CategoryAnnotation:     "Routing",
+			config.DisplayOrderAnnotation: cfgOptionCustomRoutingProfilesOrder,
+		},
+		ValidationFunc: validateRoutingProfilesConfig,
+	})
+	if err != nil {
+		return err
+	}
+	cfgOptionCustomRoutingProfiles = config.Concurrent.GetAsStringArray(CfgOptionCustomRoutingProfilesKey, []string{})
+
+	err = config.Register(&config.Option{
+		Name: "Routing Cost Model",
+		Key:  CfgOptionCostModelKey,
+		Description: `Select the cost model used to calculate the cost of Hubs and Lanes for finding routes. Leave empty to use the cost model advised by the intel data.
+
+Built-in cost models are "default", "low-latency", "load-balancing" and "realtime".`,
+		OptType:        config.OptTypeString,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		DefaultValue:   "",
+		Annotations: config.Annotations{
+			config.CategoryAnnotation:     "Routing",
+			config.DisplayOrderAnnotation: cfgOptionCostModelOrder,
+		},
+		ValidationFunc: validateCostModelConfig,
+	})
+	if err != nil {
+		return err
+	}
+	cfgOptionCostModel = config.Concurrent.GetAsString(CfgOptionCostModelKey, "")
+
+	err = module.RegisterEventHook(
+		"config",
+		"config change",
+		"update custom routing profiles",
+		func(_ context.Context, _ interface{}) error {
+			updateCustomRoutingProfiles()
+			return nil
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	return module.RegisterEventHook(
+		"config",
+		"config change",
+		"update cost model",
+		func(_ context.Context, _ interface{}) error {
+			if Main != nil {
+				Main.updateCostModel()
+			}
+			return nil
+		},
+	)
+}
+
+// validateRoutingProfilesConfig validates the custom routing profiles config option.
+func validateRoutingProfilesConfig(value interface{}) error {
+	definitions, ok := value.([]string)
+	if !ok {
+		return errors.New("invalid type")
+	}
+
+	_, err := parseRoutingProfiles(definitions)
+	return err
+}
+
+// updateCustomRoutingProfiles applies the custom routing profiles from the config.
+func updateCustomRoutingProfiles() {
+	profiles, err := parseRoutingProfiles(cfgOptionCustomRoutingProfiles())
+	if err == nil {
+		err = SetCustomRoutingProfiles(profiles)
+	}
+	if err != nil {
+		log.Warningf("spn/navigator: failed to apply custom routing profiles: %s", err)
+	}
+}
+
+// validateCostModelConfig validates the cost model config option.
+func validateCostModelConfig(value interface{}) error {
+	id, ok := value.(string)
+	if !ok {
+		return errors.New("invalid type")
+	}
+
+	if id != "" && GetCostModel(id) == nil {
+		return fmt.Errorf("cost model %q does not exist", id)
+	}
+	return nil
+}
+
+// configuredCostModel returns the ID of the cost model set in the config.
+// Returns an empty string if the config is not set or not available.
+func configuredCostModel() string {
+	if cfgOptionCostModel == nil {
+		return ""
+	}
+	return cfgOptionCostModel()
+}
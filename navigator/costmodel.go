@@ -0,0 +1,286 @@
+package navigator
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/spn/hub"
+)
+
+// CostModel calculates the costs used for finding routes and optimizing the
+// network. Lane and Hub costs are summed up along a route, the destination
+// cost is added for the last Hub of the route.
+type CostModel interface {
+	// ID returns the identifier of the cost model, which is used to select it.
+	ID() string
+
+	// Name returns a human readable name of the cost model.
+	Name() string
+
+	// LaneCost returns the cost of using a Lane with the given metrics.
+	LaneCost(lane LaneMetrics) float32
+
+	// HubCost returns the cost of using the given Hub.
+	HubCost(h *hub.Hub) float32
+
+	// DestinationCost returns the cost of a destination Hub to a destination
+	// server based on the given proximity (0-100).
+	DestinationCost(proximity float32) float32
+}
+
+// LaneMetrics holds the measured metrics of a Lane that its cost is calculated
+// from. Metrics that are not available are zero. Metrics may be added in the
+// future without changing the CostModel interface.
+type LaneMetrics struct {
+	// Latency designates the latency of the Lane.
+	Latency time.Duration
+	// Capacity designates the available bandwidth of the Lane in bit/s.
+	Capacity int
+	// Jitter designates the variation of the latency of the Lane.
+	Jitter time.Duration
+	// Loss designates the packet loss of the Lane in percent (0-100).
+	Loss float32
+}
+
+// measuredLaneMetrics returns the Lane metrics from the given measurements.
+func measuredLaneMetrics(m *hub.Measurements) LaneMetrics {
+	latency, _ := m.GetLatency()
+	capacity, _ := m.GetCapacity()
+	return LaneMetrics{
+		Latency:  latency,
+		Capacity: capacity,
+		Jitter:   m.GetJitter(),
+		Loss:     m.GetLoss(),
+	}
+}
+
+// Cost Model IDs.
+const (
+	CostModelDefaultID       = "default"
+	CostModelLowLatencyID    = "low-latency"
+	CostModelLoadBalancingID = "load-balancing"
+	CostModelRealtimeID      = "realtime"
+)
+
+var (
+	costModels = map[string]CostModel{
+		CostModelDefaultID:       DefaultCostModel,
+		CostModelLowLatencyID:    LowLatencyCostModel,
+		CostModelLoadBalancingID: LoadBalancingCostModel,
+		CostModelRealtimeID:      RealtimeCostModel,
+	}
+	costModelsLock sync.RWMutex
+)
+
+// RegisterCostModel registers an additional cost model, which can then be
+// selected by its ID via intel or config.
+func RegisterCostModel(model CostModel) error {
+	if model.ID() == "" {
+		return errors.New("cost model has no ID")
+	}
+
+	costModelsLock.Lock()
+	defer costModelsLock.Unlock()
+
+	if _, ok := costModels[model.ID()]; ok {
+		return fmt.Errorf("cost model %s is already registered", model.ID())
+	}
+	costModels[model.ID()] = model
+	return nil
+}
+
+// GetCostModel returns the cost model with the given ID.
+// Returns nil if no cost model with the ID is registered.
+func GetCostModel(id string) CostModel {
+	costModelsLock.RLock()
+	defer costModelsLock.RUnlock()
+
+	return costModels[id]
+}
+
+// CostModels returns all registered cost models, sorted by ID.
+func CostModels() []CostModel {
+	costModelsLock.RLock()
+	defer costModelsLock.RUnlock()
+
+	models := make([]CostModel, 0, len(costModels))
+	for _, model := range costModels {
+		models = append(models, model)
+	}
+	sort.Slice(models, func(i, j int) bool {
+		return models[i].ID() < models[j].ID()
+	})
+	return models
+}
+
+// Built-in cost models.
+var (
+	// DefaultCostModel balances latency, capacity and Hub load.
+	DefaultCostModel CostModel = &defaultCostModel{}
+
+	// LowLatencyCostModel prefers routes with low latency and puts less weight
+	// on capacity.
+	LowLatencyCostModel CostModel = &lowLatencyCostModel{}
+
+	// LoadBalancingCostModel spreads usage more evenly across Hubs by
+	// gradually increasing the Hub cost with the Hub load.
+	LoadBalancingCostModel CostModel = &loadBalancingCostModel{}
+
+	// RealtimeCostModel is optimized for realtime traffic, such as VoIP, and
+	// strongly avoids Lanes with high jitter or packet loss.
+	RealtimeCostModel CostModel = &realtimeCostModel{}
+)
+
+type defaultCostModel struct{}
+
+func (cm *defaultCostModel) ID() string   { return CostModelDefaultID }
+func (cm *defaultCostModel) Name() string { return "Balanced" }
+
+func (cm *defaultCostModel) LaneCost(lane LaneMetrics) float32 {
+	return CalculateLaneCost(lane.Latency, lane.Capacity) + CalculateLaneQualityCost(lane.Jitter, lane.Loss)
+}
+
+func (cm *defaultCostModel) HubCost(h *hub.Hub) float32 {
+	return CalculateHubCost(h.Status.Load)
+}
+
+func (cm *defaultCostModel) DestinationCost(proximity float32) float32 {
+	return CalculateDestinationCost(proximity)
+}
+
+type lowLatencyCostModel struct {
+	defaultCostModel
+}
+
+func (cm *lowLatencyCostModel) ID() string   { return CostModelLowLatencyID }
+func (cm *lowLatencyCostModel) Name() string { return "Low Latency" }
+
+func (cm *lowLatencyCostModel) LaneCost(lane LaneMetrics) float32 {
+	// Triple the weight of the latency and halve the weight of the capacity.
+	return 3*calculateLatencyCost(lane.Latency) + calculateCapacityCost(lane.Capacity)/2 +
+		CalculateLaneQualityCost(lane.Jitter, lane.Loss)
+}
+
+type loadBalancingCostModel struct {
+	defaultCostModel
+}
+
+func (cm *loadBalancingCostModel) ID() string   { return CostModelLoadBalancingID }
+func (cm *loadBalancingCostModel) Name() string { return "Load Balancing" }
+
+func (cm *loadBalancingCostModel) HubCost(h *hub.Hub) float32 {
+	load := h.Status.Load
+	switch {
+	case load >= 100:
+		return 10000
+	case load <= 0:
+		return 100
+	default:
+		// Increase linearly from 100 to 1000 points.
+		return 100 + float32(load)*9
+	}
+}
+
+type realtimeCostModel struct {
+	defaultCostModel
+}
+
+func (cm *realtimeCostModel) ID() string   { return CostModelRealtimeID }
+func (cm *realtimeCostModel) Name() string { return "Realtime" }
+
+func (cm *realtimeCostModel) LaneCost(lane LaneMetrics) float32 {
+	// Double the weight of the latency, weigh jitter ten times and loss five
+	// times as much and halve the weight of the capacity, as realtime traffic
+	// usually needs little bandwidth.
+	return 2*calculateLatencyCost(lane.Latency) + calculateCapacityCost(lane.Capacity)/2 +
+		10*calculateJitterCost(lane.Jitter) + calculateLossCost(lane.Loss, 500)
+}
+
+// getCostModel returns the cost model of the map.
+// The map must be locked.
+func (m *Map) getCostModel() CostModel {
+	if m.costModel == nil {
+		return DefaultCostModel
+	}
+	return m.costModel
+}
+
+// CostModel returns the cost model currently used by the map.
+func (m *Map) CostModel() CostModel {
+	m.RLock()
+	defer m.RUnlock()
+
+	return m.getCostModel()
+}
+
+// SetCostModel sets the cost model of the map and recalculates all costs.
+func (m *Map) SetCostModel(model CostModel) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.setCostModel(model)
+}
+
+// updateCostModel selects the cost model from the config or the intel data
+// and applies it to the map.
+func (m *Map) updateCostModel() {
+	m.Lock()
+	defer m.Unlock()
+
+	m.selectCostModel()
+	m.PushPinChanges()
+}
+
+// selectCostModel selects the cost model from the config or the intel data
+// and applies it to the map. The config takes precedence over the intel data.
+// The map must be locked.
+func (m *Map) selectCostModel() {
+	id := configuredCostModel()
+	if id == "" && m.intel != nil {
+		id = m.intel.CostModel
+	}
+	if id == "" {
+		id = CostModelDefaultID
+	}
+
+	model := GetCostModel(id)
+	if model == nil {
+		log.Warningf("spn/navigator: cost model %q for map %s does not exist, using default", id, m.Name)
+		model = DefaultCostModel
+	}
+
+	m.setCostModel(model)
+}
+
+// setCostModel sets the cost model of the map and recalculates all costs, if
+// the cost model changed.
+// The map must be locked.
+func (m *Map) setCostModel(model CostModel) {
+	if model.ID() == m.getCostModel().ID() {
+		return
+	}
+	m.costModel = model
+
+	// Recalculate all costs with the new cost model.
+	for _, pin := range m.all {
+		pin.Cost = model.HubCost(pin.Hub)
+		if pin.measurements != nil {
+			pin.measurements.SetCalculatedCost(model.LaneCost(measuredLaneMetrics(pin.measurements)))
+		}
+		for _, lane := range pin.ConnectedTo {
+			lane.Cost = model.LaneCost(LaneMetrics{
+				Latency:  lane.Latency,
+				Capacity: lane.Capacity,
+				Jitter:   lane.Jitter,
+				Loss:     lane.Loss,
+			})
+		}
+		pin.pushChanges.Set()
+	}
+
+	log.Infof("spn/navigator: using cost model %s on map %s", model.ID(), m.Name)
+}
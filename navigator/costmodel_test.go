@@ -0,0 +1,197 @@
+package navigator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/safing/spn/hub"
+)
+
+func TestCostModels(t *testing.T) {
+	t.Parallel()
+
+	// Lane with low latency and medium capacity.
+	fast := LaneMetrics{Latency: 10 * time.Millisecond, Capacity: 10000000}
+	// Lane with high latency and high capacity.
+	wide := LaneMetrics{Latency: 80 * time.Millisecond, Capacity: 1000000000}
+
+	// The default model must match the cost functions.
+	if DefaultCostModel.LaneCost(fast) != CalculateLaneCost(fast.Latency, fast.Capacity) {
+		t.Error("default cost model does not match lane cost function")
+	}
+	if DefaultCostModel.LaneCost(fast) <= DefaultCostModel.LaneCost(wide) {
+		t.Error("default cost model should prefer the lane with more capacity")
+	}
+
+	// The low latency model must prefer the lane with lower latency.
+	if LowLatencyCostModel.LaneCost(fast) >= LowLatencyCostModel.LaneCost(wide) {
+		t.Error("low latency cost model should prefer the lane with less latency")
+	}
+
+	// Jitter and loss must increase the cost, especially for the realtime model.
+	jittery := fast
+	jittery.Jitter = 5 * time.Millisecond
+	lossy := fast
+	lossy.Loss = 1
+	for _, model := range []CostModel{DefaultCostModel, LowLatencyCostModel, RealtimeCostModel} {
+		clean := model.LaneCost(fast)
+		if model.LaneCost(jittery) <= clean {
+			t.Errorf("%s cost model should add cost for jitter", model.ID())
+		}
+		if model.LaneCost(lossy) <= clean {
+			t.Errorf("%s cost model should add cost for loss", model.ID())
+		}
+	}
+	// A lane with slightly higher latency, but without jitter and loss.
+	unstable := fast
+	unstable.Jitter = 10 * time.Millisecond
+	unstable.Loss = 2
+	stable := LaneMetrics{Latency: 30 * time.Millisecond, Capacity: fast.Capacity}
+	if RealtimeCostModel.LaneCost(unstable) <= RealtimeCostModel.LaneCost(stable) {
+		t.Error("realtime cost model should prefer the stable lane")
+	}
+
+	// The load balancing model must gradually increase the Hub cost.
+	mediumLoad := &hub.Hub{Status: &hub.Status{Load: 50}}
+	highLoad := &hub.Hub{Status: &hub.Status{Load: 75}}
+	if DefaultCostModel.HubCost(mediumLoad) != DefaultCostModel.HubCost(highLoad) {
+		t.Error("default cost model should not differentiate below 80% load")
+	}
+	if LoadBalancingCostModel.HubCost(mediumLoad) >= LoadBalancingCostModel.HubCost(highLoad) {
+		t.Error("load balancing cost model should prefer the Hub with less load")
+	}
+
+	// Check registry.
+	for _, model := range CostModels() {
+		if GetCostModel(model.ID()) != model {
+			t.Errorf("cost model %s is not registered correctly", model.ID())
+		}
+	}
+	if err := RegisterCostModel(LowLatencyCostModel); err == nil {
+		t.Error("registering a cost model twice should fail")
+	}
+}
+
+func TestSelectCostModel(t *testing.T) {
+	t.Parallel()
+
+	m := NewMap("CostModelTest", false)
+	defer m.Close()
+
+	m.Lock()
+	defer m.Unlock()
+
+	if m.getCostModel() != DefaultCostModel {
+		t.Error("new map should use the default cost model")
+	}
+
+	// Select via intel.
+	m.intel = &hub.Intel{CostModel: CostModelLowLatencyID}
+	m.selectCostModel()
+	if m.getCostModel() != LowLatencyCostModel {
+		t.Errorf("map should use the cost model from the intel data, but uses %s", m.getCostModel().ID())
+	}
+
+	// Fall back to default for unknown models.
+	m.intel = &hub.Intel{CostModel: "does-not-exist"}
+	m.selectCostModel()
+	if m.getCostModel() != DefaultCostModel {
+		t.Errorf("map should fall back to the default cost model, but uses %s", m.getCostModel().ID())
+	}
+}
+
+func TestCostModelRouteChoices(t *testing.T) {
+	t.Parallel()
+
+	// Create a new map, as the cost model will be changed.
+	m := createRandomTestMap(5, 100)
+	defer m.Close()
+	fakeLock.Lock()
+	defer fakeLock.Unlock()
+
+	// Find nearest Pins for some destinations with the default model.
+	// They are reused for all models, so that only the route choices differ.
+	var destinations []*nearbyPins
+	m.RLock()
+	for i := 0; i < 20; i++ {
+		_, loc4 := createGoodIP(true)
+		nbPins, err := m.findNearestPins(loc4, nil, m.defaultOptions(), DestinationHub, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		destinations = append(destinations, nbPins)
+	}
+	m.RUnlock()
+
+	// Find the best routes with every model.
+	bestRoutes := make(map[string][]*Route)
+	for _, model := range []CostModel{DefaultCostModel, LowLatencyCostModel, LoadBalancingCostModel, RealtimeCostModel} {
+		m.SetCostModel(model)
+		m.RLock()
+		for _, nbPins := range destinations {
+			var best *Route
+			routes, err := m.findRoutes(nbPins, m.defaultOptions())
+			if err == nil && len(routes.All) > 0 {
+				best = lowestCostRoute(routes)
+			}
+			bestRoutes[model.ID()] = append(bestRoutes[model.ID()], best)
+		}
+		m.RUnlock()
+	}
+
+	// Every model must choose routes that are the best under this model.
+	// The routes chosen by other models may only be equal or more expensive.
+	for _, model := range []CostModel{DefaultCostModel, LowLatencyCostModel, LoadBalancingCostModel, RealtimeCostModel} {
+		m.SetCostModel(model)
+		m.RLock()
+		var differentChoices int
+		for i, best := range bestRoutes[model.ID()] {
+			if best == nil {
+				continue
+			}
+			bestCost := currentRouteCost(best)
+			for otherID, otherRoutes := range bestRoutes {
+				other := otherRoutes[i]
+				if otherID == model.ID() || other == nil {
+					continue
+				}
+				otherCost := currentRouteCost(other)
+				if otherCost < bestCost-0.01 {
+					t.Errorf(
+						"%s model chose route with %.2fc, but route of %s model costs %.2fc",
+						model.ID(), bestCost, otherID, otherCost,
+					)
+				}
+				if otherCost > bestCost {
+					differentChoices++
+				}
+			}
+		}
+		m.RUnlock()
+		t.Logf("%s model: %d better choices than other models", model.ID(), differentChoices)
+	}
+}
+
+// lowestCostRoute returns the route with the lowest total cost, as the routes
+// are randomized at the top.
+func lowestCostRoute(routes *Routes) *Route {
+	lowest := routes.All[0]
+	for _, route := range routes.All {
+		if route.TotalCost < lowest.TotalCost {
+			lowest = route
+		}
+	}
+	return lowest
+}
+
+// currentRouteCost calculates the cost of the route with the current costs of
+// the map.
+func currentRouteCost(route *Route) float32 {
+	cost := route.DstCost
+	for i := 1; i < len(route.Path); i++ {
+		pin := route.Path[i].pin
+		lane := route.Path[i-1].pin.ConnectedTo[pin.Hub.ID]
+		cost += lane.Cost + pin.Cost
+	}
+	return cost
+}
@@ -11,36 +11,74 @@ const (
 // Lane latency and capacity.
 // Ranges from 0 to 10000.
 func CalculateLaneCost(latency time.Duration, capacity int) (cost float32) {
+	return calculateLatencyCost(latency) + calculateCapacityCost(capacity)
+}
+
+// calculateLatencyCost calculates the latency part of the Lane cost.
+// Ranges from 0 to 1000 for latencies up to 1s.
+func calculateLatencyCost(latency time.Duration) float32 {
 	// - One point for every ms in latency (linear)
 	if latency != 0 {
-		cost += float32(latency) / float32(time.Millisecond)
-	} else {
-		// Add cautious default cost if latency is not available.
-		cost += 1000
+		return float32(latency) / float32(time.Millisecond)
 	}
 
+	// Add cautious default cost if latency is not available.
+	return 1000
+}
+
+// calculateCapacityCost calculates the capacity part of the Lane cost.
+// Ranges from 0 to 10000.
+func calculateCapacityCost(capacity int) float32 {
 	capacityFloat := float32(capacity)
 	switch {
 	case capacityFloat == 0:
 		// Add cautious default cost if capacity is not available.
-		cost += 4000
+		return 4000
 	case capacityFloat < cap1Mbit:
 		// - Between 1000 and 10000 points for ranges below 1Mbit/s
-		cost += 1000 + 9000*((cap1Mbit-capacityFloat)/cap1Mbit)
+		return 1000 + 9000*((cap1Mbit-capacityFloat)/cap1Mbit)
 	case capacityFloat < cap10Mbit:
 		// - Between 100 and 1000 points for ranges below 10Mbit/s
-		cost += 100 + 900*((cap10Mbit-capacityFloat)/cap10Mbit)
+		return 100 + 900*((cap10Mbit-capacityFloat)/cap10Mbit)
 	case capacityFloat < cap100Mbit:
 		// - Between 20 and 100 points for ranges below 100Mbit/s
-		cost += 20 + 80*((cap100Mbit-capacityFloat)/cap100Mbit)
+		return 20 + 80*((cap100Mbit-capacityFloat)/cap100Mbit)
 	case capacityFloat < cap1Gbit:
 		// - Between 5 and 20 points for ranges below 1Gbit/s
-		cost += 5 + 15*((cap1Gbit-capacityFloat)/cap1Gbit)
+		return 5 + 15*((cap1Gbit-capacityFloat)/cap1Gbit)
 	case capacityFloat < cap10Gbit:
 		// - Between 0 and 5 points for ranges below 10Gbit/s
-		cost += 5 * ((cap10Gbit - float32(capacity)) / cap10Gbit)
+		return 5 * ((cap10Gbit - capacityFloat) / cap10Gbit)
+	default:
+		return 0
 	}
+}
 
+// CalculateLaneQualityCost calculates the additional cost of using a Lane
+// based on the given Lane jitter and packet loss (0-100).
+// Ranges from 0 to 11000 for jitter up to 1s.
+// As older Hubs do not publish jitter and loss, no default cost is added if
+// these values are not available.
+func CalculateLaneQualityCost(jitter time.Duration, loss float32) (cost float32) {
+	// - One point for every ms in jitter (linear)
+	cost += calculateJitterCost(jitter)
+	// - 100 points for every percent of loss (linear)
+	cost += calculateLossCost(loss, 100)
+	return cost
+}
+
+// calculateJitterCost calculates the jitter part of the Lane cost.
+func calculateJitterCost(jitter time.Duration) float32 {
+	return float32(jitter) / float32(time.Millisecond)
+}
+
+// calculateLossCost calculates the loss part of the Lane cost with the given
+// points per percent of loss. The cost is capped at 10000.
+func calculateLossCost(loss float32, pointsPerPercent float32) float32 {
+	cost := loss * pointsPerPercent
+	if cost > 10000 {
+		return 10000
+	}
 	return cost
 }
 
@@ -0,0 +1,74 @@
+package navigator
+
+import (
+	"github.com/safing/portmaster/intel"
+)
+
+// Route diversity reasons.
+const (
+	routeReasonSharedCountry = "route has multiple Hubs in the same country"
+	routeReasonSharedASN     = "route has multiple Hubs in the same ASN"
+	routeReasonSharedOwner   = "route has multiple Hubs of the same verified owner"
+)
+
+// requiresRouteDiversity returns whether any route diversity constraints are set.
+func (o *Options) requiresRouteDiversity() bool {
+	return o.RequireDistinctCountries ||
+		o.RequireDistinctASNs ||
+		o.RequireDistinctOwners
+}
+
+// checkRouteDiversity checks if the last hop of the route shares a country,
+// ASN or verified owner with any of the previous hops, as configured in the
+// options. As adding more hops cannot fix this, a failing route must be
+// disqualified.
+// Unknown values (eg. no verified owner) are never regarded as shared.
+func (o *Options) checkRouteDiversity(route *Route) (ok bool, reason string) {
+	if len(route.Path) < 2 || !o.requiresRouteDiversity() {
+		return true, ""
+	}
+
+	lastPin := route.Path[len(route.Path)-1].pin
+	for _, hop := range route.Path[:len(route.Path)-1] {
+		switch {
+		case o.RequireDistinctCountries && pinsShareCountry(lastPin, hop.pin):
+			return false, routeReasonSharedCountry
+		case o.RequireDistinctASNs && pinsShareASN(lastPin, hop.pin):
+			return false, routeReasonSharedASN
+		case o.RequireDistinctOwners && pinsShareOwner(lastPin, hop.pin):
+			return false, routeReasonSharedOwner
+		}
+	}
+
+	return true, ""
+}
+
+func pinsShareCountry(a, b *Pin) bool {
+	for _, aEntity := range []*intel.Entity{a.EntityV4, a.EntityV6} {
+		for _, bEntity := range []*intel.Entity{b.EntityV4, b.EntityV6} {
+			if aEntity != nil && bEntity != nil &&
+				aEntity.Country != "" &&
+				aEntity.Country == bEntity.Country {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func pinsShareASN(a, b *Pin) bool {
+	for _, aEntity := range []*intel.Entity{a.EntityV4, a.EntityV6} {
+		for _, bEntity := range []*intel.Entity{b.EntityV4, b.EntityV6} {
+			if aEntity != nil && bEntity != nil &&
+				aEntity.ASN != 0 &&
+				aEntity.ASN == bEntity.ASN {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func pinsShareOwner(a, b *Pin) bool {
+	return a.VerifiedOwner != "" && a.VerifiedOwner == b.VerifiedOwner
+}
@@ -0,0 +1,115 @@
+package navigator
+
+import (
+	"testing"
+
+	"github.com/safing/portmaster/intel"
+)
+
+func TestCheckRouteDiversity(t *testing.T) {
+	t.Parallel()
+
+	pinA := &Pin{
+		EntityV4:      &intel.Entity{Country: "AT", ASN: 1},
+		VerifiedOwner: "Alice",
+	}
+	pinB := &Pin{
+		EntityV4:      &intel.Entity{Country: "DE", ASN: 2},
+		EntityV6:      &intel.Entity{Country: "AT", ASN: 3},
+		VerifiedOwner: "Bob",
+	}
+	pinC := &Pin{
+		EntityV6:      &intel.Entity{Country: "FR", ASN: 1},
+		VerifiedOwner: "Alice",
+	}
+	pinD := &Pin{
+		EntityV4: &intel.Entity{},
+	}
+	pinE := &Pin{
+		EntityV4: &intel.Entity{},
+	}
+
+	for _, tc := range []struct {
+		name   string
+		opts   *Options
+		path   []*Pin
+		reason string
+	}{
+		{"no constraints", &Options{}, []*Pin{pinA, pinB, pinC}, ""},
+		{"country", &Options{RequireDistinctCountries: true}, []*Pin{pinA, pinB}, routeReasonSharedCountry},
+		{"country ok", &Options{RequireDistinctCountries: true}, []*Pin{pinA, pinC}, ""},
+		{"asn", &Options{RequireDistinctASNs: true}, []*Pin{pinA, pinB, pinC}, routeReasonSharedASN},
+		{"asn ok", &Options{RequireDistinctASNs: true}, []*Pin{pinA, pinB}, ""},
+		{"owner", &Options{RequireDistinctOwners: true}, []*Pin{pinA, pinB, pinC}, routeReasonSharedOwner},
+		{"owner ok", &Options{RequireDistinctOwners: true}, []*Pin{pinB, pinC}, ""},
+		{"unknown values", &Options{
+			RequireDistinctCountries: true,
+			RequireDistinctASNs:      true,
+			RequireDistinctOwners:    true,
+		}, []*Pin{pinD, pinE}, ""},
+	} {
+		route := &Route{}
+		for _, pin := range tc.path {
+			route.Path = append(route.Path, &Hop{pin: pin})
+		}
+		ok, reason := tc.opts.checkRouteDiversity(route)
+		if ok != (tc.reason == "") || reason != tc.reason {
+			t.Errorf("%s: expected reason %q, got %v %q", tc.name, tc.reason, ok, reason)
+		}
+	}
+}
+
+func TestFindRoutesDiversity(t *testing.T) { //nolint:paralleltest // Modifies the verified owners of the default test map.
+	// Use the default test map, but let Hubs of a group share a verified owner
+	// for the duration of the test. As parallel tests only start after all
+	// sequential tests have finished, no other test sees the modified owners.
+	m := getDefaultTestMap()
+	fakeLock.Lock()
+	defer fakeLock.Unlock()
+	previousOwners := make(map[*Pin]string, len(m.all))
+	for _, pin := range m.all {
+		previousOwners[pin] = pin.VerifiedOwner
+		pin.VerifiedOwner = pin.Hub.Info.Group
+	}
+	defer func() {
+		for pin, owner := range previousOwners {
+			pin.VerifiedOwner = owner
+		}
+	}()
+
+	opts := m.DefaultOptions()
+	opts.RoutingProfile = RoutingProfileTripleHopID
+	opts.RequireDistinctCountries = true
+	opts.RequireDistinctASNs = true
+	opts.RequireDistinctOwners = true
+
+	var evaluated int
+	for i := 0; i < 10; i++ {
+		dstIP, _ := createGoodIP(i%2 == 0)
+		routes, err := m.FindRoutes(dstIP, opts.Copy())
+		if err != nil {
+			t.Logf("no routes for %s: %s", dstIP, err)
+			continue
+		}
+
+		// Check every pair of hops of every route.
+		for _, route := range routes.All {
+			evaluated++
+			for j, hopA := range route.Path {
+				for _, hopB := range route.Path[j+1:] {
+					switch {
+					case pinsShareCountry(hopA.pin, hopB.pin):
+						t.Errorf("route shares country: %s", route)
+					case pinsShareASN(hopA.pin, hopB.pin):
+						t.Errorf("route shares ASN: %s", route)
+					case pinsShareOwner(hopA.pin, hopB.pin):
+						t.Errorf("route shares verified owner: %s", route)
+					}
+				}
+			}
+		}
+	}
+	if evaluated == 0 {
+		t.Fatal("no routes were found to check")
+	}
+}
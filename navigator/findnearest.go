@@ -222,8 +222,9 @@ func (m *Map) findNearestPins(locationV4, locationV6 *geoip.Location, opts *Opti
 		nearby.debug = &nearbyPinsDebug{}
 	}
 
-	// Create pin matcher.
+	// Create pin matcher and get cost model.
 	matcher := opts.Matcher(matchFor, m.intel)
+	costs := m.getCostModel()
 
 	// Iterate over all Pins in the Map to find the nearest ones.
 	for _, pin := range m.all {
@@ -297,12 +298,12 @@ func (m *Map) findNearestPins(locationV4, locationV6 *geoip.Location, opts *Opti
 		if locationV4 != nil && pin.LocationV4 != nil {
 			if locationV4.IsAnycast && m.home != nil {
 				// If the destination is anycast, calculate cost though proximity to home hub instead, if possible.
-				cost = lessButPositive(cost, CalculateDestinationCost(
+				cost = lessButPositive(cost, costs.DestinationCost(
 					proximityBetweenPins(pin, m.home),
 				))
 			} else {
 				// Regular cost calculation through proximity.
-				cost = lessButPositive(cost, CalculateDestinationCost(
+				cost = lessButPositive(cost, costs.DestinationCost(
 					locationV4.EstimateNetworkProximity(pin.LocationV4),
 				))
 			}
@@ -311,12 +312,12 @@ func (m *Map) findNearestPins(locationV4, locationV6 *geoip.Location, opts *Opti
 		if locationV6 != nil && pin.LocationV6 != nil {
 			if locationV6.IsAnycast && m.home != nil {
 				// If the destination is anycast, calculate cost though proximity to home hub instead, if possible.
-				cost = lessButPositive(cost, CalculateDestinationCost(
+				cost = lessButPositive(cost, costs.DestinationCost(
 					proximityBetweenPins(pin, m.home),
 				))
 			} else {
 				// Regular cost calculation through proximity.
-				cost = lessButPositive(cost, CalculateDestinationCost(
+				cost = lessButPositive(cost, costs.DestinationCost(
 					locationV6.EstimateNetworkProximity(pin.LocationV6),
 				))
 			}
@@ -324,7 +325,7 @@ func (m *Map) findNearestPins(locationV4, locationV6 *geoip.Location, opts *Opti
 
 		// If no cost could be calculated, fall back to a default value.
 		if cost == 0 {
-			cost = CalculateDestinationCost(50) // proximity out of 0-100
+			cost = costs.DestinationCost(50) // proximity out of 0-100
 		}
 
 		// Debugging:
@@ -334,34 +335,37 @@ func (m *Map) findNearestPins(locationV4, locationV6 *geoip.Location, opts *Opti
 
 		// 2. Add cost based on Hub status
 
-		cost += CalculateHubCost(pin.Hub.Status.Load)
+		cost += costs.HubCost(pin.Hub)
 
 		// Debugging:
 		// if matchFor == HomeHub {
-		// 	log.Tracef("spn/navigator: adding %.2f hub cost to home hub %s", CalculateHubCost(pin.Hub.Status.Load), pin.Hub)
+		// 	log.Tracef("spn/navigator: adding %.2f hub cost to home hub %s", costs.HubCost(pin.Hub), pin.Hub)
 		// }
 
 		// 3. If matching a home hub, add cost based on capacity/latency performance.
 
 		if matchFor == HomeHub {
 			// Find best capacity/latency values.
-			var (
-				bestCapacity int
-				bestLatency  time.Duration
-			)
-			for _, lane := range pin.Hub.Status.Lanes {
-				if lane.Capacity > bestCapacity {
-					bestCapacity = lane.Capacity
+			var best LaneMetrics
+			for i, lane := range pin.Hub.Status.Lanes {
+				if lane.Capacity > best.Capacity {
+					best.Capacity = lane.Capacity
+				}
+				if best.Latency == 0 || lane.Latency < best.Latency {
+					best.Latency = lane.Latency
+				}
+				if i == 0 || lane.Jitter < best.Jitter {
+					best.Jitter = lane.Jitter
 				}
-				if bestLatency == 0 || lane.Latency < bestLatency {
-					bestLatency = lane.Latency
+				if i == 0 || lane.Loss < best.Loss {
+					best.Loss = lane.Loss
 				}
 			}
 			// Add cost of best capacity/latency values.
-			cost += CalculateLaneCost(bestLatency, bestCapacity)
+			cost += costs.LaneCost(best)
 
 			// Debugging:
-			// log.Tracef("spn/navigator: adding %.2f lane cost to home hub %s", CalculateLaneCost(bestLatency, bestCapacity), pin.Hub)
+			// log.Tracef("spn/navigator: adding %.2f lane cost to home hub %s", costs.LaneCost(best), pin.Hub)
 			// log.Debugf("spn/navigator: total cost of %.2f to home hub %s", cost, pin.Hub)
 		}
 
@@ -128,6 +128,10 @@ func (m *Map) findRoutes(dsts *nearbyPins, opts *Options) (*Routes, error) {
 		maxRoutes:           defaultMaxRouteMatches,
 		randomizeTopPercent: defaultRandomizeRoutesTopPercent,
 	}
+	// Add debugging data if enabled for the destinations.
+	if dsts.debug != nil {
+		routes.debug = &routesDebug{}
+	}
 
 	// TODO:
 	// Start from the destination and use HopDistance to prioritize
@@ -164,6 +168,12 @@ func (m *Map) findRoutes(dsts *nearbyPins, opts *Options) (*Routes, error) {
 	exploreHop = func(route *Route, lane *Lane) {
 		// Check if the Pin should be regarded as Transit Hub.
 		if !transitMatcher(lane.Pin) {
+			// Add debug data if enabled.
+			if routes.debug != nil {
+				route.addHop(lane.Pin, lane.Cost+lane.Pin.Cost)
+				routes.addEvaluated(route, routeResultRejected, "Hub does not qualify as Transit Hub")
+				route.removeHop()
+			}
 			return
 		}
 
@@ -173,12 +183,20 @@ func (m *Map) findRoutes(dsts *nearbyPins, opts *Options) (*Routes, error) {
 
 		// Check if the route would even make it into the list.
 		if !routes.isGoodEnough(route) {
+			routes.addEvaluated(route, routeResultRejected, "route is more expensive than the found routes")
+			return
+		}
+
+		// Check route diversity.
+		if ok, reason := opts.checkRouteDiversity(route); !ok {
+			routes.addEvaluated(route, routeResultDisqualified, reason)
 			return
 		}
 
 		// Check route compliance.
 		// This also includes some algorithm-based optimizations.
-		switch routingProfile.checkRouteCompliance(route, routes) {
+		compliance, reason := routingProfile.checkRouteCompliance(route, routes)
+		switch compliance {
 		case routeOk:
 			// Route would be compliant.
 			// Now, check if the last hop qualifies as a Destination Hub.
@@ -189,6 +207,7 @@ func (m *Map) findRoutes(dsts *nearbyPins, opts *Options) (*Routes, error) {
 					// Pin is listed as selected Destination Hub!
 					// Complete route to add destination ("last mile") cost.
 					route.completeRoute(nbPin.cost)
+					routes.addEvaluated(route, routeResultFound, "")
 					routes.add(route)
 
 					// We have found a route and have come to an end here.
@@ -199,12 +218,16 @@ func (m *Map) findRoutes(dsts *nearbyPins, opts *Options) (*Routes, error) {
 			// The Route is compliant, but we haven't found a Destination Hub yet.
 			fallthrough
 		case routeNonCompliant:
+			if compliance == routeNonCompliant {
+				routes.addEvaluated(route, routeResultNonCompliant, reason)
+			}
 			// Continue exploration.
 			exploreLanes(route)
 		case routeDisqualified:
 			fallthrough
 		default:
 			// Route is disqualified and we can return without further exploration.
+			routes.addEvaluated(route, routeResultDisqualified, reason)
 		}
 	}
 
@@ -215,6 +238,11 @@ func (m *Map) findRoutes(dsts *nearbyPins, opts *Options) (*Routes, error) {
 
 	// Check if we found anything.
 	if len(routes.All) == 0 {
+		// Still return the routes if debugging is enabled, as the debugging
+		// data explains why no routes were found.
+		if routes.debug != nil {
+			return routes, errors.New("failed to find any routes")
+		}
 		return nil, errors.New("failed to find any routes")
 	}
 
@@ -3,6 +3,8 @@ package navigator
 import (
 	"net"
 	"testing"
+
+	"github.com/safing/spn/hub"
 )
 
 func TestFindRoutes(t *testing.T) {
@@ -29,6 +31,47 @@ func TestFindRoutes(t *testing.T) {
 	}
 }
 
+func TestFindRoutesDebug(t *testing.T) {
+	t.Parallel()
+
+	// Create map and lock faking in order to guarantee reproducability of faked data.
+	m := getOptimizedDefaultTestMap(t)
+	fakeLock.Lock()
+	defer fakeLock.Unlock()
+
+	m.RLock()
+	defer m.RUnlock()
+
+	_, loc4 := createGoodIP(true)
+	nbPins, err := m.findNearestPins(loc4, nil, m.DefaultOptions(), DestinationHub, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes, err := m.findRoutes(nbPins, m.DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Check that all evaluated routes have a result and that all rejected
+	// routes have a reason.
+	var found int
+	for _, evaluated := range routes.debug.evaluated {
+		switch evaluated.result {
+		case routeResultFound:
+			found++
+		case routeResultNonCompliant, routeResultDisqualified, routeResultRejected:
+			if evaluated.reason == "" {
+				t.Errorf("%s route has no reason: %s", evaluated.result, evaluated.route)
+			}
+		default:
+			t.Errorf("unexpected route result %q", evaluated.result)
+		}
+	}
+	if found < len(routes.All) {
+		t.Errorf("only %d routes were found, but %d are in the result", found, len(routes.All))
+	}
+}
+
 func BenchmarkFindRoutes(b *testing.B) {
 	// Create map and lock faking in order to guarantee reproducability of faked data.
 	m := getOptimizedDefaultTestMap(nil)
@@ -52,3 +95,42 @@ func BenchmarkFindRoutes(b *testing.B) {
 		}
 	}
 }
+
+func TestRouteCapacity(t *testing.T) {
+	t.Parallel()
+
+	makePin := func(id string) *Pin {
+		return &Pin{
+			Hub:          &hub.Hub{ID: id},
+			ConnectedTo:  make(map[string]*Lane),
+			measurements: hub.NewMeasurements(),
+		}
+	}
+	home, a, b := makePin("home"), makePin("a"), makePin("b")
+	home.ConnectedTo["a"] = &Lane{Pin: a, Capacity: 50000000}
+	a.ConnectedTo["b"] = &Lane{Pin: b, Capacity: 10000000}
+
+	m := &Map{}
+	route := &Route{}
+	route.addHop(home, 0)
+	route.addHop(a, 0)
+	route.addHop(b, 0)
+
+	// The slowest Lane is the bottleneck.
+	if capacity := m.RouteCapacity(route); capacity != 10000000 {
+		t.Errorf("expected capacity of 10000000, got %d", capacity)
+	}
+
+	// The capacity measured to the first Hub is included.
+	home.measurements.SetCapacity(5000000)
+	if capacity := m.RouteCapacity(route); capacity != 5000000 {
+		t.Errorf("expected capacity of 5000000, got %d", capacity)
+	}
+
+	// Lanes without a known capacity are ignored.
+	home.measurements.SetCapacity(0)
+	a.ConnectedTo["b"].Capacity = 0
+	if capacity := m.RouteCapacity(route); capacity != 50000000 {
+		t.Errorf("expected capacity of 50000000, got %d", capacity)
+	}
+}
@@ -43,6 +43,9 @@ func (m *Map) UpdateIntel(update *hub.Intel) error {
 	// Configure the map's regions.
 	m.updateRegions(m.intel.Regions)
 
+	// Select the cost model, which might be defined by the intel data.
+	m.selectCostModel()
+
 	// Push pin changes.
 	m.PushPinChanges()
 
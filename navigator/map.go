@@ -16,9 +16,10 @@ type Map struct {
 	sync.RWMutex
 	Name string
 
-	all     map[string]*Pin
-	intel   *hub.Intel
-	regions []*Region
+	all       map[string]*Pin
+	intel     *hub.Intel
+	regions   []*Region
+	costModel CostModel
 
 	home         *Pin
 	homeTerminal *docks.CraneTerminal
@@ -100,6 +101,21 @@ func (m *Map) SetHome(id string, t *docks.CraneTerminal) (ok bool) {
 	return true
 }
 
+// GetActiveTerminalHubs returns the IDs of all Hubs that currently have an
+// active terminal.
+func (m *Map) GetActiveTerminalHubs() []string {
+	m.RLock()
+	defer m.RUnlock()
+
+	var hubIDs []string
+	for _, pin := range m.all {
+		if pin.HasActiveTerminal() {
+			hubIDs = append(hubIDs, pin.Hub.ID)
+		}
+	}
+	return hubIDs
+}
+
 // isEmpty returns whether the Map is regarded as empty.
 func (m *Map) isEmpty() bool {
 	if m.home != nil {
@@ -77,16 +77,17 @@ func (m *Map) measureHubs(ctx context.Context, _ *modules.Task) error {
 		tErr := docks.MeasureHub(ctx, pin.Hub, checkWithTTL)
 
 		// Independent of outcome, recalculate the cost.
-		latency, _ := pin.measurements.GetLatency()
-		capacity, _ := pin.measurements.GetCapacity()
-		calculatedCost := CalculateLaneCost(latency, capacity)
+		lane := measuredLaneMetrics(pin.measurements)
+		calculatedCost := m.CostModel().LaneCost(lane)
 		pin.measurements.SetCalculatedCost(calculatedCost)
 		// Log result.
 		log.Infof(
-			"spn/navigator: updated measurements for connection to %s: %s %.2fMbit/s %.2fc",
+			"spn/navigator: updated measurements for connection to %s: %s %.2fMbit/s jitter=%s loss=%.0f%% %.2fc",
 			pin.Hub,
-			latency,
-			float64(capacity)/1000000,
+			lane.Latency,
+			float64(lane.Capacity)/1000000,
+			lane.Jitter,
+			lane.Loss,
 			calculatedCost,
 		)
 
@@ -45,10 +45,16 @@ func init() {
 }
 
 func prep() error {
+	if err := prepConfig(); err != nil {
+		return err
+	}
+
 	return registerAPIEndpoints()
 }
 
 func start() error {
+	updateCustomRoutingProfiles()
+
 	Main = NewMap(conf.MainMapName, true)
 	devMode = config.Concurrent.GetAsBool(config.CfgDevModeKey, false)
 	cfgOptionRoutingAlgorithm = config.Concurrent.GetAsString(cfgOptionRoutingAlgorithmKey, DefaultRoutingProfileID)
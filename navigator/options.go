@@ -53,6 +53,16 @@ type Options struct { //nolint:maligned
 	// RequireTrustedDestinationHubs declares whether only Destination Hubs that have the Trusted state should be used.
 	RequireTrustedDestinationHubs bool
 
+	// RequireDistinctCountries declares whether all Hubs of a route must be in different countries.
+	RequireDistinctCountries bool
+
+	// RequireDistinctASNs declares whether all Hubs of a route must be in different ASNs.
+	RequireDistinctASNs bool
+
+	// RequireDistinctOwners declares whether all Hubs of a route must have different verified owners.
+	// Hubs without a verified owner are not affected by this.
+	RequireDistinctOwners bool
+
 	// RoutingProfile defines the algorithm to use to find a route.
 	RoutingProfile string
 }
@@ -67,6 +77,9 @@ func (o *Options) Copy() *Options {
 		CheckHubExitPolicyWith:        o.CheckHubExitPolicyWith,
 		NoDefaults:                    o.NoDefaults,
 		RequireTrustedDestinationHubs: o.RequireTrustedDestinationHubs,
+		RequireDistinctCountries:      o.RequireDistinctCountries,
+		RequireDistinctASNs:           o.RequireDistinctASNs,
+		RequireDistinctOwners:         o.RequireDistinctOwners,
 		RoutingProfile:                o.RoutingProfile,
 	}
 }
@@ -87,6 +87,14 @@ type Lane struct {
 	// It is specified in nanoseconds.
 	Latency time.Duration
 
+	// Jitter designates the variation of the latency between these Hubs.
+	// It is specified in nanoseconds.
+	Jitter time.Duration
+
+	// Loss designates the packet loss between these Hubs.
+	// It is specified in percent (0-100).
+	Loss float32
+
 	// Cost is the routing cost of this lane.
 	Cost float32
 
@@ -14,6 +14,50 @@ type Routes struct {
 	randomizeTopPercent float32
 	maxCost             float32 // automatic
 	maxRoutes           int     // manual setting
+
+	debug *routesDebug
+}
+
+// maxEvaluatedDebugRoutes defines how many evaluated routes are recorded for
+// debugging at most.
+const maxEvaluatedDebugRoutes = 10000
+
+// Route evaluation results.
+const (
+	routeResultFound        = "found"
+	routeResultNonCompliant = "non-compliant"
+	routeResultDisqualified = "disqualified"
+	routeResultRejected     = "rejected"
+)
+
+// routesDebug holds additional debugging data for Routes.
+type routesDebug struct {
+	evaluated []*evaluatedRoute
+	skipped   int
+}
+
+// evaluatedRoute represents a route that was evaluated while finding routes.
+type evaluatedRoute struct {
+	route  *Route
+	result string
+	reason string
+}
+
+// addEvaluated adds the route to the evaluated routes, if debugging is enabled.
+func (r *Routes) addEvaluated(route *Route, result, reason string) {
+	switch {
+	case r.debug == nil:
+		return
+	case len(r.debug.evaluated) >= maxEvaluatedDebugRoutes:
+		r.debug.skipped++
+		return
+	}
+
+	r.debug.evaluated = append(r.debug.evaluated, &evaluatedRoute{
+		route:  route.CopyUpTo(0),
+		result: result,
+		reason: reason,
+	})
 }
 
 // Len is the number of elements in the collection.
@@ -219,3 +263,35 @@ func (r *Route) String() string {
 	s = append(s, fmt.Sprintf("--> %.2fc", r.DstCost))
 	return strings.Join(s, " ")
 }
+
+// RouteCapacity returns the bottleneck capacity of the route in bit/s, which
+// is the lowest known capacity of the Lanes along the route. The capacity
+// measured to the first Hub of the route is included.
+// Returns 0 if the capacity of no Lane is known.
+func (m *Map) RouteCapacity(route *Route) int {
+	m.RLock()
+	defer m.RUnlock()
+
+	var bottleneck int
+	addLane := func(capacity int) {
+		if capacity > 0 && (bottleneck == 0 || capacity < bottleneck) {
+			bottleneck = capacity
+		}
+	}
+
+	for i, hop := range route.Path {
+		if i == 0 {
+			if hop.pin.measurements != nil {
+				capacity, _ := hop.pin.measurements.GetCapacity()
+				addLane(capacity)
+			}
+			continue
+		}
+
+		if lane, ok := route.Path[i-1].pin.ConnectedTo[hop.pin.Hub.ID]; ok {
+			addLane(lane.Capacity)
+		}
+	}
+
+	return bottleneck
+}
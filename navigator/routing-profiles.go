@@ -1,6 +1,15 @@
 package navigator
 
-import "github.com/safing/portbase/log"
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/safing/portbase/log"
+)
 
 // RoutingProfile defines a routing algorithm with some options.
 type RoutingProfile struct {
@@ -74,7 +83,21 @@ var (
 	}
 )
 
+var (
+	customRoutingProfiles     = make(map[string]*RoutingProfile)
+	customRoutingProfilesLock sync.RWMutex
+
+	// routingProfileIDRegex defines the allowed format of routing profile IDs.
+	routingProfileIDRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+)
+
+// customRoutingProfileMaxHops defines the limit for the max hops of custom
+// routing profiles, as the effort of finding routes grows exponentially.
+const customRoutingProfileMaxHops = 8
+
 // GetRoutingProfile returns the routing profile with the given ID.
+// Custom routing profiles are returned too. If no routing profile with the
+// given ID exists, the default routing profile is returned.
 func GetRoutingProfile(id string) *RoutingProfile {
 	switch id {
 	case RoutingProfileHomeID:
@@ -85,9 +108,143 @@ func GetRoutingProfile(id string) *RoutingProfile {
 		return RoutingProfileDoubleHop
 	case RoutingProfileTripleHopID:
 		return RoutingProfileTripleHop
-	default:
-		return RoutingProfileDoubleHop
 	}
+
+	customRoutingProfilesLock.RLock()
+	defer customRoutingProfilesLock.RUnlock()
+
+	if rp, ok := customRoutingProfiles[id]; ok {
+		return rp
+	}
+	return RoutingProfileDoubleHop
+}
+
+// RoutingProfiles returns all available routing profiles, starting with the
+// built-in ones, followed by the custom ones sorted by ID.
+func RoutingProfiles() []*RoutingProfile {
+	customRoutingProfilesLock.RLock()
+	defer customRoutingProfilesLock.RUnlock()
+
+	profiles := make([]*RoutingProfile, 0, 4+len(customRoutingProfiles))
+	profiles = append(profiles,
+		RoutingProfileHome,
+		RoutingProfileSingleHop,
+		RoutingProfileDoubleHop,
+		RoutingProfileTripleHop,
+	)
+	custom := make([]*RoutingProfile, 0, len(customRoutingProfiles))
+	for _, rp := range customRoutingProfiles {
+		custom = append(custom, rp)
+	}
+	sort.Slice(custom, func(i, j int) bool {
+		return custom[i].ID < custom[j].ID
+	})
+
+	return append(profiles, custom...)
+}
+
+// SetCustomRoutingProfiles replaces all custom routing profiles with the
+// given ones. All routing profiles are validated before any is applied.
+func SetCustomRoutingProfiles(profiles []*RoutingProfile) error {
+	newProfiles := make(map[string]*RoutingProfile, len(profiles))
+	for _, rp := range profiles {
+		if err := rp.Validate(); err != nil {
+			return err
+		}
+		if _, ok := newProfiles[rp.ID]; ok {
+			return fmt.Errorf("routing profile %s is defined twice", rp.ID)
+		}
+		newProfiles[rp.ID] = rp
+	}
+
+	customRoutingProfilesLock.Lock()
+	defer customRoutingProfilesLock.Unlock()
+
+	customRoutingProfiles = newProfiles
+	return nil
+}
+
+// Validate checks if the routing profile is valid and may be used as a custom
+// routing profile.
+func (rp *RoutingProfile) Validate() error {
+	switch {
+	case !routingProfileIDRegex.MatchString(rp.ID):
+		return fmt.Errorf("routing profile ID %q is invalid: only lowercase letters, numbers and dashes are allowed", rp.ID)
+	case rp.ID == RoutingProfileHomeID,
+		rp.ID == RoutingProfileSingleHopID,
+		rp.ID == RoutingProfileDoubleHopID,
+		rp.ID == RoutingProfileTripleHopID:
+		return fmt.Errorf("routing profile ID %s is reserved for a built-in routing profile", rp.ID)
+	case rp.MinHops < 1:
+		return fmt.Errorf("routing profile %s: min hops must be at least 1", rp.ID)
+	case rp.MaxHops < rp.MinHops:
+		return fmt.Errorf("routing profile %s: max hops must not be less than min hops", rp.ID)
+	case rp.MaxHops > customRoutingProfileMaxHops:
+		return fmt.Errorf("routing profile %s: max hops must not exceed %d", rp.ID, customRoutingProfileMaxHops)
+	case rp.MaxExtraHops < 0:
+		return fmt.Errorf("routing profile %s: max extra hops must not be negative", rp.ID)
+	case rp.MaxExtraCost < 0:
+		return fmt.Errorf("routing profile %s: max extra cost must not be negative", rp.ID)
+	}
+	return nil
+}
+
+// ParseRoutingProfile parses a routing profile definition in the format
+// "<id>:<min hops>:<max hops>:<max extra hops>:<max extra cost>[:<name>]".
+// If no name is given, the ID is used as the name.
+func ParseRoutingProfile(definition string) (*RoutingProfile, error) {
+	fields := strings.SplitN(strings.TrimSpace(definition), ":", 6)
+	if len(fields) < 5 {
+		return nil, fmt.Errorf("routing profile definition %q must have the format <id>:<min hops>:<max hops>:<max extra hops>:<max extra cost>[:<name>]", definition)
+	}
+
+	for i, field := range fields {
+		fields[i] = strings.TrimSpace(field)
+	}
+
+	rp := &RoutingProfile{
+		ID:   fields[0],
+		Name: fields[0],
+	}
+	if len(fields) == 6 && fields[5] != "" {
+		rp.Name = fields[5]
+	}
+
+	var err error
+	if rp.MinHops, err = strconv.Atoi(fields[1]); err != nil {
+		return nil, fmt.Errorf("routing profile %s: invalid min hops: %w", rp.ID, err)
+	}
+	if rp.MaxHops, err = strconv.Atoi(fields[2]); err != nil {
+		return nil, fmt.Errorf("routing profile %s: invalid max hops: %w", rp.ID, err)
+	}
+	if rp.MaxExtraHops, err = strconv.Atoi(fields[3]); err != nil {
+		return nil, fmt.Errorf("routing profile %s: invalid max extra hops: %w", rp.ID, err)
+	}
+	maxExtraCost, err := strconv.ParseFloat(fields[4], 32)
+	if err != nil {
+		return nil, fmt.Errorf("routing profile %s: invalid max extra cost: %w", rp.ID, err)
+	}
+	rp.MaxExtraCost = float32(maxExtraCost)
+
+	return rp, rp.Validate()
+}
+
+// parseRoutingProfiles parses all given routing profile definitions.
+func parseRoutingProfiles(definitions []string) ([]*RoutingProfile, error) {
+	profiles := make([]*RoutingProfile, 0, len(definitions))
+	seen := make(map[string]struct{}, len(definitions))
+	for _, definition := range definitions {
+		rp, err := ParseRoutingProfile(definition)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := seen[rp.ID]; ok {
+			return nil, fmt.Errorf("routing profile %s is defined twice", rp.ID)
+		}
+		seen[rp.ID] = struct{}{}
+		profiles = append(profiles, rp)
+	}
+	return profiles, nil
 }
 
 type routeCompliance uint8
@@ -98,14 +255,26 @@ const (
 	routeDisqualified                        // Route is disqualified and won't be able to become compliant.
 )
 
-func (rp *RoutingProfile) checkRouteCompliance(route *Route, foundRoutes *Routes) routeCompliance {
+// Route compliance reasons.
+const (
+	routeReasonTooFewHops       = "route has fewer hops than required"
+	routeReasonTooManyHops      = "route has more hops than allowed"
+	routeReasonHubReused        = "route uses a Hub more than once"
+	routeReasonSessionMismatch  = "Hub is already connected via a different previous Hub"
+	routeReasonExceedsExtraCost = "route exceeds the extra cost allowed over the best route"
+	routeReasonExceedsExtraHops = "route exceeds the extra hops allowed over the best route"
+)
+
+// checkRouteCompliance checks if the route is compliant with the routing
+// profile. If it is not, the reason is returned too.
+func (rp *RoutingProfile) checkRouteCompliance(route *Route, foundRoutes *Routes) (compliance routeCompliance, reason string) {
 	switch {
 	case len(route.Path) < rp.MinHops:
 		// Route is shorter than the defined minimum.
-		return routeNonCompliant
+		return routeNonCompliant, routeReasonTooFewHops
 	case len(route.Path) > rp.MaxHops:
 		// Route is longer than the defined maximum.
-		return routeDisqualified
+		return routeDisqualified, routeReasonTooManyHops
 	}
 
 	// Check for hub re-use.
@@ -113,7 +282,7 @@ func (rp *RoutingProfile) checkRouteCompliance(route *Route, foundRoutes *Routes
 		lastHop := route.Path[len(route.Path)-1]
 		for _, hop := range route.Path[:len(route.Path)-1] {
 			if lastHop.pin.Hub.ID == hop.pin.Hub.ID {
-				return routeDisqualified
+				return routeDisqualified, routeReasonHubReused
 			}
 		}
 	}
@@ -137,7 +306,7 @@ func (rp *RoutingProfile) checkRouteCompliance(route *Route, foundRoutes *Routes
 		case lastPinConnection.Route.Path[len(lastPinConnection.Route.Path)-2].pin.Hub.ID != route.Path[len(route.Path)-2].pin.Hub.ID:
 			// The previous hop of the existing route and the one we are evaluating don't match.
 			// Currently, we only allow one session per Hub.
-			return routeDisqualified
+			return routeDisqualified, routeReasonSessionMismatch
 		}
 	}
 
@@ -147,13 +316,13 @@ func (rp *RoutingProfile) checkRouteCompliance(route *Route, foundRoutes *Routes
 		best := foundRoutes.All[0]
 		// Abort if current route exceeds max extra costs.
 		if route.TotalCost > best.TotalCost+rp.MaxExtraCost {
-			return routeDisqualified
+			return routeDisqualified, routeReasonExceedsExtraCost
 		}
 		// Abort if current route exceeds max extra hops.
 		if len(route.Path) > len(best.Path)+rp.MaxExtraHops {
-			return routeDisqualified
+			return routeDisqualified, routeReasonExceedsExtraHops
 		}
 	}
 
-	return routeOk
+	return routeOk, ""
 }
@@ -0,0 +1,85 @@
+package navigator
+
+import (
+	"testing"
+)
+
+func TestParseRoutingProfile(t *testing.T) {
+	t.Parallel()
+
+	rp, err := ParseRoutingProfile("quad-hop:4:6:3:10000:Very Private")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rp.ID != "quad-hop" || rp.Name != "Very Private" ||
+		rp.MinHops != 4 || rp.MaxHops != 6 || rp.MaxExtraHops != 3 || rp.MaxExtraCost != 10000 {
+		t.Errorf("unexpected routing profile: %+v", rp)
+	}
+
+	rp, err = ParseRoutingProfile("short:1:2:0:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rp.Name != "short" {
+		t.Errorf("name should default to the ID, got %q", rp.Name)
+	}
+
+	rp, err = ParseRoutingProfile(" spaced : 2 : 3 : 1 : 0 : Spaced Out ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rp.ID != "spaced" || rp.Name != "Spaced Out" ||
+		rp.MinHops != 2 || rp.MaxHops != 3 || rp.MaxExtraHops != 1 || rp.MaxExtraCost != 0 {
+		t.Errorf("unexpected routing profile: %+v", rp)
+	}
+
+	for _, definition := range []string{
+		"",
+		"missing:1:2:3",
+		"Invalid-ID:1:2:0:0",
+		"double-hop:2:4:2:10000",
+		"no-hops:0:2:0:0",
+		"inverted:3:2:0:0",
+		"too-long:2:20:0:0",
+		"negative:1:2:-1:0",
+		"nan:1:2:0:x",
+	} {
+		if _, err := ParseRoutingProfile(definition); err == nil {
+			t.Errorf("definition %q should be invalid", definition)
+		}
+	}
+
+	if _, err := parseRoutingProfiles([]string{"dup:1:2:0:0", "dup:2:3:0:0"}); err == nil {
+		t.Error("duplicate routing profiles should be invalid")
+	}
+}
+
+func TestCustomRoutingProfiles(t *testing.T) { //nolint:paralleltest // Modifies the global custom routing profiles.
+	// Restore the previous custom routing profiles after the test.
+	customRoutingProfilesLock.RLock()
+	previousProfiles := customRoutingProfiles
+	customRoutingProfilesLock.RUnlock()
+	defer func() {
+		customRoutingProfilesLock.Lock()
+		defer customRoutingProfilesLock.Unlock()
+		customRoutingProfiles = previousProfiles
+	}()
+
+	rp, err := ParseRoutingProfile("test-custom:2:3:1:5000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SetCustomRoutingProfiles([]*RoutingProfile{rp}); err != nil {
+		t.Fatal(err)
+	}
+
+	if GetRoutingProfile("test-custom") != rp {
+		t.Error("custom routing profile should be returned")
+	}
+	if GetRoutingProfile("test-unknown") != RoutingProfileDoubleHop {
+		t.Error("unknown routing profiles should fall back to the default")
+	}
+	if all := RoutingProfiles(); len(all) != 5 || all[4] != rp {
+		t.Errorf("unexpected routing profiles: %v", all)
+	}
+}
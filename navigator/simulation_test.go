@@ -0,0 +1,473 @@
+package navigator
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brianvoe/gofakeit"
+
+	"github.com/safing/spn/hub"
+)
+
+var simulationScale = flag.Int("simulation-scale", 1, "multiply the Hub count of optimization simulations")
+
+// simulationConfig configures a synthetic SPN topology for simulating the
+// network optimization.
+type simulationConfig struct {
+	name string
+	seed int64
+	hubs int
+
+	// regions defines how many regions the Hubs are distributed across.
+	// Every fifth Hub is a satellite without region.
+	regions int
+
+	// regionalLatency and crossRegionalLatency define the latency ranges
+	// between Hubs within and between regions.
+	regionalLatency      [2]time.Duration
+	crossRegionalLatency [2]time.Duration
+
+	// randomStates enables faked Hub failures, such as invalid messages,
+	// expired keys and advisories.
+	randomStates bool
+
+	// failureRate defines the fraction of Hubs that fail after the
+	// optimization in order to measure the resilience of the network.
+	failureRate float64
+
+	// maxRounds defines after how many optimization rounds to give up.
+	maxRounds int
+}
+
+// simulation is a synthetic SPN topology.
+type simulation struct {
+	cfg *simulationConfig
+	m   *Map
+	rng *rand.Rand
+
+	// region holds the region index of every Hub. Satellites have -1.
+	region map[string]int
+	// measurements holds the measurements between two Hubs.
+	measurements map[string]*hub.Measurements
+}
+
+// simulationReport holds the results of a simulation.
+type simulationReport struct {
+	rounds     int
+	converged  bool
+	lanesAdded int
+
+	hubs        int
+	diameter    int
+	minLanes    int
+	maxLanes    int
+	avgLanes    float64
+	reachable   float64
+	articulated int
+
+	failed             int
+	diameterAfterFail  int
+	reachableAfterFail float64
+}
+
+func (r *simulationReport) String() string {
+	return fmt.Sprintf(
+		"rounds=%d converged=%v lanesAdded=%d hubs=%d diameter=%d lanes=%d/%.1f/%d reachable=%.3f articulationPoints=%d failed=%d diameterAfterFail=%d reachableAfterFail=%.3f",
+		r.rounds, r.converged, r.lanesAdded,
+		r.hubs, r.diameter, r.minLanes, r.avgLanes, r.maxLanes, r.reachable, r.articulated,
+		r.failed, r.diameterAfterFail, r.reachableAfterFail,
+	)
+}
+
+// newSimulation creates a new synthetic topology. The Hubs are initially only
+// connected in a random tree, so that the optimization needs to build the
+// network structure.
+// The fake lock must be held.
+func newSimulation(cfg *simulationConfig) *simulation {
+	gofakeit.Seed(cfg.seed)
+	sim := &simulation{
+		cfg:          cfg,
+		rng:          rand.New(rand.NewSource(cfg.seed)), //nolint:gosec
+		region:       make(map[string]int, cfg.hubs),
+		measurements: make(map[string]*hub.Measurements),
+	}
+
+	// Create Hubs and distribute them across the regions.
+	mapIntel := &hub.Intel{
+		Hubs: make(map[string]*hub.HubIntel),
+	}
+	if !cfg.randomStates {
+		mapIntel = nil
+	}
+	regionMembers := make([][]string, cfg.regions)
+	hubs := make([]*hub.Hub, 0, cfg.hubs)
+	for i := 0; i < cfg.hubs; i++ {
+		h := createFakeHub(fmt.Sprintf("group-%d", i/5), cfg.randomStates, mapIntel)
+		hubs = append(hubs, h)
+
+		regionIndex := -1
+		if cfg.regions > 0 && i%5 != 4 {
+			regionIndex = i % cfg.regions
+			regionMembers[regionIndex] = append(regionMembers[regionIndex], "+ "+h.Info.IPv4.String())
+		}
+		sim.region[h.ID] = regionIndex
+	}
+	if mapIntel == nil {
+		mapIntel = &hub.Intel{}
+	}
+
+	// Configure regions like in the production intel data.
+	for i, members := range regionMembers {
+		mapIntel.Regions = append(mapIntel.Regions, &hub.RegionConfig{
+			ID:                      fmt.Sprintf("region-%d", i),
+			Name:                    fmt.Sprintf("Region %d", i),
+			MemberPolicy:            members,
+			RegionalMinLanes:        5,
+			RegionalMinLanesPerHub:  0.7,
+			RegionalMaxLanesOnHub:   2,
+			SatelliteMinLanes:       2,
+			SatelliteMinLanesPerHub: 0.3,
+			InternalMinLanesOnHub:   3,
+			InternalMaxHops:         3,
+		})
+	}
+	if err := mapIntel.ParseAdvisories(); err != nil {
+		panic(err)
+	}
+
+	// Connect every Hub to a random previous Hub.
+	for i := 1; i < len(hubs); i++ {
+		sim.connect(hubs[i], hubs[sim.rng.Intn(i)])
+	}
+
+	// Create map and add Pins.
+	sim.m = NewMap(fmt.Sprintf("Simulation-%s", cfg.name), true)
+	sim.m.intel = mapIntel
+	for _, h := range hubs {
+		sim.m.UpdateHub(h)
+	}
+	sim.m.updateRegions(mapIntel.Regions)
+
+	return sim
+}
+
+// latencyBetween returns a synthetic latency between two Hubs based on their
+// regions.
+func (sim *simulation) latencyBetween(a, b string) time.Duration {
+	latencyRange := sim.cfg.crossRegionalLatency
+	if sim.region[a] >= 0 && sim.region[a] == sim.region[b] {
+		latencyRange = sim.cfg.regionalLatency
+	}
+	return latencyRange[0] + time.Duration(sim.rng.Int63n(int64(latencyRange[1]-latencyRange[0])+1))
+}
+
+// getMeasurements returns the measurements between two Hubs.
+func (sim *simulation) getMeasurements(a, b string) *hub.Measurements {
+	if a == b {
+		return nil
+	}
+	id := a + "-" + b
+	if strings.Compare(a, b) > 0 {
+		id = b + "-" + a
+	}
+
+	m, ok := sim.measurements[id]
+	if ok {
+		return m
+	}
+
+	m = hub.NewMeasurements()
+	m.Latency = sim.latencyBetween(a, b)
+	m.Capacity = createCapacity()
+	m.CalculatedCost = CalculateLaneCost(m.Latency, m.Capacity)
+	sim.measurements[id] = m
+	return m
+}
+
+// connect adds Lanes in both directions between the given Hubs.
+func (sim *simulation) connect(a, b *hub.Hub) {
+	measurements := sim.getMeasurements(a.ID, b.ID)
+	_ = a.AddLane(&hub.Lane{ID: b.ID, Latency: measurements.Latency, Capacity: measurements.Capacity})
+	_ = b.AddLane(&hub.Lane{ID: a.ID, Latency: measurements.Latency, Capacity: measurements.Capacity})
+}
+
+// run runs optimization rounds until no more Lanes are suggested and reports
+// on the resulting network.
+func (sim *simulation) run(t *testing.T) *simulationReport {
+	t.Helper()
+
+	report := &simulationReport{}
+	m := sim.m
+	pins := m.sortedPins(true)
+
+	for report.rounds < sim.cfg.maxRounds {
+		report.rounds++
+		lanesAddedInRound := 0
+		done := true
+
+		for _, pin := range pins {
+			// Optimize from the view of this Pin.
+			if !m.SetHome(pin.Hub.ID, nil) {
+				t.Fatal("failed to set home")
+			}
+			for _, peer := range pins {
+				peer.measurements = sim.getMeasurements(pin.Hub.ID, peer.Hub.ID)
+			}
+			result, err := m.optimize(m.defaultOptions())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Purpose != OptimizePurposeTargetStructure {
+				done = false
+			}
+
+			// Apply suggested Lanes.
+			lanesCreated := 0
+			for _, connectTo := range result.SuggestedConnections {
+				if pin.Hub.GetLaneTo(connectTo.Hub.ID) != nil {
+					continue
+				}
+				sim.connect(pin.Hub, connectTo.Hub)
+				m.UpdateHub(pin.Hub)
+				m.UpdateHub(connectTo.Hub)
+
+				// Only create as many lanes as suggested by the result.
+				lanesCreated++
+				if lanesCreated >= result.MaxConnect {
+					break
+				}
+			}
+			if lanesCreated > 0 {
+				done = false
+			}
+			lanesAddedInRound += lanesCreated
+		}
+
+		report.lanesAdded += lanesAddedInRound
+		t.Logf("simulation %s: added %d lanes in round #%d", sim.cfg.name, lanesAddedInRound, report.rounds)
+		if done {
+			report.converged = true
+			break
+		}
+	}
+
+	// Analyze resulting network.
+	graph := sim.graph(nil)
+	report.hubs = len(graph)
+	report.diameter, report.reachable = graph.diameterAndReachability()
+	report.minLanes, report.avgLanes, report.maxLanes = graph.laneStats()
+	report.articulated = graph.articulationPoints()
+
+	// Analyze network with failed Hubs.
+	if sim.cfg.failureRate > 0 {
+		failed := make(map[string]struct{})
+		ids := make([]string, 0, len(graph))
+		for id := range graph {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		sim.rng.Shuffle(len(ids), func(i, j int) { ids[i], ids[j] = ids[j], ids[i] })
+		for _, id := range ids[:int(float64(len(ids))*sim.cfg.failureRate)] {
+			failed[id] = struct{}{}
+		}
+		report.failed = len(failed)
+		report.diameterAfterFail, report.reachableAfterFail = sim.graph(failed).diameterAndReachability()
+	}
+
+	return report
+}
+
+// simulationGraph is an adjacency list of the usable Hubs of a simulation.
+type simulationGraph map[string][]string
+
+// graph returns the graph of all Hubs that are not disregarded or failed.
+func (sim *simulation) graph(failed map[string]struct{}) simulationGraph {
+	usable := func(pin *Pin) bool {
+		_, isFailed := failed[pin.Hub.ID]
+		return !isFailed && !pin.State.HasAnyOf(StateSummaryDisregard)
+	}
+
+	graph := make(simulationGraph)
+	for _, pin := range sim.m.sortedPins(true) {
+		if !usable(pin) {
+			continue
+		}
+		peers := make([]string, 0, len(pin.ConnectedTo))
+		for _, lane := range pin.ConnectedTo {
+			if usable(lane.Pin) {
+				peers = append(peers, lane.Pin.Hub.ID)
+			}
+		}
+		sort.Strings(peers)
+		graph[pin.Hub.ID] = peers
+	}
+	return graph
+}
+
+// diameterAndReachability returns the longest shortest path in hops and the
+// fraction of Hub pairs that can reach each other.
+func (g simulationGraph) diameterAndReachability() (diameter int, reachable float64) {
+	if len(g) < 2 {
+		return 0, 1
+	}
+
+	var reachablePairs int
+	for start := range g {
+		distances := map[string]int{start: 0}
+		queue := []string{start}
+		for len(queue) > 0 {
+			current := queue[0]
+			queue = queue[1:]
+			for _, peer := range g[current] {
+				if _, ok := distances[peer]; !ok {
+					distances[peer] = distances[current] + 1
+					queue = append(queue, peer)
+					if distances[peer] > diameter {
+						diameter = distances[peer]
+					}
+				}
+			}
+		}
+		reachablePairs += len(distances) - 1
+	}
+
+	return diameter, float64(reachablePairs) / float64(len(g)*(len(g)-1))
+}
+
+// laneStats returns the minimum, average and maximum Lanes per Hub.
+func (g simulationGraph) laneStats() (minLanes int, avgLanes float64, maxLanes int) {
+	if len(g) == 0 {
+		return 0, 0, 0
+	}
+
+	minLanes = len(g)
+	var total int
+	for _, peers := range g {
+		total += len(peers)
+		if len(peers) < minLanes {
+			minLanes = len(peers)
+		}
+		if len(peers) > maxLanes {
+			maxLanes = len(peers)
+		}
+	}
+	return minLanes, float64(total) / float64(len(g)), maxLanes
+}
+
+// articulationPoints returns the number of Hubs whose failure alone would
+// split the network.
+func (g simulationGraph) articulationPoints() int {
+	discovered := make(map[string]int, len(g))
+	low := make(map[string]int, len(g))
+	points := make(map[string]struct{})
+	var counter int
+
+	var visit func(id, parent string)
+	visit = func(id, parent string) {
+		counter++
+		discovered[id] = counter
+		low[id] = counter
+		children := 0
+
+		for _, peer := range g[id] {
+			switch {
+			case peer == parent:
+				continue
+			case discovered[peer] > 0:
+				if discovered[peer] < low[id] {
+					low[id] = discovered[peer]
+				}
+			default:
+				children++
+				visit(peer, id)
+				if low[peer] < low[id] {
+					low[id] = low[peer]
+				}
+				if parent != "" && low[peer] >= discovered[id] {
+					points[id] = struct{}{}
+				}
+			}
+		}
+
+		if parent == "" && children > 1 {
+			points[id] = struct{}{}
+		}
+	}
+
+	ids := make([]string, 0, len(g))
+	for id := range g {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if discovered[id] == 0 {
+			visit(id, "")
+		}
+	}
+
+	return len(points)
+}
+
+func TestSimulateOptimization(t *testing.T) { //nolint:paralleltest // Simulations hold the fake lock for a long time.
+	if testing.Short() {
+		t.Skip("skipping optimization simulation in short mode")
+	}
+
+	for _, cfg := range []*simulationConfig{
+		{
+			name:                 "flat",
+			seed:                 10,
+			hubs:                 60,
+			regionalLatency:      [2]time.Duration{10 * time.Millisecond, 100 * time.Millisecond},
+			crossRegionalLatency: [2]time.Duration{10 * time.Millisecond, 100 * time.Millisecond},
+			maxRounds:            50,
+		},
+		{
+			name:                 "regions",
+			seed:                 11,
+			hubs:                 100,
+			regions:              3,
+			regionalLatency:      [2]time.Duration{5 * time.Millisecond, 30 * time.Millisecond},
+			crossRegionalLatency: [2]time.Duration{60 * time.Millisecond, 250 * time.Millisecond},
+			maxRounds:            50,
+		},
+		{
+			name:                 "failures",
+			seed:                 12,
+			hubs:                 100,
+			regions:              2,
+			regionalLatency:      [2]time.Duration{5 * time.Millisecond, 30 * time.Millisecond},
+			crossRegionalLatency: [2]time.Duration{60 * time.Millisecond, 250 * time.Millisecond},
+			randomStates:         true,
+			failureRate:          0.1,
+			maxRounds:            50,
+		},
+	} {
+		cfg := cfg
+		cfg.hubs *= *simulationScale
+
+		t.Run(cfg.name, func(t *testing.T) { //nolint:paralleltest // Simulations hold the fake lock for a long time.
+			fakeLock.Lock()
+			defer fakeLock.Unlock()
+
+			sim := newSimulation(cfg)
+			defer sim.m.Close()
+			report := sim.run(t)
+
+			t.Logf("simulation %s: %s", cfg.name, report)
+			if !report.converged {
+				t.Errorf("simulation %s did not converge within %d rounds", cfg.name, cfg.maxRounds)
+			}
+			// Disregarded Hubs may segregate the network until the next
+			// desegregation attempt, so only check networks without them.
+			if !cfg.randomStates && report.reachable < 1 {
+				t.Errorf("simulation %s resulted in a segregated network", cfg.name)
+			}
+		})
+	}
+}
@@ -0,0 +1,301 @@
+package navigator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tevino/abool"
+
+	"github.com/safing/portmaster/intel"
+	"github.com/safing/portmaster/intel/geoip"
+	"github.com/safing/spn/hub"
+)
+
+// MapSnapshotVersion is the current version of the map snapshot format.
+const MapSnapshotVersion = 1
+
+// MapSnapshot holds all data of a Map needed to reproduce routing and
+// optimization offline.
+type MapSnapshot struct {
+	Version int
+	Name    string
+	Created time.Time
+
+	// Home is the ID of the Home Hub, if set.
+	Home  string `json:",omitempty"`
+	Intel *hub.Intel
+	Pins  []*PinSnapshot
+}
+
+// PinSnapshot holds the data of a Pin in a MapSnapshot.
+type PinSnapshot struct {
+	ID            string
+	Info          *hub.Announcement
+	Status        *hub.Status
+	FirstSeen     time.Time
+	VerifiedIPs   bool
+	InvalidInfo   bool
+	InvalidStatus bool
+
+	EntityV4   *intel.Entity   `json:",omitempty"`
+	EntityV6   *intel.Entity   `json:",omitempty"`
+	LocationV4 *geoip.Location `json:",omitempty"`
+	LocationV6 *geoip.Location `json:",omitempty"`
+
+	State         PinState
+	States        []string // Human readable version of State.
+	VerifiedOwner string   `json:",omitempty"`
+	HopDistance   int
+	Cost          float32
+	FailingUntil  time.Time
+	Measurements  *hub.Measurements `json:",omitempty"`
+
+	ConnectedTo []*LaneSnapshot
+	// Route holds the route of the active connection to the Hub, if any.
+	// It is only informational and is not restored.
+	Route []string `json:",omitempty"`
+}
+
+// LaneSnapshot holds the data of a Lane in a MapSnapshot.
+type LaneSnapshot struct {
+	HubID    string
+	Capacity int
+	Latency  time.Duration
+	Jitter   time.Duration `json:",omitempty"`
+	Loss     float32       `json:",omitempty"`
+	Cost     float32
+}
+
+// Snapshot returns a snapshot of the Map.
+func (m *Map) Snapshot() *MapSnapshot {
+	m.RLock()
+	defer m.RUnlock()
+
+	snapshot := &MapSnapshot{
+		Version: MapSnapshotVersion,
+		Name:    m.Name,
+		Created: time.Now(),
+		Intel:   m.intel,
+		Pins:    make([]*PinSnapshot, 0, len(m.all)),
+	}
+	if m.home != nil {
+		snapshot.Home = m.home.Hub.ID
+	}
+
+	for _, pin := range m.sortedPins(false) {
+		snapshot.Pins = append(snapshot.Pins, pin.snapshot())
+	}
+
+	return snapshot
+}
+
+func (pin *Pin) snapshot() *PinSnapshot {
+	pin.Lock()
+	defer pin.Unlock()
+
+	snapshot := &PinSnapshot{
+		ID:            pin.Hub.ID,
+		Info:          pin.Hub.Info,
+		Status:        pin.Hub.Status,
+		FirstSeen:     pin.Hub.FirstSeen,
+		VerifiedIPs:   pin.Hub.VerifiedIPs,
+		InvalidInfo:   pin.Hub.InvalidInfo,
+		InvalidStatus: pin.Hub.InvalidStatus,
+		EntityV4:      pin.EntityV4,
+		EntityV6:      pin.EntityV6,
+		LocationV4:    pin.LocationV4,
+		LocationV6:    pin.LocationV6,
+		State:         pin.State,
+		States:        pin.State.Export(),
+		VerifiedOwner: pin.VerifiedOwner,
+		HopDistance:   pin.HopDistance,
+		Cost:          pin.Cost,
+		FailingUntil:  pin.FailingUntil,
+		ConnectedTo:   make([]*LaneSnapshot, 0, len(pin.ConnectedTo)),
+	}
+	if pin.measurements != nil {
+		snapshot.Measurements = copyMeasurements(pin.measurements)
+	}
+
+	// Add lanes.
+	for _, lane := range pin.ConnectedTo {
+		snapshot.ConnectedTo = append(snapshot.ConnectedTo, &LaneSnapshot{
+			HubID:    lane.Pin.Hub.ID,
+			Capacity: lane.Capacity,
+			Latency:  lane.Latency,
+			Jitter:   lane.Jitter,
+			Loss:     lane.Loss,
+			Cost:     lane.Cost,
+		})
+	}
+
+	// Add route of active connection.
+	if pin.Connection != nil && pin.Connection.Route != nil {
+		snapshot.Route = make([]string, len(pin.Connection.Route.Path))
+		for key, hop := range pin.Connection.Route.Path {
+			snapshot.Route[key] = hop.HubID
+		}
+	}
+
+	return snapshot
+}
+
+// ParseMapSnapshot parses a map snapshot in the JSON format.
+func ParseMapSnapshot(data []byte) (*MapSnapshot, error) {
+	snapshot := &MapSnapshot{}
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		return nil, fmt.Errorf("failed to parse map snapshot: %w", err)
+	}
+
+	// Check version.
+	if snapshot.Version != MapSnapshotVersion {
+		return nil, fmt.Errorf("unsupported map snapshot version %d", snapshot.Version)
+	}
+
+	// Parse intel advisories, as they are not part of the snapshot.
+	if snapshot.Intel != nil {
+		if err := snapshot.Intel.ParseAdvisories(); err != nil {
+			return nil, fmt.Errorf("failed to parse map snapshot intel: %w", err)
+		}
+	}
+
+	return snapshot, nil
+}
+
+// LoadSnapshot loads the given snapshot into the Map. The Map must be empty.
+// All Pin data, including states, is restored as it was when the snapshot was
+// created, so that routing and optimization work exactly like on the Map the
+// snapshot was created from.
+// Active connections to Hubs cannot be restored.
+func (m *Map) LoadSnapshot(snapshot *MapSnapshot) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if len(m.all) > 0 {
+		return errors.New("map is not empty")
+	}
+	if snapshot.Intel != nil && snapshot.Intel.Parsed() == nil {
+		return errors.New("intel data is not parsed")
+	}
+
+	// Create Pins.
+	for _, pinSnapshot := range snapshot.Pins {
+		if pinSnapshot.Info == nil || pinSnapshot.Status == nil {
+			return fmt.Errorf("pin %s is missing hub data", pinSnapshot.ID)
+		}
+		if err := pinSnapshot.Info.ParsePolicies(); err != nil {
+			return fmt.Errorf("pin %s has invalid policies: %w", pinSnapshot.ID, err)
+		}
+
+		pin := &Pin{
+			Hub: &hub.Hub{
+				ID:            pinSnapshot.ID,
+				Map:           m.Name,
+				Info:          pinSnapshot.Info,
+				Status:        pinSnapshot.Status,
+				FirstSeen:     pinSnapshot.FirstSeen,
+				VerifiedIPs:   pinSnapshot.VerifiedIPs,
+				InvalidInfo:   pinSnapshot.InvalidInfo,
+				InvalidStatus: pinSnapshot.InvalidStatus,
+			},
+			EntityV4:      restoreSnapshotEntity(pinSnapshot.EntityV4),
+			EntityV6:      restoreSnapshotEntity(pinSnapshot.EntityV6),
+			LocationV4:    pinSnapshot.LocationV4,
+			LocationV6:    pinSnapshot.LocationV6,
+			State:         pinSnapshot.State,
+			VerifiedOwner: pinSnapshot.VerifiedOwner,
+			HopDistance:   pinSnapshot.HopDistance,
+			Cost:          pinSnapshot.Cost,
+			FailingUntil:  pinSnapshot.FailingUntil,
+			ConnectedTo:   make(map[string]*Lane, len(pinSnapshot.ConnectedTo)),
+			pushChanges:   abool.New(),
+		}
+		// Use measurements of the snapshot without sharing them with other
+		// maps, so that the loaded map does not influence the running system.
+		switch {
+		case pinSnapshot.Measurements != nil:
+			pin.measurements = copyMeasurements(pinSnapshot.Measurements)
+		case m.measuringEnabled:
+			pin.measurements = hub.NewMeasurements()
+		}
+		m.all[pin.Hub.ID] = pin
+	}
+
+	// Connect Lanes.
+	for _, pinSnapshot := range snapshot.Pins {
+		pin := m.all[pinSnapshot.ID]
+		for _, laneSnapshot := range pinSnapshot.ConnectedTo {
+			peer, ok := m.all[laneSnapshot.HubID]
+			if !ok {
+				return fmt.Errorf("lane of pin %s connects to unknown pin %s", pinSnapshot.ID, laneSnapshot.HubID)
+			}
+			pin.ConnectedTo[peer.Hub.ID] = &Lane{
+				Pin:      peer,
+				Capacity: laneSnapshot.Capacity,
+				Latency:  laneSnapshot.Latency,
+				Jitter:   laneSnapshot.Jitter,
+				Loss:     laneSnapshot.Loss,
+				Cost:     laneSnapshot.Cost,
+				active:   true,
+			}
+		}
+	}
+
+	// Set intel and regions.
+	m.intel = snapshot.Intel
+	if m.intel != nil {
+		m.updateRegions(m.intel.Regions)
+	}
+
+	// Set home.
+	if snapshot.Home != "" {
+		home, ok := m.all[snapshot.Home]
+		if !ok {
+			return fmt.Errorf("home pin %s not found", snapshot.Home)
+		}
+		m.home = home
+	}
+
+	return nil
+}
+
+// restoreSnapshotEntity prepares an entity from a snapshot for usage.
+// The entity would otherwise fetch the location data from the local geoip
+// database on first use and overwrite the location data of the snapshot.
+func restoreSnapshotEntity(snapshotEntity *intel.Entity) *intel.Entity {
+	if snapshotEntity == nil {
+		return nil
+	}
+
+	// Complete the location fetching while no IP is set, so that it is skipped
+	// without a lookup and does not run again when the entity is used.
+	entity := &intel.Entity{}
+	entity.GetLocation(context.TODO())
+
+	// Restore the data of the snapshot.
+	entity.IP = snapshotEntity.IP
+	entity.IPScope = snapshotEntity.IPScope
+	entity.Country = snapshotEntity.Country
+	entity.Coordinates = snapshotEntity.Coordinates
+	entity.ASN = snapshotEntity.ASN
+	entity.ASOrg = snapshotEntity.ASOrg
+	entity.LocationError = snapshotEntity.LocationError
+
+	return entity
+}
+
+// copyMeasurements returns a full copy of the given measurements, including
+// the measurement times, jitter, loss and the geo proximity.
+func copyMeasurements(measurements *hub.Measurements) *hub.Measurements {
+	copied := hub.NewMeasurements()
+	copied.Latency, copied.LatencyMeasuredAt = measurements.GetLatency()
+	copied.Jitter = measurements.GetJitter()
+	copied.Loss = measurements.GetLoss()
+	copied.Capacity, copied.CapacityMeasuredAt = measurements.GetCapacity()
+	copied.CalculatedCost = measurements.GetCalculatedCost()
+	copied.GeoProximity = measurements.GetGeoProximity()
+	return copied
+}
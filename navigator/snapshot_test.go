@@ -0,0 +1,118 @@
+package navigator
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/safing/portmaster/intel"
+)
+
+func TestMapSnapshot(t *testing.T) {
+	t.Parallel()
+
+	// Create map and lock faking in order to guarantee reproducability of faked data.
+	m := getOptimizedDefaultTestMap(t)
+	fakeLock.Lock()
+	defer fakeLock.Unlock()
+
+	// Create snapshot and load it into a new map.
+	data, err := json.Marshal(m.Snapshot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := ParseMapSnapshot(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewMap("SnapshotTest", false)
+	defer loaded.Close()
+	if err := loaded.LoadSnapshot(snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	// Compare maps.
+	m.RLock()
+	defer m.RUnlock()
+	if len(loaded.all) != len(m.all) {
+		t.Fatalf("loaded map has %d pins, expected %d", len(loaded.all), len(m.all))
+	}
+	if loaded.home == nil || loaded.home.Hub.ID != m.home.Hub.ID {
+		t.Fatal("home hub was not restored")
+	}
+	for id, pin := range m.all {
+		loadedPin := loaded.all[id]
+		switch {
+		case loadedPin == nil:
+			t.Fatalf("pin %s is missing", id)
+		case loadedPin.State != pin.State:
+			t.Errorf("pin %s has states %s, expected %s", id, loadedPin.State, pin.State)
+		case loadedPin.HopDistance != pin.HopDistance:
+			t.Errorf("pin %s has hop distance %d, expected %d", id, loadedPin.HopDistance, pin.HopDistance)
+		case len(loadedPin.ConnectedTo) != len(pin.ConnectedTo):
+			t.Errorf("pin %s has %d lanes, expected %d", id, len(loadedPin.ConnectedTo), len(pin.ConnectedTo))
+		}
+	}
+
+	// Check that routing works the same on the loaded map.
+	for i := 0; i < 10; i++ {
+		_, loc4 := createGoodIP(i%2 == 0)
+		nbPins, err := m.findNearestPins(loc4, nil, m.defaultOptions(), DestinationHub, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		loadedNbPins, err := loaded.findNearestPins(loc4, nil, loaded.defaultOptions(), DestinationHub, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		routes, err := m.findRoutes(nbPins, m.defaultOptions())
+		if err != nil {
+			continue
+		}
+		loadedRoutes, err := loaded.findRoutes(loadedNbPins, loaded.defaultOptions())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(loadedRoutes.All) != len(routes.All) ||
+			lowestTotalCost(loadedRoutes) != lowestTotalCost(routes) {
+			t.Errorf("loaded map found different routes for %v", loc4.Coordinates)
+		}
+	}
+}
+
+func TestRestoreSnapshotEntity(t *testing.T) {
+	t.Parallel()
+
+	snapshotEntity := &intel.Entity{
+		Country: "AT",
+		ASN:     1,
+		ASOrg:   "Test",
+	}
+	snapshotEntity.SetIP(net.ParseIP("1.1.1.1"))
+
+	// The location data of the snapshot must be used without a geoip lookup.
+	entity := restoreSnapshotEntity(snapshotEntity)
+	if !entity.IP.Equal(snapshotEntity.IP) || entity.IPScope != snapshotEntity.IPScope {
+		t.Errorf("IP was not restored: %s %d", entity.IP, entity.IPScope)
+	}
+	if country, ok := entity.GetCountry(context.Background()); !ok || country != "AT" {
+		t.Errorf("unexpected country %q", country)
+	}
+	if asn, ok := entity.GetASN(context.Background()); !ok || asn != 1 {
+		t.Errorf("unexpected ASN %d", asn)
+	}
+	if _, ok := entity.GetLocation(context.Background()); ok {
+		t.Error("entity should not have looked up the location")
+	}
+}
+
+func lowestTotalCost(routes *Routes) float32 {
+	lowest := routes.All[0].TotalCost
+	for _, route := range routes.All {
+		if route.TotalCost < lowest {
+			lowest = route.TotalCost
+		}
+	}
+	return lowest
+}
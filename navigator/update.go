@@ -181,7 +181,7 @@ func (m *Map) updateHub(h *hub.Hub, lockMap, lockHub bool) {
 	m.updateInfoOverrides(pin)
 
 	// Update Hub cost.
-	pin.Cost = CalculateHubCost(pin.Hub.Status.Load)
+	pin.Cost = m.getCostModel().HubCost(pin.Hub)
 
 	// Ensure measurements are set when enabled.
 	if m.measuringEnabled && pin.measurements == nil {
@@ -189,9 +189,7 @@ func (m *Map) updateHub(h *hub.Hub, lockMap, lockHub bool) {
 		pin.measurements = pin.Hub.GetMeasurementsWithLockedHub()
 
 		// Update cost calculation.
-		latency, _ := pin.measurements.GetLatency()
-		capacity, _ := pin.measurements.GetCapacity()
-		pin.measurements.SetCalculatedCost(CalculateLaneCost(latency, capacity))
+		pin.measurements.SetCalculatedCost(m.getCostModel().LaneCost(measuredLaneMetrics(pin.measurements)))
 
 		// Update geo proximity.
 		// Get own location.
@@ -367,14 +365,32 @@ func (m *Map) updateHubLane(pin *Pin, lane *hub.Lane, peer *Pin) {
 		combinedCapacity = maxUnconfirmedCapacity
 	}
 
+	// Calculate combined jitter and loss, use the greater value.
+	// Older Hubs do not publish these values, so no minimum is enforced.
+	combinedJitter := lane.Jitter
+	if peerLane.Jitter > combinedJitter {
+		combinedJitter = peerLane.Jitter
+	}
+	combinedLoss := lane.Loss
+	if peerLane.Loss > combinedLoss {
+		combinedLoss = peerLane.Loss
+	}
+
 	// Calculate lane cost.
-	laneCost := CalculateLaneCost(combinedLatency, combinedCapacity)
+	laneCost := m.getCostModel().LaneCost(LaneMetrics{
+		Latency:  combinedLatency,
+		Capacity: combinedCapacity,
+		Jitter:   combinedJitter,
+		Loss:     combinedLoss,
+	})
 
 	// Add Lane to both Pins and override old values in the process.
 	pin.ConnectedTo[peer.Hub.ID] = &Lane{
 		Pin:      peer,
 		Capacity: combinedCapacity,
 		Latency:  combinedLatency,
+		Jitter:   combinedJitter,
+		Loss:     combinedLoss,
 		Cost:     laneCost,
 		active:   true,
 	}
@@ -382,6 +398,8 @@ func (m *Map) updateHubLane(pin *Pin, lane *hub.Lane, peer *Pin) {
 		Pin:      pin,
 		Capacity: combinedCapacity,
 		Latency:  combinedLatency,
+		Jitter:   combinedJitter,
+		Loss:     combinedLoss,
 		Cost:     laneCost,
 		active:   true,
 	}
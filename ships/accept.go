@@ -0,0 +1,61 @@
+package ships
+
+import (
+	"net"
+	"sync"
+
+	"github.com/safing/portbase/log"
+)
+
+var (
+	acceptCheck     func(remoteAddr net.Addr) error
+	acceptCheckLock sync.RWMutex
+)
+
+// SetAcceptCheck sets a check that piers run for every new connection before
+// any handshake. Connections are closed if the check returns an error.
+// The check is called at a high rate and must not block.
+func SetAcceptCheck(check func(remoteAddr net.Addr) error) {
+	acceptCheckLock.Lock()
+	defer acceptCheckLock.Unlock()
+
+	acceptCheck = check
+}
+
+// checkAccept runs the accept check, if set.
+func checkAccept(remoteAddr net.Addr) error {
+	acceptCheckLock.RLock()
+	check := acceptCheck
+	acceptCheckLock.RUnlock()
+
+	if check == nil {
+		return nil
+	}
+	return check(remoteAddr)
+}
+
+// checkedListener is a listener that closes accepted connections that do not
+// pass the accept check.
+type checkedListener struct {
+	net.Listener
+}
+
+// Accept waits for and returns the next connection that passes the accept
+// check.
+func (l *checkedListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := checkAccept(conn.RemoteAddr()); err != nil {
+			// Only log on trace level, as this may be triggered at a high rate.
+			log.Tracef("spn/ships: listener at %s rejected connection from %s: %s", l.Addr(), conn.RemoteAddr(), err)
+			_ = conn.Close()
+			continue
+		}
+
+		return conn, nil
+	}
+}
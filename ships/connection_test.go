@@ -2,8 +2,13 @@ package ships
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"net"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
 	"sync"
 	"testing"
 
@@ -30,6 +35,23 @@ func getTestBuf() []byte {
 func TestConnections(t *testing.T) {
 	t.Parallel()
 
+	// Create a Hub identity for transports that authenticate the Hub.
+	signet, recipient, err := hub.CreateHubSignet("Ed25519", 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SetIdentity(signet); err != nil {
+		t.Fatal(err)
+	}
+	testHub := &hub.Hub{
+		ID:        signet.ID,
+		PublicKey: recipient,
+	}
+
+	// Secure WebSockets are terminated by a reverse proxy in front of the pier.
+	wssPierPort := getTestPort()
+	wssProxy := startTLSTerminatingProxy(t, wssPierPort)
+
 	registryLock.Lock()
 	t.Cleanup(func() {
 		registryLock.Unlock()
@@ -49,9 +71,18 @@ func TestConnections(t *testing.T) {
 				Protocol: protocol,
 				Port:     getTestPort(),
 			}
+			pierTransport := transport
+			if protocol == "wss" {
+				// Ships connect to the proxy, which forwards to the pier.
+				transport.Port = wssProxy
+				pierTransport = &hub.Transport{
+					Protocol: protocol,
+					Port:     wssPierPort,
+				}
+			}
 
 			// create listener
-			pier, err := builder.EstablishPier(transport, requests)
+			pier, err := builder.EstablishPier(pierTransport, requests)
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -64,7 +95,7 @@ func TestConnections(t *testing.T) {
 			}()
 
 			// connect to listener
-			ship, err := builder.LaunchShip(ctx, transport, localhost)
+			ship, err := builder.LaunchShip(ctx, testHub, transport, localhost)
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -146,3 +177,28 @@ func TestConnections(t *testing.T) {
 		})
 	}
 }
+
+// startTLSTerminatingProxy starts a reverse proxy that terminates TLS and
+// forwards requests to the given local port. Secure WebSocket ships are
+// configured to trust the proxy. It returns the port of the proxy.
+func startTLSTerminatingProxy(t *testing.T, port uint16) uint16 {
+	t.Helper()
+
+	proxy := httptest.NewTLSServer(httputil.NewSingleHostReverseProxy(&url.URL{
+		Scheme: "http",
+		Host:   net.JoinHostPort(localhost.String(), portToA(port)),
+	}))
+	t.Cleanup(proxy.Close)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(proxy.Certificate())
+	websocketTLSConfig = &tls.Config{
+		RootCAs:    roots,
+		MinVersion: tls.VersionTLS12,
+	}
+	t.Cleanup(func() {
+		websocketTLSConfig = nil
+	})
+
+	return uint16(proxy.Listener.Addr().(*net.TCPAddr).Port)
+}
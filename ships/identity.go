@@ -0,0 +1,45 @@
+package ships
+
+import (
+	"crypto/tls"
+	"sync"
+
+	"github.com/safing/jess"
+)
+
+var (
+	identityLock   sync.Mutex
+	identityHubID  string
+	tlsCertificate *tls.Certificate
+)
+
+// SetIdentity sets the Hub identity that piers use to authenticate and
+// identify themselves. The signet must hold the private identity key of the
+// Hub.
+func SetIdentity(signet *jess.Signet) error {
+	cert, err := createTLSCertificate(signet)
+	if err != nil {
+		return err
+	}
+
+	identityLock.Lock()
+	defer identityLock.Unlock()
+
+	identityHubID = signet.ID
+	tlsCertificate = cert
+	return nil
+}
+
+func getIdentityHubID() string {
+	identityLock.Lock()
+	defer identityLock.Unlock()
+
+	return identityHubID
+}
+
+func getTLSCertificate() *tls.Certificate {
+	identityLock.Lock()
+	defer identityLock.Unlock()
+
+	return tlsCertificate
+}
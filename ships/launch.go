@@ -96,11 +96,25 @@ func connectTo(ctx context.Context, h *hub.Hub, transport *hub.Transport, ip net
 	if builder == nil {
 		return nil, fmt.Errorf("protocol %s not supported", transport.Protocol)
 	}
+	obfsOpts, err := ParseObfuscationOptions(transport.Option)
+	if err != nil {
+		return nil, fmt.Errorf("invalid obfuscation options of %s: %w", transport, err)
+	}
 
-	ship, err := builder.LaunchShip(ctx, transport, ip)
+	ship, err := builder.LaunchShip(ctx, h, transport, ip)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to %s using %s (%s): %w", h, transport, ip, err)
 	}
 
+	// Wrap ship with obfuscation, if enabled.
+	if obfsOpts != nil {
+		obfsShip, err := NewObfuscatedShip(ship, h.ID, obfsOpts)
+		if err != nil {
+			ship.Sink()
+			return nil, fmt.Errorf("failed to obfuscate ship to %s: %w", h, err)
+		}
+		return obfsShip, nil
+	}
+
 	return ship, nil
 }
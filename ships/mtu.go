@@ -1,47 +1,157 @@
 package ships
 
-import "net"
+import (
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
 
 // MTU Calculation Configuration.
 const (
 	BaseMTU           = 1460 // 1500 with 40 bytes extra space for special cases.
+	MinPathMTU        = 1280 // Minimum MTU of IPv6, also used as lower bound for IPv4.
+	MaxPathMTU        = 1500 // Ethernet MTU, jumbo frames are not used.
 	IPv4HeaderMTUSize = 20   // Without options, as not common.
 	IPv6HeaderMTUSize = 40   // Without options, as not common.
 	TCPHeaderMTUSize  = 60   // Maximum size with options.
 	UDPHeaderMTUSize  = 8    // Has no options.
+	TLSHeaderMTUSize  = 22   // Record header, content type and AEAD tag of TLS 1.3.
 )
 
-func (ship *ShipBase) calculateLoadSize(ip net.IP, addr net.Addr, subtract ...int) {
-	ship.loadSize = BaseMTU
+// Path MTU Discovery Configuration.
+var (
+	// kernelPathMTURecheckInterval defines how often the path MTU discovered by
+	// the kernel is read again.
+	kernelPathMTURecheckInterval = 1 * time.Minute
+
+	// pathMTUReprobeInterval defines how often ships that discover the path MTU
+	// themselves probe it again.
+	pathMTUReprobeInterval = 10 * time.Minute
+)
+
+// pathMTUSource provides the path MTU of a connection.
+type pathMTUSource interface {
+	// PathMTU returns the current path MTU, or 0 if it is unknown.
+	PathMTU() int
+}
 
+func (ship *ShipBase) calculateLoadSize(ip net.IP, addr net.Addr, subtract ...int) {
 	// Convert addr to IP if needed.
 	if ip == nil && addr != nil {
-		switch v := addr.(type) {
-		case *net.TCPAddr:
-			ip = v.IP
-		case *net.UDPAddr:
-			ip = v.IP
-		case *net.IPAddr:
-			ip = v.IP
-		}
+		ip = ipFromAddr(addr)
 	}
 
 	// Subtract IP Header, if IP is available.
+	ship.overhead = 0
 	if ip != nil {
-		if ip4 := ip.To4(); ip4 != nil {
-			ship.loadSize -= IPv4HeaderMTUSize
-		} else {
-			ship.loadSize -= IPv6HeaderMTUSize
-		}
+		ship.overhead += ipHeaderMTUSize(ip)
 	}
 
 	// Subtract others.
-	for sub := range subtract {
-		ship.loadSize -= sub
+	for _, sub := range subtract {
+		ship.overhead += sub
 	}
 
+	ship.loadSize = BaseMTU - ship.overhead
+
 	// Raise buf size to at least load size.
 	if ship.bufSize < ship.loadSize {
 		ship.bufSize = ship.loadSize
 	}
 }
+
+func ipFromAddr(addr net.Addr) net.IP {
+	switch v := addr.(type) {
+	case *net.TCPAddr:
+		return v.IP
+	case *net.UDPAddr:
+		return v.IP
+	case *net.IPAddr:
+		return v.IP
+	default:
+		return nil
+	}
+}
+
+func ipHeaderMTUSize(ip net.IP) int {
+	if ip4 := ip.To4(); ip4 != nil {
+		return IPv4HeaderMTUSize
+	}
+	return IPv6HeaderMTUSize
+}
+
+func clampPathMTU(mtu int) int {
+	switch {
+	case mtu < MinPathMTU:
+		return MinPathMTU
+	case mtu > MaxPathMTU:
+		return MaxPathMTU
+	default:
+		return mtu
+	}
+}
+
+// kernelPathMTU provides the path MTU that the kernel discovered for a
+// connection. This is used for stream based connections, as the kernel already
+// takes care of packetization.
+type kernelPathMTU struct {
+	rawConn syscall.RawConn
+	ipv6    bool
+
+	lock      sync.Mutex
+	mtu       int
+	checkedAt time.Time
+}
+
+// newKernelPathMTU returns a path MTU source for the given connection, or nil
+// if the path MTU cannot be read from the kernel.
+func newKernelPathMTU(conn net.Conn) pathMTUSource {
+	// Unwrap connection, eg. TLS.
+	for {
+		wrapped, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			break
+		}
+		conn = wrapped.NetConn()
+	}
+
+	// Get raw connection.
+	sysConn, ok := conn.(syscall.Conn)
+	if !ok {
+		return nil
+	}
+	rawConn, err := sysConn.SyscallConn()
+	if err != nil {
+		return nil
+	}
+
+	k := &kernelPathMTU{
+		rawConn: rawConn,
+	}
+	if ip := ipFromAddr(conn.RemoteAddr()); ip != nil && ip.To4() == nil {
+		k.ipv6 = true
+	}
+
+	// Check if we can read the path MTU.
+	if k.PathMTU() == 0 {
+		return nil
+	}
+	return k
+}
+
+// PathMTU returns the current path MTU, or 0 if it is unknown.
+func (k *kernelPathMTU) PathMTU() int {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	if time.Since(k.checkedAt) > kernelPathMTURecheckInterval {
+		k.checkedAt = time.Now()
+		mtu, err := getKernelPathMTU(k.rawConn, k.ipv6)
+		if err == nil && mtu > 0 {
+			k.mtu = clampPathMTU(mtu)
+		}
+	}
+
+	return k.mtu
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+
+package ships
+
+import (
+	"errors"
+	"syscall"
+)
+
+var errPathMTUNotSupported = errors.New("path mtu discovery is not supported on this platform")
+
+// getKernelPathMTU returns the path MTU the kernel knows for the connection.
+func getKernelPathMTU(_ syscall.RawConn, _ bool) (mtu int, err error) {
+	return 0, errPathMTUNotSupported
+}
+
+// setDontFragment sets the Don't Fragment flag on all outgoing packets and
+// disables the kernel's own path MTU discovery, so that the connection can
+// probe the path MTU itself.
+func setDontFragment(_ syscall.RawConn) error {
+	return errPathMTUNotSupported
+}
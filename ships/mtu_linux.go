@@ -0,0 +1,40 @@
+package ships
+
+import (
+	"syscall"
+)
+
+// getKernelPathMTU returns the path MTU the kernel knows for the connection.
+func getKernelPathMTU(rawConn syscall.RawConn, ipv6 bool) (mtu int, err error) {
+	ctrlErr := rawConn.Control(func(fd uintptr) {
+		if ipv6 {
+			mtu, err = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MTU)
+		} else {
+			mtu, err = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU)
+		}
+	})
+	if ctrlErr != nil {
+		return 0, ctrlErr
+	}
+	return mtu, err
+}
+
+// setDontFragment sets the Don't Fragment flag on all outgoing packets and
+// disables the kernel's own path MTU discovery, so that the connection can
+// probe the path MTU itself.
+func setDontFragment(rawConn syscall.RawConn) error {
+	var v4Err, v6Err error
+	ctrlErr := rawConn.Control(func(fd uintptr) {
+		v4Err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_PROBE)
+		v6Err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER, syscall.IPV6_PMTUDISC_PROBE)
+	})
+	switch {
+	case ctrlErr != nil:
+		return ctrlErr
+	case v4Err != nil && v6Err != nil:
+		return v4Err
+	default:
+		// Sockets only support the options of their address family.
+		return nil
+	}
+}
@@ -0,0 +1,329 @@
+package ships
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	mrand "math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+
+Obfuscation hides the data patterns of the crane protocol from observers, so
+that ships cannot be easily identified by protocol fingerprinting.
+
+Obfuscated Stream Format:
+
+- Seed [32 bytes; only sent by the client at the start of the stream]
+- Frames [encrypted with a key derived from the seed and the Hub ID]
+	- PayloadLength [uint16]
+	- PaddingLength [uint16]
+	- Payload [bytes]
+	- Padding [bytes]
+
+Please note that obfuscation does not provide any security: the Hub ID is
+public, so anyone can derive the key. Its only purpose is to make the stream
+look random.
+
+*/
+
+// Obfuscation Configuration.
+const (
+	obfuscationOptionKey       = "obfs"
+	obfuscationSeedSize        = 32
+	obfuscationFrameHeaderSize = 4
+	obfuscationMaxFrameSize    = 65535
+
+	defaultObfuscationMaxPadding = 256
+
+	obfuscationClientLabel = "SPN Obfuscation Client"
+	obfuscationServerLabel = "SPN Obfuscation Server"
+)
+
+// ObfuscationOptions holds the configuration of ship obfuscation.
+type ObfuscationOptions struct {
+	// MaxPadding defines the maximum amount of random padding added to every load.
+	// It is capped to a quarter of the load size of the ship.
+	MaxPadding int
+	// MaxJitter defines the maximum random delay before every load.
+	MaxJitter time.Duration
+}
+
+// ParseObfuscationOptions parses obfuscation options from a transport option.
+// It returns nil if obfuscation is not enabled.
+// Format: "obfs[,pad=<bytes>][,jitter=<duration>]".
+// Example: "obfs,pad=512,jitter=20ms".
+func ParseObfuscationOptions(option string) (*ObfuscationOptions, error) {
+	parts := strings.Split(option, ",")
+	if parts[0] != obfuscationOptionKey {
+		return nil, nil
+	}
+
+	opts := &ObfuscationOptions{
+		MaxPadding: defaultObfuscationMaxPadding,
+	}
+	for _, part := range parts[1:] {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid obfuscation option %q", part)
+		}
+
+		switch key {
+		case "pad":
+			pad, err := strconv.ParseUint(value, 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("invalid padding %q: %w", value, err)
+			}
+			opts.MaxPadding = int(pad)
+		case "jitter":
+			jitter, err := time.ParseDuration(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid jitter %q: %w", value, err)
+			}
+			if jitter < 0 || jitter > time.Second {
+				return nil, fmt.Errorf("jitter %s out of range", jitter)
+			}
+			opts.MaxJitter = jitter
+		default:
+			return nil, fmt.Errorf("unknown obfuscation option %q", key)
+		}
+	}
+
+	return opts, nil
+}
+
+// ObfuscatedShip wraps a ship in order to obfuscate its data.
+type ObfuscatedShip struct {
+	Ship
+
+	opts  *ObfuscationOptions
+	hubID string
+
+	// seed holds the seed until it is sent by the client.
+	seed []byte
+
+	writeStream cipher.Stream
+	writeRand   *mrand.Rand
+	writeLock   sync.Mutex
+
+	readStream cipher.Stream
+	unloadBuf  []byte
+}
+
+// NewObfuscatedShip wraps the given ship with obfuscation. The hub ID must be
+// the ID of the Hub that is being connected to.
+func NewObfuscatedShip(ship Ship, hubID string, opts *ObfuscationOptions) (*ObfuscatedShip, error) {
+	if hubID == "" {
+		return nil, errors.New("obfuscation requires the hub ID")
+	}
+
+	oShip := &ObfuscatedShip{
+		Ship:      ship,
+		opts:      opts,
+		hubID:     hubID,
+		writeRand: mrand.New(mrand.NewSource(time.Now().UnixNano())), //nolint:gosec // Only used for padding and jitter.
+	}
+
+	// The client chooses the seed, the server waits for it.
+	if ship.IsMine() {
+		oShip.seed = make([]byte, obfuscationSeedSize)
+		if _, err := rand.Read(oShip.seed); err != nil {
+			return nil, fmt.Errorf("failed to get seed: %w", err)
+		}
+		if err := oShip.initStreams(oShip.seed); err != nil {
+			return nil, err
+		}
+	}
+
+	return oShip, nil
+}
+
+func (ship *ObfuscatedShip) initStreams(seed []byte) error {
+	clientStream, err := obfuscationStream(obfuscationClientLabel, ship.hubID, seed)
+	if err != nil {
+		return err
+	}
+	serverStream, err := obfuscationStream(obfuscationServerLabel, ship.hubID, seed)
+	if err != nil {
+		return err
+	}
+
+	ship.writeLock.Lock()
+	defer ship.writeLock.Unlock()
+
+	if ship.IsMine() {
+		ship.writeStream, ship.readStream = clientStream, serverStream
+	} else {
+		ship.writeStream, ship.readStream = serverStream, clientStream
+	}
+	return nil
+}
+
+func obfuscationStream(label, hubID string, seed []byte) (cipher.Stream, error) {
+	hasher := sha256.New()
+	hasher.Write([]byte(label))
+	hasher.Write([]byte(hubID))
+	hasher.Write(seed)
+
+	block, err := aes.NewCipher(hasher.Sum(nil))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create obfuscation cipher: %w", err)
+	}
+	// The key is unique per seed, so a static IV is fine.
+	return cipher.NewCTR(block, make([]byte, aes.BlockSize)), nil
+}
+
+// String returns a human readable informational summary about the ship.
+func (ship *ObfuscatedShip) String() string {
+	return ship.Ship.String() + " (obfuscated)"
+}
+
+// SegmentStats returns the segment statistics of the wrapped ship.
+// Returns false if the ship does not track segments.
+func (ship *ObfuscatedShip) SegmentStats() (sent, retransmitted uint64, ok bool) {
+	if reporter, ok := ship.Ship.(SegmentStatsReporter); ok {
+		return reporter.SegmentStats()
+	}
+	return 0, 0, false
+}
+
+// LoadSize returns the recommended data size that should be handed to Load().
+// It leaves room for the seed, the frame header and the padding, so that loads
+// of this size do not exceed the load size of the underlying ship.
+func (ship *ObfuscatedShip) LoadSize() int {
+	return ship.Ship.LoadSize() - obfuscationSeedSize - obfuscationFrameHeaderSize - ship.maxPadding()
+}
+
+// maxPadding returns the maximum padding per frame, capped to a quarter of the
+// load size of the underlying ship.
+func (ship *ObfuscatedShip) maxPadding() int {
+	limit := ship.Ship.LoadSize() / 4
+	if ship.opts.MaxPadding < limit {
+		return ship.opts.MaxPadding
+	}
+	return limit
+}
+
+// Load loads data into the ship - ie. sends the data via the connection.
+// Returns ErrSunk if the ship has already sunk earlier.
+func (ship *ObfuscatedShip) Load(data []byte) error {
+	// Empty load is used as a signal to cease operation.
+	if len(data) == 0 {
+		return ship.Ship.Load(data)
+	}
+
+	ship.writeLock.Lock()
+	defer ship.writeLock.Unlock()
+
+	// The server can only send after receiving the seed.
+	if ship.writeStream == nil {
+		return errors.New("obfuscation not yet initialized")
+	}
+
+	// Delay loading by a random duration.
+	if ship.opts.MaxJitter > 0 {
+		time.Sleep(time.Duration(ship.writeRand.Int63n(int64(ship.opts.MaxJitter))))
+	}
+
+	for len(data) > 0 {
+		// Split data into frames.
+		payload := data
+		if len(payload) > obfuscationMaxFrameSize {
+			payload = payload[:obfuscationMaxFrameSize]
+		}
+		data = data[len(payload):]
+
+		// Add random padding.
+		var paddingSize int
+		if maxPadding := ship.maxPadding(); maxPadding > 0 {
+			paddingSize = ship.writeRand.Intn(maxPadding + 1)
+		}
+
+		// Build frame, prefixed by the seed for the first frame.
+		frame := make([]byte, len(ship.seed)+obfuscationFrameHeaderSize+len(payload)+paddingSize)
+		copy(frame, ship.seed)
+		body := frame[len(ship.seed):]
+		binary.BigEndian.PutUint16(body[0:2], uint16(len(payload)))
+		binary.BigEndian.PutUint16(body[2:4], uint16(paddingSize))
+		copy(body[obfuscationFrameHeaderSize:], payload)
+		// The padding is zero, but will be random after encryption.
+		ship.writeStream.XORKeyStream(body, body)
+
+		if err := ship.Ship.Load(frame); err != nil {
+			return err
+		}
+		ship.seed = nil
+	}
+
+	return nil
+}
+
+// UnloadTo unloads data from the ship - ie. receives data from the
+// connection - puts it into the buf. It returns the amount of data
+// written and an optional error.
+// Returns ErrSunk if the ship has already sunk earlier.
+func (ship *ObfuscatedShip) UnloadTo(buf []byte) (n int, err error) {
+	// Return remaining payload of the last frame first.
+	if len(ship.unloadBuf) > 0 {
+		n = copy(buf, ship.unloadBuf)
+		ship.unloadBuf = ship.unloadBuf[n:]
+		return n, nil
+	}
+
+	// Get seed from the client.
+	if ship.readStream == nil {
+		seed := make([]byte, obfuscationSeedSize)
+		if err := ship.unloadFull(seed); err != nil {
+			return 0, err
+		}
+		if err := ship.initStreams(seed); err != nil {
+			return 0, err
+		}
+	}
+
+	for {
+		// Read frame header.
+		header := make([]byte, obfuscationFrameHeaderSize)
+		if err := ship.unloadFull(header); err != nil {
+			return 0, err
+		}
+		ship.readStream.XORKeyStream(header, header)
+		payloadSize := int(binary.BigEndian.Uint16(header[0:2]))
+		paddingSize := int(binary.BigEndian.Uint16(header[2:4]))
+
+		// Read frame body.
+		body := make([]byte, payloadSize+paddingSize)
+		if err := ship.unloadFull(body); err != nil {
+			return 0, err
+		}
+		ship.readStream.XORKeyStream(body, body)
+
+		// Skip frames with only padding.
+		if payloadSize == 0 {
+			continue
+		}
+
+		n = copy(buf, body[:payloadSize])
+		ship.unloadBuf = body[n:payloadSize]
+		return n, nil
+	}
+}
+
+func (ship *ObfuscatedShip) unloadFull(buf []byte) error {
+	for offset := 0; offset < len(buf); {
+		n, err := ship.Ship.UnloadTo(buf[offset:])
+		if err != nil {
+			return err
+		}
+		offset += n
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package ships
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseObfuscationOptions(t *testing.T) {
+	t.Parallel()
+
+	opts, err := ParseObfuscationOptions("")
+	assert.NoError(t, err)
+	assert.Nil(t, opts, "obfuscation should be disabled")
+
+	opts, err = ParseObfuscationOptions("obfs")
+	assert.NoError(t, err)
+	assert.Equal(t, &ObfuscationOptions{MaxPadding: defaultObfuscationMaxPadding}, opts)
+
+	opts, err = ParseObfuscationOptions("obfs,pad=512,jitter=20ms")
+	assert.NoError(t, err)
+	assert.Equal(t, &ObfuscationOptions{MaxPadding: 512, MaxJitter: 20 * time.Millisecond}, opts)
+
+	for _, invalid := range []string{
+		"obfs,pad",
+		"obfs,pad=-1",
+		"obfs,pad=100000",
+		"obfs,jitter=1h",
+		"obfs,color=blue",
+	} {
+		_, err = ParseObfuscationOptions(invalid)
+		assert.Error(t, err, "should fail: %s", invalid)
+	}
+}
+
+func TestObfuscatedShip(t *testing.T) {
+	t.Parallel()
+
+	opts := &ObfuscationOptions{
+		MaxPadding: 100,
+		MaxJitter:  time.Millisecond,
+	}
+	hubID := "Zwtest"
+
+	a := NewTestShip(false, 1000)
+	b := a.Reverse()
+	client, err := NewObfuscatedShip(a, hubID, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := NewObfuscatedShip(b, hubID, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The server must not send before receiving the seed.
+	assert.Error(t, server.Load(testData), "server should not be able to send first")
+
+	// Client to server.
+	assert.NoError(t, client.Load(testData))
+	buf := getTestBuf()
+	if _, err := server.UnloadTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, testData, buf, "should match")
+
+	// The data on the wire must not contain the plaintext.
+	assert.NoError(t, client.Load(testData))
+	raw := <-a.forward
+	assert.False(t, bytes.Contains(raw, testData), "should be obfuscated")
+
+	// Server to client, with a payload larger than a single frame.
+	bigData := bytes.Repeat(testData, 2000)
+	assert.NoError(t, server.Load(bigData))
+	bigBuf := make([]byte, len(bigData))
+	for offset := 0; offset < len(bigBuf); {
+		n, err := client.UnloadTo(bigBuf[offset:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		offset += n
+	}
+	assert.Equal(t, bigData, bigBuf, "should match")
+}
+
+func TestObfuscatedShipLoadSize(t *testing.T) {
+	t.Parallel()
+
+	for _, maxPadding := range []int{0, 100, 65535} {
+		a := NewTestShip(false, 1000)
+		client, err := NewObfuscatedShip(a, "Zwtest", &ObfuscationOptions{
+			MaxPadding: maxPadding,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Loads of the recommended size must fit into the underlying ship,
+		// including the seed of the first frame.
+		for i := 0; i < 20; i++ {
+			assert.NoError(t, client.Load(make([]byte, client.LoadSize())))
+			if raw := <-a.forward; len(raw) > a.LoadSize() {
+				t.Errorf("frame with max padding %d has %d bytes, exceeding the load size of %d", maxPadding, len(raw), a.LoadSize())
+			}
+		}
+	}
+}
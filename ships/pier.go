@@ -7,6 +7,7 @@ import (
 
 	"github.com/tevino/abool"
 
+	"github.com/safing/portbase/log"
 	"github.com/safing/spn/hub"
 )
 
@@ -41,6 +42,13 @@ func EstablishPier(transport *hub.Transport, dockingRequests chan *DockingReques
 	if builder == nil {
 		return nil, fmt.Errorf("protocol %s not supported", transport.Protocol)
 	}
+	obfsOpts, err := ParseObfuscationOptions(transport.Option)
+	if err != nil {
+		return nil, fmt.Errorf("invalid obfuscation options of %s: %w", transport, err)
+	}
+	if obfsOpts != nil && getIdentityHubID() == "" {
+		return nil, fmt.Errorf("obfuscation of %s requires an identity", transport)
+	}
 
 	pier, err := builder.EstablishPier(transport, dockingRequests)
 	if err != nil {
@@ -93,10 +101,16 @@ func (pier *PierBase) Docking(ctx context.Context) error {
 		pier.Abolish()
 	}()
 
+	// Get obfuscation options.
+	// These have already been checked when establishing the pier.
+	obfsOpts, _ := ParseObfuscationOptions(pier.transport.Option)
+
 	for {
 		ship, err := pier.dockShip()
 		if err != nil {
-			if pier.abolishing.SetToIf(false, true) {
+			// Only report the failure if the pier was not abolished on purpose.
+			// The listener is closed by the deferred Abolish.
+			if !pier.abolishing.IsSet() {
 				// Notify higher layer, if possible.
 				select {
 				case pier.dockingRequests <- &DockingRequest{
@@ -109,6 +123,18 @@ func (pier *PierBase) Docking(ctx context.Context) error {
 			return nil
 		}
 
+		// Obfuscate ship, if enabled.
+		// A failure only affects this ship, so continue with the next one.
+		if obfsOpts != nil {
+			obfsShip, err := NewObfuscatedShip(ship, getIdentityHubID(), obfsOpts)
+			if err != nil {
+				log.Warningf("spn/ships: %s failed to obfuscate %s: %s", pier, ship, err)
+				ship.Sink()
+				continue
+			}
+			ship = obfsShip
+		}
+
 		select {
 		case <-ctx.Done():
 			return nil
@@ -0,0 +1,173 @@
+package ships
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/safing/spn/hub"
+)
+
+func TestPierDockingFailure(t *testing.T) {
+	t.Parallel()
+
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{
+		IP:   localhost,
+		Port: int(getTestPort()),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dockingRequests := make(chan *DockingRequest, 1)
+	pier := &PierBase{
+		transport:       &hub.Transport{Protocol: "tcp"},
+		listener:        listener,
+		dockingRequests: dockingRequests,
+	}
+	pier.initBase()
+	pier.dockShip = func() (Ship, error) {
+		return nil, errors.New("test failure")
+	}
+
+	// Docking must report the failure and close the listener, so that the
+	// pier can be built again on the same port.
+	_ = pier.Docking(context.Background())
+	select {
+	case r := <-dockingRequests:
+		if r.Err == nil {
+			t.Error("docking request should report the failure")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("failure was not reported")
+	}
+	relisten, err := net.ListenTCP("tcp", listener.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatalf("listener was not closed: %s", err)
+	}
+	_ = relisten.Close()
+}
+
+func TestPierAcceptCheck(t *testing.T) { //nolint:paralleltest // Sets the global accept check.
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{
+		IP:   localhost,
+		Port: int(getTestPort()),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checked := &checkedListener{listener}
+	defer func() {
+		_ = checked.Close()
+	}()
+
+	// Reject the first connection only.
+	var checks int
+	SetAcceptCheck(func(remoteAddr net.Addr) error {
+		checks++
+		if checks == 1 {
+			return errors.New("test rejection")
+		}
+		return nil
+	})
+	defer SetAcceptCheck(nil)
+
+	rejected, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = rejected.Close()
+	}()
+	admitted, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = admitted.Close()
+	}()
+
+	// Only the second connection must be returned.
+	conn, err := checked.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	if conn.RemoteAddr().String() != admitted.LocalAddr().String() {
+		t.Errorf("accepted %s instead of %s", conn.RemoteAddr(), admitted.LocalAddr())
+	}
+
+	// The first connection must be closed.
+	_ = rejected.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := rejected.Read(make([]byte, 1)); err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("rejected connection should be closed, got %v", err)
+	}
+}
+
+func TestPierObfuscationWithoutIdentity(t *testing.T) { //nolint:paralleltest // Removes the global identity.
+	// Remove the identity for this test.
+	identityLock.Lock()
+	hubID := identityHubID
+	identityHubID = ""
+	identityLock.Unlock()
+	defer func() {
+		identityLock.Lock()
+		identityHubID = hubID
+		identityLock.Unlock()
+	}()
+
+	// Establishing an obfuscated pier must fail without identity.
+	transport := &hub.Transport{
+		Protocol: "tcp",
+		Port:     getTestPort(),
+		Option:   "obfs",
+	}
+	if _, err := EstablishPier(transport, make(chan *DockingRequest)); err == nil {
+		t.Fatal("obfuscated pier should not be established without identity")
+	}
+
+	// A ship that fails to be obfuscated must be sunk without failing the pier.
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{
+		IP:   localhost,
+		Port: int(getTestPort()),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dockingRequests := make(chan *DockingRequest, 1)
+	pier := &PierBase{
+		transport:       transport,
+		listener:        listener,
+		dockingRequests: dockingRequests,
+	}
+	pier.initBase()
+	ship := NewTestShip(false, 1000).Reverse()
+	var docked int
+	pier.dockShip = func() (Ship, error) {
+		docked++
+		if docked == 1 {
+			return ship, nil
+		}
+		return nil, errors.New("test end")
+	}
+
+	_ = pier.Docking(context.Background())
+	if docked != 2 {
+		t.Errorf("pier should continue docking after a failed ship, docked %d", docked)
+	}
+	if !ship.sinking.IsSet() {
+		t.Error("ship that failed to be obfuscated should be sunk")
+	}
+	select {
+	case r := <-dockingRequests:
+		if r.Ship != nil {
+			t.Error("ship that failed to be obfuscated should not be docked")
+		}
+	default:
+		t.Error("end of docking should be reported")
+	}
+}
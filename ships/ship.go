@@ -70,6 +70,22 @@ type Ship interface {
 	Mask(value []byte) string
 }
 
+// SegmentStatsReporter is implemented by ships that send their data in
+// segments and retransmit lost segments themselves, which allows measuring
+// packet loss.
+type SegmentStatsReporter interface {
+	// SegmentStats returns how many segments were sent and how many of them
+	// had to be retransmitted since the ship was launched.
+	// Returns false if the ship does not track segments.
+	SegmentStats() (sent, retransmitted uint64, ok bool)
+}
+
+// segmentStatsSource provides the segment statistics of a connection.
+type segmentStatsSource interface {
+	// SegmentStats returns how many segments were sent and retransmitted.
+	SegmentStats() (sent, retransmitted uint64)
+}
+
 // ShipBase implements common functions to comply with the Ship interface.
 type ShipBase struct {
 	// conn is the actual underlying connection.
@@ -87,6 +103,12 @@ type ShipBase struct {
 	bufSize int
 	// loadSize specifies the recommended data size that should be handed to Load().
 	loadSize int
+	// overhead specifies the size of all headers that are added to loaded data.
+	overhead int
+	// pathMTU optionally provides the discovered path MTU of the connection.
+	pathMTU pathMTUSource
+	// segmentStats optionally provides the segment statistics of the connection.
+	segmentStats segmentStatsSource
 
 	// initial holds initial data from setting up the ship.
 	initial []byte
@@ -144,10 +166,28 @@ func (ship *ShipBase) MarkPublic() {
 // LoadSize returns the recommended data size that should be handed to Load().
 // This value will be most likely somehow related to the connection's MTU.
 // Alternatively, using a multiple of LoadSize is also recommended.
+// The value may change during the lifetime of the ship, if the path MTU of the
+// connection is discovered.
 func (ship *ShipBase) LoadSize() int {
+	if ship.pathMTU != nil {
+		if mtu := ship.pathMTU.PathMTU(); mtu > 0 {
+			return mtu - ship.overhead
+		}
+	}
 	return ship.loadSize
 }
 
+// SegmentStats returns how many segments were sent and how many of them had to
+// be retransmitted since the ship was launched.
+// Returns false if the ship does not track segments.
+func (ship *ShipBase) SegmentStats() (sent, retransmitted uint64, ok bool) {
+	if ship.segmentStats == nil {
+		return 0, 0, false
+	}
+	sent, retransmitted = ship.segmentStats.SegmentStats()
+	return sent, retransmitted, true
+}
+
 // Load loads data into the ship - ie. sends the data via the connection.
 // Returns ErrSunk if the ship has already sunk earlier.
 func (ship *ShipBase) Load(data []byte) error {
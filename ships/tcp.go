@@ -25,7 +25,7 @@ func init() {
 	})
 }
 
-func launchTCPShip(ctx context.Context, transport *hub.Transport, ip net.IP) (Ship, error) {
+func launchTCPShip(ctx context.Context, _ *hub.Hub, transport *hub.Transport, ip net.IP) (Ship, error) {
 	dialer := &net.Dialer{
 		Timeout: 30 * time.Second,
 	}
@@ -44,6 +44,7 @@ func launchTCPShip(ctx context.Context, transport *hub.Transport, ip net.IP) (Sh
 	}
 
 	ship.calculateLoadSize(ip, nil, TCPHeaderMTUSize)
+	ship.pathMTU = newKernelPathMTU(conn)
 	ship.initBase()
 	return ship, nil
 }
@@ -59,7 +60,7 @@ func establishTCPPier(transport *hub.Transport, dockingRequests chan *DockingReq
 	pier := &TCPPier{
 		PierBase: PierBase{
 			transport:       transport,
-			listener:        listener,
+			listener:        &checkedListener{listener},
 			dockingRequests: dockingRequests,
 		},
 	}
@@ -84,6 +85,7 @@ func (pier *TCPPier) dockShip() (Ship, error) {
 	}
 
 	ship.calculateLoadSize(nil, conn.RemoteAddr(), TCPHeaderMTUSize)
+	ship.pathMTU = newKernelPathMTU(conn)
 	ship.initBase()
 	return ship, nil
 }
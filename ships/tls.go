@@ -0,0 +1,289 @@
+package ships
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+
+	"github.com/safing/jess"
+	"github.com/safing/portbase/log"
+	"github.com/safing/spn/hub"
+)
+
+// TLSShip is a ship that uses TLS.
+type TLSShip struct {
+	ShipBase
+}
+
+// TLSPier is a pier that uses TLS.
+type TLSPier struct {
+	PierBase
+
+	// handshakeSlots limits the amount of concurrent handshakes.
+	handshakeSlots chan struct{}
+
+	newShips  chan *TLSShip
+	acceptErr error
+	stopped   chan struct{}
+}
+
+const (
+	// tlsHandshakeTimeout defines how long a pier waits for a client to
+	// complete the handshake.
+	tlsHandshakeTimeout = 10 * time.Second
+
+	// tlsMaxConcurrentHandshakes defines how many handshakes a pier runs at
+	// the same time. Further connections are closed until a slot is free.
+	tlsMaxConcurrentHandshakes = 1000
+)
+
+func init() {
+	Register("tls", &Builder{
+		LaunchShip:    launchTLSShip,
+		EstablishPier: establishTLSPier,
+	})
+	// HTTPS is the same as TLS, but advertises HTTP via ALPN in order to blend
+	// in with regular web traffic.
+	Register("https", &Builder{
+		LaunchShip:    launchTLSShip,
+		EstablishPier: establishTLSPier,
+	})
+}
+
+// createTLSCertificate creates a self-signed certificate from the given
+// signet. The certificate is not verified through any CA, but its public key
+// is checked against the public key of the Hub.
+func createTLSCertificate(signet *jess.Signet) (*tls.Certificate, error) {
+	if err := signet.LoadKey(); err != nil {
+		return nil, fmt.Errorf("failed to load identity key: %w", err)
+	}
+	privKey, ok := signet.PrivateKey().(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("identity key of type %s cannot be used for TLS", signet.Scheme)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName: signet.ID,
+		},
+		NotBefore:   time.Now().Add(-24 * time.Hour),
+		NotAfter:    time.Now().Add(10 * 365 * 24 * time.Hour),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	certData, err := x509.CreateCertificate(rand.Reader, template, template, privKey.Public(), privKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+
+	return &tls.Certificate{
+		Certificate: [][]byte{certData},
+		PrivateKey:  privKey,
+	}, nil
+}
+
+// verifyHubCertificate returns a certificate verification function that
+// checks if the presented certificate belongs to the given Hub.
+func verifyHubCertificate(h *hub.Hub) func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("no certificate presented")
+		}
+		cert, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return fmt.Errorf("failed to parse certificate: %w", err)
+		}
+
+		// Get the public key of the Hub.
+		if err := h.PublicKey.LoadKey(); err != nil {
+			return fmt.Errorf("failed to load public key of %s: %w", h, err)
+		}
+		hubKey, ok := h.PublicKey.PublicKey().(ed25519.PublicKey)
+		if !ok {
+			return fmt.Errorf("public key of %s cannot be used for TLS", h)
+		}
+
+		// Check if the certificate was issued for the Hub's key.
+		certKey, ok := cert.PublicKey.(ed25519.PublicKey)
+		if !ok || !bytes.Equal(certKey, hubKey) {
+			return fmt.Errorf("certificate does not match public key of %s", h)
+		}
+
+		return nil
+	}
+}
+
+// tlsNextProtos returns the ALPN protocols to use for the given transport.
+func tlsNextProtos(transport *hub.Transport) []string {
+	if transport.Protocol == "https" {
+		return []string{"http/1.1"}
+	}
+	return nil
+}
+
+func launchTLSShip(ctx context.Context, h *hub.Hub, transport *hub.Transport, ip net.IP) (Ship, error) {
+	// Check if we can verify the Hub.
+	if h == nil || h.PublicKey == nil {
+		return nil, errors.New("cannot verify tls connection without hub public key")
+	}
+
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{
+			Timeout: 30 * time.Second,
+		},
+		Config: &tls.Config{
+			MinVersion: tls.VersionTLS13,
+			ServerName: transport.Domain,
+			NextProtos: tlsNextProtos(transport),
+			// The Hub certificate is self-signed and is verified manually.
+			InsecureSkipVerify:    true, //nolint:gosec
+			VerifyPeerCertificate: verifyHubCertificate(h),
+		},
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), portToA(transport.Port)))
+	if err != nil {
+		return nil, err
+	}
+
+	ship := &TLSShip{
+		ShipBase: ShipBase{
+			conn:      conn,
+			transport: transport,
+			mine:      true,
+			secure:    true,
+		},
+	}
+
+	ship.calculateLoadSize(ip, nil, TCPHeaderMTUSize, TLSHeaderMTUSize)
+	ship.pathMTU = newKernelPathMTU(conn)
+	ship.initBase()
+	return ship, nil
+}
+
+func establishTLSPier(transport *hub.Transport, dockingRequests chan *DockingRequest) (Pier, error) {
+	cert := getTLSCertificate()
+	if cert == nil {
+		return nil, errors.New("no tls identity set")
+	}
+
+	tcpListener, err := net.Listen("tcp", net.JoinHostPort("", portToA(transport.Port)))
+	if err != nil {
+		return nil, err
+	}
+	// Check connections before the handshake.
+	listener := tls.NewListener(&checkedListener{tcpListener}, &tls.Config{
+		MinVersion:   tls.VersionTLS13,
+		Certificates: []tls.Certificate{*cert},
+		NextProtos:   tlsNextProtos(transport),
+	})
+
+	pier := &TLSPier{
+		PierBase: PierBase{
+			transport:       transport,
+			listener:        listener,
+			dockingRequests: dockingRequests,
+		},
+		handshakeSlots: make(chan struct{}, tlsMaxConcurrentHandshakes),
+		newShips:       make(chan *TLSShip),
+		stopped:        make(chan struct{}),
+	}
+	pier.PierBase.dockShip = pier.dockShip
+	pier.initBase()
+
+	// Start accepting connections.
+	go pier.accept()
+
+	return pier, nil
+}
+
+// accept accepts new connections and completes their handshakes in separate
+// goroutines, so that slow clients do not block others.
+func (pier *TLSPier) accept() {
+	defer close(pier.stopped)
+
+	for {
+		conn, err := pier.listener.Accept()
+		if err != nil {
+			pier.acceptErr = err
+			return
+		}
+
+		select {
+		case pier.handshakeSlots <- struct{}{}:
+			go func() {
+				defer func() {
+					<-pier.handshakeSlots
+				}()
+				pier.handshake(conn)
+			}()
+		default:
+			log.Debugf("spn/ships: %s is busy, closing connection from %s", pier, conn.RemoteAddr())
+			_ = conn.Close()
+		}
+	}
+}
+
+// handshake completes the handshake of the connection and hands the new ship
+// over to the docking procedure.
+func (pier *TLSPier) handshake(conn net.Conn) {
+	// Complete the handshake before docking, as the ship would otherwise
+	// hang until someone reads from it.
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		log.Warningf("spn/ships: %s accepted unexpected connection type %T", pier, conn)
+		_ = conn.Close()
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), tlsHandshakeTimeout)
+	err := tlsConn.HandshakeContext(ctx)
+	cancel()
+	if err != nil {
+		// Failed handshakes are the fault of the client.
+		log.Debugf("spn/ships: %s failed tls handshake with %s: %s", pier, conn.RemoteAddr(), err)
+		_ = conn.Close()
+		return
+	}
+
+	ship := &TLSShip{
+		ShipBase: ShipBase{
+			transport: pier.transport,
+			conn:      conn,
+			mine:      false,
+			secure:    true,
+		},
+	}
+	ship.calculateLoadSize(nil, conn.RemoteAddr(), TCPHeaderMTUSize, TLSHeaderMTUSize)
+	ship.pathMTU = newKernelPathMTU(conn)
+	ship.initBase()
+
+	// Hand ship over to the docking procedure.
+	select {
+	case pier.newShips <- ship:
+	case <-pier.stopped:
+		ship.Sink()
+	}
+}
+
+func (pier *TLSPier) dockShip() (Ship, error) {
+	select {
+	case ship := <-pier.newShips:
+		return ship, nil
+	case <-pier.stopped:
+		return nil, pier.acceptErr
+	}
+}
@@ -0,0 +1,383 @@
+package ships
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/spn/hub"
+)
+
+// UDPShip is a ship that uses UDP.
+type UDPShip struct {
+	ShipBase
+}
+
+// UDPPier is a pier that uses UDP.
+type UDPPier struct {
+	PierBase
+}
+
+func init() {
+	Register("udp", &Builder{
+		LaunchShip:    launchUDPShip,
+		EstablishPier: establishUDPPier,
+	})
+}
+
+func launchUDPShip(ctx context.Context, _ *hub.Hub, transport *hub.Transport, ip net.IP) (Ship, error) {
+	conn, err := dialUDP(ctx, &net.UDPAddr{
+		IP:   ip,
+		Port: int(transport.Port),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ship := &UDPShip{
+		ShipBase: ShipBase{
+			conn:      conn,
+			transport: transport,
+			mine:      true,
+			secure:    false,
+		},
+	}
+
+	ship.calculateLoadSize(ip, nil, UDPHeaderMTUSize, udpSegmentHeaderSize)
+	ship.pathMTU = conn
+	ship.segmentStats = conn
+	ship.initBase()
+	return ship, nil
+}
+
+func establishUDPPier(transport *hub.Transport, dockingRequests chan *DockingRequest) (Pier, error) {
+	listener, err := listenUDP(&net.UDPAddr{
+		Port: int(transport.Port),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	pier := &UDPPier{
+		PierBase: PierBase{
+			transport:       transport,
+			listener:        listener,
+			dockingRequests: dockingRequests,
+		},
+	}
+	pier.PierBase.dockShip = pier.dockShip
+	pier.initBase()
+	return pier, nil
+}
+
+func (pier *UDPPier) dockShip() (Ship, error) {
+	netConn, err := pier.listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	conn, ok := netConn.(*udpConn)
+	if !ok {
+		_ = netConn.Close()
+		return nil, fmt.Errorf("unexpected connection type %T", netConn)
+	}
+
+	ship := &UDPShip{
+		ShipBase: ShipBase{
+			transport: pier.transport,
+			conn:      conn,
+			mine:      false,
+			secure:    false,
+		},
+	}
+
+	ship.calculateLoadSize(nil, conn.RemoteAddr(), UDPHeaderMTUSize, udpSegmentHeaderSize)
+	ship.pathMTU = conn
+	ship.segmentStats = conn
+	ship.initBase()
+	return ship, nil
+}
+
+// dialUDP connects to the given address and completes the handshake.
+func dialUDP(ctx context.Context, raddr *net.UDPAddr) (*udpConn, error) {
+	socket, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return nil, err
+	}
+	probeMTU := enablePathMTUProbing(socket)
+
+	// Generate connection ID.
+	connIDData := make([]byte, 4)
+	if _, err := rand.Read(connIDData); err != nil {
+		_ = socket.Close()
+		return nil, fmt.Errorf("failed to generate connection ID: %w", err)
+	}
+	connID := binary.BigEndian.Uint32(connIDData)
+
+	// Send handshake until we get a reply.
+	syn := make([]byte, udpSegmentHeaderSize)
+	syn[0] = udpMsgSyn
+	binary.BigEndian.PutUint32(syn[1:5], connID)
+	buf := make([]byte, udpMaxPacketSize)
+	handshakeDeadline := time.Now().Add(udpHandshakeTimeout)
+handshake:
+	for {
+		switch {
+		case ctx.Err() != nil:
+			_ = socket.Close()
+			return nil, ctx.Err()
+		case time.Now().After(handshakeDeadline):
+			_ = socket.Close()
+			return nil, errors.New("udp handshake timed out")
+		}
+
+		if _, err := socket.Write(syn); err != nil {
+			_ = socket.Close()
+			return nil, err
+		}
+
+		// Wait for reply.
+		_ = socket.SetReadDeadline(time.Now().Add(udpHandshakeRetryInterval))
+		for {
+			n, err := socket.Read(buf)
+			if err != nil {
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					continue handshake
+				}
+				_ = socket.Close()
+				return nil, err
+			}
+			if n >= udpSegmentHeaderSize &&
+				buf[0] == udpMsgSynAck &&
+				binary.BigEndian.Uint32(buf[1:5]) == connID {
+				break handshake
+			}
+		}
+	}
+	_ = socket.SetReadDeadline(time.Time{})
+
+	// Create connection and start receiving.
+	conn := newUDPConn(
+		socket.LocalAddr(), socket.RemoteAddr(),
+		func(packet []byte) error {
+			_, err := socket.Write(packet)
+			return err
+		},
+		func() {
+			_ = socket.Close()
+		},
+		probeMTU,
+	)
+	go func() {
+		buf := make([]byte, udpMaxPacketSize)
+		for {
+			n, err := socket.Read(buf)
+			if err != nil {
+				conn.shutdown(err)
+				return
+			}
+			conn.handlePacket(buf[:n])
+		}
+	}()
+
+	return conn, nil
+}
+
+// udpListener accepts udp connections and demultiplexes their packets by the
+// remote address. All connections share the listener's socket, so closing the
+// listener also closes all connections.
+type udpListener struct {
+	socket   *net.UDPConn
+	probeMTU bool
+
+	conns     map[string]*udpListenerConn
+	connsLock sync.Mutex
+
+	accept    chan *udpConn
+	closed    chan struct{}
+	closeErr  error
+	closeOnce sync.Once
+}
+
+type udpListenerConn struct {
+	connID uint32
+	conn   *udpConn
+}
+
+func listenUDP(laddr *net.UDPAddr) (*udpListener, error) {
+	socket, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &udpListener{
+		socket:   socket,
+		probeMTU: enablePathMTUProbing(socket),
+		conns:    make(map[string]*udpListenerConn),
+		accept:   make(chan *udpConn, 10),
+		closed:   make(chan struct{}),
+	}
+	go l.receive()
+
+	return l, nil
+}
+
+func (l *udpListener) receive() {
+	buf := make([]byte, udpMaxPacketSize)
+	for {
+		n, raddr, err := l.socket.ReadFromUDP(buf)
+		if err != nil {
+			l.closeWithErr(err)
+			return
+		}
+		if n < udpSegmentHeaderSize {
+			continue
+		}
+
+		// Get connection of remote address.
+		key := raddr.String()
+		l.connsLock.Lock()
+		lc := l.conns[key]
+		l.connsLock.Unlock()
+
+		// Handle handshakes.
+		if buf[0] == udpMsgSyn {
+			l.handleSyn(raddr, binary.BigEndian.Uint32(buf[1:5]), lc)
+			continue
+		}
+
+		// Forward packet to connection.
+		if lc != nil {
+			lc.conn.handlePacket(buf[:n])
+		}
+	}
+}
+
+func (l *udpListener) handleSyn(raddr *net.UDPAddr, connID uint32, existing *udpListenerConn) {
+	switch {
+	case existing == nil:
+		// New connection.
+	case existing.connID == connID:
+		// The handshake reply was lost, send it again.
+		l.sendSynAck(raddr, connID)
+		return
+	case existing.conn.idleFor() < udpReplaceAfterIdle:
+		// The remote end started a new connection, but the old one is still
+		// alive. Ignore the handshake, as anyone could have spoofed it. If the
+		// remote end really lost the old connection, it stops sending and the
+		// handshake succeeds once the old connection is considered dead.
+		log.Debugf("spn/ships: udp listener at %s ignoring handshake from %s, as the existing connection is still alive", l.socket.LocalAddr(), raddr)
+		return
+	}
+
+	// Check the new connection before accepting it.
+	if err := checkAccept(raddr); err != nil {
+		// Only log on trace level, as this may be triggered at a high rate.
+		log.Tracef("spn/ships: udp listener at %s rejected handshake from %s: %s", l.socket.LocalAddr(), raddr, err)
+		return
+	}
+	if existing != nil {
+		// The remote end started a new connection, close the old one.
+		existing.conn.shutdown(errors.New("replaced by new connection"))
+	}
+
+	key := raddr.String()
+	lc := &udpListenerConn{
+		connID: connID,
+	}
+	lc.conn = newUDPConn(
+		l.socket.LocalAddr(), raddr,
+		func(packet []byte) error {
+			_, err := l.socket.WriteToUDP(packet, raddr)
+			return err
+		},
+		func() {
+			l.connsLock.Lock()
+			defer l.connsLock.Unlock()
+
+			if l.conns[key] == lc {
+				delete(l.conns, key)
+			}
+		},
+		l.probeMTU,
+	)
+
+	// Hand over connection for docking.
+	select {
+	case l.accept <- lc.conn:
+	default:
+		// The docking queue is full, let the client try again.
+		log.Debugf("spn/ships: udp listener at %s is busy, ignoring handshake from %s", l.socket.LocalAddr(), raddr)
+		lc.conn.shutdown(errors.New("listener busy"))
+		return
+	}
+
+	l.connsLock.Lock()
+	l.conns[key] = lc
+	l.connsLock.Unlock()
+
+	l.sendSynAck(raddr, connID)
+}
+
+func (l *udpListener) sendSynAck(raddr *net.UDPAddr, connID uint32) {
+	synAck := make([]byte, udpSegmentHeaderSize)
+	synAck[0] = udpMsgSynAck
+	binary.BigEndian.PutUint32(synAck[1:5], connID)
+	_, _ = l.socket.WriteToUDP(synAck, raddr)
+}
+
+// Accept waits for and returns the next connection.
+func (l *udpListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.accept:
+		return conn, nil
+	case <-l.closed:
+		return nil, l.closeErr
+	}
+}
+
+// Close closes the listener and all its connections.
+func (l *udpListener) Close() error {
+	l.closeWithErr(net.ErrClosed)
+	return nil
+}
+
+func (l *udpListener) closeWithErr(err error) {
+	l.closeOnce.Do(func() {
+		l.closeErr = err
+		close(l.closed)
+		_ = l.socket.Close()
+
+		// Shut down all connections.
+		l.connsLock.Lock()
+		conns := make([]*udpConn, 0, len(l.conns))
+		for _, lc := range l.conns {
+			conns = append(conns, lc.conn)
+		}
+		l.connsLock.Unlock()
+		for _, conn := range conns {
+			conn.shutdown(net.ErrClosed)
+		}
+	})
+}
+
+// Addr returns the listener's network address.
+func (l *udpListener) Addr() net.Addr {
+	return l.socket.LocalAddr()
+}
+
+// enablePathMTUProbing prepares the socket for probing the path MTU and
+// returns whether it was successful.
+func enablePathMTUProbing(socket *net.UDPConn) bool {
+	rawConn, err := socket.SyscallConn()
+	if err != nil {
+		return false
+	}
+	return setDontFragment(rawConn) == nil
+}
@@ -0,0 +1,757 @@
+package ships
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+
+UDP Segment Format:
+
+- MsgType [uint8]
+- Seq [uint32]
+- Ack [uint32]
+- Payload [bytes; only when MsgType is Data or Probe]
+
+Connections are identified by the remote address. The handshake uses the Seq
+field to transfer a random connection ID, so that a client reconnecting from
+the same address can be told apart from retransmitted handshakes.
+
+The amount of segments in flight is limited by a congestion window, which
+grows with acknowledged segments and is halved when segments are lost (AIMD).
+
+Path MTU probes carry the probed size in the Seq field and are padded to that
+size. The remote end acknowledges them with a ProbeAck of the same Seq.
+
+*/
+
+// UDP Msg Types.
+const (
+	udpMsgSyn    = 1
+	udpMsgSynAck = 2
+	udpMsgData   = 3
+	udpMsgAck    = 4
+	udpMsgPing   = 5
+	udpMsgFin    = 6
+
+	udpMsgProbe    = 7
+	udpMsgProbeAck = 8
+)
+
+// UDP Connection Configuration.
+const (
+	udpSegmentHeaderSize = 9
+	udpMaxPacketSize     = 65535
+
+	udpSendWindow     = 256
+	udpInitialCwnd    = 10
+	udpMinCwnd        = 2
+	udpRecvWindow     = 1024
+	udpMaxReadBufSize = 1 << 20 // 1MB
+
+	udpInitialRTO            = 1 * time.Second
+	udpMinRTO                = 200 * time.Millisecond
+	udpMaxRTO                = 10 * time.Second
+	udpMaxRetransmitDuration = 30 * time.Second
+	udpTickInterval          = 50 * time.Millisecond
+	udpKeepAliveInterval     = 15 * time.Second
+	udpIdleTimeout           = 1 * time.Minute
+	udpCloseTimeout          = 3 * time.Second
+
+	udpHandshakeTimeout       = 30 * time.Second
+	udpHandshakeRetryInterval = 1 * time.Second
+	// udpReplaceAfterIdle defines how long a connection must not have received
+	// anything before a handshake from the same address may replace it. It is
+	// longer than the keep-alive interval, so that only dead connections are
+	// replaced.
+	udpReplaceAfterIdle = udpKeepAliveInterval + 5*time.Second
+
+	udpMaxProbeAttempts     = 3
+	udpProbeGranularity     = 16
+	udpBlackHoleRetransmits = 3
+)
+
+// Errors.
+var (
+	errUDPTimeout           = errors.New("udp connection timed out")
+	errUDPRetransmitTimeout = errors.New("udp segment was not acknowledged in time")
+)
+
+// udpConn is a reliable and ordered data stream on top of UDP. It implements
+// the net.Conn interface.
+type udpConn struct {
+	lock sync.Mutex
+
+	localAddr  net.Addr
+	remoteAddr net.Addr
+	// overhead is the size of all headers that are added to a segment payload.
+	overhead int
+	// sendPacket sends a packet to the remote end.
+	sendPacket func(packet []byte) error
+	// onClose is called when the connection is shut down.
+	onClose func()
+
+	// nextRecvSeq is the next sequence number to be delivered to the reader.
+	nextRecvSeq uint32
+	// recvSegments holds received segments that were not delivered yet.
+	recvSegments map[uint32][]byte
+	// readBuf holds delivered data waiting to be read.
+	readBuf      [][]byte
+	readBufSize  int
+	readSignal   chan struct{}
+	readDeadline time.Time
+	// finSeq holds the sequence number of the remote end's last segment.
+	finSeq      uint32
+	finReceived bool
+
+	// nextSendSeq is the sequence number of the next segment to be sent.
+	nextSendSeq uint32
+	// inflight holds all sent segments that have not yet been acknowledged.
+	inflight      map[uint32]*udpSegment
+	writeSignal   chan struct{}
+	writeDeadline time.Time
+
+	// cwnd is the congestion window in segments. It limits the segments in
+	// flight together with the send window.
+	cwnd float64
+	// ssthresh is the congestion window size at which slow start ends.
+	ssthresh float64
+	// lastCwndDecrease is when the congestion window was last decreased.
+	lastCwndDecrease time.Time
+
+	// sentSegments and retransmittedSegments count the sent data segments.
+	sentSegments          uint64
+	retransmittedSegments uint64
+
+	srtt   time.Duration
+	rttvar time.Duration
+	rto    time.Duration
+
+	lastSent     time.Time
+	lastReceived time.Time
+
+	// plpmtu is the confirmed packetization layer path MTU.
+	plpmtu int
+	// probing specifies whether the path MTU is probed.
+	probing bool
+	// probeLow and probeHigh define the range of the current path MTU search.
+	probeLow  int
+	probeHigh int
+	// probeSize is the size of the probe in flight.
+	probeSize     int
+	probeSentAt   time.Time
+	probeAttempts int
+	nextProbeAt   time.Time
+
+	closed    chan struct{}
+	closeErr  error
+	closeOnce sync.Once
+}
+
+type udpSegment struct {
+	seq         uint32
+	data        []byte
+	sentAt      time.Time
+	firstSentAt time.Time
+	retransmits int
+}
+
+func newUDPConn(localAddr, remoteAddr net.Addr, sendPacket func([]byte) error, onClose func(), probeMTU bool) *udpConn {
+	now := time.Now()
+	conn := &udpConn{
+		localAddr:    localAddr,
+		remoteAddr:   remoteAddr,
+		overhead:     ipHeaderMTUSize(ipFromAddr(remoteAddr)) + UDPHeaderMTUSize + udpSegmentHeaderSize,
+		sendPacket:   sendPacket,
+		onClose:      onClose,
+		recvSegments: make(map[uint32][]byte),
+		readSignal:   make(chan struct{}, 1),
+		inflight:     make(map[uint32]*udpSegment),
+		cwnd:         udpInitialCwnd,
+		ssthresh:     udpSendWindow,
+		writeSignal:  make(chan struct{}, 1),
+		rto:          udpInitialRTO,
+		lastSent:     now,
+		lastReceived: now,
+		closed:       make(chan struct{}),
+	}
+
+	// Start with the minimum path MTU when probing, as packets that are too big
+	// are dropped instead of fragmented.
+	if probeMTU {
+		conn.probing = true
+		conn.plpmtu = MinPathMTU
+		conn.probeLow = MinPathMTU
+		conn.probeHigh = MaxPathMTU
+	} else {
+		conn.plpmtu = BaseMTU
+	}
+
+	go conn.manage()
+	return conn
+}
+
+// seqBefore returns whether sequence number a is before b, respecting
+// wrap-arounds.
+func seqBefore(a, b uint32) bool {
+	return int32(a-b) < 0
+}
+
+func signal(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+// waitFor waits for a signal, the connection to close or the deadline to pass.
+func (conn *udpConn) waitFor(wake chan struct{}, deadline time.Time) error {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-wake:
+		return nil
+	case <-conn.closed:
+		return nil
+	case <-timeout:
+		return os.ErrDeadlineExceeded
+	}
+}
+
+func (conn *udpConn) sendLocked(msgType uint8, seq, ack uint32, payload []byte) error {
+	packet := make([]byte, udpSegmentHeaderSize+len(payload))
+	packet[0] = msgType
+	binary.BigEndian.PutUint32(packet[1:5], seq)
+	binary.BigEndian.PutUint32(packet[5:9], ack)
+	copy(packet[udpSegmentHeaderSize:], payload)
+
+	conn.lastSent = time.Now()
+	return conn.sendPacket(packet)
+}
+
+// handlePacket handles a packet received from the remote end.
+func (conn *udpConn) handlePacket(packet []byte) {
+	if len(packet) < udpSegmentHeaderSize {
+		return
+	}
+	msgType := packet[0]
+	seq := binary.BigEndian.Uint32(packet[1:5])
+	ack := binary.BigEndian.Uint32(packet[5:9])
+
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+
+	if conn.closeErr != nil {
+		return
+	}
+	conn.lastReceived = time.Now()
+
+	switch msgType {
+	case udpMsgData:
+		conn.ackUntilLocked(ack)
+		conn.handleDataLocked(seq, packet[udpSegmentHeaderSize:])
+	case udpMsgAck:
+		conn.ackSegmentLocked(seq)
+		conn.ackUntilLocked(ack)
+	case udpMsgFin:
+		conn.finSeq = seq
+		conn.finReceived = true
+		conn.deliverLocked()
+	case udpMsgProbe:
+		_ = conn.sendLocked(udpMsgProbeAck, seq, conn.nextRecvSeq, nil)
+	case udpMsgProbeAck:
+		conn.handleProbeAckLocked(int(seq))
+	case udpMsgPing, udpMsgSynAck:
+		// Receiving is enough to keep the connection alive.
+		// SynAcks may still arrive as duplicates after the handshake.
+	}
+}
+
+func (conn *udpConn) handleDataLocked(seq uint32, payload []byte) {
+	switch {
+	case seqBefore(seq, conn.nextRecvSeq):
+		// Already delivered, the ack was probably lost.
+	case seq-conn.nextRecvSeq >= udpRecvWindow:
+		// Outside of the receive window, drop without ack.
+		return
+	default:
+		if _, ok := conn.recvSegments[seq]; !ok {
+			data := make([]byte, len(payload))
+			copy(data, payload)
+			conn.recvSegments[seq] = data
+		}
+		conn.deliverLocked()
+	}
+
+	_ = conn.sendLocked(udpMsgAck, seq, conn.nextRecvSeq, nil)
+}
+
+// deliverLocked moves received segments to the read buffer in order.
+func (conn *udpConn) deliverLocked() {
+	var delivered bool
+	for conn.readBufSize < udpMaxReadBufSize {
+		data, ok := conn.recvSegments[conn.nextRecvSeq]
+		if !ok {
+			break
+		}
+		delete(conn.recvSegments, conn.nextRecvSeq)
+		conn.nextRecvSeq++
+
+		conn.readBuf = append(conn.readBuf, data)
+		conn.readBufSize += len(data)
+		delivered = true
+	}
+	if delivered {
+		signal(conn.readSignal)
+	}
+
+	// Shut down when all data of the remote end has been received.
+	if conn.finReceived && conn.nextRecvSeq == conn.finSeq {
+		conn.shutdownLocked(io.EOF)
+	}
+}
+
+func (conn *udpConn) ackSegmentLocked(seq uint32) {
+	seg, ok := conn.inflight[seq]
+	if !ok {
+		return
+	}
+
+	// Only use segments that were not retransmitted for measuring the RTT.
+	if seg.retransmits == 0 {
+		conn.updateRTTLocked(time.Since(seg.sentAt))
+	}
+	delete(conn.inflight, seq)
+	conn.increaseCwndLocked()
+	signal(conn.writeSignal)
+}
+
+func (conn *udpConn) ackUntilLocked(ack uint32) {
+	for seq := range conn.inflight {
+		if seqBefore(seq, ack) {
+			delete(conn.inflight, seq)
+			conn.increaseCwndLocked()
+			signal(conn.writeSignal)
+		}
+	}
+}
+
+// sendWindowLocked returns how many segments may be in flight.
+func (conn *udpConn) sendWindowLocked() int {
+	if conn.cwnd < udpSendWindow {
+		return int(conn.cwnd)
+	}
+	return udpSendWindow
+}
+
+// increaseCwndLocked increases the congestion window for an acknowledged
+// segment. It grows exponentially during slow start and by about one segment
+// per round trip afterwards.
+func (conn *udpConn) increaseCwndLocked() {
+	if conn.cwnd < conn.ssthresh {
+		conn.cwnd++
+	} else {
+		conn.cwnd += 1 / conn.cwnd
+	}
+	if conn.cwnd > udpSendWindow {
+		conn.cwnd = udpSendWindow
+	}
+}
+
+// decreaseCwndLocked halves the congestion window when segments are lost.
+// It is only decreased once per round trip, as segments sent in the same
+// window are usually lost together.
+func (conn *udpConn) decreaseCwndLocked(now time.Time) {
+	rtt := conn.srtt
+	if rtt == 0 {
+		rtt = conn.rto
+	}
+	if now.Sub(conn.lastCwndDecrease) < rtt {
+		return
+	}
+	conn.lastCwndDecrease = now
+
+	conn.cwnd /= 2
+	if conn.cwnd < udpMinCwnd {
+		conn.cwnd = udpMinCwnd
+	}
+	conn.ssthresh = conn.cwnd
+}
+
+// updateRTTLocked updates the round trip time estimation and the
+// retransmission timeout as described in RFC 6298.
+func (conn *udpConn) updateRTTLocked(rtt time.Duration) {
+	if conn.srtt == 0 {
+		conn.srtt = rtt
+		conn.rttvar = rtt / 2
+	} else {
+		diff := conn.srtt - rtt
+		if diff < 0 {
+			diff = -diff
+		}
+		conn.rttvar = (3*conn.rttvar + diff) / 4
+		conn.srtt = (7*conn.srtt + rtt) / 8
+	}
+
+	conn.rto = conn.srtt + 4*conn.rttvar
+	switch {
+	case conn.rto < udpMinRTO:
+		conn.rto = udpMinRTO
+	case conn.rto > udpMaxRTO:
+		conn.rto = udpMaxRTO
+	}
+}
+
+// manage retransmits lost segments and keeps the connection alive.
+func (conn *udpConn) manage() {
+	ticker := time.NewTicker(udpTickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-conn.closed:
+			return
+		case now := <-ticker.C:
+			conn.tick(now)
+		}
+	}
+}
+
+func (conn *udpConn) tick(now time.Time) {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+
+	if conn.closeErr != nil {
+		return
+	}
+
+	// Check if the remote end is still there.
+	if now.Sub(conn.lastReceived) > udpIdleTimeout {
+		conn.shutdownLocked(errUDPTimeout)
+		return
+	}
+
+	// Retransmit segments with exponential backoff.
+	for _, seg := range conn.inflight {
+		if now.Sub(seg.firstSentAt) > udpMaxRetransmitDuration {
+			conn.shutdownLocked(errUDPRetransmitTimeout)
+			return
+		}
+
+		timeout := conn.rto << seg.retransmits
+		if timeout > udpMaxRTO || timeout <= 0 {
+			timeout = udpMaxRTO
+		}
+		if now.Sub(seg.sentAt) > timeout {
+			conn.decreaseCwndLocked(now)
+			seg.retransmits++
+			seg.sentAt = now
+			conn.retransmittedSegments++
+			_ = conn.sendLocked(udpMsgData, seg.seq, conn.nextRecvSeq, seg.data)
+
+			if seg.retransmits == udpBlackHoleRetransmits {
+				conn.checkBlackHoleLocked(now, len(seg.data))
+			}
+		}
+	}
+
+	// Probe path MTU.
+	conn.probePathMTULocked(now)
+
+	// Send keep-alive if idle.
+	if now.Sub(conn.lastSent) > udpKeepAliveInterval {
+		_ = conn.sendLocked(udpMsgPing, conn.nextSendSeq, conn.nextRecvSeq, nil)
+	}
+}
+
+// SegmentStats returns how many data segments were sent and how many of them
+// had to be retransmitted.
+func (conn *udpConn) SegmentStats() (sent, retransmitted uint64) {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+
+	return conn.sentSegments, conn.retransmittedSegments
+}
+
+// idleFor returns how long the connection has not received anything.
+func (conn *udpConn) idleFor() time.Duration {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+
+	return time.Since(conn.lastReceived)
+}
+
+// Read reads data from the connection.
+func (conn *udpConn) Read(b []byte) (n int, err error) {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+
+	for {
+		// Deliver segments that did not fit into the read buffer before.
+		conn.deliverLocked()
+
+		if len(conn.readBuf) > 0 {
+			n = copy(b, conn.readBuf[0])
+			if n < len(conn.readBuf[0]) {
+				conn.readBuf[0] = conn.readBuf[0][n:]
+			} else {
+				conn.readBuf = conn.readBuf[1:]
+			}
+			conn.readBufSize -= n
+			return n, nil
+		}
+
+		if conn.closeErr != nil {
+			return 0, conn.closeErr
+		}
+
+		// Wait for data.
+		deadline := conn.readDeadline
+		conn.lock.Unlock()
+		err = conn.waitFor(conn.readSignal, deadline)
+		conn.lock.Lock()
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Write writes data to the connection.
+func (conn *udpConn) Write(b []byte) (n int, err error) {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+
+	for n < len(b) {
+		if conn.closeErr != nil {
+			return n, net.ErrClosed
+		}
+
+		// Wait until the send and congestion window have space.
+		if len(conn.inflight) >= conn.sendWindowLocked() {
+			deadline := conn.writeDeadline
+			conn.lock.Unlock()
+			err = conn.waitFor(conn.writeSignal, deadline)
+			conn.lock.Lock()
+			if err != nil {
+				return n, err
+			}
+			continue
+		}
+
+		// Send next segment.
+		size := len(b) - n
+		if mss := conn.plpmtu - conn.overhead; size > mss {
+			size = mss
+		}
+		data := make([]byte, size)
+		copy(data, b[n:n+size])
+		now := time.Now()
+		seg := &udpSegment{
+			seq:         conn.nextSendSeq,
+			data:        data,
+			sentAt:      now,
+			firstSentAt: now,
+		}
+		conn.inflight[seg.seq] = seg
+		conn.nextSendSeq++
+		conn.sentSegments++
+		// Failed sends are handled by the retransmission.
+		_ = conn.sendLocked(udpMsgData, seg.seq, conn.nextRecvSeq, seg.data)
+
+		n += size
+	}
+
+	return n, nil
+}
+
+// Close closes the connection after trying to deliver all remaining data.
+func (conn *udpConn) Close() error {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+
+	if conn.closeErr != nil {
+		return nil
+	}
+
+	// Wait for in-flight data to be acknowledged.
+	deadline := time.Now().Add(udpCloseTimeout)
+	for len(conn.inflight) > 0 && conn.closeErr == nil && time.Now().Before(deadline) {
+		conn.lock.Unlock()
+		_ = conn.waitFor(conn.writeSignal, deadline)
+		conn.lock.Lock()
+	}
+	if conn.closeErr != nil {
+		return nil
+	}
+
+	// Notify remote end. Send twice, as this is not retransmitted.
+	_ = conn.sendLocked(udpMsgFin, conn.nextSendSeq, conn.nextRecvSeq, nil)
+	_ = conn.sendLocked(udpMsgFin, conn.nextSendSeq, conn.nextRecvSeq, nil)
+	conn.shutdownLocked(net.ErrClosed)
+	return nil
+}
+
+func (conn *udpConn) shutdown(err error) {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+
+	conn.shutdownLocked(err)
+}
+
+func (conn *udpConn) shutdownLocked(err error) {
+	conn.closeOnce.Do(func() {
+		conn.closeErr = err
+		close(conn.closed)
+		if conn.onClose != nil {
+			conn.onClose()
+		}
+	})
+}
+
+// LocalAddr returns the local network address.
+func (conn *udpConn) LocalAddr() net.Addr {
+	return conn.localAddr
+}
+
+// RemoteAddr returns the remote network address.
+func (conn *udpConn) RemoteAddr() net.Addr {
+	return conn.remoteAddr
+}
+
+// SetDeadline sets the read and write deadlines.
+func (conn *udpConn) SetDeadline(t time.Time) error {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+
+	conn.readDeadline = t
+	conn.writeDeadline = t
+	return nil
+}
+
+// SetReadDeadline sets the read deadline.
+func (conn *udpConn) SetReadDeadline(t time.Time) error {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+
+	conn.readDeadline = t
+	return nil
+}
+
+// SetWriteDeadline sets the write deadline.
+func (conn *udpConn) SetWriteDeadline(t time.Time) error {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+
+	conn.writeDeadline = t
+	return nil
+}
+
+// PathMTU returns the current path MTU, or 0 if it is unknown.
+func (conn *udpConn) PathMTU() int {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+
+	if !conn.probing {
+		return 0
+	}
+	return conn.plpmtu
+}
+
+// probePathMTULocked searches for the path MTU by sending padded probes, as
+// described in RFC 8899 (DPLPMTUD).
+func (conn *udpConn) probePathMTULocked(now time.Time) {
+	if !conn.probing {
+		return
+	}
+
+	switch {
+	case conn.probeSize != 0:
+		// Wait for the probe in flight to be acknowledged.
+		if now.Sub(conn.probeSentAt) < conn.rto {
+			return
+		}
+		// Retry probe a couple times before giving up.
+		if conn.probeAttempts < udpMaxProbeAttempts {
+			conn.sendProbeLocked(now)
+			return
+		}
+		// The probe size is too big.
+		conn.probeHigh = conn.probeSize - 1
+		conn.probeSize = 0
+
+	case now.Before(conn.nextProbeAt):
+		return
+	}
+
+	// Check if the search is complete.
+	if conn.probeHigh-conn.probeLow < udpProbeGranularity {
+		// Search again later, as the path may change.
+		conn.probeLow = conn.plpmtu
+		conn.probeHigh = MaxPathMTU
+		conn.nextProbeAt = now.Add(pathMTUReprobeInterval)
+		return
+	}
+
+	// Probe the middle of the remaining range.
+	conn.probeSize = (conn.probeLow + conn.probeHigh + 1) / 2
+	conn.probeAttempts = 0
+	conn.sendProbeLocked(now)
+}
+
+func (conn *udpConn) sendProbeLocked(now time.Time) {
+	conn.probeAttempts++
+	conn.probeSentAt = now
+
+	// Pad the probe to the probed size, without the IP and UDP headers.
+	padding := conn.probeSize - conn.overhead
+	if padding < 0 {
+		padding = 0
+	}
+	err := conn.sendLocked(udpMsgProbe, uint32(conn.probeSize), conn.nextRecvSeq, make([]byte, padding))
+	if err != nil {
+		// The probe could not even be sent, it is too big for the local interface.
+		conn.probeHigh = conn.probeSize - 1
+		conn.probeSize = 0
+	}
+}
+
+func (conn *udpConn) handleProbeAckLocked(size int) {
+	if conn.probeSize == 0 || size != conn.probeSize {
+		return
+	}
+
+	// The probed size was confirmed.
+	conn.plpmtu = size
+	conn.probeLow = size
+	conn.probeSize = 0
+	conn.nextProbeAt = time.Time{}
+}
+
+// checkBlackHoleLocked falls back to the minimum path MTU when full-sized
+// segments are repeatedly lost, as the path MTU might have decreased.
+func (conn *udpConn) checkBlackHoleLocked(now time.Time, segmentSize int) {
+	if !conn.probing ||
+		conn.plpmtu == MinPathMTU ||
+		segmentSize+conn.overhead <= MinPathMTU {
+		return
+	}
+
+	// Segments that are already in flight cannot be split anymore. If they do
+	// not get through, the connection will fail and must be reestablished.
+	conn.probeHigh = conn.plpmtu - 1
+	conn.plpmtu = MinPathMTU
+	conn.probeLow = MinPathMTU
+	conn.probeSize = 0
+	conn.nextProbeAt = now
+}
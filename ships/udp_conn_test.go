@@ -0,0 +1,175 @@
+package ships
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestUDPConnLossyLink(t *testing.T) {
+	t.Parallel()
+
+	// Connect two connections through a link that drops packets.
+	var a, b *udpConn
+	aToB := make(chan []byte, 1000)
+	bToA := make(chan []byte, 1000)
+	lossyLink := func(link chan []byte) func([]byte) error {
+		return func(packet []byte) error {
+			// Drop about every tenth packet, except for the final one.
+			if packet[0] != udpMsgFin && rand.Intn(10) == 0 { //nolint:gosec // Not used for security.
+				return nil
+			}
+			select {
+			case link <- packet:
+			default:
+			}
+			return nil
+		}
+	}
+	a = newUDPConn(&net.UDPAddr{}, &net.UDPAddr{}, lossyLink(aToB), nil, false)
+	b = newUDPConn(&net.UDPAddr{}, &net.UDPAddr{}, lossyLink(bToA), nil, false)
+	go func() {
+		for packet := range aToB {
+			b.handlePacket(packet)
+		}
+	}()
+	go func() {
+		for packet := range bToA {
+			a.handlePacket(packet)
+		}
+	}()
+
+	// Generate test data.
+	data := make([]byte, 256*1024)
+	_, _ = rand.Read(data) //nolint:gosec // Not used for security.
+
+	// Send data and close afterwards.
+	go func() {
+		if _, err := a.Write(data); err != nil {
+			t.Errorf("failed to write: %s", err)
+		}
+		_ = a.Close()
+	}()
+
+	// Receive all data until the connection is closed.
+	received, err := io.ReadAll(b)
+	if err != nil {
+		t.Fatalf("failed to read: %s", err)
+	}
+	if !bytes.Equal(data, received) {
+		t.Fatalf("received data does not match: got %d of %d bytes", len(received), len(data))
+	}
+
+	// Retransmissions of the dropped segments must be counted.
+	sent, retransmitted := a.SegmentStats()
+	if sent == 0 || retransmitted == 0 || retransmitted > sent {
+		t.Fatalf("unexpected segment stats: %d sent, %d retransmitted", sent, retransmitted)
+	}
+}
+
+func TestUDPConnPathMTUProbing(t *testing.T) {
+	t.Parallel()
+
+	// Connect two connections through a link with a limited MTU.
+	linkMTU := 1400
+	aToB := make(chan []byte, 1000)
+	bToA := make(chan []byte, 1000)
+	limitedLink := func(link chan []byte) func([]byte) error {
+		return func(packet []byte) error {
+			if len(packet)+IPv6HeaderMTUSize+UDPHeaderMTUSize > linkMTU {
+				return nil
+			}
+			select {
+			case link <- packet:
+			default:
+			}
+			return nil
+		}
+	}
+	a := newUDPConn(&net.UDPAddr{}, &net.UDPAddr{}, limitedLink(aToB), nil, true)
+	b := newUDPConn(&net.UDPAddr{}, &net.UDPAddr{}, limitedLink(bToA), nil, true)
+	go func() {
+		for packet := range aToB {
+			b.handlePacket(packet)
+		}
+	}()
+	go func() {
+		for packet := range bToA {
+			a.handlePacket(packet)
+		}
+	}()
+	defer func() {
+		_ = a.Close()
+		_ = b.Close()
+	}()
+
+	// Wait for the search to complete.
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		mtu := a.PathMTU()
+		if mtu > linkMTU {
+			t.Fatalf("discovered path mtu %d exceeds link mtu %d", mtu, linkMTU)
+		}
+		if mtu > linkMTU-udpProbeGranularity {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("path mtu discovery did not converge: got %d, expected close to %d", a.PathMTU(), linkMTU)
+}
+
+func TestUDPConnCongestionWindow(t *testing.T) {
+	t.Parallel()
+
+	conn := newUDPConn(&net.UDPAddr{}, &net.UDPAddr{}, func([]byte) error { return nil }, nil, false)
+	defer conn.shutdown(net.ErrClosed)
+	ack := func(seq uint32) {
+		packet := make([]byte, udpSegmentHeaderSize)
+		packet[0] = udpMsgAck
+		binary.BigEndian.PutUint32(packet[1:5], seq-1)
+		binary.BigEndian.PutUint32(packet[5:9], seq)
+		conn.handlePacket(packet)
+	}
+	cwnd := func() float64 {
+		conn.lock.Lock()
+		defer conn.lock.Unlock()
+		return conn.cwnd
+	}
+
+	// Writes must not exceed the initial congestion window.
+	mss := conn.plpmtu - conn.overhead
+	_ = conn.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+	n, err := conn.Write(make([]byte, mss*udpInitialCwnd+1))
+	if err == nil {
+		t.Fatal("write should block when the congestion window is full")
+	}
+	if n != mss*udpInitialCwnd {
+		t.Fatalf("wrote %d bytes instead of the congestion window", n)
+	}
+
+	// The window doubles with every round trip during slow start.
+	ack(conn.nextSendSeq)
+	if cwnd() != 2*udpInitialCwnd {
+		t.Fatalf("congestion window should be %d, is %f", 2*udpInitialCwnd, cwnd())
+	}
+
+	// Loss halves the window.
+	_ = conn.SetWriteDeadline(time.Time{})
+	if _, err := conn.Write(make([]byte, mss*2*udpInitialCwnd)); err != nil {
+		t.Fatal(err)
+	}
+	conn.tick(time.Now().Add(2 * udpInitialRTO))
+	if cwnd() != udpInitialCwnd {
+		t.Fatalf("congestion window should be %d, is %f", udpInitialCwnd, cwnd())
+	}
+
+	// Afterwards, the window only grows by about one segment per round trip.
+	ack(conn.nextSendSeq)
+	if cwnd() < udpInitialCwnd+1 || cwnd() > udpInitialCwnd+2 {
+		t.Fatalf("congestion window should be about %d, is %f", udpInitialCwnd+2, cwnd())
+	}
+}
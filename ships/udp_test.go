@@ -0,0 +1,54 @@
+package ships
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestUDPListenerReplaceConn(t *testing.T) {
+	t.Parallel()
+
+	l, err := listenUDP(&net.UDPAddr{IP: localhost})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = l.Close()
+	}()
+	raddr := &net.UDPAddr{IP: localhost, Port: int(getTestPort())}
+	getConn := func() *udpListenerConn {
+		l.connsLock.Lock()
+		defer l.connsLock.Unlock()
+		return l.conns[raddr.String()]
+	}
+
+	// Accept new connection.
+	l.handleSyn(raddr, 1, nil)
+	existing := getConn()
+	if existing == nil || existing.connID != 1 {
+		t.Fatal("connection should have been accepted")
+	}
+	<-l.accept
+
+	// Handshakes for a new connection must not replace a live connection, as
+	// they could be spoofed.
+	l.handleSyn(raddr, 2, existing)
+	if getConn() != existing {
+		t.Fatal("live connection should not be replaced")
+	}
+
+	// Dead connections are replaced.
+	existing.conn.lock.Lock()
+	existing.conn.lastReceived = time.Now().Add(-udpReplaceAfterIdle)
+	existing.conn.lock.Unlock()
+	l.handleSyn(raddr, 3, existing)
+	if replaced := getConn(); replaced == nil || replaced.connID != 3 {
+		t.Fatal("dead connection should be replaced")
+	}
+	select {
+	case <-existing.conn.closed:
+	default:
+		t.Error("replaced connection should be closed")
+	}
+}
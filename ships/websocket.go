@@ -0,0 +1,259 @@
+package ships
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/spn/hub"
+)
+
+// WebsocketShip is a ship that uses WebSockets.
+type WebsocketShip struct {
+	ShipBase
+}
+
+// WebsocketPier is a pier that uses WebSockets.
+type WebsocketPier struct {
+	PierBase
+
+	server   *http.Server
+	upgrader *websocket.Upgrader
+
+	newShips chan *WebsocketShip
+	serveErr error
+	stopped  chan struct{}
+}
+
+// websocketTLSConfig is the TLS config used to launch ships via secure
+// WebSockets. If nil, the default config is used.
+var websocketTLSConfig *tls.Config
+
+func init() {
+	Register("ws", &Builder{
+		LaunchShip:    launchWebsocketShip,
+		EstablishPier: establishWebsocketPier,
+	})
+	// Secure WebSockets are expected to be terminated by a reverse proxy or CDN
+	// in front of the Hub, which then forwards plain WebSockets to the pier.
+	Register("wss", &Builder{
+		LaunchShip:    launchWebsocketShip,
+		EstablishPier: establishWebsocketPier,
+	})
+}
+
+// websocketURL returns the URL to connect to for the given transport.
+// If the transport defines a domain, it is used instead of the given IP, so
+// that requests can be routed through reverse proxies and CDNs.
+func websocketURL(transport *hub.Transport, ip net.IP) string {
+	u := &url.URL{
+		Scheme: transport.Protocol,
+		Path:   websocketPath(transport),
+	}
+	if transport.Domain != "" {
+		u.Host = net.JoinHostPort(transport.Domain, portToA(transport.Port))
+	} else {
+		u.Host = net.JoinHostPort(ip.String(), portToA(transport.Port))
+	}
+	return u.String()
+}
+
+// websocketPath returns the HTTP path of the given transport.
+func websocketPath(transport *hub.Transport) string {
+	if transport.Path == "" {
+		return "/"
+	}
+	return transport.Path
+}
+
+func launchWebsocketShip(ctx context.Context, _ *hub.Hub, transport *hub.Transport, ip net.IP) (Ship, error) {
+	dialer := &websocket.Dialer{
+		NetDialContext: (&net.Dialer{
+			Timeout: 30 * time.Second,
+		}).DialContext,
+		HandshakeTimeout: 30 * time.Second,
+		TLSClientConfig:  websocketTLSConfig,
+	}
+	wsConn, resp, err := dialer.DialContext(ctx, websocketURL(transport, ip), nil)
+	if resp != nil && resp.Body != nil {
+		_ = resp.Body.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ship := &WebsocketShip{
+		ShipBase: ShipBase{
+			conn:      newWebsocketConn(wsConn),
+			transport: transport,
+			mine:      true,
+			secure:    false,
+		},
+	}
+
+	ship.calculateLoadSize(ip, nil, TCPHeaderMTUSize)
+	ship.pathMTU = newKernelPathMTU(wsConn.UnderlyingConn())
+	ship.initBase()
+	return ship, nil
+}
+
+func establishWebsocketPier(transport *hub.Transport, dockingRequests chan *DockingRequest) (Pier, error) {
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{
+		Port: int(transport.Port),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	pier := &WebsocketPier{
+		PierBase: PierBase{
+			transport:       transport,
+			listener:        &checkedListener{listener},
+			dockingRequests: dockingRequests,
+		},
+		upgrader: &websocket.Upgrader{
+			HandshakeTimeout: 30 * time.Second,
+			// Ships do not come from browsers, so the origin is irrelevant.
+			CheckOrigin: func(r *http.Request) bool { return true },
+		},
+		newShips: make(chan *WebsocketShip),
+		stopped:  make(chan struct{}),
+	}
+	pier.PierBase.dockShip = pier.dockShip
+	pier.initBase()
+
+	// Start HTTP server.
+	mux := http.NewServeMux()
+	mux.HandleFunc(websocketPath(transport), pier.handleRequest)
+	pier.server = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+	go func() {
+		pier.serveErr = pier.server.Serve(pier.listener)
+		close(pier.stopped)
+	}()
+
+	return pier, nil
+}
+
+func (pier *WebsocketPier) handleRequest(w http.ResponseWriter, r *http.Request) {
+	wsConn, err := pier.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader already replied with an error.
+		log.Debugf("spn/ships: %s failed to upgrade request from %s: %s", pier, r.RemoteAddr, err)
+		return
+	}
+
+	ship := &WebsocketShip{
+		ShipBase: ShipBase{
+			conn:      newWebsocketConn(wsConn),
+			transport: pier.transport,
+			mine:      false,
+			secure:    false,
+		},
+	}
+	ship.calculateLoadSize(nil, wsConn.RemoteAddr(), TCPHeaderMTUSize)
+	ship.pathMTU = newKernelPathMTU(wsConn.UnderlyingConn())
+	ship.initBase()
+
+	// Hand ship over to the docking procedure.
+	select {
+	case pier.newShips <- ship:
+	case <-pier.stopped:
+		ship.Sink()
+	}
+}
+
+func (pier *WebsocketPier) dockShip() (Ship, error) {
+	select {
+	case ship := <-pier.newShips:
+		return ship, nil
+	case <-pier.stopped:
+		if pier.serveErr == nil {
+			return nil, errors.New("http server stopped")
+		}
+		return nil, pier.serveErr
+	}
+}
+
+// Abolish closes the underlying listener and cleans up any related resources.
+func (pier *WebsocketPier) Abolish() {
+	if pier.abolishing.SetToIf(false, true) {
+		_ = pier.server.Close()
+	}
+}
+
+// websocketConn wraps a websocket connection in order to provide a continuous
+// data stream, as the net.Conn interface requires.
+type websocketConn struct {
+	*websocket.Conn
+
+	reader    io.Reader
+	writeLock sync.Mutex
+}
+
+func newWebsocketConn(wsConn *websocket.Conn) *websocketConn {
+	return &websocketConn{
+		Conn: wsConn,
+	}
+}
+
+// Read reads data from the current message, continuing with the next message
+// when the current one is finished.
+func (conn *websocketConn) Read(b []byte) (n int, err error) {
+	for {
+		// Get the next message, if we don't have one yet.
+		if conn.reader == nil {
+			msgType, reader, err := conn.NextReader()
+			if err != nil {
+				return 0, err
+			}
+			if msgType != websocket.BinaryMessage {
+				return 0, fmt.Errorf("received unexpected websocket message type %d", msgType)
+			}
+			conn.reader = reader
+		}
+
+		// Read from the current message.
+		n, err = conn.reader.Read(b)
+		if errors.Is(err, io.EOF) {
+			conn.reader = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+// Write sends the given data as a single binary message.
+func (conn *websocketConn) Write(b []byte) (n int, err error) {
+	conn.writeLock.Lock()
+	defer conn.writeLock.Unlock()
+
+	err = conn.WriteMessage(websocket.BinaryMessage, b)
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// SetDeadline sets the read and write deadlines.
+func (conn *websocketConn) SetDeadline(t time.Time) error {
+	if err := conn.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return conn.SetWriteDeadline(t)
+}
@@ -16,6 +16,16 @@ import (
 
 const onWindows = runtime.GOOS == "windows"
 
+const (
+	// maxDatagramSize is the maximum size of an UDP datagram.
+	maxDatagramSize = 65535
+
+	// udpConnQueueSize defines how many datagrams are queued per connection.
+	// Protocols like QUIC send bursts of datagrams, which would otherwise be
+	// dropped when not read fast enough.
+	udpConnQueueSize = 64
+)
+
 // UDPListener is a listener for UDP.
 type UDPListener struct {
 	sock     *net.UDPConn
@@ -127,16 +137,15 @@ func (ln *UDPListener) setConn(conn *UDPConn) {
 }
 
 func (ln *UDPListener) reader(_ context.Context) error {
-	for {
-		// TODO: Find good buf size.
-		// With a buf size of 512 we have seen this error on Windows:
-		// wsarecvmsg: A message sent on a datagram socket was larger than the internal message buffer or some other network limit, or the buffer used to receive a datagram into was smaller than the datagram itself.
-		// UDP is not (yet) heavily used, so we can go for the 1500 bytes size for now.
+	// Read into a buffer that fits any datagram in order to preserve them fully.
+	// With a smaller buffer we have seen this error on Windows:
+	// wsarecvmsg: A message sent on a datagram socket was larger than the internal message buffer or some other network limit, or the buffer used to receive a datagram into was smaller than the datagram itself.
+	readBuf := make([]byte, maxDatagramSize)
 
+	for {
 		// Read data from connection.
-		buf := make([]byte, 1500) // TODO: see comment above.
 		oob := make([]byte, ln.oobSize)
-		n, oobn, _, addr, err := ln.sock.ReadMsgUDP(buf, oob)
+		n, oobn, _, addr, err := ln.sock.ReadMsgUDP(readBuf, oob)
 		if err != nil {
 			// Set socket error.
 			ln.lock.Lock()
@@ -146,7 +155,8 @@ func (ln *UDPListener) reader(_ context.Context) error {
 			_ = ln.Close()
 			return nil //nolint:nilerr
 		}
-		buf = buf[:n]
+		buf := make([]byte, n)
+		copy(buf, readBuf[:n])
 		oob = oob[:oobn]
 
 		// Get connection and supply data.
@@ -172,7 +182,7 @@ func (ln *UDPListener) reader(_ context.Context) error {
 			closed:        abool.New(),
 			closing:       make(chan struct{}),
 			buf:           buf,
-			in:            make(chan []byte, 1),
+			in:            make(chan []byte, udpConnQueueSize),
 			inactivityCnt: new(uint32),
 		}
 		ln.setConn(conn)
@@ -158,7 +158,11 @@ func NewLocalBaseTerminal(
 
 		// Create new session.
 		env := jess.NewUnconfiguredEnvelope()
-		env.SuiteID = jess.SuiteWireV1
+		if suiteID, ok := hub.WireSuiteForScheme(s.Scheme); ok {
+			env.SuiteID = suiteID
+		} else {
+			env.SuiteID = jess.SuiteWireV1
+		}
 		env.Recipients = []*jess.Signet{s}
 		jession, err := env.WireCorrespondence(nil)
 		if err != nil {
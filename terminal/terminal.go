@@ -51,6 +51,9 @@ type Terminal interface { //nolint:golint // Being explicit is helpful here.
 	// Abandon shuts down the terminal unregistering it from upstream and calling HandleAbandon().
 	// Should not be overridden by implementations.
 	Abandon(err *Error)
+	// AbandonError returns the error the terminal is being abandoned with.
+	// Should not be overridden by implementations.
+	AbandonError() *Error
 	// HandleAbandon gives the terminal the ability to cleanly shut down.
 	// The terminal is still fully functional at this point.
 	// The returned error is the error to send to the other side.
@@ -143,6 +146,9 @@ type TerminalBase struct { //nolint:golint,maligned // Being explicit is helpful
 	// No new operations should be started.
 	// Whoever initiates the abandoning must also start the abandon procedure.
 	Abandoning *abool.AtomicBool
+	// abandonErr holds the error the Terminal is being abandoned with.
+	abandonErr     *Error
+	abandonErrLock sync.Mutex
 }
 
 func createTerminalBase(
@@ -782,6 +788,10 @@ func (t *TerminalBase) sendOpMsgs(msg *Msg) *Error {
 // Should not be overridden by implementations.
 func (t *TerminalBase) Abandon(err *Error) {
 	if t.Abandoning.SetToIf(false, true) {
+		t.abandonErrLock.Lock()
+		t.abandonErr = err
+		t.abandonErrLock.Unlock()
+
 		module.StartWorker("terminal abandon procedure", func(_ context.Context) error {
 			t.handleAbandonProcedure(err)
 			return nil
@@ -789,6 +799,16 @@ func (t *TerminalBase) Abandon(err *Error) {
 	}
 }
 
+// AbandonError returns the error the terminal is being abandoned with.
+// It is nil if the terminal is not being abandoned or is being shut down by
+// the owner.
+func (t *TerminalBase) AbandonError() *Error {
+	t.abandonErrLock.Lock()
+	defer t.abandonErrLock.Unlock()
+
+	return t.abandonErr
+}
+
 // HandleAbandon gives the terminal the ability to cleanly shut down.
 // The returned error is the error to send to the other side.
 // Should never be called directly. Call Abandon() instead.
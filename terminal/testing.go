@@ -221,6 +221,12 @@ func (t *BareTerminal) StopOperation(op Operation, err *Error) {}
 // Should not be overridden by implementations.
 func (t *BareTerminal) Abandon(err *Error) {}
 
+// AbandonError returns the error the terminal is being abandoned with.
+// Should not be overridden by implementations.
+func (t *BareTerminal) AbandonError() *Error {
+	return nil
+}
+
 // HandleAbandon gives the terminal the ability to cleanly shut down.
 // The terminal is still fully functional at this point.
 // The returned error is the error to send to the other side.